@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/config"
+)
+
+// extensions of the archives whose rules are validated without extracting
+// them. UCS backups and qkviews are gzipped tarballs as well
+var archiveExtensions = []string{".tar", ".tar.gz", ".tgz", ".ucs", ".qkview"}
+
+// a file read out of an archive
+type archiveMember struct {
+	archive string
+	content []byte
+}
+
+// the members of the archives given as arguments, keyed by the path of the
+// archive joined with the member name. they are read while the arguments are
+// expanded, before any file is validated, and only read afterwards
+var archiveMembers = map[string]archiveMember{}
+
+// archives that couldn't be read, reported when their turn comes
+var archiveErrors = map[string]error{}
+
+func isArchive(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether a name is a configuration file found in an archive, whose
+// ltm rules are validated one by one as with --extract-rules
+func isArchivedConfig(name string) bool {
+	_, archived := archiveMembers[name]
+	return archived && strings.ToLower(filepath.Ext(name)) == ".conf"
+}
+
+// reads the rule files and the configuration files holding ltm rules out of
+// a tarball, gzipped or not, and returns their names in the order they are
+// stored. an archive that can't be read is returned as is and reported when
+// it is validated
+func expandArchive(name string) []string {
+	members, err := readArchive(name)
+	if err == nil && len(members) == 0 {
+		err = errors.New("no .irule, .tcl or bigip.conf with ltm rules in archive")
+	}
+	if err != nil {
+		archiveErrors[name] = fmt.Errorf("reading archive %s: %w", name, err)
+		return []string{name}
+	}
+	return members
+}
+
+// returns the directory whose configuration files apply to a file: the one
+// holding it, or the one holding its archive
+func overridesDir(filename string) string {
+	if member, ok := archiveMembers[filename]; ok {
+		return filepath.Dir(member.archive)
+	}
+	return filepath.Dir(filename)
+}
+
+func readArchive(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var stream io.Reader = reader
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		stream = gz
+	}
+
+	members := []string{}
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		member := filepath.Join(name, header.Name)
+		isConfig := strings.ToLower(filepath.Ext(member)) == ".conf"
+		if !isRuleFile(member) && !isConfig {
+			continue
+		}
+
+		// an oversized member is read one byte past the limit, so it is
+		// skipped like a file of that size
+		var content io.Reader = tr
+		if config.MaxFileSize > 0 {
+			content = io.LimitReader(tr, config.MaxFileSize+1)
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+		if isConfig && !ltmRuleStanzaRegex.Match(data) {
+			continue
+		}
+		archiveMembers[member] = archiveMember{archive: name, content: data}
+		members = append(members, member)
+	}
+}
@@ -14,6 +14,8 @@ func precedence(op string) int {
 		token.NOT_EQ:      1,
 		token.LT:          2,
 		token.GT:          2,
+		token.LT_EQ:       2,
+		token.GT_EQ:       2,
 		token.PLUS:        3,
 		token.MINUS:       3,
 		token.SLASH:       4,
@@ -240,6 +242,7 @@ func (sl *StringLiteral) String() string {
 type BlockStatement struct {
 	Token      token.Token // { token
 	Statements []Statement
+	End        token.Token // } token, unset for blocks without braces such as an elseif chain
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -444,9 +447,10 @@ func (cs *CommandSubstitution) String() string {
 
 // WHEN EXPRESSION
 type WhenExpression struct {
-	Token token.Token // when token
-	Event Expression  // identifier like HTTP_REQUEST
-	Block *BlockStatement
+	Token    token.Token // when token
+	Event    Expression  // identifier like HTTP_REQUEST
+	Priority int         // execution priority, lower runs first
+	Block    *BlockStatement
 }
 
 func (we *WhenExpression) expressionNode()      {}
@@ -460,6 +464,16 @@ func (we *WhenExpression) String() string {
 	return out.String()
 }
 
+// rule wide default priority, e.g. priority 100
+type PriorityStatement struct {
+	Token token.Token // the 'priority' token
+	Value int
+}
+
+func (ps *PriorityStatement) statementNode()       {}
+func (ps *PriorityStatement) TokenLiteral() string { return ps.Token.Literal }
+func (ps *PriorityStatement) String() string       { return fmt.Sprintf("priority %d", ps.Value) }
+
 // HTTP URI EXPRESSION
 type HttpUriExpression struct {
 	Token  token.Token // HTTP_URI token
@@ -509,6 +523,48 @@ func (he *HttpExpression) String() string {
 	return out.String()
 }
 
+// HttpRespondExpression is HTTP::respond status ?-version version? ?content
+// value? ?noserver? ?-reset? ?name value ...?
+type HttpRespondExpression struct {
+	Token   token.Token // HTTP::respond token
+	Status  Expression
+	Version Expression
+	Content Expression
+	Headers []HttpHeaderField
+	Flags   []string // noserver and -reset
+}
+
+// HttpHeaderField is a header name and value pair given to HTTP::respond
+type HttpHeaderField struct {
+	Name  Expression
+	Value Expression
+}
+
+func (hr *HttpRespondExpression) expressionNode()      {}
+func (hr *HttpRespondExpression) TokenLiteral() string { return hr.Token.Literal }
+func (hr *HttpRespondExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("[")
+	out.WriteString(hr.Token.Literal)
+	if hr.Status != nil {
+		out.WriteString(" " + hr.Status.String())
+	}
+	if hr.Version != nil {
+		out.WriteString(" -version " + hr.Version.String())
+	}
+	if hr.Content != nil {
+		out.WriteString(" content " + hr.Content.String())
+	}
+	for _, flag := range hr.Flags {
+		out.WriteString(" " + flag)
+	}
+	for _, header := range hr.Headers {
+		out.WriteString(" " + header.Name.String() + " " + header.Value.String())
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
 type BracketExpression struct {
 	Token      token.Token
 	Expression Expression
@@ -532,6 +588,8 @@ type SwitchStatement struct {
 	Default *CaseStatement
 	IsRegex bool
 	IsGlob  bool
+	NoCase  bool
+	End     token.Token // } token closing the cases
 }
 
 func (ss *SwitchStatement) expressionNode()      {}
@@ -554,10 +612,25 @@ func (ss *SwitchStatement) String() string {
 	return out.String()
 }
 
+// returns the body run when the case at index i matches, which for a case
+// falling through is the body of the next case with one
+func (ss *SwitchStatement) Body(i int) *BlockStatement {
+	for ; i < len(ss.Cases); i++ {
+		if !ss.Cases[i].FallThrough {
+			return ss.Cases[i].Consequence
+		}
+	}
+	if ss.Default != nil {
+		return ss.Default.Consequence
+	}
+	return nil
+}
+
 type CaseStatement struct {
 	Token       token.Token // case token
 	Value       Expression
-	Consequence *BlockStatement
+	Consequence *BlockStatement // nil when the case falls through
+	FallThrough bool            // the body is -, the next body is used
 	Line        int
 }
 
@@ -565,9 +638,16 @@ func (cs *CaseStatement) expressionNode()      {}
 func (cs *CaseStatement) TokenLiteral() string { return cs.Token.Literal }
 func (cs *CaseStatement) String() string {
 	var out bytes.Buffer
-	out.WriteString(cs.Value.String())
-	out.WriteString(" ")
-	out.WriteString(cs.Consequence.String())
+	if cs.Value != nil {
+		// the default case has no value
+		out.WriteString(cs.Value.String())
+		out.WriteString(" ")
+	}
+	if cs.FallThrough {
+		out.WriteString("-")
+	} else {
+		out.WriteString(cs.Consequence.String())
+	}
 	out.WriteString("\n")
 	return out.String()
 }
@@ -590,6 +670,18 @@ func (ip *IpAddressLiteral) expressionNode()      {}
 func (ip *IpAddressLiteral) TokenLiteral() string { return ip.Token.Literal }
 func (ip *IpAddressLiteral) String() string       { return ip.Value }
 
+// CidrLiteral is a network such as 10.0.0.0/8, 2001:db8::/32 or
+// 10.0.0.0/255.0.0.0, whose mask is a prefix length or a netmask
+type CidrLiteral struct {
+	Token   token.Token // the address
+	Address string
+	Mask    string
+}
+
+func (c *CidrLiteral) expressionNode()      {}
+func (c *CidrLiteral) TokenLiteral() string { return c.Token.Literal }
+func (c *CidrLiteral) String() string       { return c.Address + "/" + c.Mask }
+
 type LoadBalancerExpression struct {
 	Token    token.Token // LB token
 	Command  *Identifier // Load Balancer command (e.g., LB::select)
@@ -706,6 +798,38 @@ func (cc *ClassCommand) String() string {
 	return out.String()
 }
 
+// TableCommand is table <subcommand> ?options? <arguments> on the session
+// table. Subtable holds the value of -subtable, the other options are kept in
+// Options
+type TableCommand struct {
+	Token      token.Token // the 'table' token
+	Subcommand string
+	Subtable   Expression
+	Options    []Expression
+	Arguments  []Expression
+}
+
+func (tc *TableCommand) expressionNode()      {}
+func (tc *TableCommand) TokenLiteral() string { return tc.Token.Literal }
+func (tc *TableCommand) String() string {
+	var out bytes.Buffer
+	out.WriteString("table ")
+	out.WriteString(tc.Subcommand)
+	if tc.Subtable != nil {
+		out.WriteString(" -subtable ")
+		out.WriteString(tc.Subtable.String())
+	}
+	for _, opt := range tc.Options {
+		out.WriteString(" ")
+		out.WriteString(opt.String())
+	}
+	for _, arg := range tc.Arguments {
+		out.WriteString(" ")
+		out.WriteString(arg.String())
+	}
+	return out.String()
+}
+
 // represents a string that may contain embedded expressions
 type InterpolatedString struct {
 	Token token.Token //  token containing the string literal
@@ -754,6 +878,50 @@ func (fs *ForEachStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while ")
+	if ws.Condition != nil {
+		out.WriteString(ws.Condition.String())
+	}
+	out.WriteString(" ")
+	if ws.Body != nil {
+		out.WriteString(ws.Body.String())
+	}
+	return out.String()
+}
+
+// for {init} {condition} {next} {body}
+type ForStatement struct {
+	Token     token.Token // 'for' token
+	Init      *BlockStatement
+	Condition Expression
+	Next      *BlockStatement
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for ")
+	for _, part := range []Node{fs.Init, fs.Condition, fs.Next, fs.Body} {
+		if !isNil(part) {
+			out.WriteString(part.String())
+		}
+		out.WriteString(" ")
+	}
+	return strings.TrimSuffix(out.String(), " ")
+}
+
 type NodeStatement struct {
 	Token     token.Token
 	IPAddress string
@@ -783,6 +951,28 @@ func (lr *LtmRule) String() string {
 	return out.String()
 }
 
+type ProcStatement struct {
+	Token      token.Token // the 'proc' token
+	Name       *Identifier
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ps *ProcStatement) statementNode()       {}
+func (ps *ProcStatement) TokenLiteral() string { return ps.Token.Literal }
+func (ps *ProcStatement) String() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, param := range ps.Parameters {
+		params = append(params, param.String())
+	}
+	out.WriteString("proc ")
+	out.WriteString(ps.Name.String())
+	out.WriteString(" {" + strings.Join(params, " ") + "} ")
+	out.WriteString(ps.Body.String())
+	return out.String()
+}
+
 type SlashExpression struct {
 	Token token.Token
 }
@@ -849,6 +1039,112 @@ func (re *RegsubExpression) String() string {
 	return out.String()
 }
 
+// RegexpExpression is regexp ?switches? exp string ?matchVar? ?subMatchVar ...?
+type RegexpExpression struct {
+	Token       token.Token // the 'regexp' token
+	Flags       []string
+	Pattern     Expression
+	InputString Expression
+	MatchVars   []*Identifier
+}
+
+func (re *RegexpExpression) expressionNode()      {}
+func (re *RegexpExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RegexpExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("regexp")
+	for _, flag := range re.Flags {
+		out.WriteString(" " + flag)
+	}
+	out.WriteString(" " + re.Pattern.String())
+	out.WriteString(" " + re.InputString.String())
+	for _, v := range re.MatchVars {
+		out.WriteString(" " + v.String())
+	}
+	return out.String()
+}
+
+// ArrayAccess is an element of a Tcl array, such as $counts(total), or the
+// target of set counts($key) 1. the key may embed variables and commands
+type ArrayAccess struct {
+	Token token.Token // the array name
+	Name  *Identifier
+	Key   Expression
+}
+
+func (aa *ArrayAccess) expressionNode()      {}
+func (aa *ArrayAccess) TokenLiteral() string { return aa.Token.Literal }
+func (aa *ArrayAccess) String() string {
+	key := aa.Key.String()
+	if lit, ok := aa.Key.(*StringLiteral); ok {
+		key = lit.Value
+	}
+	return aa.Name.String() + "(" + key + ")"
+}
+
+// CatchExpression is catch script ?resultVarName? ?optionsVarName?. a braced
+// script is parsed as a block, anything else is kept as the Script argument
+type CatchExpression struct {
+	Token      token.Token // the 'catch' token
+	Body       *BlockStatement
+	Script     Expression
+	ResultVar  *Identifier
+	OptionsVar *Identifier
+}
+
+func (ce *CatchExpression) expressionNode()      {}
+func (ce *CatchExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CatchExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("catch")
+	if ce.Body != nil {
+		out.WriteString(" " + ce.Body.String())
+	} else if ce.Script != nil {
+		out.WriteString(" " + ce.Script.String())
+	}
+	if ce.ResultVar != nil {
+		out.WriteString(" " + ce.ResultVar.String())
+	}
+	if ce.OptionsVar != nil {
+		out.WriteString(" " + ce.OptionsVar.String())
+	}
+	return out.String()
+}
+
+// ExprExpression is expr arg ?arg ...?. Braced tells whether the expression
+// was a single braced argument, which expr substitutes itself
+type ExprExpression struct {
+	Token      token.Token // the 'expr' token
+	Expression Expression
+	Braced     bool
+}
+
+func (ee *ExprExpression) expressionNode()      {}
+func (ee *ExprExpression) TokenLiteral() string { return ee.Token.Literal }
+func (ee *ExprExpression) String() string {
+	if ee.Expression == nil {
+		return "expr"
+	}
+	if ee.Braced {
+		return "expr {" + ee.Expression.String() + "}"
+	}
+	return "expr " + ee.Expression.String()
+}
+
+// ConditionalExpression is condition ? consequence : alternative
+type ConditionalExpression struct {
+	Token       token.Token // the '?' token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (ce *ConditionalExpression) expressionNode()      {}
+func (ce *ConditionalExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *ConditionalExpression) String() string {
+	return ce.Condition.String() + " ? " + ce.Consequence.String() + " : " + ce.Alternative.String()
+}
+
 type CommandInvocation struct {
 	Token     token.Token
 	Command   string
@@ -1,8 +1,11 @@
 package ast
 
 import (
-	"github.com/elkrammer/irule-validator/token"
+	"encoding/json"
+	"strings"
 	"testing"
+
+	"github.com/elkrammer/irule-validator/token"
 )
 
 func TestString(t *testing.T) {
@@ -31,3 +34,117 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. Got=%q, Expected=%q", program.String(), expected)
 	}
 }
+
+func TestInspect(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &WhenExpression{
+					Event: &Identifier{Value: "HTTP_REQUEST"},
+					Block: &BlockStatement{
+						Statements: []Statement{
+							&SetStatement{
+								Name:  &Identifier{Value: "host"},
+								Value: &CommandInvocation{Command: "HTTP::host"},
+							},
+							&IfStatement{
+								Condition:   &Identifier{Value: "$host"},
+								Consequence: &BlockStatement{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	visited := []string{}
+	Inspect(program, func(node Node) bool {
+		switch n := node.(type) {
+		case *Identifier:
+			visited = append(visited, n.Value)
+		case *CommandInvocation:
+			visited = append(visited, n.Command)
+		case *IfStatement:
+			// the condition is not visited
+			return false
+		}
+		return true
+	})
+
+	expected := []string{"HTTP_REQUEST", "host", "HTTP::host"}
+	if len(visited) != len(expected) {
+		t.Fatalf("Inspect visited wrong nodes. Got=%v, Expected=%v", visited, expected)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Errorf("visited[%d] wrong. Got=%q, Expected=%q", i, visited[i], expected[i])
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&SetStatement{
+				Token: token.Token{Type: token.SET, Literal: "set", Line: 2, Column: 5},
+				Name:  &Identifier{Token: token.Token{Literal: "codes", Line: 2, Column: 9}, Value: "codes"},
+				Value: &MapLiteral{
+					Token: token.Token{Literal: "{", Line: 2, Column: 15},
+					Pairs: map[Expression]Expression{
+						&StringLiteral{Value: "b"}: &NumberLiteral{Value: 2},
+						&StringLiteral{Value: "a"}: &NumberLiteral{Value: 1},
+					},
+				},
+			},
+			&IfStatement{
+				Condition:   &Boolean{Value: true},
+				Consequence: &BlockStatement{End: token.Token{Literal: "}", Line: 4, Column: 1}},
+			},
+		},
+	}
+
+	tree := ToJSON(program)
+	if tree.Type != "Program" || len(tree.Children) != 2 {
+		t.Fatalf("ToJSON(program) wrong. Got type=%q with %d children", tree.Type, len(tree.Children))
+	}
+
+	set := tree.Children[0]
+	if set.Type != "SetStatement" || set.Field != "Statements" || set.Line != 2 || set.Column != 5 || set.Literal != "set" {
+		t.Errorf("set statement wrong. Got=%+v", set)
+	}
+	if len(set.Children) != 2 || set.Children[0].Field != "Name" || set.Children[1].Field != "Value" {
+		t.Fatalf("set statement children wrong. Got=%+v", set.Children)
+	}
+	if set.Children[0].Fields["Value"] != "codes" {
+		t.Errorf("set name fields wrong. Got=%v", set.Children[0].Fields)
+	}
+
+	pairs := set.Children[1].Children
+	keys := []string{}
+	for _, pair := range pairs {
+		if pair.Type != "Pair" || len(pair.Children) != 2 {
+			t.Fatalf("map pair wrong. Got=%+v", pair)
+		}
+		keys = append(keys, pair.Children[0].Fields["Value"].(string))
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("map pairs not sorted by key. Got=%v", keys)
+	}
+
+	// the unset alternative is left out, the end of the block is a field
+	ifStmt := tree.Children[1]
+	if len(ifStmt.Children) != 2 {
+		t.Fatalf("if statement children wrong. Got=%+v", ifStmt.Children)
+	}
+	if end, ok := ifStmt.Children[1].Fields["End"].(JSONPosition); !ok || end.Line != 4 || end.Column != 1 {
+		t.Errorf("block end wrong. Got=%v", ifStmt.Children[1].Fields)
+	}
+
+	if _, err := json.Marshal(tree); err != nil {
+		t.Errorf("json.Marshal failed: %v", err)
+	}
+	if ToJSON(nil) != nil {
+		t.Errorf("ToJSON(nil) should be nil")
+	}
+}
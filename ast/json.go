@@ -0,0 +1,147 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// a node of the parse tree as written by --ast. the position and literal
+// come from the token of the node, nodes without one such as Program have
+// none. Field names the field of the parent holding the node
+type JSONNode struct {
+	Type     string         `json:"type"`
+	Field    string         `json:"field,omitempty"`
+	Line     int            `json:"line,omitempty"`
+	Column   int            `json:"column,omitempty"`
+	Literal  string         `json:"literal,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
+	Children []*JSONNode    `json:"children,omitempty"`
+}
+
+// the position of a token other than the one a node starts at, such as the
+// closing brace of a block
+type JSONPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+var tokenType = reflect.TypeOf(token.Token{})
+
+// converts a tree into nodes that marshal to JSON. every node type is
+// handled alike: nodes and lists of nodes become children in the order of
+// their fields, other values become fields. the pairs of hash and map
+// literals are sorted by key so the output doesn't change between runs
+func ToJSON(node Node) *JSONNode {
+	if isNil(node) {
+		return nil
+	}
+	return jsonValue(reflect.ValueOf(node), "")
+}
+
+// converts a node, or a struct such as HttpHeaderField, given as a value or
+// a pointer
+func jsonValue(v reflect.Value, field string) *JSONNode {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	out := &JSONNode{Type: v.Type().Name(), Field: field}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		switch {
+		case f.Type == tokenType && f.Name == "Token":
+			tok := value.Interface().(token.Token)
+			out.Line, out.Column, out.Literal = tok.Line, tok.Column, tok.Literal
+		case f.Type == tokenType:
+			if tok := value.Interface().(token.Token); tok.Line > 0 {
+				out.setField(f.Name, JSONPosition{Line: tok.Line, Column: tok.Column})
+			}
+		case isTreeType(f.Type):
+			if child := jsonValue(value, f.Name); child != nil {
+				out.Children = append(out.Children, child)
+			}
+		case f.Type.Kind() == reflect.Slice && isTreeType(f.Type.Elem()):
+			for j := 0; j < value.Len(); j++ {
+				if child := jsonValue(value.Index(j), f.Name); child != nil {
+					out.Children = append(out.Children, child)
+				}
+			}
+		case f.Type.Kind() == reflect.Map:
+			out.Children = append(out.Children, jsonPairs(value, f.Name)...)
+		default:
+			if !value.IsZero() {
+				out.setField(f.Name, value.Interface())
+			}
+		}
+	}
+	return out
+}
+
+// converts the entries of a map into Pair nodes holding the key and the
+// value, sorted by the source of the key
+func jsonPairs(m reflect.Value, field string) []*JSONNode {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return nodeString(keys[i]) < nodeString(keys[j])
+	})
+
+	pairs := []*JSONNode{}
+	for _, key := range keys {
+		pair := &JSONNode{Type: "Pair", Field: field}
+		for _, child := range []*JSONNode{jsonValue(key, "Key"), jsonValue(m.MapIndex(key), "Value")} {
+			if child != nil {
+				pair.Children = append(pair.Children, child)
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// returns the source of a node held by value, such as the StringLiteral keys
+// of a HashLiteral, whose methods take a pointer
+func nodeString(v reflect.Value) string {
+	if v.Kind() == reflect.Struct {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	if node, ok := v.Interface().(Node); ok && !isNil(node) {
+		return node.String()
+	}
+	return ""
+}
+
+// reports whether values of a type are converted into child nodes: nodes,
+// and the structs of this package grouping them such as WhenNode
+func isTreeType(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return t.Implements(reflect.TypeOf((*Node)(nil)).Elem())
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t.PkgPath() == astPkgPath
+}
+
+// the package of the tree types
+var astPkgPath = reflect.TypeOf(Program{}).PkgPath()
+
+func (n *JSONNode) setField(name string, value any) {
+	if n.Fields == nil {
+		n.Fields = map[string]any{}
+	}
+	n.Fields[name] = value
+}
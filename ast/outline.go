@@ -0,0 +1,105 @@
+package ast
+
+import "github.com/elkrammer/irule-validator/token"
+
+// OutlineItem is a foldable construct of a rule, from its first word to its
+// closing brace
+type OutlineItem struct {
+	Kind     string // rule, event, proc, if, switch, case, foreach, while or for
+	Name     string // the rule, event or proc name, the case pattern or the loop variable
+	Start    token.Token
+	End      token.Token // the closing brace, unset when the construct wasn't closed
+	Children []OutlineItem
+}
+
+// Outline returns the foldable constructs beneath node, nested as they are
+// in the rule. an elseif or else branch is folded with its if
+func Outline(node Node) []OutlineItem {
+	items := []OutlineItem{}
+	Inspect(node, func(n Node) bool {
+		if n == node {
+			return true
+		}
+		item, ok := outlineItem(n)
+		if !ok {
+			return true
+		}
+		items = append(items, item)
+		return false
+	})
+	return items
+}
+
+func outlineItem(node Node) (OutlineItem, bool) {
+	switch n := node.(type) {
+	case *LtmRule:
+		return OutlineItem{Kind: "rule", Name: n.Name.Value, Start: n.Token, End: blockEnd(n.Body), Children: Outline(n.Body)}, true
+	case *WhenExpression:
+		return OutlineItem{Kind: "event", Name: n.Event.String(), Start: n.Token, End: blockEnd(n.Block), Children: Outline(n.Block)}, true
+	case *ProcStatement:
+		return OutlineItem{Kind: "proc", Name: n.Name.Value, Start: n.Token, End: blockEnd(n.Body), Children: Outline(n.Body)}, true
+	case *IfStatement:
+		return OutlineItem{Kind: "if", Start: n.Token, End: ifEnd(n), Children: ifBranches(n)}, true
+	case *SwitchStatement:
+		return OutlineItem{Kind: "switch", Start: n.Token, End: n.End, Children: Outline(n)}, true
+	case *CaseStatement:
+		name := "default"
+		switch value := n.Value.(type) {
+		case *StringLiteral:
+			name = value.Value
+		case Expression:
+			name = value.String()
+		}
+		return OutlineItem{Kind: "case", Name: name, Start: n.Token, End: blockEnd(n.Consequence), Children: Outline(n.Consequence)}, true
+	case *ForEachStatement:
+		return OutlineItem{Kind: "foreach", Name: n.Variable, Start: n.Token, End: blockEnd(n.Body), Children: Outline(n.Body)}, true
+	case *WhileStatement:
+		return OutlineItem{Kind: "while", Start: n.Token, End: blockEnd(n.Body), Children: Outline(n.Body)}, true
+	case *ForStatement:
+		return OutlineItem{Kind: "for", Start: n.Token, End: blockEnd(n.Body), Children: Outline(n.Body)}, true
+	}
+	return OutlineItem{}, false
+}
+
+func blockEnd(block *BlockStatement) token.Token {
+	if block == nil {
+		return token.Token{}
+	}
+	return block.End
+}
+
+// the parser wraps an elseif in a block of its own, without braces
+func elseIf(stmt *IfStatement) (*IfStatement, bool) {
+	if stmt.Alternative == nil || stmt.Alternative.End.Line != 0 || len(stmt.Alternative.Statements) != 1 {
+		return nil, false
+	}
+	next, ok := stmt.Alternative.Statements[0].(*IfStatement)
+	return next, ok
+}
+
+// the closing brace of the last branch of an if chain
+func ifEnd(stmt *IfStatement) token.Token {
+	for {
+		if next, ok := elseIf(stmt); ok {
+			stmt = next
+			continue
+		}
+		if stmt.Alternative != nil {
+			return stmt.Alternative.End
+		}
+		return blockEnd(stmt.Consequence)
+	}
+}
+
+// the constructs within every branch of an if chain
+func ifBranches(stmt *IfStatement) []OutlineItem {
+	items := []OutlineItem{}
+	for {
+		items = append(items, Outline(stmt.Consequence)...)
+		next, ok := elseIf(stmt)
+		if !ok {
+			return append(items, Outline(stmt.Alternative)...)
+		}
+		stmt = next
+	}
+}
@@ -0,0 +1,177 @@
+package ast
+
+import "reflect"
+
+// Inspect traverses the tree rooted at node depth first, calling f for every
+// node. the children of a node are skipped when f returns false
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *SetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *IfStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *HashLiteral:
+		for _, value := range n.Pairs {
+			Inspect(value, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *ListLiteral:
+		inspectAll(n.Elements, f)
+	case *ArrayLiteral:
+		inspectAll(n.Elements, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		inspectAll(n.Arguments, f)
+	case *ParenthesizedExpression:
+		Inspect(n.Expression, f)
+	case *CommandSubstitution:
+		Inspect(n.Command, f)
+	case *WhenExpression:
+		Inspect(n.Event, f)
+		Inspect(n.Block, f)
+	case *HttpUriExpression:
+		Inspect(n.Method, f)
+	case *HttpExpression:
+		Inspect(n.Command, f)
+		Inspect(n.Method, f)
+		Inspect(n.Argument, f)
+	case *HttpRespondExpression:
+		Inspect(n.Status, f)
+		Inspect(n.Version, f)
+		Inspect(n.Content, f)
+		for _, header := range n.Headers {
+			Inspect(header.Name, f)
+			Inspect(header.Value, f)
+		}
+	case *BracketExpression:
+		Inspect(n.Expression, f)
+	case *SwitchStatement:
+		Inspect(n.Value, f)
+		for _, c := range n.Cases {
+			Inspect(c, f)
+		}
+		Inspect(n.Default, f)
+	case *CaseStatement:
+		Inspect(n.Value, f)
+		Inspect(n.Consequence, f)
+	case *LoadBalancerExpression:
+		Inspect(n.Command, f)
+		Inspect(n.Method, f)
+		Inspect(n.Argument, f)
+	case *SSLExpression:
+		Inspect(n.Command, f)
+		Inspect(n.Method, f)
+		Inspect(n.Argument, f)
+	case *StringOperation:
+		inspectAll(n.Arguments, f)
+	case *MapLiteral:
+		for key, value := range n.Pairs {
+			Inspect(key, f)
+			Inspect(value, f)
+		}
+	case *ClassCommand:
+		inspectAll(n.Options, f)
+		inspectAll(n.Arguments, f)
+	case *TableCommand:
+		Inspect(n.Subtable, f)
+		inspectAll(n.Options, f)
+		inspectAll(n.Arguments, f)
+	case *InterpolatedString:
+		inspectAll(n.Parts, f)
+	case *ForEachStatement:
+		Inspect(n.List, f)
+		Inspect(n.Body, f)
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForStatement:
+		Inspect(n.Init, f)
+		Inspect(n.Condition, f)
+		Inspect(n.Next, f)
+		Inspect(n.Body, f)
+	case *LtmRule:
+		Inspect(n.Name, f)
+		Inspect(n.Body, f)
+	case *ProcStatement:
+		Inspect(n.Name, f)
+		for _, param := range n.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(n.Body, f)
+	case *MultiPattern:
+		inspectAll(n.Patterns, f)
+	case *RegsubExpression:
+		Inspect(n.Pattern, f)
+		Inspect(n.InputString, f)
+		Inspect(n.Replacement, f)
+		Inspect(n.ResultVar, f)
+	case *ArrayAccess:
+		Inspect(n.Name, f)
+		Inspect(n.Key, f)
+	case *RegexpExpression:
+		Inspect(n.Pattern, f)
+		Inspect(n.InputString, f)
+		for _, v := range n.MatchVars {
+			Inspect(v, f)
+		}
+	case *CatchExpression:
+		Inspect(n.Body, f)
+		Inspect(n.Script, f)
+		Inspect(n.ResultVar, f)
+		Inspect(n.OptionsVar, f)
+	case *CommandInvocation:
+		inspectAll(n.Arguments, f)
+	case *ExprExpression:
+		Inspect(n.Expression, f)
+	case *ConditionalExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	}
+}
+
+func inspectAll(nodes []Expression, f func(Node) bool) {
+	for _, node := range nodes {
+		Inspect(node, f)
+	}
+}
+
+// the parser leaves optional children unset, which may be a nil interface or
+// a nil pointer of a concrete node type
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
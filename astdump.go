@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// the parse tree of a single file in --ast output
+type jsonFileAST struct {
+	File  string        `json:"file"`
+	AST   *ast.JSONNode `json:"ast"`
+	Stats jsonTreeStats `json:"stats"`
+}
+
+// writes the parse tree of every file as a single JSON array instead of
+// validating them. a file that fails to parse still has its partial tree
+// written; only files that can't be read fail the run. returns the exit code
+func runAST(out io.Writer, filenames []string) int {
+	status := 0
+	files := []jsonFileAST{}
+	for _, filename := range filenames {
+		content, skipped, err := readInput(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file :%v\n", err)
+			status = 1
+			continue
+		}
+		if skipped != nil {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", filename, skipped.Message)
+			continue
+		}
+		overrides, err := config.LoadOverrides(overridesDir(filename))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading configuration: %v\n", err)
+			status = 1
+			continue
+		}
+
+		l := lexer.NewWithOptions(string(content), overrides.Apply(config.CurrentOptions()))
+		program := parser.New(l).ParseProgram()
+		stats := parser.CollectStats(program)
+		files = append(files, jsonFileAST{
+			File:  filename,
+			AST:   ast.ToJSON(program),
+			Stats: jsonTreeStats{Nodes: stats.Nodes, MaxDepth: stats.MaxDepth},
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(files); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+		return 1
+	}
+	return status
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/elkrammer/irule-validator/config"
+)
+
+// prints the settings in effect for the rules of a directory, the current one
+// by default, and where each value comes from. returns the exit code
+func runConfig(out io.Writer, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s config [directory]\n", os.Args[0])
+		return 2
+	}
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	settings, err := config.EffectiveSettings(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tORIGIN")
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, setting.Value, setting.Origin)
+	}
+	w.Flush()
+	return 0
+}
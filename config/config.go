@@ -4,21 +4,88 @@ import (
 	"fmt"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/spf13/pflag"
 )
 
 // app Config
-var DebugMode bool
+var DebugMode bool  // debug output of the parser and the validation run
+var DebugLexer bool // debug output of the lexer
+var DebugSubsystems []string
 var PrintErrors bool
 var PrintVersion bool
+var OnlyPhases []diagnostic.Phase
+var MaxFileSize int64
+var MaxMemory int64
+var Progress string
+var Format string
+var TmosVersion string
+var Modules []string
+var TestMode bool
+var FailFast bool
+var Recursive bool
+var PutsSeverity string
+var Metrics bool
+var ExtractRules bool
+var Strict bool
+var MaxWarnings int
+var RuleName string
+var RenameVar string
+var DryRun bool
+var SuggestPolicies bool
+var Lenient bool
+var Libraries []string
+var FormatRules bool
+var FormatCheck bool
+var DumpAST bool
+
+// environment variables named after a flag with this prefix set it, e.g.
+// IRULE_VALIDATOR_MAX_WARNINGS for --max-warnings
+const envPrefix = "IRULE_VALIDATOR_"
+
+// the flag annotation naming the environment variable a flag was set from
+const originAnnotation = "origin"
+
+// optional product modules whose namespaces and events are only accepted when
+// enabled with --module
+var knownModules = []string{"mqtt", "mr"}
+
+// the parts of the validator --debug-subsystem can limit debug output to
+var knownSubsystems = []string{"lexer", "parser"}
 
 // setup program flags
 func SetupFlags() {
 	pflag.BoolVarP(&DebugMode, "debug", "d", false, "Debugging Mode")
+	pflag.StringSliceVar(&DebugSubsystems, "debug-subsystem", nil, "Limit debug output to these parts of the validator (lexer, parser); implies --debug")
 	pflag.BoolVarP(&PrintErrors, "print-errors", "p", false, "Print Errors")
 	pflag.BoolVarP(&PrintVersion, "version", "v", false, "Print App Version")
+	pflag.Int64Var(&MaxFileSize, "max-file-size", 4<<20, "Skip files larger than this many bytes (0 disables the check)")
+	pflag.Int64Var(&MaxMemory, "max-memory", 1<<30, "Stop reading new files once the heap grows past this many bytes (0 disables the watchdog)")
+	pflag.StringVar(&TmosVersion, "tmos-version", "", "Target TMOS version (e.g. 15.1); commands newer than it and rules past its size limits are reported")
+	pflag.StringVar(&Progress, "progress", "none", "Progress output written to stderr (none, json)")
+	pflag.StringVar(&Format, "format", "text", "Output format for results (text, json, outline)")
+	pflag.BoolVar(&ExtractRules, "extract-rules", false, "Validate every ltm rule of a bigip.conf on its own, ignoring the rest of the configuration")
+	pflag.StringVar(&RuleName, "name", "", "The ltm rule the extract command prints, or the rule the new command creates")
+	pflag.StringVar(&RenameVar, "var", "", "The variable the rename command renames")
+	pflag.BoolVar(&DryRun, "dry-run", false, "Print the changes of the rename command without writing them")
+	pflag.BoolVar(&FormatRules, "fmt", false, "Rewrite the given rules with canonical indentation and spacing instead of validating them")
+	pflag.BoolVar(&FormatCheck, "fmt-check", false, "List the given rules --fmt would change and fail if there are any, without changing them")
+	pflag.BoolVar(&DumpAST, "ast", false, "Print the parse tree of the given rules as JSON instead of validating them")
+	pflag.BoolVar(&Metrics, "metrics", false, "Print rule metrics and the expensive operations of every event")
+	pflag.StringVar(&PutsSeverity, "puts", "warning", "How puts in events other than RULE_INIT is reported (off, info, warning, error)")
+	pflag.BoolVar(&SuggestPolicies, "suggest-policies", false, "Report rules simple enough to be replaced by an LTM policy")
+	pflag.BoolVar(&Strict, "strict", false, "Fail validation on warnings as well as errors")
+	pflag.BoolVar(&Lenient, "lenient", false, "Report commands the validator doesn't support yet as warnings instead of errors")
+	pflag.IntVar(&MaxWarnings, "max-warnings", -1, "Fail the run when more than this many warnings are found (-1 disables the check)")
+	pflag.StringSliceVar(&Libraries, "lib", nil, "Library rules whose procs calls into other rules, such as call /Common/library::helper, are checked against")
+	pflag.StringSliceVar(&Modules, "module", nil, "Enable optional module namespaces and events (mqtt, mr)")
+	pflag.BoolVar(&FailFast, "fail-fast", false, "Stop at the first file that fails validation")
+	pflag.BoolVarP(&Recursive, "recursive", "r", false, "Validate every .irule and .tcl file beneath the given directories")
+	pflag.BoolVar(&TestMode, "test-mode", false, "Accept assert commands and check '# expect:' comments in test fixtures")
+	only := pflag.StringSlice("only", nil, "Only print findings from these phases (lexer, parser, semantic)")
 	help := pflag.BoolP("help", "h", false, "Show help message")
 
 	pflag.Usage = func() {
@@ -28,23 +95,101 @@ func SetupFlags() {
 		fmt.Fprintf(os.Stderr, `
 If no parameter is specified it will run in quiet mode returning only
 the result.
-If a file name is specified, it will parse the provided file.
-If no file name is specified, it will go into REPL mode.
+If file names or glob patterns are specified, it will parse every matching
+file and exit with an error if any of them fails.
+If no file name is specified, it validates the rule piped into it, or goes
+into REPL mode when run from a terminal. A file name of - reads standard input.
 
 Examples:
 ./irule-validator http.irule      # Parse http.irule and show only the result
 ./irule-validator -p http.irule   # Parse http.irule and print errors
-./irule-validator                 # Start REPL
+cat http.irule | ./irule-validator -p  # Parse the rule piped in and print errors
+./irule-validator 'rules/*.irule'  # Parse every rule in rules/ and print a summary
+./irule-validator --fail-fast rules/*.irule  # Stop at the first failing rule
+./irule-validator --max-warnings 10 rules/*.irule  # Fail once the rules hold more than 10 warnings
+./irule-validator --lenient -p apm.irule  # Only warn about ACCESS:: and other commands not supported yet
+./irule-validator --extract-rules bigip.conf  # Validate every ltm rule found in bigip.conf
+./irule-validator backup.ucs       # Validate the rules of the bigip.conf and .irule files in a UCS or .tar.gz
+./irule-validator --fmt rules/*.irule  # Indent rules/*.irule canonically, rewriting them in place
+./irule-validator --fmt-check -r ./irules  # Fail CI when a rule beneath ./irules isn't formatted
+./irule-validator --ast http.irule  # Print the parse tree of http.irule as JSON
+./irule-validator --metrics http.irule  # Show which events run costly regex, table or loop constructs
+./irule-validator -p --suggest-policies http.irule  # Report whether http.irule could be an LTM policy
+./irule-validator -r ./irules      # Parse every rule beneath ./irules and print a table per directory
+./irule-validator -p --only semantic http.irule  # Print only semantic findings
+./irule-validator --format json http.irule        # Print findings as a JSON array
+./irule-validator --format outline http.irule     # Print events, procs and blocks with their ranges as JSON
+./irule-validator --module mqtt,mr broker.irule   # Accept MQTT:: and MR_* events
+./irule-validator --lib library.irule -p http.irule  # Check the calls of http.irule into the procs of library.irule
+./irule-validator selftest        # Check the built-in example rules still validate as expected
+./irule-validator extract bigip.conf  # List the ltm rules of bigip.conf
+./irule-validator extract --name /Common/redirect_rule bigip.conf  # Print the body of a single rule
+./irule-validator new redirect --name www_redirect  # Create www_redirect.irule from the redirect template
+./irule-validator rename --var host --dry-run hostname http.irule  # Show what renaming $host to $hostname changes
+./irule-validator --debug-subsystem parser http.irule  # Print parser debug output only
+./irule-validator lsp             # Serve findings to editors over the Language Server Protocol on stdio
+./irule-validator config --strict rules  # Show the settings applied to rules/ and where each comes from
+./irule-validator                 # Start REPL, where :debug on|off toggles debug output
+
+Every flag can also be set with an environment variable named after it, such
+as IRULE_VALIDATOR_FORMAT=json or IRULE_VALIDATOR_STRICT=true. Flags given on
+the command line take precedence.
 `)
 	}
 
 	pflag.Parse()
 
+	if err := applyEnvironment(pflag.CommandLine, os.LookupEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
 	if *help {
 		pflag.Usage()
 		os.Exit(0)
 	}
 
+	for _, name := range *only {
+		phase, err := diagnostic.ParsePhase(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --only value: %v\n", err)
+			os.Exit(2)
+		}
+		OnlyPhases = append(OnlyPhases, phase)
+	}
+
+	for i, module := range Modules {
+		Modules[i] = strings.ToLower(module)
+		if !isKnownModule(Modules[i]) {
+			fmt.Fprintf(os.Stderr, "Invalid --module value: %q (expected one of %s)\n", module, strings.Join(knownModules, ", "))
+			os.Exit(2)
+		}
+	}
+
+	for i, subsystem := range DebugSubsystems {
+		DebugSubsystems[i] = strings.ToLower(subsystem)
+		if !containsString(knownSubsystems, DebugSubsystems[i]) {
+			fmt.Fprintf(os.Stderr, "Invalid --debug-subsystem value: %q (expected one of %s)\n", subsystem, strings.Join(knownSubsystems, ", "))
+			os.Exit(2)
+		}
+	}
+	SetDebug(DebugMode || len(DebugSubsystems) > 0)
+
+	if Progress != "none" && Progress != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid --progress value: %q (expected none or json)\n", Progress)
+		os.Exit(2)
+	}
+
+	if Format != "text" && Format != "json" && Format != "outline" {
+		fmt.Fprintf(os.Stderr, "Invalid --format value: %q (expected text, json or outline)\n", Format)
+		os.Exit(2)
+	}
+
+	if PutsSeverity != "off" && PutsSeverity != "info" && PutsSeverity != "warning" && PutsSeverity != "error" {
+		fmt.Fprintf(os.Stderr, "Invalid --puts value: %q (expected off, info, warning or error)\n", PutsSeverity)
+		os.Exit(2)
+	}
+
 	if PrintVersion {
 		version := printVersion()
 		fmt.Println(version)
@@ -52,6 +197,54 @@ Examples:
 	}
 }
 
+// sets the flags not given on the command line from their environment
+// variables. lookup is os.LookupEnv outside of tests
+func applyEnvironment(flags *pflag.FlagSet, lookup func(string) (string, bool)) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s value: %v", name, setErr)
+			return
+		}
+		err = flags.SetAnnotation(f.Name, originAnnotation, []string{name})
+	})
+	return err
+}
+
+// returns the environment variable setting a flag
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// turns debug output on or off. when on, only the subsystems given with
+// --debug-subsystem print it, or all of them when none were
+func SetDebug(on bool) {
+	all := len(DebugSubsystems) == 0
+	DebugMode = on && (all || containsString(DebugSubsystems, "parser"))
+	DebugLexer = on && (all || containsString(DebugSubsystems, "lexer"))
+}
+
+func isKnownModule(module string) bool {
+	return containsString(knownModules, module)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func printVersion() string {
 	info, ok := debug.ReadBuildInfo()
 	if !ok {
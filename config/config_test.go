@@ -0,0 +1,79 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	env := map[string]string{
+		"IRULE_VALIDATOR_FORMAT":       "json",
+		"IRULE_VALIDATOR_STRICT":       "true",
+		"IRULE_VALIDATOR_MAX_WARNINGS": "10",
+		"IRULE_VALIDATOR_MODULE":       "mqtt,mr",
+		"IRULE_VALIDATOR_PUTS":         "error",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	format := flags.String("format", "text", "")
+	strict := flags.Bool("strict", false, "")
+	maxWarnings := flags.Int("max-warnings", -1, "")
+	modules := flags.StringSlice("module", nil, "")
+	puts := flags.String("puts", "warning", "")
+	debug := flags.BoolP("debug", "d", false, "")
+
+	// the command line wins over the environment
+	if err := flags.Parse([]string{"--puts", "info"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnvironment(flags, lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if *format != "json" || !*strict || *maxWarnings != 10 || *puts != "info" || *debug {
+		t.Errorf("flags wrong. format=%q strict=%v max-warnings=%d puts=%q debug=%v", *format, *strict, *maxWarnings, *puts, *debug)
+	}
+	if len(*modules) != 2 || (*modules)[0] != "mqtt" || (*modules)[1] != "mr" {
+		t.Errorf("modules wrong. expected [mqtt mr], got %v", *modules)
+	}
+
+	env["IRULE_VALIDATOR_DEBUG"] = "maybe"
+	err := applyEnvironment(flags, lookup)
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid IRULE_VALIDATOR_DEBUG value:") {
+		t.Errorf("expected an invalid value error, got %v", err)
+	}
+}
+
+func TestSetDebug(t *testing.T) {
+	defer func() {
+		DebugSubsystems = nil
+		SetDebug(false)
+	}()
+
+	tests := []struct {
+		subsystems []string
+		on         bool
+		parser     bool
+		lexer      bool
+	}{
+		{nil, true, true, true},
+		{nil, false, false, false},
+		{[]string{"lexer"}, true, false, true},
+		{[]string{"parser"}, true, true, false},
+		{[]string{"lexer", "parser"}, true, true, true},
+	}
+
+	for _, tt := range tests {
+		DebugSubsystems = tt.subsystems
+		SetDebug(tt.on)
+		if DebugMode != tt.parser || DebugLexer != tt.lexer {
+			t.Errorf("SetDebug(%v) with %v: parser=%v lexer=%v, expected parser=%v lexer=%v", tt.on, tt.subsystems, DebugMode, DebugLexer, tt.parser, tt.lexer)
+		}
+	}
+}
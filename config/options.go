@@ -0,0 +1,80 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Options holds the settings the lexer and parser consult while validating a
+// rule. every lexer and parser keeps its own copy, so rules can be validated
+// side by side with different settings, such as other modules enabled
+type Options struct {
+	DebugMode       bool // debug output of the parser
+	DebugLexer      bool // debug output of the lexer
+	TestMode        bool
+	Modules         []string
+	TmosVersion     string
+	PutsSeverity    string
+	SuggestPolicies bool
+	Lenient         bool     // report unsupported commands as warnings
+	DisabledChecks  []string // finding codes and analyzers turned off
+	CustomCommands  []string // commands of the organization accepted as valid
+	CustomHeaders   []string // header names accepted as valid
+}
+
+// returns the options set with the command line flags
+func CurrentOptions() Options {
+	return Options{
+		DebugMode:       DebugMode,
+		DebugLexer:      DebugLexer,
+		TestMode:        TestMode,
+		Modules:         append([]string(nil), Modules...),
+		TmosVersion:     TmosVersion,
+		PutsSeverity:    PutsSeverity,
+		SuggestPolicies: SuggestPolicies,
+		Lenient:         Lenient,
+	}
+}
+
+// reports whether an optional module is enabled
+func (o Options) ModuleEnabled(module string) bool {
+	return containsString(o.Modules, module)
+}
+
+// reports whether the targeted TMOS version ships features introduced in the
+// given release. without a target version every release is accepted
+func (o Options) TmosVersionAtLeast(release string) bool {
+	if o.TmosVersion == "" {
+		return true
+	}
+
+	target := versionParts(o.TmosVersion)
+	wanted := versionParts(release)
+	for i := 0; i < len(target) || i < len(wanted); i++ {
+		var t, w int
+		if i < len(target) {
+			t = target[i]
+		}
+		if i < len(wanted) {
+			w = wanted[i]
+		}
+		if t != w {
+			return t > w
+		}
+	}
+	return true
+}
+
+// returns the numbers of a dotted version such as 15.1.2, up to the first
+// part that isn't a number
+func versionParts(version string) []int {
+	parts := []int{}
+	for _, field := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
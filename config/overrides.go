@@ -0,0 +1,305 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// OverridesFile is the name of the per-directory configuration file
+const OverridesFile = ".irule-validator.yml"
+
+// LintFile is the name of the project configuration file. it takes the same
+// settings as OverridesFile, which is read after it in the same directory
+const LintFile = ".irulelint"
+
+var codeRegex = regexp.MustCompile(`^[LPS]\d{3}$`)
+
+// the settings holding a list rather than a map
+var listSettings = []string{"commands", "headers"}
+
+// the values of the style settings of --fmt
+var styleValues = map[string][]string{
+	"braces":       {"same-line", "next-line"},
+	"switch-cases": {"indented", "aligned"},
+}
+
+// Overrides holds the settings of the .irulelint and .irule-validator.yml
+// files that apply to a directory. a file may hold a severity map from
+// finding codes to error, warning, info or off, a checks map turning finding
+// codes or analyzers registered by name on or off, the lists of custom
+// commands and header names the rules may use, and the style --fmt writes:
+//
+//	severity:
+//	  S201: warning
+//	checks:
+//	  S211: off
+//	  request-id: off
+//	commands:
+//	  - ACME::tag
+//	headers:
+//	  - X-Acme-Trace
+//	style:
+//	  indent: 2
+//	  braces: next-line
+//	  switch-cases: aligned
+type Overrides struct {
+	Severity map[diagnostic.Code]string
+	Checks   map[string]bool
+	Commands []string // nil unless set, an empty list clears the inherited one
+	Headers  []string
+	Style    Style
+}
+
+// Style holds the layout --fmt writes rules in. the zero value is its
+// default: four spaces a level, braces left where they are and the cases of
+// a switch indented a level past it
+type Style struct {
+	Indent      int    // spaces a level, 0 unless set
+	Braces      string // same-line or next-line, after a \ continuing the line, for the { opening the body of when, if, elseif and else
+	SwitchCases string // indented, or aligned with their switch
+}
+
+// Apply adds the checks turned off and the custom commands and headers to
+// the options a rule is validated with
+func (o Overrides) Apply(opts Options) Options {
+	for name, enabled := range o.Checks {
+		if !enabled {
+			opts.DisabledChecks = append(opts.DisabledChecks, name)
+		}
+	}
+	sort.Strings(opts.DisabledChecks)
+	opts.CustomCommands = append(opts.CustomCommands, o.Commands...)
+	opts.CustomHeaders = append(opts.CustomHeaders, o.Headers...)
+	return opts
+}
+
+// replaces the settings of o with those set in other
+func (o *Overrides) merge(other Overrides) {
+	for code, severity := range other.Severity {
+		o.Severity[code] = severity
+	}
+	for name, enabled := range other.Checks {
+		o.Checks[name] = enabled
+	}
+	if other.Commands != nil {
+		o.Commands = other.Commands
+	}
+	if other.Headers != nil {
+		o.Headers = other.Headers
+	}
+	if other.Style.Indent != 0 {
+		o.Style.Indent = other.Style.Indent
+	}
+	if other.Style.Braces != "" {
+		o.Style.Braces = other.Style.Braces
+	}
+	if other.Style.SwitchCases != "" {
+		o.Style.SwitchCases = other.Style.SwitchCases
+	}
+}
+
+func newOverrides() Overrides {
+	return Overrides{Severity: map[diagnostic.Code]string{}, Checks: map[string]bool{}}
+}
+
+var (
+	overridesMu    sync.Mutex
+	overridesCache = map[string]Overrides{}
+)
+
+// LoadOverrides merges the configuration files of dir and of every directory
+// above it. a setting in a deeper directory replaces the one of its parents,
+// and within a directory .irule-validator.yml replaces .irulelint
+func LoadOverrides(dir string) (Overrides, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Overrides{}, err
+	}
+
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	return loadOverrides(abs)
+}
+
+func loadOverrides(dir string) (Overrides, error) {
+	if overrides, ok := overridesCache[dir]; ok {
+		return overrides, nil
+	}
+
+	merged := newOverrides()
+	if parent := filepath.Dir(dir); parent != dir {
+		inherited, err := loadOverrides(parent)
+		if err != nil {
+			return Overrides{}, err
+		}
+		merged.merge(inherited)
+	}
+
+	for _, name := range []string{LintFile, OverridesFile} {
+		own, err := readOverrides(filepath.Join(dir, name))
+		if err != nil {
+			return Overrides{}, err
+		}
+		merged.merge(own)
+	}
+
+	overridesCache[dir] = merged
+	return merged, nil
+}
+
+// a missing file holds no overrides
+func readOverrides(filename string) (Overrides, error) {
+	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Overrides{}, nil
+	}
+	if err != nil {
+		return Overrides{}, err
+	}
+	defer file.Close()
+
+	overrides, err := ParseOverrides(bufio.NewScanner(file))
+	if err != nil {
+		return Overrides{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return overrides, nil
+}
+
+// ParseOverrides reads the subset of YAML used by configuration files: top
+// level keys holding maps or lists of plain or quoted scalars, and comments
+func ParseOverrides(scanner *bufio.Scanner) (Overrides, error) {
+	overrides := newOverrides()
+	section := ""
+
+	for line := 1; scanner.Scan(); line++ {
+		text := stripComment(scanner.Text())
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			continue
+		}
+		indented := text[0] == ' ' || text[0] == '\t'
+
+		if indented && (trimmed == "-" || strings.HasPrefix(trimmed, "- ")) {
+			if !containsString(listSettings, section) {
+				return Overrides{}, fmt.Errorf("line %d: unexpected list entry", line)
+			}
+			item := unquote(strings.TrimSpace(trimmed[1:]))
+			if item == "" {
+				return Overrides{}, fmt.Errorf("line %d: empty %s entry", line, section)
+			}
+			if section == "commands" {
+				overrides.Commands = append(overrides.Commands, item)
+			} else {
+				overrides.Headers = append(overrides.Headers, item)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		key, value = unquote(strings.TrimSpace(key)), unquote(strings.TrimSpace(value))
+		if !ok {
+			return Overrides{}, fmt.Errorf("line %d: expected key: value", line)
+		}
+
+		if !indented {
+			switch key {
+			case "severity", "checks", "style":
+				if value != "" {
+					return Overrides{}, fmt.Errorf("line %d: %s expects a map", line, key)
+				}
+			case "commands", "headers":
+				if value != "" && value != "[]" {
+					return Overrides{}, fmt.Errorf("line %d: %s expects a list", line, key)
+				}
+				if key == "commands" {
+					overrides.Commands = []string{}
+				} else {
+					overrides.Headers = []string{}
+				}
+			default:
+				return Overrides{}, fmt.Errorf("line %d: unknown setting %q (expected severity, checks, commands, headers or style)", line, key)
+			}
+			section = key
+			continue
+		}
+
+		switch section {
+		case "":
+			return Overrides{}, fmt.Errorf("line %d: unexpected indentation", line)
+		case "checks":
+			if value != "on" && value != "off" {
+				return Overrides{}, fmt.Errorf("line %d: invalid value %q for check %s (expected on or off)", line, value, key)
+			}
+			overrides.Checks[key] = value == "on"
+		case "severity":
+			if !codeRegex.MatchString(key) {
+				return Overrides{}, fmt.Errorf("line %d: invalid code %q", line, key)
+			}
+			if value != "error" && value != "warning" && value != "info" && value != "off" {
+				return Overrides{}, fmt.Errorf("line %d: invalid severity %q for %s (expected error, warning, info or off)", line, value, key)
+			}
+			overrides.Severity[diagnostic.Code(key)] = value
+		case "style":
+			if err := overrides.Style.set(key, value); err != nil {
+				return Overrides{}, fmt.Errorf("line %d: %w", line, err)
+			}
+		default:
+			return Overrides{}, fmt.Errorf("line %d: %s expects a list", line, section)
+		}
+	}
+
+	return overrides, scanner.Err()
+}
+
+func (s *Style) set(key, value string) error {
+	if key == "indent" {
+		indent, err := strconv.Atoi(value)
+		if err != nil || indent < 1 || indent > 8 {
+			return fmt.Errorf("invalid indent %q (expected 1 to 8 spaces)", value)
+		}
+		s.Indent = indent
+		return nil
+	}
+
+	values, ok := styleValues[key]
+	if !ok {
+		return fmt.Errorf("unknown style setting %q (expected indent, braces or switch-cases)", key)
+	}
+	if !containsString(values, value) {
+		return fmt.Errorf("invalid value %q for %s (expected %s)", value, key, strings.Join(values, " or "))
+	}
+	if key == "braces" {
+		s.Braces = value
+	} else {
+		s.SwitchCases = value
+	}
+	return nil
+}
+
+// a # starts a comment at the beginning of a line or after whitespace
+func stripComment(line string) string {
+	for i, r := range line {
+		if r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+func TestParseOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[diagnostic.Code]string
+		err      string
+	}{
+		{
+			name: "Severity map with comments",
+			input: `# legacy rules
+severity:
+  S201: warning  # set by an older rule
+  "P104": 'off'
+`,
+			expected: map[diagnostic.Code]string{"S201": "warning", "P104": "off"},
+		},
+		{
+			name:     "Empty file",
+			input:    "\n# nothing here\n",
+			expected: map[diagnostic.Code]string{},
+		},
+		{
+			name:  "Unknown setting",
+			input: "format: json\n",
+			err:   `line 1: unknown setting "format"`,
+		},
+		{
+			name:  "Invalid code",
+			input: "severity:\n  S2011: off\n",
+			err:   `line 2: invalid code "S2011"`,
+		},
+		{
+			name:  "Invalid severity",
+			input: "severity:\n  S201: fatal\n",
+			err:   `line 2: invalid severity "fatal"`,
+		},
+		{
+			name:  "Entry outside a setting",
+			input: "  S201: off\n",
+			err:   "line 1: unexpected indentation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides, err := ParseOverrides(bufio.NewScanner(strings.NewReader(tt.input)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(overrides.Severity) != len(tt.expected) {
+				t.Fatalf("expected %d overrides, got %v", len(tt.expected), overrides.Severity)
+			}
+			for code, severity := range tt.expected {
+				if overrides.Severity[code] != severity {
+					t.Errorf("severity of %s wrong. expected=%q, got=%q", code, severity, overrides.Severity[code])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadOverridesNested(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "legacy")
+	old := filepath.Join(legacy, "old")
+	if err := os.MkdirAll(old, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		root:   "severity:\n  S201: error\n  S211: info\n",
+		legacy: "severity:\n  S201: warning\n",
+	}
+	for dir, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, OverridesFile), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overrides, err := LoadOverrides(old)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[diagnostic.Code]string{"S201": "warning", "S211": "info"}
+	for code, severity := range expected {
+		if overrides.Severity[code] != severity {
+			t.Errorf("severity of %s wrong. expected=%q, got=%q", code, severity, overrides.Severity[code])
+		}
+	}
+}
+
+func TestParseLintSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Overrides
+		err      string
+	}{
+		{
+			name: "Checks and lists",
+			input: `checks:
+  S213: off
+  request-id: "off"
+  S211: on
+commands:
+  - ACME::tag
+  - 'ACME::log'  # audit trail
+headers:
+  - X-Acme-Trace
+`,
+			expected: Overrides{
+				Checks:   map[string]bool{"S213": false, "request-id": false, "S211": true},
+				Commands: []string{"ACME::tag", "ACME::log"},
+				Headers:  []string{"X-Acme-Trace"},
+			},
+		},
+		{
+			name:     "Empty list",
+			input:    "commands: []\n",
+			expected: Overrides{Checks: map[string]bool{}, Commands: []string{}},
+		},
+		{
+			name:     "Style",
+			input:    "style:\n  indent: 2\n  braces: next-line\n  switch-cases: aligned\n",
+			expected: Overrides{Checks: map[string]bool{}, Style: Style{Indent: 2, Braces: "next-line", SwitchCases: "aligned"}},
+		},
+		{
+			name:  "Invalid indent",
+			input: "style:\n  indent: tab\n",
+			err:   `line 2: invalid indent "tab" (expected 1 to 8 spaces)`,
+		},
+		{
+			name:  "Invalid brace placement",
+			input: "style:\n  braces: k&r\n",
+			err:   `line 2: invalid value "k&r" for braces (expected same-line or next-line)`,
+		},
+		{
+			name:  "Unknown style setting",
+			input: "style:\n  tabs: on\n",
+			err:   `line 2: unknown style setting "tabs"`,
+		},
+		{
+			name:  "Invalid check value",
+			input: "checks:\n  S213: disabled\n",
+			err:   `line 2: invalid value "disabled" for check S213 (expected on or off)`,
+		},
+		{
+			name:  "List entry in a map",
+			input: "severity:\n  - S201\n",
+			err:   "line 2: unexpected list entry",
+		},
+		{
+			name:  "Map entry in a list",
+			input: "headers:\n  X-Acme-Trace: on\n",
+			err:   "line 2: headers expects a list",
+		},
+		{
+			name:  "Inline list",
+			input: "commands: ACME::tag\n",
+			err:   "line 1: commands expects a list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides, err := ParseOverrides(bufio.NewScanner(strings.NewReader(tt.input)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			overrides.Severity = nil
+			if !reflect.DeepEqual(overrides, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, overrides)
+			}
+		})
+	}
+}
+
+func TestLoadOverridesLintFile(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "legacy")
+	if err := os.MkdirAll(legacy, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(root, LintFile):        "checks:\n  S213: off\nseverity:\n  S201: error\ncommands:\n  - ACME::tag\n",
+		filepath.Join(root, OverridesFile):   "severity:\n  S201: info\n",
+		filepath.Join(legacy, LintFile):      "checks:\n  S213: on\n  request-id: off\ncommands: []\nheaders:\n  - X-Acme-Trace\n",
+		filepath.Join(legacy, OverridesFile): "severity:\n  S211: off\n",
+	}
+	for filename, content := range files {
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overrides, err := LoadOverrides(legacy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Overrides{
+		Severity: map[diagnostic.Code]string{"S201": "info", "S211": "off"},
+		Checks:   map[string]bool{"S213": true, "request-id": false},
+		Commands: []string{},
+		Headers:  []string{"X-Acme-Trace"},
+	}
+	if !reflect.DeepEqual(overrides, expected) {
+		t.Errorf("expected %+v, got %+v", expected, overrides)
+	}
+
+	opts := overrides.Apply(Options{})
+	if !reflect.DeepEqual(opts.DisabledChecks, []string{"request-id"}) || len(opts.CustomCommands) != 0 || !reflect.DeepEqual(opts.CustomHeaders, []string{"X-Acme-Trace"}) {
+		t.Errorf("options wrong, got %+v", opts)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Setting is an effective setting and where its value comes from: default, the
+// flag, the environment variable or the configuration file that set it
+type Setting struct {
+	Name   string
+	Value  string
+	Origin string
+}
+
+// EffectiveSettings returns the value of every flag followed by the settings
+// of the configuration files that apply to dir
+func EffectiveSettings(dir string) ([]Setting, error) {
+	overrides, err := overrideSettings(dir)
+	if err != nil {
+		return nil, err
+	}
+	return append(flagSettings(pflag.CommandLine), overrides...), nil
+}
+
+// returns the flags sorted by name with the origin of their values
+func flagSettings(flags *pflag.FlagSet) []Setting {
+	settings := []Setting{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		origin := "default"
+		if env, ok := f.Annotations[originAnnotation]; ok {
+			origin = env[0]
+		} else if f.Changed {
+			origin = "--" + f.Name
+		}
+		settings = append(settings, Setting{Name: f.Name, Value: f.Value.String(), Origin: origin})
+	})
+	return settings
+}
+
+// returns the settings of the configuration files applying to dir sorted by
+// name, each with the file it is taken from. as with LoadOverrides, a file in
+// a deeper directory replaces the settings of its parents
+func overrideSettings(dir string) ([]Setting, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{abs}
+	for parent := filepath.Dir(abs); parent != dirs[0]; parent = filepath.Dir(parent) {
+		dirs = append([]string{parent}, dirs...)
+	}
+
+	byName := map[string]Setting{}
+	for _, d := range dirs {
+		for _, name := range []string{LintFile, OverridesFile} {
+			filename := filepath.Join(d, name)
+			own, err := readOverrides(filename)
+			if err != nil {
+				return nil, err
+			}
+			for code, severity := range own.Severity {
+				byName["severity."+string(code)] = Setting{Name: "severity." + string(code), Value: severity, Origin: filename}
+			}
+			for check, enabled := range own.Checks {
+				value := "off"
+				if enabled {
+					value = "on"
+				}
+				byName["checks."+check] = Setting{Name: "checks." + check, Value: value, Origin: filename}
+			}
+			if own.Commands != nil {
+				byName["commands"] = Setting{Name: "commands", Value: strings.Join(own.Commands, ","), Origin: filename}
+			}
+			if own.Headers != nil {
+				byName["headers"] = Setting{Name: "headers", Value: strings.Join(own.Headers, ","), Origin: filename}
+			}
+			if own.Style.Indent != 0 {
+				byName["style.indent"] = Setting{Name: "style.indent", Value: strconv.Itoa(own.Style.Indent), Origin: filename}
+			}
+			if own.Style.Braces != "" {
+				byName["style.braces"] = Setting{Name: "style.braces", Value: own.Style.Braces, Origin: filename}
+			}
+			if own.Style.SwitchCases != "" {
+				byName["style.switch-cases"] = Setting{Name: "style.switch-cases", Value: own.Style.SwitchCases, Origin: filename}
+			}
+		}
+	}
+
+	settings := make([]Setting, 0, len(byName))
+	for _, setting := range byName {
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings, nil
+}
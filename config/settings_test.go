@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestFlagSettings(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("format", "text", "")
+	flags.Bool("strict", false, "")
+	flags.Int("max-warnings", -1, "")
+	flags.BoolP("help", "h", false, "")
+
+	if err := flags.Parse([]string{"--strict"}); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) (string, bool) {
+		return "json", name == "IRULE_VALIDATOR_FORMAT"
+	}
+	if err := applyEnvironment(flags, lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Setting{
+		{Name: "format", Value: "json", Origin: "IRULE_VALIDATOR_FORMAT"},
+		{Name: "max-warnings", Value: "-1", Origin: "default"},
+		{Name: "strict", Value: "true", Origin: "--strict"},
+	}
+	settings := flagSettings(flags)
+	if len(settings) != len(expected) {
+		t.Fatalf("expected %d settings, got %d: %v", len(expected), len(settings), settings)
+	}
+	for i, setting := range expected {
+		if settings[i] != setting {
+			t.Errorf("settings[%d] wrong. expected=%v, got=%v", i, setting, settings[i])
+		}
+	}
+}
+
+func TestOverrideSettings(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "legacy")
+	if err := os.MkdirAll(legacy, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		root:   "severity:\n  S201: error\n  S211: info\n",
+		legacy: "severity:\n  S201: warning\n",
+	}
+	for dir, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, OverridesFile), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, LintFile), []byte("checks:\n  S213: off\ncommands:\n  - ACME::tag\n  - ACME::log\nstyle:\n  indent: 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := overrideSettings(legacy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Setting{
+		{Name: "checks.S213", Value: "off", Origin: filepath.Join(root, LintFile)},
+		{Name: "commands", Value: "ACME::tag,ACME::log", Origin: filepath.Join(root, LintFile)},
+		{Name: "severity.S201", Value: "warning", Origin: filepath.Join(legacy, OverridesFile)},
+		{Name: "severity.S211", Value: "info", Origin: filepath.Join(root, OverridesFile)},
+		{Name: "style.indent", Value: "2", Origin: filepath.Join(root, LintFile)},
+	}
+	if len(settings) != len(expected) {
+		t.Fatalf("expected %d settings, got %d: %v", len(expected), len(settings), settings)
+	}
+	for i, setting := range expected {
+		if settings[i] != setting {
+			t.Errorf("settings[%d] wrong. expected=%v, got=%v", i, setting, settings[i])
+		}
+	}
+}
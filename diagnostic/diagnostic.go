@@ -0,0 +1,268 @@
+package diagnostic
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Phase identifies the stage of validation that produced a finding
+type Phase string
+
+const (
+	Lexer    Phase = "lexer"
+	Parser   Phase = "parser"
+	Semantic Phase = "semantic"
+)
+
+var phases = []Phase{Lexer, Parser, Semantic}
+
+// Code is a stable identifier for a class of finding. the prefix encodes the
+// phase: L0xx for the lexer, P1xx for the parser and S2xx for semantic checks
+type Code string
+
+// lexer findings
+const (
+	IllegalToken      Code = "L001"
+	UnterminatedRegex Code = "L002"
+	CommentInSwitch   Code = "L003"
+
+	// the input was not lexed at all
+	InputTooLarge   Code = "L010"
+	BinaryInput     Code = "L011"
+	MemoryCeiling   Code = "L012"
+	InvalidEncoding Code = "L013"
+)
+
+// parser findings
+const (
+	SyntaxError       Code = "P100"
+	UnexpectedToken   Code = "P101"
+	InvalidIdentifier Code = "P102"
+	UnbalancedBraces  Code = "P103"
+	InvalidCommand    Code = "P104"
+	UnknownNamespace  Code = "P105"
+
+	// a command the validator knows of but doesn't check yet
+	UnsupportedConstruct Code = "P106"
+)
+
+// semantic findings
+const (
+	NodePoolConflict    Code = "S200"
+	UndeclaredVariable  Code = "S201"
+	InvalidPattern      Code = "S202"
+	VersionMismatch     Code = "S203"
+	ModuleDisabled      Code = "S204"
+	InvalidResolver     Code = "S205"
+	UndefinedProc       Code = "S206"
+	PriorityTie         Code = "S207"
+	TestOnlyCommand     Code = "S208"
+	UnmetExpectation    Code = "S209"
+	CommandNotInEvent   Code = "S210"
+	PutsInEvent         Code = "S211"
+	UnreachableVariable Code = "S212"
+	PolicyCandidate     Code = "S213"
+	URIRewrite          Code = "S214"
+	SuspectSubstitution Code = "S215"
+	StaticInitInEvent   Code = "S217"
+	RuleLimit           Code = "S218"
+
+	// a finding of an analyzer registered by a program embedding the validator
+	CustomCheck Code = "S219"
+
+	// style: an expression left outside braces, substituted before the
+	// command evaluates it
+	UnbracedExpression Code = "S220"
+)
+
+func (c Code) Phase() Phase {
+	switch {
+	case strings.HasPrefix(string(c), "L"):
+		return Lexer
+	case strings.HasPrefix(string(c), "S"):
+		return Semantic
+	default:
+		return Parser
+	}
+}
+
+// Severity tells whether a finding fails validation. warnings only do with
+// --strict, notes never do
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+	Info    Severity = "info"
+)
+
+// Diagnostic is a single finding reported while validating an iRule
+type Diagnostic struct {
+	File     string // empty when validating a single input
+	Rule     string // the ltm rule the finding is in when rules are extracted from a configuration
+	Code     Code
+	Message  string
+	Line     int
+	Column   int      // 0 when unknown
+	Severity Severity // empty means Error
+	Fix      *SuggestedFix
+}
+
+// Position is a place in the input. lines and columns count from 1, columns
+// in characters
+type Position struct {
+	Line   int
+	Column int
+}
+
+// TextEdit replaces the text from Start up to End with NewText. an edit whose
+// Start and End are the same inserts NewText
+type TextEdit struct {
+	Start   Position
+	End     Position
+	NewText string
+}
+
+// SuggestedFix is a change resolving a finding, offered to editors and tools
+// that apply it themselves
+type SuggestedFix struct {
+	Message string
+	Edits   []TextEdit
+}
+
+func (d Diagnostic) Phase() Phase {
+	return d.Code.Phase()
+}
+
+func (d Diagnostic) IsError() bool {
+	return d.Severity == "" || d.Severity == Error
+}
+
+func (d Diagnostic) IsWarning() bool {
+	return d.Severity == Warning
+}
+
+func (d Diagnostic) String() string {
+	label := fmt.Sprintf("%s %s", d.Phase(), d.Code)
+	if !d.IsError() {
+		label += " " + string(d.Severity)
+	}
+	if d.Line == 0 {
+		// file level finding, e.g. an input that was skipped
+		return fmt.Sprintf("[%s] %s", label, d.Message)
+	}
+	if d.Column == 0 {
+		return fmt.Sprintf("[%s] %s, Line: %d", label, d.Message, d.Line)
+	}
+	return fmt.Sprintf("[%s] %s, Line: %d, Column: %d", label, d.Message, d.Line, d.Column)
+}
+
+// HasErrors reports whether any of the diagnostics is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.IsError() {
+			return true
+		}
+	}
+	return false
+}
+
+// CountWarnings returns the number of warnings among the diagnostics
+func CountWarnings(diagnostics []Diagnostic) int {
+	count := 0
+	for _, d := range diagnostics {
+		if d.IsWarning() {
+			count++
+		}
+	}
+	return count
+}
+
+// Normalize returns the diagnostics ordered by file, position, code and message
+// with exact duplicates removed, so that the output of a run is stable and can
+// be diffed against a baseline. overlapping code paths in the parser often
+// report the same finding twice, or an unexpected token along with the error
+// of the construct it broke, which is kept alone
+func Normalize(diagnostics []Diagnostic) []Diagnostic {
+	sorted := make([]Diagnostic, len(diagnostics))
+	copy(sorted, diagnostics)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		return a.Message < b.Message
+	})
+
+	normalized := []Diagnostic{}
+	for i, d := range sorted {
+		if i > 0 && d.equal(sorted[i-1]) {
+			continue
+		}
+		if d.Code == UnexpectedToken && d.Column > 0 && hasPrimary(sorted, d) {
+			continue
+		}
+		normalized = append(normalized, d)
+	}
+	return normalized
+}
+
+// reports whether another error was made at the position of an unexpected
+// token, known down to the column. the parser reports the token it didn't expect while the construct
+// being parsed reports what it was missing, and only the latter is kept
+func hasPrimary(diagnostics []Diagnostic, secondary Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Code != UnexpectedToken && d.IsError() && d.File == secondary.File && d.Rule == secondary.Rule &&
+			d.Line == secondary.Line && d.Column == secondary.Column {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether two diagnostics are the same finding with the same fix
+func (d Diagnostic) equal(other Diagnostic) bool {
+	fix, otherFix := d.Fix, other.Fix
+	d.Fix, other.Fix = nil, nil
+	return d == other && reflect.DeepEqual(fix, otherFix)
+}
+
+// ParsePhase converts a user supplied phase name into a Phase
+func ParsePhase(name string) (Phase, error) {
+	for _, phase := range phases {
+		if strings.EqualFold(name, string(phase)) {
+			return phase, nil
+		}
+	}
+	return "", fmt.Errorf("unknown phase %q (expected lexer, parser or semantic)", name)
+}
+
+// Filter returns the diagnostics produced by any of the given phases. an empty
+// phase list keeps every diagnostic
+func Filter(diagnostics []Diagnostic, only []Phase) []Diagnostic {
+	if len(only) == 0 {
+		return diagnostics
+	}
+
+	filtered := []Diagnostic{}
+	for _, d := range diagnostics {
+		for _, phase := range only {
+			if d.Phase() == phase {
+				filtered = append(filtered, d)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,160 @@
+package diagnostic
+
+import "testing"
+
+func TestCodePhase(t *testing.T) {
+	tests := []struct {
+		code     Code
+		expected Phase
+	}{
+		{IllegalToken, Lexer},
+		{SyntaxError, Parser},
+		{UnbalancedBraces, Parser},
+		{UndeclaredVariable, Semantic},
+	}
+
+	for _, tt := range tests {
+		if tt.code.Phase() != tt.expected {
+			t.Errorf("%s.Phase() wrong. expected=%q, got=%q", tt.code, tt.expected, tt.code.Phase())
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Code: IllegalToken, Message: "illegal", Line: 1},
+		{Code: SyntaxError, Message: "syntax", Line: 2},
+		{Code: InvalidPattern, Message: "pattern", Line: 3},
+	}
+
+	if got := Filter(diagnostics, nil); len(got) != 3 {
+		t.Fatalf("Filter without phases should keep everything. got=%d", len(got))
+	}
+
+	got := Filter(diagnostics, []Phase{Lexer, Semantic})
+	if len(got) != 2 {
+		t.Fatalf("Filter returned wrong number of diagnostics. expected=2, got=%d", len(got))
+	}
+	if got[0].Code != IllegalToken || got[1].Code != InvalidPattern {
+		t.Errorf("Filter returned wrong diagnostics: %v", got)
+	}
+}
+
+func TestParsePhase(t *testing.T) {
+	if phase, err := ParsePhase("Semantic"); err != nil || phase != Semantic {
+		t.Errorf("ParsePhase(Semantic) wrong. got=%q, %v", phase, err)
+	}
+
+	if _, err := ParsePhase("runtime"); err == nil {
+		t.Errorf("ParsePhase(runtime) should fail")
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   string
+	}{
+		{Diagnostic{Code: SyntaxError, Message: "oops", Line: 3}, "[parser P100] oops, Line: 3"},
+		{Diagnostic{Code: SyntaxError, Message: "oops", Line: 3, Column: 12}, "[parser P100] oops, Line: 3, Column: 12"},
+		{Diagnostic{Code: InputTooLarge, Message: "too big"}, "[lexer L010] too big"},
+		{Diagnostic{Code: PriorityTie, Message: "tie", Line: 7, Severity: Warning}, "[semantic S207 warning] tie, Line: 7"},
+		{Diagnostic{Code: PutsInEvent, Message: "puts", Line: 2, Column: 3, Severity: Info}, "[semantic S211 info] puts, Line: 2, Column: 3"},
+	}
+
+	for _, tt := range tests {
+		if tt.diagnostic.String() != tt.expected {
+			t.Errorf("String() wrong. expected=%q, got=%q", tt.expected, tt.diagnostic.String())
+		}
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	warning := Diagnostic{Code: PriorityTie, Message: "tie", Severity: Warning}
+	note := Diagnostic{Code: PutsInEvent, Message: "puts", Severity: Info}
+	err := Diagnostic{Code: SyntaxError, Message: "oops"}
+
+	if HasErrors([]Diagnostic{warning, note}) {
+		t.Errorf("HasErrors should ignore warnings and notes")
+	}
+	if !HasErrors([]Diagnostic{warning, err}) {
+		t.Errorf("HasErrors should report errors")
+	}
+	if count := CountWarnings([]Diagnostic{warning, note, err, warning}); count != 2 {
+		t.Errorf("CountWarnings wrong. expected=2, got=%d", count)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Code: SyntaxError, Message: "missing brace", Line: 4},
+		{Code: IllegalToken, Message: "illegal", Line: 2},
+		{Code: UnexpectedToken, Message: "unexpected", Line: 4},
+		{Code: SyntaxError, Message: "missing brace", Line: 4},
+		{Code: InvalidCommand, Message: "first", Line: 2, Column: 1},
+		{File: "a.irule", Code: SyntaxError, Message: "oops", Line: 9},
+	}
+
+	got := Normalize(diagnostics)
+	expected := []Diagnostic{
+		{Code: IllegalToken, Message: "illegal", Line: 2},
+		{Code: InvalidCommand, Message: "first", Line: 2, Column: 1},
+		{Code: SyntaxError, Message: "missing brace", Line: 4},
+		{Code: UnexpectedToken, Message: "unexpected", Line: 4},
+		{File: "a.irule", Code: SyntaxError, Message: "oops", Line: 9},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Normalize returned wrong number of diagnostics. expected=%d, got=%v", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Normalize()[%d] wrong. expected=%v, got=%v", i, expected[i], got[i])
+		}
+	}
+
+	if diagnostics[0].Line != 4 {
+		t.Errorf("Normalize should not reorder its input")
+	}
+}
+
+func TestNormalizeUnexpectedToken(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Code: UnexpectedToken, Message: "peekError: Expected next token to be }", Line: 11, Column: 14},
+		{Code: SyntaxError, Message: "parseIfStatement: Expected } after condition", Line: 11, Column: 14},
+		{Code: UnexpectedToken, Message: "No prefix parse function for ; found", Line: 12, Column: 3},
+		{Code: InvalidCommand, Message: "wrong # args", Line: 12, Column: 3, Severity: Warning},
+		{File: "a.irule", Code: UnexpectedToken, Message: "unexpected", Line: 11, Column: 14},
+	}
+
+	got := Normalize(diagnostics)
+	expected := []Diagnostic{
+		{Code: SyntaxError, Message: "parseIfStatement: Expected } after condition", Line: 11, Column: 14},
+		{Code: UnexpectedToken, Message: "No prefix parse function for ; found", Line: 12, Column: 3},
+		{Code: InvalidCommand, Message: "wrong # args", Line: 12, Column: 3, Severity: Warning},
+		{File: "a.irule", Code: UnexpectedToken, Message: "unexpected", Line: 11, Column: 14},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Normalize returned wrong number of diagnostics. expected=%d, got=%v", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Normalize()[%d] wrong. expected=%v, got=%v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestNormalizeFixes(t *testing.T) {
+	fix := func() *SuggestedFix {
+		return &SuggestedFix{Message: "brace it", Edits: []TextEdit{{Start: Position{1, 6}, End: Position{1, 6}, NewText: "{"}}}
+	}
+	diagnostics := []Diagnostic{
+		{Code: SyntaxError, Message: "unbraced", Line: 1, Fix: fix()},
+		{Code: SyntaxError, Message: "unbraced", Line: 1, Fix: fix()},
+		{Code: SyntaxError, Message: "unbraced", Line: 1},
+	}
+
+	got := Normalize(diagnostics)
+	if len(got) != 2 {
+		t.Fatalf("Normalize should drop the duplicate with an equal fix only. got=%v", got)
+	}
+}
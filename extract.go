@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// the first line of an ltm rule stanza. bigip.conf starts every stanza at the
+// beginning of a line
+var ltmRuleStanzaRegex = regexp.MustCompile(`(?m)^ltm rule\s+(\S+)\s*\{`)
+
+// an ltm rule stanza found in a configuration file
+type extractedRule struct {
+	name string
+	line int    // line of bigip.conf the stanza starts on
+	text string // the stanza from ltm rule to its closing brace
+}
+
+// finds every ltm rule stanza of a bigip.conf. a stanza whose braces never
+// close runs to the end of the file
+func extractRules(content string) []extractedRule {
+	rules := []extractedRule{}
+
+	offset := 0
+	for {
+		match := ltmRuleStanzaRegex.FindStringSubmatchIndex(content[offset:])
+		if match == nil {
+			return rules
+		}
+		start, open := offset+match[0], offset+match[1]-1
+		end := closingBrace(content, open)
+
+		rules = append(rules, extractedRule{
+			name: content[offset+match[2] : offset+match[3]],
+			line: strings.Count(content[:start], "\n") + 1,
+			text: content[start:end],
+		})
+		offset = end
+	}
+}
+
+// returns the position after the brace closing the one at open, or the end of
+// the content. like Tcl, only escaped braces are not counted
+func closingBrace(content string, open int) int {
+	depth := 0
+	for i := open; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(content)
+}
+
+// validates every ltm rule of a bigip.conf on its own, ignoring the rest of
+// the configuration
+func validateConfig(filename, content string, overrides config.Overrides) fileResult {
+	text := config.Format == "text"
+	var out bytes.Buffer
+
+	rules := extractRules(content)
+	if len(rules) == 0 {
+		if text {
+			fmt.Fprintf(&out, "⚠️ Skipped %v: no ltm rule found\n", filename)
+		}
+		return fileResult{status: statusSkipped, output: out.Bytes()}
+	}
+
+	result := fileResult{status: statusPassed}
+	failed := 0
+	for _, rule := range rules {
+		// pad the stanza so findings carry their line in bigip.conf
+		padded := strings.Repeat("\n", rule.line-1) + rule.text
+		subject := fmt.Sprintf("ltm rule %s (%s:%d)", rule.name, filename, rule.line)
+
+		checked := checkRule(&out, padded, subject, overrides, func(d *diagnostic.Diagnostic) {
+			d.File = filename
+			d.Rule = rule.name
+		})
+		if checked.failed {
+			failed++
+		}
+		result.diagnostics += checked.diagnostics
+		result.warnings += checked.warnings
+		result.findings = append(result.findings, checked.findings...)
+		result.outline = append(result.outline, checked.outline...)
+		result.stats.Merge(checked.stats)
+		if result.namespaces == nil {
+			result.namespaces = map[string]int{}
+		}
+		for namespace, count := range checked.namespaces {
+			result.namespaces[namespace] += count
+		}
+	}
+
+	if failed > 0 {
+		result.status = statusFailed
+	}
+	if text {
+		fmt.Fprintf(&out, "%v: %d of %d rules passed\n", filename, len(rules)-failed, len(rules))
+	}
+	result.output = out.Bytes()
+	return result
+}
+
+// prints the body of the ltm rule named with --name, or lists the rules of the
+// configuration when no name is given. returns the exit code
+func runExtract(out io.Writer, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s extract [--name <rule>] <bigip.conf>\n", os.Args[0])
+		return 2
+	}
+
+	content, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file :%v\n", err)
+		return 1
+	}
+	content, ok := decodeText(content)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error reading file :%s is UTF-16 with an odd number of bytes\n", args[0])
+		return 1
+	}
+	rules := extractRules(string(content))
+
+	if config.RuleName == "" {
+		for _, rule := range rules {
+			fmt.Fprintf(out, "%s (line %d)\n", rule.name, rule.line)
+		}
+		return 0
+	}
+
+	for _, rule := range rules {
+		if rule.name == config.RuleName || path.Base(rule.name) == config.RuleName {
+			fmt.Fprintln(out, ruleBody(rule.text))
+			return 0
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "No ltm rule named %s in %s\n", config.RuleName, args[0])
+	return 1
+}
+
+// returns the iRule code of an ltm rule stanza: what is between its braces,
+// without the properties bigip.conf stores next to the code
+func ruleBody(stanza string) string {
+	body := stanza[strings.Index(stanza, "{")+1:]
+	if strings.HasSuffix(body, "}") {
+		body = body[:len(body)-1]
+	}
+
+	lines := []string{}
+	depth := 0
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if depth == 0 && len(fields) > 0 && parser.IsLtmRuleProperty(fields[0]) {
+			continue
+		}
+		lines = append(lines, line)
+		depth += braceDepth(line)
+	}
+	return strings.Trim(strings.Join(dedent(lines), "\n"), "\n")
+}
+
+// removes the indentation every non-blank line shares
+func dedent(lines []string) []string {
+	indent, first := "", true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = lead, false
+		}
+		for !strings.HasPrefix(lead, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+
+	dedented := make([]string, len(lines))
+	for i, line := range lines {
+		dedented[i] = strings.TrimPrefix(line, indent)
+	}
+	return dedented
+}
+
+// returns how many more braces a line opens than it closes
+func braceDepth(line string) int {
+	depth := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+	}
+	return depth
+}
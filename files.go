@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/elkrammer/irule-validator/config"
+)
+
+// extensions of the files picked up when walking directories with --recursive
+var ruleExtensions = []string{".irule", ".tcl"}
+
+// expands the glob patterns among the file arguments so quoted patterns work
+// without a shell. like the shell, a pattern without matches is kept as is and
+// fails to open later on. with --recursive, directories are replaced by the
+// rules found beneath them. archives are replaced by the rules they hold
+func expandFileArgs(args []string) []string {
+	filenames := []string{}
+	for _, arg := range args {
+		if arg == stdinName {
+			filenames = append(filenames, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			matches = []string{arg}
+		}
+		for _, match := range matches {
+			if isArchive(match) {
+				filenames = append(filenames, expandArchive(match)...)
+				continue
+			}
+			filenames = append(filenames, expandDir(match)...)
+		}
+	}
+	return filenames
+}
+
+// returns the rule files beneath a directory in lexical order, or the name
+// itself if it isn't a directory or --recursive wasn't given
+func expandDir(name string) []string {
+	if !config.Recursive {
+		return []string{name}
+	}
+
+	filenames := []string{}
+	err := filepath.WalkDir(name, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() && isRuleFile(path) {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	if err != nil || len(filenames) == 0 {
+		// a plain file, or a directory that can't be read, is reported when
+		// it fails to open
+		return []string{name}
+	}
+	return filenames
+}
+
+func isRuleFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, ruleExt := range ruleExtensions {
+		if ext == ruleExt {
+			return true
+		}
+	}
+	return false
+}
+
+// validates files on a pool of workers. results are handed to report in the
+// order the files were given, no matter which finishes first; once report
+// returns false no further files are started
+func validateFiles(filenames []string, workers int, progress *progressReporter, report func(filename string, result fileResult) bool) {
+	results := make([]chan fileResult, len(filenames))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+
+	jobs := make(chan int)
+	stop := make(chan struct{})
+	go func() {
+		defer close(jobs)
+		for i := range filenames {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range min(workers, len(filenames)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				progress.start(i, filenames[i])
+				result := validateFile(filenames[i])
+				progress.finish(i, filenames[i], result)
+				results[i] <- result
+			}
+		}()
+	}
+
+	for i, filename := range filenames {
+		if !report(filename, <-results[i]) {
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// rewrites the rules with canonical indentation and spacing, in the style of
+// the configuration files of their directory, or with --fmt-check lists those
+// that aren't formatted without touching them. a rule read from standard input
+// is written formatted to out. returns the exit code
+func runFormat(out io.Writer, filenames []string) int {
+	status := 0
+	for _, filename := range filenames {
+		if _, archived := archiveMembers[filename]; archived {
+			fmt.Fprintf(os.Stderr, "Cannot format %s: it is inside an archive\n", filename)
+			status = 1
+			continue
+		}
+		content, skipped, err := readInput(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file :%v\n", err)
+			status = 1
+			continue
+		}
+		if skipped != nil {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", filename, skipped.Message)
+			continue
+		}
+
+		overrides, err := config.LoadOverrides(overridesDir(filename))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading configuration: %v\n", err)
+			status = 1
+			continue
+		}
+
+		formatted, err := parser.FormatRuleWithStyle(string(content), overrides.Style)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot format %s: %v\n", filename, err)
+			status = 1
+			continue
+		}
+		changed := formatted != string(content)
+
+		switch {
+		case config.FormatCheck:
+			if changed {
+				fmt.Fprintf(out, "%s is not formatted\n", filename)
+				status = 1
+			}
+		case filename == stdinName:
+			io.WriteString(out, formatted)
+		case changed:
+			info, err := os.Stat(filename)
+			if err == nil {
+				err = os.WriteFile(filename, []byte(formatted), info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file :%v\n", err)
+				status = 1
+				continue
+			}
+			fmt.Fprintf(out, "Formatted %s\n", filename)
+		}
+	}
+	return status
+}
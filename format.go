@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/parser"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// a single finding in --format json output
+type jsonDiagnostic struct {
+	File     string              `json:"file"`
+	Rule     string              `json:"rule,omitempty"`
+	Line     int                 `json:"line"`
+	Column   int                 `json:"column,omitempty"`
+	Severity diagnostic.Severity `json:"severity"`
+	Phase    diagnostic.Phase    `json:"phase"`
+	RuleID   diagnostic.Code     `json:"rule_id"`
+	Message  string              `json:"message"`
+	Fix      *jsonFix            `json:"fix,omitempty"`
+}
+
+// the suggested fix of a finding
+type jsonFix struct {
+	Message string         `json:"message"`
+	Edits   []jsonTextEdit `json:"edits"`
+}
+
+type jsonTextEdit struct {
+	Start   jsonPosition `json:"start"`
+	End     jsonPosition `json:"end"`
+	NewText string       `json:"new_text"`
+}
+
+// writes the findings of every file as a single JSON array
+func writeJSONDiagnostics(out io.Writer, diagnostics []diagnostic.Diagnostic) error {
+	findings := []jsonDiagnostic{}
+	for _, d := range diagnostics {
+		severity := d.Severity
+		if severity == "" {
+			severity = diagnostic.Error
+		}
+		findings = append(findings, jsonDiagnostic{
+			File:     d.File,
+			Rule:     d.Rule,
+			Line:     d.Line,
+			Column:   d.Column,
+			Severity: severity,
+			Phase:    d.Phase(),
+			RuleID:   d.Code,
+			Message:  d.Message,
+			Fix:      jsonSuggestedFix(d.Fix),
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(findings)
+}
+
+func jsonSuggestedFix(fix *diagnostic.SuggestedFix) *jsonFix {
+	if fix == nil {
+		return nil
+	}
+	converted := &jsonFix{Message: fix.Message, Edits: []jsonTextEdit{}}
+	for _, edit := range fix.Edits {
+		converted.Edits = append(converted.Edits, jsonTextEdit{
+			Start:   jsonPosition{Line: edit.Start.Line, Column: edit.Start.Column},
+			End:     jsonPosition{Line: edit.End.Line, Column: edit.End.Column},
+			NewText: edit.NewText,
+		})
+	}
+	return converted
+}
+
+// the outline of a single file
+type fileOutline struct {
+	file  string
+	items []ast.OutlineItem
+	stats parser.Stats
+}
+
+// a foldable construct in --format outline output
+type jsonOutlineItem struct {
+	Kind     string            `json:"kind"`
+	Name     string            `json:"name,omitempty"`
+	Start    jsonPosition      `json:"start"`
+	End      *jsonPosition     `json:"end,omitempty"`
+	Children []jsonOutlineItem `json:"children,omitempty"`
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// the size of the parse tree of a file, so visualizers don't have to walk
+// the outline to scale it
+type jsonTreeStats struct {
+	Nodes    map[string]int `json:"nodes"`
+	MaxDepth int            `json:"max_depth"`
+}
+
+type jsonFileOutline struct {
+	File    string            `json:"file"`
+	Outline []jsonOutlineItem `json:"outline"`
+	Stats   jsonTreeStats     `json:"stats"`
+}
+
+// writes the outline of every file as a single JSON array
+func writeJSONOutline(out io.Writer, outlines []fileOutline) error {
+	files := []jsonFileOutline{}
+	for _, outline := range outlines {
+		nodes := outline.stats.Nodes
+		if nodes == nil {
+			nodes = map[string]int{}
+		}
+		files = append(files, jsonFileOutline{
+			File:    outline.file,
+			Outline: jsonOutlineItems(outline.items),
+			Stats:   jsonTreeStats{Nodes: nodes, MaxDepth: outline.stats.MaxDepth},
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(files)
+}
+
+func jsonOutlineItems(items []ast.OutlineItem) []jsonOutlineItem {
+	converted := []jsonOutlineItem{}
+	for _, item := range items {
+		converted = append(converted, jsonOutlineItem{
+			Kind:     item.Kind,
+			Name:     item.Name,
+			Start:    tokenPosition(item.Start),
+			End:      endPosition(item.End),
+			Children: jsonOutlineItems(item.Children),
+		})
+	}
+	return converted
+}
+
+func tokenPosition(tok token.Token) jsonPosition {
+	return jsonPosition{Line: tok.Line, Column: tok.Column}
+}
+
+// constructs whose closing brace is missing have no end
+func endPosition(tok token.Token) *jsonPosition {
+	if tok.Line == 0 {
+		return nil
+	}
+	position := tokenPosition(tok)
+	return &position
+}
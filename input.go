@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"unicode/utf16"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// how much of a file is inspected when sniffing for binary content
+const sniffLength = 8000
+
+// the file name standing for standard input
+const stdinName = "-"
+
+// reports whether standard input is a pipe or a file rather than a terminal
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// reads a file for validation. inputs that are too large, look binary (core
+// dumps, tarballs) or arrive after the memory ceiling was hit are not returned;
+// a diagnostic explaining why the file was skipped is returned instead. the
+// members of archives were read beforehand and are only checked
+func readInput(filename string) ([]byte, *diagnostic.Diagnostic, error) {
+	if config.MaxMemory > 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if int64(stats.HeapAlloc) > config.MaxMemory {
+			return nil, skipInput(diagnostic.MemoryCeiling, "memory ceiling of %d bytes reached (heap is %d bytes), not reading file", config.MaxMemory, stats.HeapAlloc), nil
+		}
+	}
+
+	if err, ok := archiveErrors[filename]; ok {
+		return nil, nil, err
+	}
+	if member, ok := archiveMembers[filename]; ok {
+		return checkInput(member.content)
+	}
+
+	file := os.Stdin
+	if filename != stdinName {
+		opened, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	var reader io.Reader = file
+	if config.MaxFileSize > 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.Mode().IsRegular() && info.Size() > config.MaxFileSize {
+			return nil, skipInput(diagnostic.InputTooLarge, "file is %d bytes, larger than --max-file-size of %d bytes", info.Size(), config.MaxFileSize), nil
+		}
+		// devices and pipes don't report a size, so never read more than the limit
+		reader = io.LimitReader(file, config.MaxFileSize+1)
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return checkInput(content)
+}
+
+// checks the content of an input once read: its size, its encoding and
+// whether it looks binary
+func checkInput(content []byte) ([]byte, *diagnostic.Diagnostic, error) {
+	if config.MaxFileSize > 0 && int64(len(content)) > config.MaxFileSize {
+		return nil, skipInput(diagnostic.InputTooLarge, "input is larger than --max-file-size of %d bytes", config.MaxFileSize), nil
+	}
+
+	content, ok := decodeText(content)
+	if !ok {
+		return nil, skipInput(diagnostic.InvalidEncoding, "file starts with a UTF-16 byte order mark but has an odd number of bytes"), nil
+	}
+
+	if bytes.IndexByte(content[:min(len(content), sniffLength)], 0) >= 0 {
+		return nil, skipInput(diagnostic.BinaryInput, "file looks like binary data (core dump or archive?), not an iRule"), nil
+	}
+
+	return content, nil, nil
+}
+
+// the byte order marks of the encodings an iRule may arrive in
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// converts a file to utf-8 by its byte order mark. exports of tmsh captured
+// on windows are often utf-16, which would otherwise look binary. the mark
+// itself is dropped. returns false for utf-16 cut off in the middle of a
+// character
+func decodeText(content []byte) ([]byte, bool) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(content, utf8BOM):
+		return content[len(utf8BOM):], true
+	case bytes.HasPrefix(content, utf16LEBOM):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(content, utf16BEBOM):
+		order = binary.BigEndian
+	default:
+		return content, true
+	}
+
+	content = content[2:]
+	if len(content)%2 != 0 {
+		return nil, false
+	}
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		units[i] = order.Uint16(content[2*i:])
+	}
+	return []byte(string(utf16.Decode(units))), true
+}
+
+func skipInput(code diagnostic.Code, format string, args ...any) *diagnostic.Diagnostic {
+	return &diagnostic.Diagnostic{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -2,20 +2,31 @@ package lexer
 
 import (
 	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/elkrammer/irule-validator/token"
 )
 
 type Lexer struct {
-	input         string
-	position      int      // current position in input (points to current char)
-	readPosition  int      // current reading position in input (after current char)
-	ch            byte     // current char under examination
-	braceDepth    int      // current depth in block statements
-	line          int      // current line number
-	errors        []string // catch lexing errors
-	inSwitchBlock bool
+	input        string
+	position     int                     // current position in input (points to current char)
+	readPosition int                     // current reading position in input (after current char)
+	ch           byte                    // current char under examination
+	braceDepth   int                     // current depth in block statements
+	line         int                     // current line number
+	lineOffset   int                     // position of the first char of the current line
+	diagnostics  []diagnostic.Diagnostic // catch lexing errors
+	switchDepths []int                   // brace depths of the switch bodies being lexed, innermost last
+	lineStart    bool                    // an unescaped newline was crossed since the last token
+	callTarget   bool                    // the next word is the target of a call command
+	expectations []Expectation
+	opts         config.Options
 }
 
 var HttpKeywords = map[string]token.TokenType{
@@ -29,6 +40,17 @@ var HttpKeywords = map[string]token.TokenType{
 	"HTTP::method":   token.HTTP_METHOD,
 	"HTTP::path":     token.HTTP_PATH,
 	"HTTP::query":    token.HTTP_QUERY,
+	"HTTP::collect":  token.HTTP_COLLECT,
+	"HTTP::release":  token.HTTP_RELEASE,
+	"HTTP::payload":  token.HTTP_PAYLOAD,
+	"HTTP::version":  token.HTTP_VERSION,
+	"HTTP::status":   token.HTTP_STATUS,
+	"HTTP::username": token.HTTP_USERNAME,
+	"HTTP::password": token.HTTP_PASSWORD,
+	"HTTP::proxy":    token.HTTP_PROXY,
+	"HTTP::class":    token.HTTP_CLASS,
+	"HTTP::compress": token.HTTP_COMPRESS,
+	"HTTP::filter":   token.HTTP_FILTER,
 }
 
 var LbKeywords = map[string]token.TokenType{
@@ -68,10 +90,15 @@ var SSLKeywords = map[string]token.TokenType{
 	"SSL::sessionupdates": token.SSL_SESSIONUPDATES,
 }
 
+// returns a lexer using the options set with the command line flags
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1}
+	return NewWithOptions(input, config.CurrentOptions())
+}
+
+func NewWithOptions(input string, opts config.Options) *Lexer {
+	l := &Lexer{input: input, line: 1, lineStart: true, opts: opts}
 	l.readChar()
-	if config.DebugMode {
+	if l.opts.DebugLexer {
 		fmt.Printf("DEBUG: Lexer initialized with input length: %d\n", len(input))
 	}
 	return l
@@ -79,17 +106,17 @@ func New(input string) *Lexer {
 
 // read one forward character
 func (l *Lexer) readChar() {
-	// if config.DebugMode {
+	// if l.opts.DebugLexer {
 	// 	fmt.Printf(">>> readChar: BEFORE - l.ch: %q(%d), l.position: %d, l.readPosition: %d\n", l.ch, l.ch, l.position, l.readPosition)
 	// }
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
-		if config.DebugMode {
+		if l.opts.DebugLexer {
 			fmt.Printf("DEBUG: Reached EOF in lexer at position %d. Line: %d\n", l.position, l.line)
 		}
 	} else {
 		l.ch = l.input[l.readPosition]
-		// if config.DebugMode {
+		// if l.opts.DebugLexer {
 		// 	fmt.Printf(">>> readChar: Reading l.input[%d] = %q (%d)\n", l.readPosition, l.ch, l.ch)
 		// }
 	}
@@ -99,20 +126,39 @@ func (l *Lexer) readChar() {
 	// update line number
 	if l.ch == '\n' {
 		l.line++
+		l.lineOffset = l.readPosition
 	}
-	// if config.DebugMode {
+	// if l.opts.DebugLexer {
 	// 	fmt.Printf(">>> readChar: AFTER  - l.ch: %q(%d), l.position: %d, l.readPosition: %d\n", l.ch, l.ch, l.position, l.readPosition)
 	// }
 }
 
+// returns the 1-based column of the current char, counted in characters
+func (l *Lexer) column() int {
+	if l.position < l.lineOffset {
+		// the current char is the newline ending the previous line
+		return 0
+	}
+	end := l.position
+	if end > len(l.input) {
+		end = len(l.input)
+	}
+	return utf8.RuneCountInString(l.input[l.lineOffset:end]) + 1
+}
+
 func newToken(tokenType token.TokenType, ch byte, line int) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch), Line: line}
 }
 
-func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+// reads an operator of two characters such as << or >=
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	first := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(first) + string(l.ch), Line: l.line}
+}
 
-	// if config.DebugMode {
+func (l *Lexer) NextToken() token.Token {
+	// if l.opts.DebugLexer {
 	// 	fmt.Printf("DEBUG LEXER: NextToken() Entry - l.ch: %q, l.position: %d, l.readPosition: %d\n", l.ch, l.position, l.readPosition)
 	// }
 
@@ -120,30 +166,64 @@ func (l *Lexer) NextToken() token.Token {
 
 	// check for comments
 	if l.ch == '#' || (l.ch == '/' && l.peekChar() == '/') {
-		if l.inSwitchBlock {
-			l.reportError("Comments are not allowed in switch statement")
+		if n := len(l.switchDepths); n > 0 && l.switchDepths[n-1] == l.braceDepth {
+			l.reportError(diagnostic.CommentInSwitch, "Comments are not allowed in switch statement")
 			l.skipComment()
+			l.lineStart = true
 			return token.Token{
 				Type:    token.SKIP_TO_NEXT_CASE,
 				Literal: "SKIP_TO_NEXT_CASE",
 				Line:    l.line,
+				Column:  l.column(),
 			}
 		}
 		l.skipComment()
+		l.lineStart = true
 		return l.NextToken()
 	}
 
+	// every token is stamped with the position it starts at, regardless of how
+	// far the individual readers below advance
+	line, column, offset, lineStart := l.line, l.column(), l.position, l.lineStart
+	l.lineStart = false
+
+	tok := l.readToken()
+	tok.Line = line
+	tok.Column = column
+	tok.Offset = offset
+	tok.LineStart = lineStart
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
+	if l.callTarget {
+		l.callTarget = false
+		// call targets such as /Common/library::proc_name are a single word
+		if l.ch == '/' || IsLetter(l.ch) {
+			return token.Token{Type: token.IDENT, Literal: l.readBareWord()}
+		}
+	}
+
+	// IPv6 addresses such as fe80::1 or [2001:db8::1]:443 would otherwise lex
+	// as namespaced names or command substitutions
+	if n := l.ipv6Length(); n > 0 {
+		literal := l.input[l.position : l.position+n]
+		for i := 0; i < n; i++ {
+			l.readChar()
+		}
+		return token.Token{Type: token.IP_ADDRESS, Literal: literal, Line: l.line}
+	}
+
 	switch l.ch {
-	case '\n':
-		l.line++
-		return l.NextToken()
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal, Line: l.line}
-			if config.DebugMode {
+			if l.opts.DebugLexer {
 				fmt.Printf("DEBUG: Lexer produced EQ token in case '=': %v\n", tok)
 			}
 
@@ -159,13 +239,17 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.LBRACE, l.ch, l.line)
 			l.braceDepth++
 		}
-		if config.DebugMode {
+		if l.opts.DebugLexer {
 			fmt.Printf("DEBUG: Lexer identified opening brace '{', depth now %d\n", l.braceDepth)
 		}
 	case '}':
 		tok = newToken(token.RBRACE, l.ch, l.line)
 		l.braceDepth--
-		if config.DebugMode {
+		// leave the switch bodies this brace closes
+		for n := len(l.switchDepths); n > 0 && l.switchDepths[n-1] > l.braceDepth; n-- {
+			l.switchDepths = l.switchDepths[:n-1]
+		}
+		if l.opts.DebugLexer {
 			fmt.Printf("DEBUG: Lexer identified closing brace '}', depth now %d\n", l.braceDepth)
 		}
 	case '(':
@@ -194,11 +278,33 @@ func (l *Lexer) NextToken() token.Token {
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch, l.line)
 	case '<':
-		tok = newToken(token.LT, l.ch, l.line)
+		switch l.peekChar() {
+		case '<':
+			tok = l.readTwoCharToken(token.SHIFT_LEFT)
+		case '=':
+			tok = l.readTwoCharToken(token.LT_EQ)
+		default:
+			tok = newToken(token.LT, l.ch, l.line)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch, l.line)
+		switch l.peekChar() {
+		case '>':
+			tok = l.readTwoCharToken(token.SHIFT_RIGHT)
+		case '=':
+			tok = l.readTwoCharToken(token.GT_EQ)
+		default:
+			tok = newToken(token.GT, l.ch, l.line)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch, l.line)
+		if l.peekChar() == '*' {
+			tok = l.readTwoCharToken(token.POWER)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch, l.line)
+		}
+	case '~':
+		tok = newToken(token.TILDE, l.ch, l.line)
+	case '?':
+		tok = newToken(token.QUESTION, l.ch, l.line)
 	case '/':
 		tok = newToken(token.SLASH, l.ch, l.line)
 	case '-':
@@ -222,7 +328,7 @@ func (l *Lexer) NextToken() token.Token {
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.OR, Literal: literal, Line: l.line}
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch, l.line)
+			tok = newToken(token.PIPE, l.ch, l.line)
 		}
 	case '!':
 		if l.peekChar() == '=' {
@@ -233,7 +339,16 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch, l.line)
 		}
+	case '@':
+		tok.Type = token.RESOLVER_REF
+		tok.Literal = l.readBareWord()
+		return tok
 	case ':':
+		// a namespace-qualified name such as ::global
+		if l.peekChar() == ':' && l.readPosition+1 < len(l.input) && (IsLetter(l.input[l.readPosition+1]) && l.input[l.readPosition+1] != '.' && l.input[l.readPosition+1] != ':') {
+			identifier, line := l.readIdentifier()
+			return token.Token{Type: token.IDENT, Literal: identifier, Line: line}
+		}
 		if l.peekChar() == ':' {
 			ch := l.ch
 			l.readChar()
@@ -265,15 +380,23 @@ func (l *Lexer) NextToken() token.Token {
 
 		identifier, line := l.readIdentifier()
 		return token.Token{Type: token.IDENT, Literal: identifier, Line: line}
+	case '\\':
+		// an escape outside quotes, as in the regular expression {(\d+)\.html}
+		if next := l.peekChar(); next != 0 && next != ' ' && next != '\t' && next != '\n' && next != '\r' {
+			l.readChar()
+			tok = token.Token{Type: token.IDENT, Literal: "\\" + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch, l.line)
+		}
 	case 0:
 		if l.braceDepth > 0 {
-			if config.DebugMode {
+			if l.opts.DebugLexer {
 				fmt.Printf("Unexpected EOF: unclosed brace, depth: %d", l.braceDepth)
 			}
 		}
 		tok.Type = token.EOF
 		tok.Literal = ""
-		if config.DebugMode {
+		if l.opts.DebugLexer {
 			fmt.Printf("DEBUG: Lexer reached EOF at position %d\n", l.position)
 		}
 	default:
@@ -283,8 +406,13 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 		// check for identifier
-		if IsLetter(l.ch) {
+		if IsLetter(l.ch) || l.isLetterRune() {
 			tok.Literal, tok.Line = l.readIdentifier()
+			if strings.HasSuffix(tok.Literal, ":") && l.ch == '/' && l.peekChar() == '/' {
+				tok.Type = token.STRING
+				tok.Literal += l.readURLWord()
+				return tok
+			}
 			switch tok.Literal {
 			case "IP::client_addr":
 				tok.Type = token.IP_CLIENT_ADDR
@@ -314,6 +442,9 @@ func (l *Lexer) NextToken() token.Token {
 				tok.Type = token.OR
 			case "and":
 				tok.Type = token.AND
+			case "call":
+				tok.Type = token.CALL
+				l.callTarget = true
 			default:
 				tok.Type = token.LookupIdent(tok.Literal)
 			}
@@ -321,13 +452,20 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 		// everything else is an illegal token
-		l.reportError("NextToken: Illegal token found = '%c'", l.ch)
+		if l.ch >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(l.input[l.position:])
+			l.reportError(diagnostic.IllegalToken, "NextToken: Illegal token found = '%c'", r)
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position : l.position+size], Line: l.line}
+			l.skipRuneTail(size)
+			break
+		}
+		l.reportError(diagnostic.IllegalToken, "NextToken: Illegal token found = '%c'", l.ch)
 		tok = newToken(token.ILLEGAL, l.ch, l.line)
 	}
 
 	l.readChar()
 
-	if config.DebugMode {
+	if l.opts.DebugLexer {
 		fmt.Printf("DEBUG: Lexer produced token: %v. State AFTER readChar() - l.ch: %q, l.position: %d, l.readPosition: %d\n", tok, l.ch, l.position, l.readPosition)
 	}
 
@@ -337,15 +475,66 @@ func (l *Lexer) NextToken() token.Token {
 func (l *Lexer) readIdentifier() (string, int) {
 	position := l.position
 	startLine := l.line
-	for IsLetter(l.ch) || IsDigit(l.ch) || l.ch == '_' || l.ch == ':' || l.ch == '.' || l.ch == '-' {
-		if l.ch == '\n' {
-			l.line++
+	for {
+		if IsLetter(l.ch) || IsDigit(l.ch) || l.ch == '_' || l.ch == ':' || l.ch == '.' || l.ch == '-' {
+			l.readChar()
+		} else if !l.readLetterRune() {
+			break
 		}
-		l.readChar()
 	}
 	return l.input[position:l.position], startLine
 }
 
+// reads the rest of an unquoted URL such as https://[HTTP::host][HTTP::uri],
+// which Tcl treats as a single word. brackets are balanced so the command
+// substitutions in it may contain spaces
+func (l *Lexer) readURLWord() string {
+	position := l.position
+	depth := 0
+	for l.ch != 0 {
+		if l.ch == '[' {
+			depth++
+		} else if l.ch == ']' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		} else if depth == 0 && (l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' || l.ch == ';' || l.ch == '}') {
+			break
+		}
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
+
+// reports whether the current char starts a multibyte letter such as é
+func (l *Lexer) isLetterRune() bool {
+	if l.ch < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.position:])
+	return unicode.IsLetter(r)
+}
+
+// consumes a multibyte letter, keeping ascii words on the byte-wise fast path
+func (l *Lexer) readLetterRune() bool {
+	if !l.isLetterRune() {
+		return false
+	}
+	_, size := utf8.DecodeRuneInString(l.input[l.position:])
+	l.skipRuneTail(size)
+	l.readChar()
+	return true
+}
+
+// moves onto the last byte of a multibyte rune so the next readChar lands on
+// the char after it
+func (l *Lexer) skipRuneTail(size int) {
+	for i := 1; i < size; i++ {
+		l.readChar()
+	}
+}
+
 func IsLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch == ':' || ch == '.'
 }
@@ -354,8 +543,22 @@ func IsDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// skips whitespace, remembering whether a command-terminating newline was
+// crossed. a backslash-newline is a line continuation and is skipped silently.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == '\n':
+			l.lineStart = true
+		case l.ch == '\\' && (l.peekChar() == '\n' || l.peekChar() == '\r'):
+			l.readChar() // consume the backslash, the newline is consumed below
+			if l.ch == '\r' && l.peekChar() == '\n' {
+				l.readChar()
+			}
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\r':
+		default:
+			return
+		}
 		l.readChar()
 	}
 }
@@ -364,9 +567,11 @@ func (l *Lexer) skipWhitespace() {
 func (l *Lexer) skipComment() {
 	// handle single-line comments starting with # or //
 	if l.ch == '#' || (l.ch == '/' && l.peekChar() == '/') {
+		position, line := l.position, l.line
 		for l.ch != '\x00' && l.ch != '\n' {
 			l.readChar()
 		}
+		l.recordExpectation(l.input[position:l.position], line)
 		if l.ch == '\n' {
 			l.readChar() // move past the newline character
 		}
@@ -397,6 +602,57 @@ func (l *Lexer) skipComment() {
 	l.skipWhitespace()
 }
 
+// Expectation is an outcome a test fixture declares in a comment such as
+// # expect: pool api_pool
+type Expectation struct {
+	Words []string
+	Line  int
+}
+
+func (l *Lexer) recordExpectation(comment string, line int) {
+	text := strings.TrimSpace(strings.TrimLeft(comment, "#/"))
+	if !strings.HasPrefix(text, "expect:") {
+		return
+	}
+	words := expectationWords(strings.TrimPrefix(text, "expect:"))
+	if len(words) > 0 {
+		l.expectations = append(l.expectations, Expectation{Words: words, Line: line})
+	}
+}
+
+// splits the command of an expectation into words. a quoted word may hold
+// spaces and is kept whole without its quotes, as the lexer hands it out
+func expectationWords(text string) []string {
+	words := []string{}
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// returns the expectations declared in comments, in source order
+func (l *Lexer) Expectations() []Expectation {
+	return l.expectations
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
@@ -408,28 +664,84 @@ func (l *Lexer) peekChar() byte {
 func (l *Lexer) readString() string {
 	startingQuote := l.ch // capture the type of quote used to start the string
 	position := l.position + 1
-	for {
+	end := stringEnd(l.input, position, startingQuote)
+
+	for l.position < end && l.ch != 0 {
 		l.readChar()
+	}
 
-		// break if we encounter the same quote used to start the string or the end of input
-		if l.ch == startingQuote || l.ch == 0 {
-			break
-		}
+	return l.input[position:l.position]
+}
 
-		// handle escape sequences
-		if l.ch == '\\' && l.peekChar() == startingQuote {
-			l.readChar() // skip the escaped quote
+// returns the index of the quote closing a string that starts at start. quotes
+// inside a command substitution belong to the nested command, as in
+// "hits [ISTATS::get "ltm.virtual [virtual name] c hits"]"
+func stringEnd(input string, start int, quote byte) int {
+	// one entry per open command substitution, telling whether a quoted word
+	// is open inside it
+	quoted := []bool{}
+	fallback := -1
+
+	for i := start; i < len(input); i++ {
+		n := len(quoted)
+		switch input[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '[':
+			if quote == '"' {
+				quoted = append(quoted, false)
+			}
+		case ']':
+			if n > 0 && !quoted[n-1] {
+				quoted = quoted[:n-1]
+			}
+		case quote:
+			switch {
+			case n == 0:
+				return i
+			case quoted[n-1]:
+				quoted[n-1] = false
+			case !startsWord(input, start, i):
+				// a quote inside a word can't open a nested string, the
+				// substitution before it is missing its closing bracket
+				return i
+			default:
+				quoted[n-1] = true
+				if fallback == -1 {
+					fallback = i
+				}
+			}
 		}
 	}
 
-	return l.input[position:l.position]
+	// an unbalanced bracket, end the string at the first quote instead
+	if fallback != -1 {
+		return fallback
+	}
+	return len(input)
+}
+
+// reports whether the char at i starts a word of a command substitution
+func startsWord(input string, start, i int) bool {
+	if i == start {
+		return true
+	}
+	switch input[i-1] {
+	case ' ', '\t', '\n', '\r', '[', '{':
+		return true
+	}
+	return false
 }
 
 func (l *Lexer) readVariable() string {
 	position := l.position
 	l.readChar() // consume $
-	for IsLetter(l.ch) || IsDigit(l.ch) || l.ch == '_' {
-		l.readChar()
+	for {
+		if IsLetter(l.ch) || IsDigit(l.ch) || l.ch == '_' {
+			l.readChar()
+		} else if !l.readLetterRune() {
+			break
+		}
 	}
 	return l.input[position:l.position]
 }
@@ -509,6 +821,66 @@ func (l *Lexer) readIpAddress(startPosition int) token.Token {
 	}
 }
 
+// returns the length of the IPv6 address starting at the current character,
+// or 0 when there is none. the address may carry a route domain as in
+// fe80::1%2, or be bracketed and followed by a port as in [fe80::1]:443. it
+// has to hold a digit so names such as ::cafe stay names
+func (l *Lexer) ipv6Length() int {
+	if l.position >= len(l.input) {
+		return 0
+	}
+	rest := l.input[l.position:]
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 || !isIPv6(rest[1:end]) {
+			return 0
+		}
+		n := end + 1
+		if n+1 < len(rest) && rest[n] == ':' && IsDigit(rest[n+1]) {
+			n++
+			for n < len(rest) && IsDigit(rest[n]) {
+				n++
+			}
+		}
+		return n
+	}
+
+	n := 0
+	for n < len(rest) && (isHexDigit(rest[n]) || rest[n] == ':' || rest[n] == '.' || rest[n] == '%') {
+		n++
+	}
+	if n == 0 || (n < len(rest) && (IsLetter(rest[n]) || rest[n] == '_' || rest[n] == '-')) || !isIPv6(rest[:n]) {
+		return 0
+	}
+	return n
+}
+
+func isIPv6(word string) bool {
+	if !strings.ContainsAny(word, "0123456789") {
+		return false
+	}
+	address, domain, _ := strings.Cut(word, "%")
+	if _, err := strconv.Atoi(domain); domain != "" && err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(address)
+	return err == nil && ip.Is6()
+}
+
+func isHexDigit(ch byte) bool {
+	return IsDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+// reads a word such as @/Common/dns_resolver that ends at whitespace or at the
+// end of the enclosing command
+func (l *Lexer) readBareWord() string {
+	position := l.position
+	for l.ch != 0 && l.ch != ' ' && l.ch != '\t' && l.ch != '\r' && l.ch != '\n' && l.ch != ']' && l.ch != '}' && l.ch != ';' {
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
+
 func (l *Lexer) isPartOfHeaderName() bool {
 	// check if the previous token was an identifier or part of a header name
 	return l.position > 0 && (IsLetter(l.input[l.position-1]) || l.input[l.position-1] == '-')
@@ -522,14 +894,40 @@ func (l *Lexer) readHeaderName() token.Token {
 	return token.Token{Type: token.IDENT, Literal: l.input[position:l.position], Line: l.line}
 }
 
-func (l *Lexer) reportError(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	formattedMsg := "   [Lexer] " + msg + fmt.Sprintf(", Line: %d", l.line)
-	l.errors = append(l.errors, formattedMsg)
+func (l *Lexer) reportError(code diagnostic.Code, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, diagnostic.Diagnostic{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Line:    l.line,
+		Column:  l.column(),
+	})
 }
 
 func (l *Lexer) Errors() []string {
-	return l.errors
+	errors := []string{}
+	for _, d := range l.diagnostics {
+		errors = append(errors, "   [Lexer] "+d.Message+fmt.Sprintf(", Line: %d", d.Line))
+	}
+	return errors
+}
+
+func (l *Lexer) Diagnostics() []diagnostic.Diagnostic {
+	return l.diagnostics
+}
+
+// returns the input between two byte offsets, such as the text between the
+// braces of a braced word
+func (l *Lexer) Source(start, end int) string {
+	start, end = max(start, 0), min(end, len(l.input))
+	if start > end {
+		return ""
+	}
+	return l.input[start:end]
+}
+
+// returns the options the lexer was created with
+func (l *Lexer) Options() config.Options {
+	return l.opts
 }
 
 func (l *Lexer) CurrentLine() int {
@@ -544,17 +942,16 @@ func (l *Lexer) readRegexPattern() string {
 			break
 		}
 		if l.ch == 0 {
-			l.reportError("Unterminated regex pattern")
+			l.reportError(diagnostic.UnterminatedRegex, "Unterminated regex pattern")
 			return ""
 		}
 	}
 	return l.input[position:l.position]
 }
 
+// marks the brace just lexed as opening the body of a switch. comments are
+// invalid between its patterns but not inside the case bodies, and the body
+// is left when its closing brace is lexed, so switches can nest
 func (l *Lexer) EnterSwitchBlock() {
-	l.inSwitchBlock = true
-}
-
-func (l *Lexer) ExitSwitchBlock() {
-	l.inSwitchBlock = false
+	l.switchDepths = append(l.switchDepths, l.braceDepth)
 }
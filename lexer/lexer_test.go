@@ -172,6 +172,93 @@ when HTTP_REQUEST {
 	}
 }
 
+func TestNamespaceVariables(t *testing.T) {
+	input := `set ::hits 0
+set app::config::mode strict
+log local0. "$::hits" $static::maxrate $app::config::mode`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.SET, "set"},
+		{token.IDENT, "::hits"},
+		{token.NUMBER, "0"},
+		{token.SET, "set"},
+		{token.IDENT, "app::config::mode"},
+		{token.IDENT, "strict"},
+		{token.IDENT, "log"},
+		{token.IDENT, "local0."},
+		{token.STRING, "$::hits"},
+		{token.IDENT, "$static::maxrate"},
+		{token.IDENT, "$app::config::mode"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+	input := `node 2001:db8::1 443
+node [fe80::1]:8443
+node fe80::1%2
+set ::cafe ::1
+set mapped ::ffff:10.0.0.1
+set time 12:30:45`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "node"},
+		{token.IP_ADDRESS, "2001:db8::1"},
+		{token.NUMBER, "443"},
+		{token.IDENT, "node"},
+		{token.IP_ADDRESS, "[fe80::1]:8443"},
+		{token.IDENT, "node"},
+		{token.IP_ADDRESS, "fe80::1%2"},
+		{token.SET, "set"},
+		{token.IDENT, "::cafe"},
+		{token.IP_ADDRESS, "::1"},
+		{token.SET, "set"},
+		{token.IDENT, "mapped"},
+		{token.IP_ADDRESS, "::ffff:10.0.0.1"},
+		{token.SET, "set"},
+		{token.IDENT, "time"},
+		{token.NUMBER, "12"},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestEdgeCaseTokens(t *testing.T) {
 	input := `
     set uri    [HTTP::uri ]
@@ -210,3 +297,238 @@ func TestEdgeCaseTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestExprOperators(t *testing.T) {
+	input := `$a ** 2 << 1 >> 1 <= 3 >= 4 < 5 > 6 | ~$b ? 1 : 0`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "$a"},
+		{token.POWER, "**"},
+		{token.NUMBER, "2"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.NUMBER, "1"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.NUMBER, "1"},
+		{token.LT_EQ, "<="},
+		{token.NUMBER, "3"},
+		{token.GT_EQ, ">="},
+		{token.NUMBER, "4"},
+		{token.LT, "<"},
+		{token.NUMBER, "5"},
+		{token.GT, ">"},
+		{token.NUMBER, "6"},
+		{token.PIPE, "|"},
+		{token.TILDE, "~"},
+		{token.IDENT, "$b"},
+		{token.QUESTION, "?"},
+		{token.NUMBER, "1"},
+		{token.COLON, ":"},
+		{token.NUMBER, "0"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+	if diagnostics := l.Diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	input := `{(\d+)\.html}`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LBRACE, "{"},
+		{token.LPAREN, "("},
+		{token.IDENT, `\d`},
+		{token.PLUS, "+"},
+		{token.RPAREN, ")"},
+		{token.IDENT, `\.`},
+		{token.IDENT, "html"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+	if diagnostics := l.Diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestUnquotedURL(t *testing.T) {
+	input := `HTTP::redirect https://[getfield [HTTP::host] ":" 1][HTTP::uri]; [HTTP::host http://a.b]`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.HTTP_REDIRECT, "HTTP::redirect"},
+		{token.STRING, `https://[getfield [HTTP::host] ":" 1][HTTP::uri]`},
+		{token.SEMICOLON, ";"},
+		{token.LBRACKET, "["},
+		{token.HTTP_HOST, "HTTP::host"},
+		{token.STRING, "http://a.b"},
+		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%s %q, got=%s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestLineBoundaries(t *testing.T) {
+	input := `set a 1
+set b \
+    2; set c 3
+  log local0. "multi
+line" done`
+
+	tests := []struct {
+		expectedLiteral   string
+		expectedLine      int
+		expectedColumn    int
+		expectedLineStart bool
+	}{
+		{"set", 1, 1, true},
+		{"a", 1, 5, false},
+		{"1", 1, 7, false},
+		{"set", 2, 1, true},
+		{"b", 2, 5, false},
+		{"2", 3, 5, false},
+		{";", 3, 6, false},
+		{"set", 3, 8, false},
+		{"c", 3, 12, false},
+		{"3", 3, 14, false},
+		{"log", 4, 3, true},
+		{"local0.", 4, 7, false},
+		{"multi\nline", 4, 15, false},
+		{"done", 5, 7, false},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Line != tt.expectedLine {
+			t.Fatalf("tests[%d] - line wrong for %q. expected=%d, got=%d",
+				i, tok.Literal, tt.expectedLine, tok.Line)
+		}
+
+		if tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong for %q. expected=%d, got=%d",
+				i, tok.Literal, tt.expectedColumn, tok.Column)
+		}
+
+		if tok.LineStart != tt.expectedLineStart {
+			t.Fatalf("tests[%d] - line start wrong for %q. expected=%t, got=%t",
+				i, tok.Literal, tt.expectedLineStart, tok.LineStart)
+		}
+	}
+}
+
+func TestUnicodeTokens(t *testing.T) {
+	input := `set größe "héllo ✓"; log local0. café $größe ✓ done`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.SET, "set", 1},
+		{token.IDENT, "größe", 5},
+		{token.STRING, "héllo ✓", 11},
+		{token.SEMICOLON, ";", 20},
+		{token.IDENT, "log", 22},
+		{token.IDENT, "local0.", 26},
+		{token.IDENT, "café", 34},
+		{token.IDENT, "$größe", 39},
+		{token.ILLEGAL, "✓", 46},
+		{token.IDENT, "done", 48},
+		{token.EOF, "", 52},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%s %q, got=%s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong for %q. expected=%d, got=%d",
+				i, tok.Literal, tt.expectedColumn, tok.Column)
+		}
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Message != "NextToken: Illegal token found = '✓'" {
+		t.Fatalf("expected a single illegal token diagnostic for ✓, got %v", diagnostics)
+	}
+}
+
+func TestStringsWithCommandSubstitution(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{`"hits [ISTATS::get "ltm.virtual [virtual name] c hits"]" done`, `hits [ISTATS::get "ltm.virtual [virtual name] c hits"]`},
+		{`"escaped \" quote" done`, `escaped \" quote`},
+		{`"unbalanced [ bracket" done`, `unbalanced [ bracket`},
+		{"\"uri [HTTP::uri\" done\nlog local0. \"host [HTTP::host]\"", `uri [HTTP::uri`},
+		{`"[HTTP::header "X-Id"] and [HTTP::host]" done`, `[HTTP::header "X-Id"] and [HTTP::host]`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		if tok.Type != token.STRING || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong string token. expected=%q, got=%s %q", i, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		tok = l.NextToken()
+		if tok.Literal != "done" {
+			t.Fatalf("tests[%d] - expected the string to end before 'done', got %q", i, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// the procs of the rules given with --lib, loaded before any file is
+// validated and only read afterwards. calls into other rules are resolved
+// against them when it is set
+var library parser.Library
+
+// parses the library rules and registers their procs. a rule file is known
+// by its name without the extension, the ltm rules of a configuration by
+// their own names
+func loadLibrary(filenames []string) (parser.Library, error) {
+	lib := parser.Library{}
+	for _, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading library %s: %w", filename, err)
+		}
+		p := parser.New(lexer.New(string(content)))
+		p.ParseProgram()
+		lib.Add(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)), p)
+	}
+	return lib, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// the json-rpc error code of a request for a method the server doesn't
+// implement
+const lspMethodNotFound = -32601
+
+// a request, response or notification of the language server protocol
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+// the params of didOpen, didChange and didClose. documents are synced in
+// full, so the last change holds the whole text
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// a position in a document. lines count from 0, characters in utf-16 code
+// units from the start of the line
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspPublishParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+// the lsp severity of every finding severity
+var lspSeverities = map[diagnostic.Severity]int{
+	diagnostic.Error:   1,
+	diagnostic.Warning: 2,
+	diagnostic.Info:    3,
+}
+
+// serves the language server protocol over in and out until the client asks
+// it to exit. every document opened or changed is validated as a whole and
+// its findings published, so editors show them as the rule is typed. the
+// configuration files of the directory of a document apply as on the command
+// line. returns the exit code
+func runLSP(in io.Reader, out io.Writer) int {
+	// out is the protocol stream, debug output would corrupt it
+	config.SetDebug(false)
+
+	if len(config.Libraries) > 0 {
+		lib, err := loadLibrary(config.Libraries)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		library = lib
+	}
+
+	reader := bufio.NewReader(in)
+	shutdown := false
+	for {
+		msg, err := readLSPMessage(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading message: %v\n", err)
+			return 1
+		}
+
+		switch msg.Method {
+		case "initialize":
+			err = respondLSP(out, msg.ID, map[string]any{
+				"capabilities": map[string]any{
+					// open, close and full text changes
+					"textDocumentSync": map[string]any{"openClose": true, "change": 1},
+				},
+				"serverInfo": map[string]string{"name": "irule-validator"},
+			})
+		case "shutdown":
+			shutdown = true
+			err = respondLSP(out, msg.ID, nil)
+		case "exit":
+			if shutdown {
+				return 0
+			}
+			return 1
+		case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+			var params lspDocumentParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid %s params: %v\n", msg.Method, err)
+				continue
+			}
+			text := params.TextDocument.Text
+			if n := len(params.ContentChanges); n > 0 {
+				text = params.ContentChanges[n-1].Text
+			}
+			diagnostics := []lspDiagnostic{}
+			if msg.Method != "textDocument/didClose" {
+				diagnostics = lspDiagnostics(params.TextDocument.URI, text)
+			}
+			err = notifyLSP(out, "textDocument/publishDiagnostics", lspPublishParams{URI: params.TextDocument.URI, Diagnostics: diagnostics})
+		default:
+			// notifications the server has no use for are dropped
+			if len(msg.ID) > 0 {
+				err = sendLSP(out, lspMessage{JSONRPC: "2.0", ID: msg.ID, Error: &lspError{Code: lspMethodNotFound, Message: "method not found: " + msg.Method}})
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing message: %v\n", err)
+			return 1
+		}
+	}
+	if shutdown {
+		return 0
+	}
+	return 1
+}
+
+// validates the text of a document and returns its findings
+func lspDiagnostics(uri string, text string) []lspDiagnostic {
+	dir := "."
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		dir = filepath.Dir(filepath.FromSlash(u.Path))
+	}
+	overrides, err := config.LoadOverrides(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration: %v\n", err)
+	}
+
+	p := parser.New(lexer.NewWithOptions(text, overrides.Apply(config.CurrentOptions())))
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if library != nil {
+		diagnostics = append(diagnostics, parser.ResolveLibraryCalls(p.ProcCalls(), library)...)
+	}
+	diagnostics = applyOverrides(diagnostic.Normalize(diagnostics), overrides)
+
+	lines := strings.Split(text, "\n")
+	converted := []lspDiagnostic{}
+	for _, d := range diagnostic.Filter(diagnostics, config.OnlyPhases) {
+		severity := lspSeverities[d.Severity]
+		if severity == 0 {
+			severity = lspSeverities[diagnostic.Error]
+		}
+		converted = append(converted, lspDiagnostic{
+			Range:    lspWordRange(lines, d.Line, d.Column),
+			Severity: severity,
+			Code:     string(d.Code),
+			Source:   "irule-validator",
+			Message:  d.Message,
+		})
+	}
+	return converted
+}
+
+// returns the range of the word a finding is reported at, given the line and
+// the column in characters counting from 1. a finding without a column
+// covers its whole line
+func lspWordRange(lines []string, line, column int) lspRange {
+	if line < 1 {
+		line = 1
+	}
+	if line > len(lines) {
+		line = len(lines)
+	}
+	text := []rune(strings.TrimSuffix(lines[line-1], "\r"))
+
+	start, end := 0, len(text)
+	if column > 0 {
+		start = min(column-1, len(text))
+		end = start
+		for end < len(text) && !unicode.IsSpace(text[end]) {
+			end++
+		}
+	}
+	return lspRange{
+		Start: lspPosition{Line: line - 1, Character: utf16Length(text[:start])},
+		End:   lspPosition{Line: line - 1, Character: utf16Length(text[:end])},
+	}
+}
+
+func utf16Length(runes []rune) int {
+	return len(utf16.Encode(runes))
+}
+
+// reads a message framed by a Content-Length header
+func readLSPMessage(r *bufio.Reader) (lspMessage, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return lspMessage{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return lspMessage{}, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return lspMessage{}, fmt.Errorf("message without a Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return lspMessage{}, err
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return lspMessage{}, fmt.Errorf("invalid message: %w", err)
+	}
+	return msg, nil
+}
+
+// answers a request. a nil result is sent as null, which shutdown expects
+func respondLSP(out io.Writer, id json.RawMessage, result any) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return sendLSP(out, lspMessage{JSONRPC: "2.0", ID: id, Result: data})
+}
+
+// sends a notification
+func notifyLSP(out io.Writer, method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return sendLSP(out, lspMessage{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+func sendLSP(out io.Writer, msg lspMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/elkrammer/irule-validator/ast"
 	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/elkrammer/irule-validator/lexer"
 	"github.com/elkrammer/irule-validator/parser"
 	"github.com/elkrammer/irule-validator/repl"
@@ -17,44 +26,441 @@ func main() {
 	args := pflag.Args()
 
 	if len(args) == 0 {
-		config.DebugMode = true
-		repl.Start(os.Stdin, os.Stdout)
+		if !stdinIsPiped() {
+			repl.Start(os.Stdin, os.Stdout)
+			return
+		}
+		// a rule piped in is validated like a file instead of filling the pipe
+		// with prompts and debug output
+		args = []string{stdinName}
+	}
+
+	if args[0] == "selftest" {
+		if runSelftest(os.Stdout) > 0 {
+			os.Exit(1)
+		}
 		return
 	}
 
-	filename := args[0]
+	if args[0] == "extract" {
+		os.Exit(runExtract(os.Stdout, args[1:]))
+	}
 
-	content, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Printf("Error reading file :%v\n", err)
+	if args[0] == "rename" {
+		os.Exit(runRename(os.Stdout, args[1:]))
+	}
+
+	if args[0] == "new" {
+		os.Exit(runNew(os.Stdin, os.Stdout, args[1:]))
+	}
+
+	if args[0] == "lsp" {
+		os.Exit(runLSP(os.Stdin, os.Stdout))
+	}
+
+	if args[0] == "config" {
+		os.Exit(runConfig(os.Stdout, args[1:]))
+	}
+
+	if config.MaxMemory > 0 {
+		// make the GC work harder before we get anywhere near the ceiling
+		debug.SetMemoryLimit(config.MaxMemory)
+	}
+
+	filenames := expandFileArgs(args)
+	if config.FormatRules || config.FormatCheck {
+		os.Exit(runFormat(os.Stdout, filenames))
+	}
+	if config.DumpAST {
+		os.Exit(runAST(os.Stdout, filenames))
+	}
+
+	if len(config.Libraries) > 0 {
+		lib, err := loadLibrary(config.Libraries)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		library = lib
+	}
+
+	progress := newProgressReporter(os.Stderr, config.Progress, len(filenames))
+	findings := []diagnostic.Diagnostic{}
+	outlines := []fileOutline{}
+	summary := runSummary{files: len(filenames)}
+
+	workers := runtime.NumCPU()
+	if config.DebugMode {
+		// keep the debug output of different files apart
+		workers = 1
+	}
+
+	validateFiles(filenames, workers, progress, func(filename string, result fileResult) bool {
+		textOutput().Write(result.output)
+		findings = append(findings, result.findings...)
+		if config.Format == "outline" {
+			outlines = append(outlines, fileOutline{file: filename, items: result.outline, stats: result.stats})
+		}
+		summary.add(filename, result.status)
+		summary.warnings += result.warnings
+		summary.addNamespaces(result.namespaces)
+		return !config.FailFast || result.status == statusPassed
+	})
+
+	if config.Format == "text" && len(filenames) > 1 {
+		if config.Recursive {
+			summary.writeTable(os.Stdout)
+		}
+		fmt.Println(summary)
+		if len(summary.namespaces) > 0 {
+			fmt.Println(summary.namespaceSummary())
+		}
+		if len(findings) > 0 {
+			writeCodeTable(os.Stdout, findings)
+		}
+	}
+
+	if config.Format == "json" {
+		if err := writeJSONDiagnostics(os.Stdout, findings); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.Format == "outline" {
+		if err := writeJSONOutline(os.Stdout, outlines); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if summary.tooManyWarnings() {
+		fmt.Fprintf(textOutput(), "❌ Found %d warnings, more than --max-warnings %d allows\n", summary.warnings, config.MaxWarnings)
+	}
+
+	if !summary.passed() || summary.tooManyWarnings() {
 		os.Exit(1)
 	}
+}
+
+// counts the outcome of every validated file, in total and per directory
+type runSummary struct {
+	files    int
+	checked  int
+	warnings int
+	counts   map[fileStatus]int
+	dirs     []string
+	byDir    map[string]map[fileStatus]int
+	// how often every unknown command namespace was used, revealing commands
+	// the validator has no spec for yet
+	namespaces map[string]int
+}
+
+func (s *runSummary) add(filename string, status fileStatus) {
+	if s.counts == nil {
+		s.counts = map[fileStatus]int{}
+		s.byDir = map[string]map[fileStatus]int{}
+	}
+	s.checked++
+	s.counts[status]++
+
+	dir := filepath.Dir(filename)
+	if s.byDir[dir] == nil {
+		s.dirs = append(s.dirs, dir)
+		s.byDir[dir] = map[fileStatus]int{}
+	}
+	s.byDir[dir][status]++
+}
+
+func (s *runSummary) addNamespaces(namespaces map[string]int) {
+	if s.namespaces == nil {
+		s.namespaces = map[string]int{}
+	}
+	for namespace, count := range namespaces {
+		s.namespaces[namespace] += count
+	}
+}
+
+// lists the unknown command namespaces, the most used first
+func (s runSummary) namespaceSummary() string {
+	namespaces := []string{}
+	for namespace := range s.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if s.namespaces[namespaces[i]] != s.namespaces[namespaces[j]] {
+			return s.namespaces[namespaces[i]] > s.namespaces[namespaces[j]]
+		}
+		return namespaces[i] < namespaces[j]
+	})
+
+	counts := []string{}
+	for _, namespace := range namespaces {
+		counts = append(counts, fmt.Sprintf("%s (%d)", namespace, s.namespaces[namespace]))
+	}
+	return "Unknown command namespaces: " + strings.Join(counts, ", ")
+}
+
+func (s runSummary) passed() bool {
+	return s.counts[statusPassed] == s.checked
+}
+
+func (s runSummary) tooManyWarnings() bool {
+	return config.MaxWarnings >= 0 && s.warnings > config.MaxWarnings
+}
+
+// prints the pass/fail counts of every directory that held a rule
+func (s runSummary) writeTable(out io.Writer) {
+	dirs := append([]string{}, s.dirs...)
+	sort.Strings(dirs)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DIRECTORY\tPASSED\tFAILED\tSKIPPED")
+	for _, dir := range dirs {
+		counts := s.byDir[dir]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", dir, counts[statusPassed], counts[statusFailed], counts[statusSkipped])
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\n", s.counts[statusPassed], s.counts[statusFailed], s.counts[statusSkipped])
+	w.Flush()
+}
+
+// prints how often every code was reported and in how many files, the most
+// frequent first
+func writeCodeTable(out io.Writer, findings []diagnostic.Diagnostic) {
+	counts := map[diagnostic.Code]int{}
+	files := map[diagnostic.Code]map[string]bool{}
+	for _, d := range findings {
+		if files[d.Code] == nil {
+			files[d.Code] = map[string]bool{}
+		}
+		counts[d.Code]++
+		files[d.Code][d.File] = true
+	}
+
+	codes := []diagnostic.Code{}
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tPHASE\tCOUNT\tFILES")
+	for _, code := range codes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", code, code.Phase(), counts[code], len(files[code]))
+	}
+	w.Flush()
+}
+
+func (s runSummary) String() string {
+	validated := fmt.Sprintf("%d files", s.checked)
+	if s.checked < s.files {
+		validated = fmt.Sprintf("%d of %d files (stopped at the first failure)", s.checked, s.files)
+	}
+	return fmt.Sprintf("Validated %s: %d passed, %d failed, %d skipped",
+		validated, s.counts[statusPassed], s.counts[statusFailed], s.counts[statusSkipped])
+}
+
+type fileStatus string
+
+const (
+	statusPassed  fileStatus = "passed"
+	statusFailed  fileStatus = "failed"
+	statusSkipped fileStatus = "skipped"
+)
+
+type fileResult struct {
+	status      fileStatus
+	diagnostics int
+	warnings    int
+	findings    []diagnostic.Diagnostic // the diagnostics selected with --only
+	output      []byte                  // text printed for the file once its turn comes
+	outline     []ast.OutlineItem       // the constructs of the file with --format outline
+	stats       parser.Stats            // the size and nesting of the file with --format outline
+	namespaces  map[string]int          // how often every unknown command namespace was used
+}
+
+// validates a single file. the text result is buffered so files validated
+// concurrently are still printed in order; nothing but read errors is
+// printed when the results are written as JSON or as an outline once every
+// file is done
+func validateFile(filename string) fileResult {
+	text := config.Format == "text"
+	var out bytes.Buffer
+
+	content, skipped, err := readInput(filename)
+	if err != nil {
+		fmt.Fprintf(&out, "Error reading file :%v\n", err)
+		return fileResult{status: statusSkipped, output: out.Bytes()}
+	}
+
+	if skipped != nil {
+		skipped.File = filename
+		findings := diagnostic.Filter([]diagnostic.Diagnostic{*skipped}, config.OnlyPhases)
+		if text {
+			fmt.Fprintf(&out, "⚠️ Skipped irule %v\n", filename)
+			printParserErrors(&out, findings)
+		}
+		return fileResult{status: statusSkipped, diagnostics: 1, findings: findings, output: out.Bytes()}
+	}
 
 	if config.DebugMode {
 		fmt.Printf("DEBUG: Input content:\n%s\n", string(content))
 	}
 
-	l := lexer.New(string(content))
+	overrides, err := config.LoadOverrides(overridesDir(filename))
+	if err != nil {
+		fmt.Fprintf(&out, "Error reading configuration: %v\n", err)
+		return fileResult{status: statusSkipped, output: out.Bytes()}
+	}
+
+	if config.ExtractRules || isArchivedConfig(filename) {
+		return validateConfig(filename, string(content), overrides)
+	}
+
+	result := checkRule(&out, string(content), "irule "+filename, overrides, func(d *diagnostic.Diagnostic) {
+		d.File = filename
+	})
+	status := statusPassed
+	if result.failed {
+		status = statusFailed
+	}
+	return fileResult{status: status, diagnostics: result.diagnostics, warnings: result.warnings, findings: result.findings, output: out.Bytes(), outline: result.outline, stats: result.stats, namespaces: result.namespaces}
+}
+
+// the outcome of validating a single rule
+type ruleResult struct {
+	failed      bool
+	diagnostics int
+	warnings    int
+	findings    []diagnostic.Diagnostic // the diagnostics selected with --only
+	outline     []ast.OutlineItem
+	stats       parser.Stats
+	namespaces  map[string]int
+}
+
+// parses a rule and prints its result, naming the rule by subject. locate
+// records where each finding was made, overrides come from the configuration
+// files of the rule's directory
+func checkRule(out io.Writer, content string, subject string, overrides config.Overrides, locate func(*diagnostic.Diagnostic)) ruleResult {
+	text := config.Format == "text"
+
+	l := lexer.NewWithOptions(content, overrides.Apply(config.CurrentOptions()))
 	p := parser.New(l)
 
-	p.ParseProgram()
+	program := p.ParseProgram()
 
-	errors := p.Errors()
+	diagnostics := p.Diagnostics()
+	if library != nil {
+		diagnostics = append(diagnostics, parser.ResolveLibraryCalls(p.ProcCalls(), library)...)
+	}
+	diagnostics = applyOverrides(diagnostic.Normalize(diagnostics), overrides)
+	for i := range diagnostics {
+		locate(&diagnostics[i])
+	}
+	findings := diagnostic.Filter(diagnostics, config.OnlyPhases)
+	verbose := text && (config.PrintErrors || config.DebugMode)
+	warnings := diagnostic.CountWarnings(diagnostics)
+	errors := diagnostic.HasErrors(diagnostics)
+	failed := errors || (config.Strict && warnings > 0)
 
-	if len(errors) > 0 {
-		fmt.Printf("❌ Errors parsing irule %v\n", filename)
-		if config.PrintErrors || config.DebugMode {
-			printParserErrors(os.Stdout, p.Errors())
+	if text {
+		if errors {
+			fmt.Fprintf(out, "❌ Errors parsing %v\n", subject)
+		} else if failed {
+			fmt.Fprintf(out, "❌ Warnings in %v (--strict)\n", subject)
+		} else {
+			fmt.Fprintf(out, "✅ Successfully parsed %v\n", subject)
 		}
-		os.Exit(1)
+	}
+	if verbose {
+		// without errors only warnings and notes are left
+		printParserErrors(out, findings)
+		printEventOrder(out, p.EventHandlers())
+	}
+	if text && config.Metrics {
+		printMetrics(out, ruleStats(p, program))
+	}
+	result := ruleResult{failed: failed, diagnostics: len(diagnostics), warnings: warnings, findings: findings, namespaces: p.UnknownNamespaces()}
+	if config.Format == "outline" {
+		result.outline = ast.Outline(program)
+		result.stats = ruleStats(p, program)
+	}
+	return result
+}
+
+// changes the severity of the findings listed in the configuration files and
+// drops the ones turned off
+func applyOverrides(diagnostics []diagnostic.Diagnostic, overrides config.Overrides) []diagnostic.Diagnostic {
+	if len(overrides.Severity) == 0 {
+		return diagnostics
 	}
 
-	fmt.Printf("✅ Successfully parsed irule %v\n", filename)
+	kept := []diagnostic.Diagnostic{}
+	for _, d := range diagnostics {
+		severity, ok := overrides.Severity[d.Code]
+		if !ok {
+			kept = append(kept, d)
+			continue
+		}
+		if severity == "off" {
+			continue
+		}
+		d.Severity = diagnostic.Severity(severity)
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// keeps stdout clean for JSON results
+func textOutput() io.Writer {
+	if config.Format != "text" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// prints the execution order of events that are handled more than once. the
+// handlers of every ltm rule are ordered separately
+func printEventOrder(out io.Writer, handlers []parser.EventHandler) {
+	rules := []string{}
+	byRule := map[string][]parser.EventHandler{}
+	for _, handler := range handlers {
+		if _, seen := byRule[handler.Rule]; !seen {
+			rules = append(rules, handler.Rule)
+		}
+		byRule[handler.Rule] = append(byRule[handler.Rule], handler)
+	}
+
+	for _, rule := range rules {
+		order := parser.EventOrder(byRule[rule])
+		events := []string{}
+		for event := range order {
+			events = append(events, event)
+		}
+		sort.Strings(events)
+
+		for _, event := range events {
+			steps := []string{}
+			for _, handler := range order[event] {
+				steps = append(steps, fmt.Sprintf("line %d (priority %d)", handler.Line, handler.Priority))
+			}
+			label := event
+			if rule != "" {
+				label = rule + " " + event
+			}
+			fmt.Fprintf(out, "   %s runs in order: %s\n", label, strings.Join(steps, ", "))
+		}
+	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, msg)
+func printParserErrors(out io.Writer, diagnostics []diagnostic.Diagnostic) {
+	for _, d := range diagnostics {
+		io.WriteString(out, "   "+d.String())
 		io.WriteString(out, "\n")
 	}
 }
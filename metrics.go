@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// gathers the statistics parser.Validate reports for an already parsed rule
+func ruleStats(p *parser.Parser, program *ast.Program) parser.Stats {
+	stats := parser.CollectStats(program)
+	stats.Events = len(p.EventHandlers())
+	stats.Procs = len(p.Procs())
+	stats.Expensive = p.EventCosts()
+	return stats
+}
+
+// prints the size of a rule and the expensive constructs of its events, the
+// events most likely to cost CPU first
+func printMetrics(out io.Writer, stats parser.Stats) {
+	fmt.Fprintf(out, "   Metrics: %d statements, %d events, %d procs\n", stats.Statements, stats.Events, stats.Procs)
+
+	namespaces := []string{}
+	for namespace := range stats.Commands {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	if len(namespaces) > 0 {
+		counts := []string{}
+		for _, namespace := range namespaces {
+			counts = append(counts, fmt.Sprintf("%s %d", namespace, stats.Commands[namespace]))
+		}
+		fmt.Fprintf(out, "   Commands: %s\n", strings.Join(counts, ", "))
+	}
+
+	costs := []parser.EventCost{}
+	for _, cost := range stats.Expensive {
+		if cost.Score() > 0 {
+			costs = append(costs, cost)
+		}
+	}
+	if len(costs) == 0 {
+		fmt.Fprintf(out, "   Expensive operations: none\n")
+		return
+	}
+	sort.SliceStable(costs, func(i, j int) bool { return costs[i].Score() > costs[j].Score() })
+
+	fmt.Fprintf(out, "   Expensive operations:\n")
+	for _, cost := range costs {
+		event := cost.Event
+		if cost.Rule != "" {
+			event = cost.Rule + " " + event
+		}
+		fmt.Fprintf(out, "      %s (line %d): score %d (%s)\n", event, cost.Line, cost.Score(), describeCost(cost))
+	}
+}
+
+func describeCost(cost parser.EventCost) string {
+	parts := []string{}
+	for _, construct := range []struct {
+		count int
+		name  string
+	}{
+		{cost.Regex, "regex"},
+		{cost.Table, "table"},
+		{cost.ClassSearch, "class search"},
+		{cost.StringMap, "string map"},
+		{cost.NestedLoops, "nested loop"},
+	} {
+		if construct.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", construct.count, construct.name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// skeleton rules of common patterns, filled in with the answers to the
+// prompts of the new command
+//
+//go:embed templates
+var ruleTemplates embed.FS
+
+var (
+	ruleNameRegex    = regexp.MustCompile(`^[\w.-]+$`)
+	hostRegex        = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+	poolRegex        = regexp.MustCompile(`^(/[\w.-]+)*/?[\w.-]+$`)
+	headerRegex      = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	headerValueRegex = regexp.MustCompile(`^[^"\[\]$\\]*$`)
+)
+
+// a value the new command prompts for
+type templateParam struct {
+	field    string
+	prompt   string
+	fallback string
+	valid    *regexp.Regexp
+}
+
+var templateParams = map[string][]templateParam{
+	"redirect": {
+		{"Host", "Host to redirect", "www.example.com", hostRegex},
+		{"Target", "Host to redirect to", "example.com", hostRegex},
+	},
+	"maintenance": {
+		{"Pool", "Pool serving the site", "web_pool", poolRegex},
+		{"Host", "Site named on the maintenance page", "www.example.com", hostRegex},
+	},
+	"header-insert": {
+		{"Header", "Header to insert", "X-Forwarded-Proto", headerRegex},
+		{"Value", "Header value", "https", headerValueRegex},
+		{"Pool", "Pool to send requests to", "web_pool", poolRegex},
+	},
+}
+
+// writes a skeleton rule of the given pattern to <name>.irule, prompting for
+// its parameters on stderr. the rule is validated before it is written.
+// returns the exit code
+func runNew(in io.Reader, out io.Writer, args []string) int {
+	patterns := []string{}
+	for pattern := range templateParams {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	if len(args) != 1 || templateParams[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: %s new %s [--name <rule>]\n", os.Args[0], strings.Join(patterns, "|"))
+		return 2
+	}
+	pattern := args[0]
+
+	name := config.RuleName
+	if name == "" {
+		name = pattern
+	}
+	if !ruleNameRegex.MatchString(name) {
+		fmt.Fprintf(os.Stderr, "Invalid rule name %q\n", name)
+		return 2
+	}
+	filename := name + ".irule"
+	if _, err := os.Stat(filename); !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%s already exists\n", filename)
+		return 1
+	}
+
+	values := map[string]string{"Name": name}
+	answers := bufio.NewScanner(in)
+	for _, param := range templateParams[pattern] {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", param.prompt, param.fallback)
+		value := ""
+		if answers.Scan() {
+			value = strings.TrimSpace(answers.Text())
+		} else {
+			fmt.Fprintln(os.Stderr)
+		}
+		if value == "" {
+			value = param.fallback
+		}
+		if !param.valid.MatchString(value) {
+			fmt.Fprintf(os.Stderr, "Invalid value %q for %s\n", value, strings.ToLower(param.prompt))
+			return 1
+		}
+		values[param.field] = value
+	}
+
+	rule, err := renderTemplate(pattern, values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering template :%v\n", err)
+		return 1
+	}
+
+	result := parser.Validate(rule)
+	if diagnostic.HasErrors(result.Diagnostics) {
+		fmt.Fprintf(os.Stderr, "❌ The %s rule doesn't validate with these values\n", pattern)
+		printParserErrors(os.Stderr, result.Diagnostics)
+		return 1
+	}
+
+	if err := os.WriteFile(filename, []byte(rule), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing file :%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "✅ Created %s from the %s template\n", filename, pattern)
+	return 0
+}
+
+func renderTemplate(pattern string, values map[string]string) (string, error) {
+	text, err := ruleTemplates.ReadFile("templates/" + pattern + ".irule")
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(pattern).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return "", err
+	}
+
+	var rule bytes.Buffer
+	if err := tmpl.Execute(&rule, values); err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
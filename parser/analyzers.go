@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// Analyzer is a check of its own a program embedding the validator runs on
+// the parse tree of every rule, such as the conventions of an organization.
+// its findings are reported along with those of the validator
+type Analyzer interface {
+	Name() string
+	Check(program *ast.Program) []diagnostic.Diagnostic
+}
+
+// the registered analyzers, in the order they run
+var analyzerRegistry = []Analyzer{}
+
+// RegisterAnalyzer adds an analyzer run on every rule parsed from then on,
+// replacing any analyzer of the same name. analyzers are usually registered
+// from an init function
+func RegisterAnalyzer(a Analyzer) {
+	for i, registered := range analyzerRegistry {
+		if registered.Name() == a.Name() {
+			analyzerRegistry[i] = a
+			return
+		}
+	}
+	analyzerRegistry = append(analyzerRegistry, a)
+}
+
+// UnregisterAnalyzer removes the analyzer of the given name
+func UnregisterAnalyzer(name string) {
+	kept := []Analyzer{}
+	for _, a := range analyzerRegistry {
+		if a.Name() != name {
+			kept = append(kept, a)
+		}
+	}
+	analyzerRegistry = kept
+}
+
+// Analyzers returns the registered analyzers in the order they run
+func Analyzers() []Analyzer {
+	return append([]Analyzer{}, analyzerRegistry...)
+}
+
+// runs the registered analyzers on the parsed rule, but for those turned off.
+// a finding without a code is reported as CustomCheck, naming the analyzer
+// that made it
+func (p *Parser) runAnalyzers(program *ast.Program) {
+	for _, a := range analyzerRegistry {
+		if containsString(p.opts.DisabledChecks, a.Name()) {
+			continue
+		}
+		for _, d := range a.Check(program) {
+			if d.Code == "" {
+				d.Code = diagnostic.CustomCheck
+				d.Message = fmt.Sprintf("%s: %s", a.Name(), d.Message)
+			}
+			p.diagnostics = append(p.diagnostics, d)
+		}
+	}
+}
+
+// drops the findings whose codes were turned off
+func (p *Parser) dropDisabledChecks() {
+	if len(p.opts.DisabledChecks) == 0 {
+		return
+	}
+	kept := []diagnostic.Diagnostic{}
+	for _, d := range p.diagnostics {
+		if !containsString(p.opts.DisabledChecks, string(d.Code)) {
+			kept = append(kept, d)
+		}
+	}
+	p.diagnostics = kept
+}
+
+// reports whether a command was listed as a custom command of the
+// organization
+func (p *Parser) isCustomCommand(name string) bool {
+	return containsString(p.opts.CustomCommands, name)
+}
+
+// reports whether a header name was listed as a custom header
+func (p *Parser) isCustomHeader(name string) bool {
+	for _, header := range p.opts.CustomHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the subcommands of array and the arguments each takes after the array name
+var arraySubcommands = subcommandArgs{
+	"anymore": {2, 2}, "donesearch": {2, 2}, "exists": {1, 1}, "get": {1, 2},
+	"names": {1, 2}, "nextelement": {2, 2}, "set": {2, 2}, "size": {1, 1},
+	"startsearch": {1, 1}, "statistics": {1, 1}, "unset": {1, 2},
+}
+
+// parses the key of an array element following its name, as in $counts(total)
+// or $state($client_ip). the key runs up to the matching parenthesis and may
+// embed variables and commands, which are substituted as in a quoted string
+func (p *Parser) parseArrayAccess(name *ast.Identifier) ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseArrayAccess Start - Array: %s\n", name.Value)
+	}
+	access := &ast.ArrayAccess{Token: name.Token, Name: name}
+
+	p.nextToken() // move to '('
+	open := p.curToken
+	depth := 1
+	for depth > 0 {
+		if p.peekTokenIs(token.EOF) || p.peekIsCommandEnd() {
+			p.reportError("parseArrayAccess: missing ) after the key of array %s", name.Value)
+			return access
+		}
+		p.nextToken()
+		switch {
+		case p.curTokenIs(token.LPAREN):
+			depth++
+		case p.curTokenIs(token.RPAREN):
+			depth--
+		}
+	}
+
+	key := p.l.Source(open.Offset+1, p.curToken.Offset)
+	access.Key = p.parseArrayKey(token.Token{Type: token.STRING, Literal: key, Line: open.Line, Column: open.Column, Offset: open.Offset})
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseArrayAccess End - %s\n", access.String())
+	}
+	return access
+}
+
+// returns the index of the parenthesis closing the one at start, or -1 when
+// there is no parenthesis at start
+func matchingParen(value string, start int) int {
+	if start >= len(value) || value[start] != '(' {
+		return -1
+	}
+	depth := 0
+	for i := start; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// returns the element of an array referenced in a quoted string, whose key
+// lies between the parentheses at open and end of the string value
+func (p *Parser) parseEmbeddedArrayAccess(name *ast.Identifier, tok token.Token, value string, open, end int) ast.Expression {
+	keyToken := embeddedToken(tok, value, open)
+	keyToken.Literal = value[open+1 : end]
+	return &ast.ArrayAccess{Token: name.Token, Name: name, Key: p.parseArrayKey(keyToken)}
+}
+
+// parses the key of an array element. the token is placed on the opening
+// parenthesis and holds the key. variables and commands in the key are
+// substituted as in a quoted string
+func (p *Parser) parseArrayKey(tok token.Token) ast.Expression {
+	if strings.ContainsAny(tok.Literal, "[\\") || stringVariableRegex.MatchString(tok.Literal) {
+		if key := p.parseInterpolatedString(tok, tok.Literal); key != nil {
+			return key
+		}
+	}
+	return &ast.StringLiteral{Token: tok, Value: tok.Literal}
+}
+
+// reports whether the current word names an element of an array: a
+// parenthesis follows it without a space
+func (p *Parser) peekIsArrayKey() bool {
+	return p.peekTokenIs(token.LPAREN) && p.peekIsAdjacent()
+}
+
+// array subcommand arrayName ?arg ...?. array set declares the array
+func checkArray(p *Parser, cmd *ast.CommandInvocation) {
+	checkSubcommand(arraySubcommands)(p, cmd)
+	if len(cmd.Arguments) > 1 && isWord(cmd.Arguments[0], "set") {
+		if name, ok := literalWord(cmd.Arguments[1]); ok {
+			p.declareSetVariable(name)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// a conversion of a format or scan string. position is the argument it
+// consumes with %n$ specifiers, 0 otherwise
+type conversion struct {
+	verb       byte
+	position   int
+	suppressed bool // scan %*d, which assigns no variable
+	stars      int  // format * widths and precisions, each taking an argument
+}
+
+const (
+	formatVerbs = "duioxXcsfeEgGb"
+	scanVerbs   = "duioxXcsfeEgG[n"
+)
+
+// parses the conversion specifiers of a format or scan string. returns the
+// message tcl gives for a malformed one
+func parseConversions(format string, scan bool) ([]conversion, string) {
+	verbs := formatVerbs
+	if scan {
+		verbs = scanVerbs
+	}
+
+	var conversions []conversion
+	positional, sequential := false, false
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+
+		var c conversion
+		if scan && i < len(format) && format[i] == '*' {
+			c.suppressed = true
+			i++
+		}
+		start := i
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			i++
+		}
+		if i < len(format) && format[i] == '$' && i > start {
+			c.position, _ = strconv.Atoi(format[start:i])
+			i++
+		} else {
+			i = start
+		}
+		if !c.suppressed {
+			if c.position > 0 {
+				positional = true
+			} else {
+				sequential = true
+			}
+		}
+
+		for !scan && i < len(format) && strings.IndexByte("-+ 0#", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (format[i] >= '0' && format[i] <= '9' || !scan && format[i] == '*') {
+			if format[i] == '*' {
+				c.stars++
+			}
+			i++
+		}
+		if !scan && i < len(format) && format[i] == '.' {
+			i++
+			for i < len(format) && (format[i] >= '0' && format[i] <= '9' || format[i] == '*') {
+				if format[i] == '*' {
+					c.stars++
+				}
+				i++
+			}
+		}
+		for i < len(format) && strings.IndexByte("hlL", format[i]) >= 0 {
+			i++
+		}
+
+		if i >= len(format) {
+			return nil, "format string ended in middle of field specifier"
+		}
+		c.verb = format[i]
+		if strings.IndexByte(verbs, c.verb) < 0 {
+			if scan {
+				return nil, fmt.Sprintf("bad scan conversion character \"%c\"", c.verb)
+			}
+			return nil, fmt.Sprintf("bad field specifier \"%c\"", c.verb)
+		}
+		if c.verb == '[' {
+			// a leading ] or ^] belongs to the set
+			i++
+			if i < len(format) && format[i] == '^' {
+				i++
+			}
+			if i < len(format) && format[i] == ']' {
+				i++
+			}
+			end := strings.IndexByte(format[i:], ']')
+			if end < 0 {
+				return nil, "unmatched [ in format string"
+			}
+			i += end
+		}
+		conversions = append(conversions, c)
+	}
+
+	if positional && sequential {
+		return nil, "cannot mix \"%\" and \"%n$\" conversion specifiers"
+	}
+	return conversions, ""
+}
+
+// returns the number of arguments the conversions consume
+func conversionArgs(conversions []conversion) int {
+	count := 0
+	for _, c := range conversions {
+		switch {
+		case c.suppressed:
+		case c.position > 0:
+			count = max(count, c.position)
+		default:
+			count += 1 + c.stars
+		}
+	}
+	return count
+}
+
+// format formatString ?arg ...?
+func checkFormat(p *Parser, cmd *ast.CommandInvocation) {
+	format, ok := literalWord(cmd.Arguments[0])
+	if !ok {
+		return
+	}
+	conversions, err := parseConversions(format, false)
+	if err != "" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "%s", []any{err, cmd.Token}...)
+		return
+	}
+
+	args := cmd.Arguments[1:]
+	expected := conversionArgs(conversions)
+	switch {
+	case len(args) < expected:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "not enough arguments for all format specifiers, expected %d, got %d", []any{expected, len(args), cmd.Token}...)
+		return
+	case len(args) > expected:
+		p.reportWarning(diagnostic.InvalidCommand, "format string \"%s\" uses %d of its %d arguments", []any{format, expected, len(args), cmd.Token}...)
+	}
+
+	// the literal arguments of numeric conversions have to be numbers
+	next := 0
+	for _, c := range conversions {
+		index := next + c.stars
+		if c.position > 0 {
+			index = c.position - 1
+		} else {
+			next += 1 + c.stars
+		}
+		word, ok := literalWord(args[index])
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.IndexByte("duioxXcb", c.verb) >= 0:
+			if _, err := strconv.ParseInt(word, 0, 64); err != nil {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "expected integer but got \"%s\" for %%%c", []any{word, c.verb, cmd.Token}...)
+			}
+		case strings.IndexByte("feEgG", c.verb) >= 0:
+			if _, err := strconv.ParseFloat(word, 64); err != nil {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "expected floating-point number but got \"%s\" for %%%c", []any{word, c.verb, cmd.Token}...)
+			}
+		}
+	}
+}
+
+// scan string format ?varName ...?. without variables the values are
+// returned as a list
+func checkScan(p *Parser, cmd *ast.CommandInvocation) {
+	format, ok := literalWord(cmd.Arguments[1])
+	if !ok {
+		return
+	}
+	conversions, err := parseConversions(format, true)
+	if err != "" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "%s", []any{err, cmd.Token}...)
+		return
+	}
+
+	variables := len(cmd.Arguments) - 2
+	if variables == 0 {
+		return
+	}
+	if expected := conversionArgs(conversions); variables != expected {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "different numbers of variable names and field specifiers, expected %d, got %d", []any{expected, variables, cmd.Token}...)
+	}
+}
+
+// the arguments of each clock subcommand and the options it takes, keyed by
+// option name with whether the option takes a value
+var clockSubcommands = map[string]struct {
+	operands int
+	options  map[string]bool
+}{
+	"clicks":       {0, map[string]bool{"-milliseconds": false, "-microseconds": false}},
+	"format":       {1, map[string]bool{"-format": true, "-gmt": true}},
+	"microseconds": {0, nil},
+	"milliseconds": {0, nil},
+	"scan":         {1, map[string]bool{"-base": true, "-gmt": true}},
+	"seconds":      {0, nil},
+}
+
+// the conversions clock format understands
+const clockFormatVerbs = "aAbBcCdDeEgGhHIjJklmMnNOpQrRsStTuUVwWxXyYzZ%+"
+
+// clock seconds | clock clicks ?-milliseconds? | clock format clockValue
+// ?-format string? ?-gmt boolean? | clock scan dateString ?-base clockVal?
+// ?-gmt boolean?
+func checkClock(p *Parser, cmd *ast.CommandInvocation) {
+	name, ok := literalWord(cmd.Arguments[0])
+	if !ok {
+		return
+	}
+	subcommand, ok := clockSubcommands[name]
+	if !ok {
+		valid := []string{}
+		for name := range clockSubcommands {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for %s, expected one of %s", []any{name, cmd.Command, strings.Join(valid, ", "), cmd.Token}...)
+		return
+	}
+
+	args := cmd.Arguments[1:]
+	if len(args) < subcommand.operands {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: clock %s expects %d argument(s) before its options, got %d", []any{name, subcommand.operands, len(args), cmd.Token}...)
+		return
+	}
+	if name == "format" {
+		checkClockValue(p, cmd, args[0])
+	}
+	for i := subcommand.operands; i < len(args); i++ {
+		option, _ := literalWord(args[i])
+		takesValue, ok := subcommand.options[option]
+		if !ok {
+			valid := sortedKeys(subcommand.options)
+			if len(valid) == 0 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: clock %s expects no arguments, got %d", []any{name, len(args), cmd.Token}...)
+			} else {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for clock %s, expected one of %s", []any{args[i].String(), name, strings.Join(valid, ", "), cmd.Token}...)
+			}
+			return
+		}
+		if !takesValue {
+			continue
+		}
+		i++
+		if i == len(args) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "clock %s option %s expects a value", []any{name, option, cmd.Token}...)
+			return
+		}
+		switch option {
+		case "-format":
+			checkClockFormat(p, cmd, args[i])
+		case "-base":
+			checkClockValue(p, cmd, args[i])
+		case "-gmt":
+			word, ok := literalWord(args[i])
+			if _, err := strconv.Atoi(word); ok && err != nil && !containsString(exprBooleans, strings.ToLower(word)) {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "expected boolean value but got \"%s\" for clock %s -gmt", []any{word, name, cmd.Token}...)
+			}
+		}
+	}
+}
+
+// reports a literal clock value that isn't a number of seconds
+func checkClockValue(p *Parser, cmd *ast.CommandInvocation, arg ast.Expression) {
+	word, ok := literalWord(arg)
+	if !ok {
+		return
+	}
+	if _, err := strconv.ParseInt(word, 0, 64); err != nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "expected integer but got \"%s\" as a clock value", []any{word, cmd.Token}...)
+	}
+}
+
+// warns about % conversions clock format passes through unchanged
+func checkClockFormat(p *Parser, cmd *ast.CommandInvocation, arg ast.Expression) {
+	format, ok := literalWord(arg)
+	if !ok {
+		return
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(format) || strings.IndexByte(clockFormatVerbs, format[i]) < 0 {
+			p.reportWarning(diagnostic.InvalidCommand, "unknown clock format conversion \"%s\"", []any{format[i-1 : min(i+1, len(format))], cmd.Token}...)
+		}
+	}
+}
+
+// after ms ?-periodic? ?script? | after cancel ?-current? ?id ...? | after info ?id ...?
+func checkAfter(p *Parser, cmd *ast.CommandInvocation) {
+	word, ok := literalWord(cmd.Arguments[0])
+	if !ok || word == "cancel" || word == "info" {
+		return
+	}
+	if _, err := strconv.Atoi(word); err != nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "after expects a number of milliseconds, cancel or info, got '%s'", []any{word, cmd.Token}...)
+		return
+	}
+
+	args := cmd.Arguments[1:]
+	periodic := len(args) > 0 && isWord(args[0], "-periodic")
+	if periodic {
+		args = args[1:]
+	}
+	switch {
+	case len(args) > 1:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: after %s expects one script, got %d arguments", []any{word, len(args), cmd.Token}...)
+	case periodic && len(args) == 0:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "after -periodic expects a script to run", []any{cmd.Token}...)
+	}
+}
+
+// reports whether an argument is the given literal word
+func isWord(arg ast.Expression, want string) bool {
+	word, ok := literalWord(arg)
+	return ok && word == want
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// parses catch script ?resultVarName? ?optionsVarName?. the variables named
+// after the script are set by catch, so they count as declared
+func (p *Parser) parseCatchExpression() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseCatchExpression Start - Current token: %s\n", p.curToken.Literal)
+	}
+	expr := &ast.CatchExpression{Token: p.curToken}
+
+	if p.catchArgumentEnd() {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: catch expects 1 to 3 arguments, got 0", []any{expr.Token}...)
+		return expr
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		expr.Body = p.parseBlockStatement()
+	} else {
+		expr.Script = p.parseCommandArgument()
+	}
+
+	variables := []**ast.Identifier{&expr.ResultVar, &expr.OptionsVar}
+	for _, variable := range variables {
+		if p.catchArgumentEnd() {
+			break
+		}
+		p.nextToken()
+		if !p.curTokenIs(token.IDENT) || strings.HasPrefix(p.curToken.Literal, "$") {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "catch expects a variable name, got '%s'", []any{p.curToken.Literal, p.curToken}...)
+			return expr
+		}
+		*variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.declareSetVariable(p.curToken.Literal)
+	}
+
+	if !p.catchArgumentEnd() {
+		extra := len(p.parseCommandArguments())
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: catch expects 1 to 3 arguments, got %d", []any{3 + extra, expr.Token}...)
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseCatchExpression End - Result variable: %v\n", expr.ResultVar)
+	}
+	return expr
+}
+
+func (p *Parser) catchArgumentEnd() bool {
+	return p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE)
+}
+
+// declares a variable set by a command other than set, such as catch or
+// switch -matchvar
+func (p *Parser) declareSetVariable(name string) {
+	p.declaredVariables[name] = true
+	if p.currentVariables != nil {
+		if name := variableName(name); name != "" {
+			p.currentVariables.sets[name] = true
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// a class subcommand: the options it takes and its argument bounds. for match
+// and search, operator is the index of the argument comparing the item with
+// the data group
+type classSubcommand struct {
+	options  []string
+	minArgs  int
+	maxArgs  int
+	operator int // -1 when the subcommand takes no operator
+}
+
+var (
+	// options selecting what a matching data group element returns
+	classResultOptions = []string{"-name", "-index", "-value", "-element"}
+
+	classOperators = []string{"equals", "starts_with", "ends_with", "contains"}
+)
+
+var classSubcommands = map[string]classSubcommand{
+	"match":       {options: append([]string{"-all", "-nocase"}, classResultOptions...), minArgs: 3, maxArgs: 3, operator: 1},
+	"search":      {options: append([]string{"-all", "-nocase"}, classResultOptions...), minArgs: 3, maxArgs: 3, operator: 1},
+	"lookup":      {minArgs: 2, maxArgs: 2, operator: -1},
+	"element":     {options: []string{"-name", "-value"}, minArgs: 2, maxArgs: 2, operator: -1},
+	"get":         {options: []string{"-nocase"}, minArgs: 1, maxArgs: 2, operator: -1},
+	"names":       {options: []string{"-nocase"}, minArgs: 1, maxArgs: 2, operator: -1},
+	"exists":      {minArgs: 1, maxArgs: 1, operator: -1},
+	"size":        {minArgs: 1, maxArgs: 1, operator: -1},
+	"type":        {minArgs: 1, maxArgs: 1, operator: -1},
+	"startsearch": {minArgs: 1, maxArgs: 1, operator: -1},
+	"nextelement": {options: []string{"-name", "-index", "-value"}, minArgs: 2, maxArgs: 2, operator: -1},
+	"anymore":     {minArgs: 2, maxArgs: 2, operator: -1},
+	"donesearch":  {minArgs: 2, maxArgs: 2, operator: -1},
+}
+
+// parses class <subcommand> ?options? <arguments> on data groups, such as
+// class match ?-value? <item> equals <class> or class lookup <item> <class>
+func (p *Parser) parseClassCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseClassCommand Start - curToken: %s (Type: %s), peekToken: %s (Type: %s)\n",
+			p.curToken.Literal, p.curToken.Type, p.peekToken.Literal, p.peekToken.Type)
+	}
+
+	p.isParsingClassMatch = true
+	defer func() { p.isParsingClassMatch = false }()
+
+	cmd := &ast.ClassCommand{Token: p.curToken}
+
+	if p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "class expects a subcommand", []any{cmd.Token}...)
+		return cmd
+	}
+	p.nextToken()
+	cmd.Subcommand = p.curToken.Literal
+
+	args := p.parseCommandArguments()
+	for len(args) > 0 {
+		option, ok := literalWord(args[0])
+		if !ok || !strings.HasPrefix(option, "-") {
+			break
+		}
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		cmd.Options = append(cmd.Options, &ast.Identifier{Token: cmd.Token, Value: option})
+	}
+	cmd.Arguments = args
+
+	p.validateClassCommand(cmd)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseClassCommand End - Subcommand: %s, Arguments: %v\n", cmd.Subcommand, cmd.Arguments)
+	}
+	return cmd
+}
+
+func (p *Parser) validateClassCommand(cmd *ast.ClassCommand) {
+	subcommand, ok := classSubcommands[cmd.Subcommand]
+	if !ok {
+		valid := []string{}
+		for name := range classSubcommands {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for class, expected one of %s", []any{cmd.Subcommand, strings.Join(valid, ", "), cmd.Token}...)
+		return
+	}
+
+	results := []string{}
+	for _, opt := range cmd.Options {
+		option := opt.(*ast.Identifier).Value
+		if !containsString(subcommand.options, option) {
+			if len(subcommand.options) == 0 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "class %s takes no options, got '%s'", []any{cmd.Subcommand, option, cmd.Token}...)
+			} else {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for class %s, expected one of %s", []any{option, cmd.Subcommand, strings.Join(subcommand.options, ", "), cmd.Token}...)
+			}
+			continue
+		}
+		if containsString(classResultOptions, option) {
+			results = append(results, option)
+		}
+	}
+	if len(results) > 1 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "class %s accepts only one of %s, got %s", []any{cmd.Subcommand, strings.Join(classResultOptions, ", "), strings.Join(results, " "), cmd.Token}...)
+	}
+
+	spec := CommandSpec{MinArgs: subcommand.minArgs, MaxArgs: subcommand.maxArgs}
+	argCount := len(cmd.Arguments)
+	if argCount < spec.MinArgs || argCount > spec.MaxArgs {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: class %s expects %s, got %d", []any{cmd.Subcommand, spec.describeArgs(), argCount, cmd.Token}...)
+		return
+	}
+
+	if subcommand.operator >= 0 {
+		if operator, ok := literalWord(cmd.Arguments[subcommand.operator]); ok && !containsString(classOperators, operator) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid operator '%s' for class %s, expected one of %s", []any{operator, cmd.Subcommand, strings.Join(classOperators, ", "), cmd.Token}...)
+		}
+		p.checkClassOperands(cmd)
+	}
+	if cmd.Subcommand == "element" {
+		if index, ok := literalWord(cmd.Arguments[0]); ok {
+			if _, err := strconv.Atoi(index); err != nil {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "class element expects a number for the index, got '%s'", []any{index, cmd.Token}...)
+			}
+		}
+	}
+}
+
+// a data group name, optionally with its partition as in /Common/hosts
+var dataGroupNameRegex = regexp.MustCompile(`^(/[\w.-]+/)?[A-Za-z_][\w.-]*$`)
+
+// class match <item> <operator> <data group>. the value under test comes
+// first, reversing the operands compares the data group name with the item
+func (p *Parser) checkClassOperands(cmd *ast.ClassCommand) {
+	item, group := cmd.Arguments[0], cmd.Arguments[2]
+
+	if literal, ok := group.(*ast.StringLiteral); ok && literal.Token.Type == token.STRING && !dataGroupNameRegex.MatchString(literal.Value) {
+		p.reportWarning(diagnostic.InvalidCommand, "class %s expects a data group name last, got the quoted literal \"%s\"; expected class %s <item> <operator> <data group>", []any{cmd.Subcommand, literal.Value, cmd.Subcommand, cmd.Token}...)
+		return
+	}
+	if literal, ok := item.(*ast.StringLiteral); ok && literal.Token.Type == token.STRING && !isLiteral(group) {
+		p.reportWarning(diagnostic.InvalidCommand, "class %s tests the literal \"%s\" against the data group %s, the operands look reversed; expected class %s <item> <operator> <data group>", []any{cmd.Subcommand, literal.Value, p.lastArgumentSource(group), cmd.Subcommand, cmd.Token}...)
+	}
+}
+
+// returns the last argument of the command being parsed as written in the
+// rule, which the string of its node doesn't keep for command substitutions
+func (p *Parser) lastArgumentSource(arg ast.Expression) string {
+	return strings.TrimSpace(p.l.Source(tokenOf(arg).Offset, p.peekToken.Offset))
+}
+
+// reports whether an argument's value is known before runtime
+func isLiteral(arg ast.Expression) bool {
+	_, ok := literalWord(arg)
+	return ok
+}
@@ -0,0 +1,897 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// ArgType constrains the value of a command argument. values computed at
+// runtime (variables and command substitutions) satisfy every type
+type ArgType int
+
+const (
+	AnyArg    ArgType = iota
+	NumberArg         // an integer
+	WordArg           // a word such as a subcommand or an option, not a number
+)
+
+// CommandSpec describes a command so it can be parsed and validated without a
+// token or a prefix function of its own
+type CommandSpec struct {
+	Name     string
+	MinArgs  int
+	MaxArgs  int       // -1 means no upper bound
+	ArgTypes []ArgType // the last type applies to any further arguments
+	Events   []string  // events the command may be used in, empty means any
+	Since    string    // first TMOS release that ships the command
+	Module   string    // optional module that has to be enabled with --module
+
+	// further validation once the arguments are parsed
+	Check func(p *Parser, cmd *ast.CommandInvocation)
+}
+
+func (s CommandSpec) describeArgs() string {
+	switch {
+	case s.MaxArgs < 0:
+		return fmt.Sprintf("at least %d argument(s)", s.MinArgs)
+	case s.MinArgs == s.MaxArgs:
+		return fmt.Sprintf("%d argument(s)", s.MinArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", s.MinArgs, s.MaxArgs)
+	}
+}
+
+func (s CommandSpec) argType(i int) ArgType {
+	if len(s.ArgTypes) == 0 {
+		return AnyArg
+	}
+	if i >= len(s.ArgTypes) {
+		return s.ArgTypes[len(s.ArgTypes)-1]
+	}
+	return s.ArgTypes[i]
+}
+
+var commandRegistry = map[string]CommandSpec{}
+
+// RegisterCommand adds a command to the registry, replacing any command of
+// the same name
+func RegisterCommand(spec CommandSpec) {
+	commandRegistry[spec.Name] = spec
+}
+
+// LookupCommand returns the registered command of the given name
+func LookupCommand(name string) (CommandSpec, bool) {
+	spec, ok := commandRegistry[name]
+	return spec, ok
+}
+
+// the namespaces of commands the lexer has tokens for instead of specs
+var tokenNamespaces = []string{"HTTP", "IP", "LB", "SSL", "X509"}
+
+// returns the namespaces of every known command, sorted
+func knownNamespaces() []string {
+	namespaces := append([]string{}, tokenNamespaces...)
+	for name := range commandRegistry {
+		namespace, _, found := strings.Cut(name, "::")
+		if found && !containsString(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// returns the namespace of a word such as FOO::bar when no known command
+// lives in it. static:: holds variables rather than commands
+func unknownNamespace(word string) (string, bool) {
+	namespace, name, found := strings.Cut(word, "::")
+	if !found || namespace == "" || name == "" || namespace == "static" {
+		return "", false
+	}
+	for _, known := range knownNamespaces() {
+		if strings.EqualFold(namespace, known) {
+			return "", false
+		}
+	}
+	return namespace, true
+}
+
+// UnknownNamespaces returns how often each unknown command namespace was used
+func (p *Parser) UnknownNamespaces() map[string]int {
+	return p.unknownNamespaces
+}
+
+func init() {
+	for _, spec := range builtinCommands {
+		RegisterCommand(spec)
+	}
+}
+
+func (p *Parser) parseRegisteredCommand(spec CommandSpec) ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegisteredCommand Start - Command: %s\n", spec.Name)
+	}
+
+	cmd := &ast.CommandInvocation{Token: p.curToken, Command: p.curToken.Literal}
+	cmd.Arguments = p.parseCommandArguments()
+	p.validateCommand(cmd, spec)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegisteredCommand End - Command: %s, Arguments: %d\n", cmd.Command, len(cmd.Arguments))
+	}
+	return cmd
+}
+
+// parses the words following a command name up to the end of the command or
+// the closing bracket of a command substitution
+func (p *Parser) parseCommandArguments() []ast.Expression {
+	args := []ast.Expression{}
+
+	for !p.peekIsCommandEnd() && !p.peekTokenIs(token.RBRACKET) && !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		// options such as -a or -nocase lex as a minus sign followed by a word
+		if p.curTokenIs(token.MINUS) && p.peekTokenIs(token.IDENT) && !p.peekToken.LineStart {
+			minus := p.curToken
+			p.nextToken()
+			args = append(args, &ast.Identifier{Token: minus, Value: minus.Literal + p.curToken.Literal})
+			continue
+		}
+
+		// ${name} lexes as $, {, name and }, and is a single word
+		if variable := p.parseBracedVariable(); variable != nil {
+			args = append(args, variable)
+			continue
+		}
+
+		// a word starting with a number, such as the expiration 1d01h00m00s
+		// or 01:00:00, lexes as several tokens and is a single word
+		if p.curTokenIs(token.NUMBER) && p.peekIsAdjacent() && (p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.COLON)) {
+			start := p.curToken
+			args = append(args, &ast.Identifier{Token: start, Value: p.readAdjacentWord()})
+			continue
+		}
+
+		// a number that isn't an integer, such as the version 1.1, is a plain word
+		if p.curTokenIs(token.NUMBER) {
+			if _, err := strconv.ParseInt(p.curToken.Literal, 0, 64); err != nil {
+				args = append(args, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+				continue
+			}
+		}
+
+		// subcommands such as 'class' or 'default' are plain words here
+		if p.curToken.Type != token.IDENT && token.LookupIdent(p.curToken.Literal) == p.curToken.Type {
+			args = append(args, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			continue
+		}
+
+		arg := p.parseCommandArgument()
+		if arg == nil {
+			break
+		}
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+// parses a variable whose name is braced, as in ${name}, when the current
+// token is a $ followed by a brace. the variable is named as one substituted
+// in a quoted string. returns nil, leaving the tokens alone, otherwise
+func (p *Parser) parseBracedVariable() ast.Expression {
+	if p.curToken.Literal != "$" || !p.peekTokenIs(token.LBRACE) || !p.peekIsAdjacent() {
+		return nil
+	}
+	dollar := p.curToken
+	p.nextToken() // move to '{'
+	open := p.curToken
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.EOF) || p.peekIsCommandEnd() {
+			p.reportError("parseBracedVariable: missing } after the name of variable ${")
+			return &ast.Identifier{Token: dollar, Value: "$"}
+		}
+		p.nextToken()
+	}
+	p.nextToken() // move to '}'
+	name := p.l.Source(open.Offset+1, p.curToken.Offset)
+	return &ast.Identifier{Token: dollar, Value: "$" + name}
+}
+
+// checks a command invocation against its spec: the module and TMOS version
+// that provide it, its arguments and the event it is used in
+func (p *Parser) validateCommand(cmd *ast.CommandInvocation, spec CommandSpec) {
+	pos := cmd.Token
+
+	if spec.Module != "" && !p.opts.ModuleEnabled(spec.Module) {
+		p.reportDiagnostic(diagnostic.ModuleDisabled, "%s requires --module %s", []any{cmd.Command, spec.Module, pos}...)
+		return
+	}
+
+	if !p.opts.TmosVersionAtLeast(spec.Since) {
+		p.reportDiagnostic(diagnostic.VersionMismatch, "%s requires TMOS %s or later, targeting %s", []any{cmd.Command, spec.Since, p.opts.TmosVersion, pos}...)
+	}
+
+	if len(spec.Events) > 0 && p.currentEvent != "" && !containsString(spec.Events, p.currentEvent) {
+		p.reportDiagnostic(diagnostic.CommandNotInEvent, "%s is not available in %s, expected one of %s", []any{cmd.Command, p.currentEvent, strings.Join(spec.Events, ", "), pos}...)
+	}
+
+	argCount := len(cmd.Arguments)
+	if argCount < spec.MinArgs || (spec.MaxArgs >= 0 && argCount > spec.MaxArgs) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects %s, got %d", []any{cmd.Command, spec.describeArgs(), argCount, pos}...)
+		return
+	}
+
+	for i, arg := range cmd.Arguments {
+		if word, ok := literalWord(arg); ok {
+			_, err := strconv.Atoi(word)
+			switch spec.argType(i) {
+			case NumberArg:
+				if err != nil {
+					p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects a number for argument %d, got '%s'", []any{cmd.Command, i + 1, word, pos}...)
+				}
+			case WordArg:
+				if err == nil {
+					p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects a word for argument %d, got '%s'", []any{cmd.Command, i + 1, word, pos}...)
+				}
+			}
+		}
+	}
+
+	if spec.Check != nil {
+		spec.Check(p, cmd)
+	}
+}
+
+// returns the text of an argument whose value is known before runtime
+func literalWord(arg ast.Expression) (string, bool) {
+	switch arg := arg.(type) {
+	case *ast.Identifier:
+		if strings.HasPrefix(arg.Value, "$") {
+			return "", false
+		}
+		return arg.Value, true
+	case *ast.StringLiteral:
+		return arg.Value, true
+	case *ast.NumberLiteral:
+		return arg.Token.Literal, true
+	}
+	return "", false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) parseResolverReference() ast.Expression {
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+var (
+	wsFrameEvents = []string{
+		"WS_CLIENT_FRAME", "WS_SERVER_FRAME", "WS_CLIENT_DATA", "WS_SERVER_DATA",
+		"WS_CLIENT_FRAME_DONE", "WS_SERVER_FRAME_DONE",
+	}
+
+	dnsEvents = []string{"DNS_REQUEST", "DNS_RESPONSE"}
+
+	builtinCommands = []CommandSpec{
+		// HTTP/2
+		{Name: "HTTP2::active", MinArgs: 0, MaxArgs: 0, Since: "12.0"},
+		{Name: "HTTP2::concurrency", MinArgs: 0, MaxArgs: 1, Since: "12.0"},
+		{Name: "HTTP2::disable", MinArgs: 0, MaxArgs: 1, Since: "12.0"},
+		{Name: "HTTP2::disconnect", MinArgs: 0, MaxArgs: 2, Since: "12.0"},
+		{Name: "HTTP2::enable", MinArgs: 0, MaxArgs: 1, Since: "12.0"},
+		{Name: "HTTP2::header", MinArgs: 1, MaxArgs: -1, ArgTypes: []ArgType{WordArg, AnyArg}, Since: "12.0"},
+		{Name: "HTTP2::priority", MinArgs: 0, MaxArgs: 1, Since: "12.0"},
+		{Name: "HTTP2::push", MinArgs: 1, MaxArgs: -1, Since: "14.1"},
+		{Name: "HTTP2::requests", MinArgs: 0, MaxArgs: 0, Since: "12.0"},
+		{Name: "HTTP2::stream", MinArgs: 0, MaxArgs: 1, Since: "12.0"},
+		{Name: "HTTP2::version", MinArgs: 0, MaxArgs: 0, Since: "12.0"},
+
+		// websockets
+		{Name: "WS::collect", MinArgs: 0, MaxArgs: 2, ArgTypes: []ArgType{WordArg, NumberArg}, Events: wsFrameEvents, Since: "12.1"},
+		{Name: "WS::disconnect", MinArgs: 0, MaxArgs: 2, ArgTypes: []ArgType{NumberArg, AnyArg}, Since: "12.1"},
+		{Name: "WS::enabled", MinArgs: 0, MaxArgs: 0, Since: "12.1"},
+		{Name: "WS::frame", MinArgs: 1, MaxArgs: -1, ArgTypes: []ArgType{WordArg, AnyArg}, Events: wsFrameEvents, Since: "12.1"},
+		{Name: "WS::masking", MinArgs: 0, MaxArgs: 2, Since: "12.1"},
+		{Name: "WS::message", MinArgs: 1, MaxArgs: -1, ArgTypes: []ArgType{WordArg, AnyArg}, Since: "12.1"},
+		{Name: "WS::payload", MinArgs: 0, MaxArgs: -1, Events: wsFrameEvents, Since: "12.1"},
+		{Name: "WS::payload_ivs", MinArgs: 0, MaxArgs: 1, Since: "12.1"},
+		{Name: "WS::release", MinArgs: 0, MaxArgs: 0, Events: wsFrameEvents, Since: "12.1"},
+		{Name: "WS::request", MinArgs: 1, MaxArgs: -1, ArgTypes: []ArgType{WordArg, AnyArg}, Since: "12.1"},
+		{Name: "WS::response", MinArgs: 1, MaxArgs: -1, ArgTypes: []ArgType{WordArg, AnyArg}, Since: "12.1"},
+
+		// TCP
+		{Name: "TCP::client_port", MinArgs: 0, MaxArgs: 0, Since: "9.0"},
+		{Name: "TCP::close", MinArgs: 0, MaxArgs: 0, Since: "9.0"},
+		{Name: "TCP::collect", MinArgs: 0, MaxArgs: 2, ArgTypes: []ArgType{NumberArg}, Since: "9.0"},
+		{Name: "TCP::local_port", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{WordArg}, Since: "9.0", Check: checkTcpContext},
+		{Name: "TCP::payload", MinArgs: 0, MaxArgs: 4, Since: "9.0", Check: checkTcpPayload},
+		{Name: "TCP::release", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "9.0"},
+		{Name: "TCP::remote_port", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{WordArg}, Since: "9.0", Check: checkTcpContext},
+		{Name: "TCP::respond", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+
+		// URI parsing
+		{Name: "URI::basename", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "URI::compare", MinArgs: 2, MaxArgs: 2, Since: "9.0"},
+		{Name: "URI::decode", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "URI::encode", MinArgs: 1, MaxArgs: 1, Since: "9.0", Check: checkURIEncode},
+		{Name: "URI::host", MinArgs: 1, MaxArgs: 1, Since: "10.0"},
+		{Name: "URI::path", MinArgs: 1, MaxArgs: 3, Since: "9.0"},
+		{Name: "URI::port", MinArgs: 1, MaxArgs: 1, Since: "10.0"},
+		{Name: "URI::protocol", MinArgs: 1, MaxArgs: 1, Since: "10.0"},
+		{Name: "URI::query", MinArgs: 1, MaxArgs: 2, Since: "9.0"},
+
+		// DNS
+		{Name: "DNS::additional", MinArgs: 0, MaxArgs: 2, Events: dnsEvents, Since: "10.1", Check: checkSubcommand(dnsSectionSubcommands)},
+		{Name: "DNS::answer", MinArgs: 0, MaxArgs: 2, Events: dnsEvents, Since: "10.1", Check: checkSubcommand(dnsSectionSubcommands)},
+		{Name: "DNS::authority", MinArgs: 0, MaxArgs: 2, Events: dnsEvents, Since: "10.1", Check: checkSubcommand(dnsSectionSubcommands)},
+		{Name: "DNS::class", MinArgs: 1, MaxArgs: 2, Since: "10.1"},
+		{Name: "DNS::drop", MinArgs: 0, MaxArgs: 0, Events: dnsEvents, Since: "10.1"},
+		{Name: "DNS::header", MinArgs: 1, MaxArgs: 2, ArgTypes: []ArgType{WordArg, AnyArg}, Events: dnsEvents, Since: "10.1"},
+		{Name: "DNS::name", MinArgs: 1, MaxArgs: 2, Since: "10.1"},
+		{Name: "DNS::question", MinArgs: 1, MaxArgs: 2, Events: dnsEvents, Since: "10.1", Check: checkSubcommand(dnsQuestionSubcommands)},
+		{Name: "DNS::rcode", MinArgs: 0, MaxArgs: 1, Events: dnsEvents, Since: "10.1", Check: checkDnsRcode},
+		{Name: "DNS::rdata", MinArgs: 1, MaxArgs: 2, Since: "10.1"},
+		{Name: "DNS::return", MinArgs: 0, MaxArgs: 0, Events: dnsEvents, Since: "10.1"},
+		{Name: "DNS::rr", MinArgs: 1, MaxArgs: -1, Since: "10.1"},
+		{Name: "DNS::ttl", MinArgs: 1, MaxArgs: 2, ArgTypes: []ArgType{AnyArg, NumberArg}, Since: "10.1"},
+		{Name: "DNS::type", MinArgs: 1, MaxArgs: 2, Since: "10.1"},
+
+		// name resolution
+		{Name: "NAME::lookup", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "NAME::response", MinArgs: 0, MaxArgs: 0, Events: []string{"NAME_RESOLVED"}, Since: "9.0"},
+		{Name: "RESOLV::lookup", MinArgs: 1, MaxArgs: 4, Since: "10.1", Check: checkResolvLookup},
+
+		// url categorization
+		{Name: "CATEGORY::filetype", MinArgs: 0, MaxArgs: 0, Since: "11.6"},
+		{Name: "CATEGORY::lookup", MinArgs: 1, MaxArgs: 2, Since: "11.6", Check: checkCategoryLookup},
+		{Name: "CATEGORY::matchtype", MinArgs: 0, MaxArgs: 0, Since: "11.6"},
+		{Name: "CATEGORY::result", MinArgs: 0, MaxArgs: 1, Since: "11.6"},
+		{Name: "URLCAT::lookup", MinArgs: 1, MaxArgs: 2, Since: "11.6", Check: checkCategoryLookup},
+		{Name: "URLCAT::result", MinArgs: 0, MaxArgs: 1, Since: "11.6"},
+
+		// iStats
+		{Name: "ISTATS::get", MinArgs: 1, MaxArgs: 1, Since: "11.5", Check: checkIstatsKey},
+		{Name: "ISTATS::incr", MinArgs: 2, MaxArgs: 2, ArgTypes: []ArgType{AnyArg, NumberArg}, Since: "11.5", Check: checkIstatsKey},
+		{Name: "ISTATS::remove", MinArgs: 1, MaxArgs: 1, Since: "11.5", Check: checkIstatsKey},
+		{Name: "ISTATS::set", MinArgs: 2, MaxArgs: 2, Since: "11.5", Check: checkIstatsKey},
+
+		// stream profile
+		{Name: "STREAM::disable", MinArgs: 0, MaxArgs: 0, Since: "9.0"},
+		{Name: "STREAM::enable", MinArgs: 0, MaxArgs: 0, Since: "9.0"},
+		{Name: "STREAM::expression", MinArgs: 1, MaxArgs: 1, Since: "9.0", Check: checkStreamExpression},
+		{Name: "STREAM::match", MinArgs: 0, MaxArgs: 0, Events: []string{"STREAM_MATCHED"}, Since: "9.0"},
+		{Name: "STREAM::max_matchsize", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "10.0"},
+		{Name: "STREAM::replace", MinArgs: 0, MaxArgs: 1, Events: []string{"STREAM_MATCHED"}, Since: "9.0"},
+
+		// MQTT
+		{Name: "MQTT::client_id", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::collect", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::disable", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::drop", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::dup", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::enable", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::insert", MinArgs: 1, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::keep_alive", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::length", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::message", MinArgs: 1, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::packet_id", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::password", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::payload", MinArgs: 0, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::protocol_name", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::protocol_version", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::qos", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::release", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::replace", MinArgs: 1, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::respond", MinArgs: 1, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::retain", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::return_code", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::topic", MinArgs: 0, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::type", MinArgs: 0, MaxArgs: 0, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::username", MinArgs: 0, MaxArgs: 1, Since: "13.0", Module: "mqtt"},
+		{Name: "MQTT::will", MinArgs: 0, MaxArgs: -1, Since: "13.0", Module: "mqtt"},
+
+		// crypto
+		{Name: "AES::decrypt", MinArgs: 2, MaxArgs: 2, Since: "9.0", Check: checkAesCipher},
+		{Name: "AES::encrypt", MinArgs: 2, MaxArgs: 2, Since: "9.0", Check: checkAesCipher},
+		{Name: "AES::key", MinArgs: 0, MaxArgs: 1, ArgTypes: []ArgType{NumberArg}, Since: "9.0", Check: checkAesKey},
+		{Name: "CRYPTO::decrypt", MinArgs: 1, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "CRYPTO::encrypt", MinArgs: 1, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "CRYPTO::hash", MinArgs: 1, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "CRYPTO::keygen", MinArgs: 2, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "CRYPTO::sign", MinArgs: 2, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "CRYPTO::verify", MinArgs: 2, MaxArgs: -1, Since: "11.1", Check: checkCrypto},
+		{Name: "b64decode", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "b64encode", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "md5", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "sha1", MinArgs: 1, MaxArgs: 1, Since: "9.0"},
+		{Name: "sha256", MinArgs: 1, MaxArgs: 1, Since: "10.1"},
+		{Name: "sha384", MinArgs: 1, MaxArgs: 1, Since: "10.1"},
+		{Name: "sha512", MinArgs: 1, MaxArgs: 1, Since: "10.1"},
+
+		// persistence
+		{Name: "persist", MinArgs: 1, MaxArgs: 6, ArgTypes: []ArgType{WordArg, AnyArg}, Since: "9.0", Check: checkPersist},
+
+		// Tcl
+		{Name: "after", MinArgs: 1, MaxArgs: 3, Check: checkAfter},
+		{Name: "array", MinArgs: 2, MaxArgs: 4, ArgTypes: []ArgType{WordArg, AnyArg}, Check: checkArray},
+		{Name: "clock", MinArgs: 1, MaxArgs: 6, ArgTypes: []ArgType{WordArg, AnyArg}, Check: checkClock},
+		{Name: "format", MinArgs: 1, MaxArgs: -1, Check: checkFormat},
+		{Name: "puts", MinArgs: 1, MaxArgs: 3, Check: checkPuts},
+		{Name: "scan", MinArgs: 2, MaxArgs: -1, Check: checkScan},
+	}
+)
+
+// RESOLV::lookup [@<ip>|@<virtual>] [inet|inet6] [-a|-aaaa|-txt|-mx|-ptr] <name|ip>
+func checkResolvLookup(p *Parser, cmd *ast.CommandInvocation) {
+	for i, arg := range cmd.Arguments {
+		word, ok := literalWord(arg)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case i == 0 && strings.HasPrefix(word, "@"):
+			if !isValidResolverReference(word) {
+				p.reportDiagnostic(diagnostic.InvalidResolver, "invalid resolver reference '%s', expected @<ip> or @/<partition>/<name>", []any{word, cmd.Token}...)
+			}
+		case strings.HasPrefix(word, "-"):
+			if !validResolvFlags[word] {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid record type '%s' for %s", []any{word, cmd.Command, cmd.Token}...)
+			}
+		}
+	}
+}
+
+// a resolver reference names either an IP address or a virtual server, e.g.
+// @10.0.0.53, @dns_vs or @/Common/dns_resolver
+func isValidResolverReference(ref string) bool {
+	name := strings.TrimPrefix(ref, "@")
+	if name == "" {
+		return false
+	}
+	if net.ParseIP(name) != nil {
+		return true
+	}
+
+	segments := strings.Split(name, "/")
+	if strings.HasPrefix(name, "/") {
+		// a full path needs at least a partition and an object name
+		segments = segments[1:]
+		if len(segments) < 2 {
+			return false
+		}
+	}
+	segmentRegex := regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	for _, segment := range segments {
+		if !segmentRegex.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// CATEGORY::lookup <url> [<lookup type>]
+func checkCategoryLookup(p *Parser, cmd *ast.CommandInvocation) {
+	if len(cmd.Arguments) != 2 {
+		return
+	}
+	// the lookup type is a literal word, a variable may hold any of them
+	if lookupType, ok := literalWord(cmd.Arguments[1]); ok && !validCategoryLookupTypes[lookupType] {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid lookup type '%s' for %s", []any{lookupType, cmd.Command, cmd.Token}...)
+	}
+}
+
+// puts ?-nonewline? ?channelId? string. outside of RULE_INIT it writes to the
+// TMM log on every request, which is rarely what was intended
+func checkPuts(p *Parser, cmd *ast.CommandInvocation) {
+	if p.currentEvent == "" || p.currentEvent == "RULE_INIT" || p.opts.PutsSeverity == "off" {
+		return
+	}
+
+	format := "puts in %s writes to the TMM log on every event, use log instead"
+	switch p.opts.PutsSeverity {
+	case "error":
+		p.reportDiagnostic(diagnostic.PutsInEvent, format, []any{p.currentEvent, cmd.Token}...)
+	case "info":
+		p.reportSeverity(diagnostic.Info, diagnostic.PutsInEvent, format, []any{p.currentEvent, cmd.Token}...)
+	default:
+		p.reportWarning(diagnostic.PutsInEvent, format, []any{p.currentEvent, cmd.Token}...)
+	}
+
+	// with options or a channel the message isn't simply the last word
+	if len(cmd.Arguments) == 1 && cmd.Token.Column > 0 {
+		p.suggestFix("Replace puts with log local0.", replaceToken(cmd.Token, "log local0."))
+	}
+}
+
+// ISTATS::<command> "<class> <object> <type> <name>" ?value?
+func checkIstatsKey(p *Parser, cmd *ast.CommandInvocation) {
+	var key string
+	switch arg := cmd.Arguments[0].(type) {
+	case *ast.StringLiteral:
+		key = arg.Value
+	case *ast.InterpolatedString:
+		key = arg.Token.Literal
+	}
+	if key == "" {
+		return
+	}
+
+	if err := validateIstatsKey(key); err != "" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid %s key \"%s\": %s", []any{cmd.Command, key, err, cmd.Token}...)
+	}
+}
+
+// an iStats key is a triple of object, stat type and stat name where the
+// object is a class and a name, e.g. "ltm.virtual [virtual name] c my_counter"
+func validateIstatsKey(key string) string {
+	words := splitWords(key)
+	if len(words) != 4 {
+		return fmt.Sprintf("expected <class> <object> <type> <name>, got %d word(s)", len(words))
+	}
+
+	class, statType := words[0], words[2]
+	if !regexp.MustCompile(`^[a-z]+(\.[a-z_]+)+$`).MatchString(class) && !strings.HasPrefix(class, "$") {
+		return fmt.Sprintf("invalid object class '%s'", class)
+	}
+	if !validIstatsTypes[statType] && !strings.HasPrefix(statType, "$") {
+		return fmt.Sprintf("invalid stat type '%s', expected c, g or s", statType)
+	}
+	return ""
+}
+
+// splits a string into whitespace separated words keeping bracketed command
+// substitutions in one piece
+func splitWords(s string) []string {
+	words := []string{}
+	current := ""
+	depth := 0
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '[':
+			depth++
+		case ch == ']' && depth > 0:
+			depth--
+		case (ch == ' ' || ch == '\t' || ch == '\n') && depth == 0:
+			if current != "" {
+				words = append(words, current)
+				current = ""
+			}
+			continue
+		}
+		current += string(ch)
+	}
+
+	if current != "" {
+		words = append(words, current)
+	}
+	return words
+}
+
+// TCP::local_port [clientside|serverside]
+func checkTcpContext(p *Parser, cmd *ast.CommandInvocation) {
+	if len(cmd.Arguments) == 0 {
+		return
+	}
+	if side, ok := literalWord(cmd.Arguments[0]); ok && side != "clientside" && side != "serverside" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects clientside or serverside, got '%s'", []any{cmd.Command, side, cmd.Token}...)
+	}
+}
+
+// TCP::payload [<length>|length] | TCP::payload replace <offset> <length> <data>
+func checkTcpPayload(p *Parser, cmd *ast.CommandInvocation) {
+	args := cmd.Arguments
+	if len(args) == 0 {
+		return
+	}
+
+	word, ok := literalWord(args[0])
+	switch {
+	case ok && word == "replace":
+		if len(args) != 4 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s replace expects <offset> <length> <data>, got %d", []any{cmd.Command, len(args) - 1, cmd.Token}...)
+			return
+		}
+		for i, arg := range args[1:3] {
+			if n, ok := literalWord(arg); ok {
+				if _, err := strconv.Atoi(n); err != nil {
+					p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects a number for argument %d, got '%s'", []any{cmd.Command, i + 2, n, cmd.Token}...)
+				}
+			}
+		}
+	case len(args) > 1:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects a length or replace, got %d", []any{cmd.Command, len(args), cmd.Token}...)
+	case ok && word != "length":
+		if _, err := strconv.Atoi(word); err != nil {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects a length or replace, got '%s'", []any{cmd.Command, word, cmd.Token}...)
+		}
+	}
+}
+
+// argument bounds of each subcommand, keyed by subcommand name. the
+// subcommand itself is not counted
+type subcommandArgs map[string][2]int
+
+var (
+	dnsQuestionSubcommands = subcommandArgs{"name": {0, 1}, "type": {0, 1}, "class": {0, 1}}
+	dnsSectionSubcommands  = subcommandArgs{"clear": {0, 0}, "insert": {1, 1}, "remove": {1, 1}}
+)
+
+// returns a check that the first argument of a command names one of the given
+// subcommands and that the subcommand gets the right number of arguments
+func checkSubcommand(subcommands subcommandArgs) func(p *Parser, cmd *ast.CommandInvocation) {
+	return func(p *Parser, cmd *ast.CommandInvocation) {
+		if len(cmd.Arguments) == 0 {
+			return
+		}
+		name, ok := literalWord(cmd.Arguments[0])
+		if !ok {
+			return
+		}
+
+		bounds, ok := subcommands[name]
+		if !ok {
+			valid := []string{}
+			for subcommand := range subcommands {
+				valid = append(valid, subcommand)
+			}
+			sort.Strings(valid)
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for %s, expected one of %s", []any{name, cmd.Command, strings.Join(valid, ", "), cmd.Token}...)
+			return
+		}
+
+		checkArgCount(p, cmd, cmd.Command+" "+name, CommandSpec{MinArgs: bounds[0], MaxArgs: bounds[1]}, len(cmd.Arguments)-1)
+	}
+}
+
+var validDnsRcodes = map[string]bool{
+	"NOERROR": true, "FORMERR": true, "SERVFAIL": true, "NXDOMAIN": true,
+	"NOTIMP": true, "REFUSED": true, "YXDOMAIN": true, "YXRRSET": true,
+	"NXRRSET": true, "NOTAUTH": true, "NOTZONE": true,
+}
+
+// DNS::rcode [<name>|<number>]
+func checkDnsRcode(p *Parser, cmd *ast.CommandInvocation) {
+	if len(cmd.Arguments) == 0 {
+		return
+	}
+	rcode, ok := literalWord(cmd.Arguments[0])
+	if !ok {
+		return
+	}
+	if _, err := strconv.Atoi(rcode); err != nil && !validDnsRcodes[strings.ToUpper(rcode)] {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid response code '%s' for %s", []any{rcode, cmd.Command, cmd.Token}...)
+	}
+}
+
+// STREAM::expression <expression>. the expression is a list of @search@replace@
+// pairs, each delimited by its own first character
+func checkStreamExpression(p *Parser, cmd *ast.CommandInvocation) {
+	expression, ok := literalWord(cmd.Arguments[0])
+	if !ok || strings.ContainsAny(expression, "$[") {
+		return
+	}
+
+	rest := strings.TrimSpace(expression)
+	if rest == "" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "STREAM::expression expects at least one @search@replace@ pair", []any{cmd.Token}...)
+		return
+	}
+	for rest != "" {
+		delimiter := rest[:1]
+		parts := strings.SplitN(rest[1:], delimiter, 3)
+		if len(parts) < 3 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "STREAM::expression pair '%s' is not terminated, expected %ssearch%sreplace%s", []any{rest, delimiter, delimiter, delimiter, cmd.Token}...)
+			return
+		}
+		if parts[0] == "" {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "STREAM::expression pair %s%s%s%s has an empty search pattern", []any{delimiter, delimiter, parts[1], delimiter, cmd.Token}...)
+		}
+		rest = strings.TrimSpace(parts[2])
+	}
+}
+
+// the arguments of a persistence method, besides the method itself, and the
+// position of its timeout among them, -1 when it takes none
+type persistMethod struct {
+	minArgs int
+	maxArgs int
+	timeout int
+}
+
+var (
+	persistMethods = map[string]persistMethod{
+		"none":        {0, 0, -1},
+		"uie":         {1, 2, 1},
+		"universal":   {1, 2, 1},
+		"hash":        {1, 2, 1},
+		"carp":        {1, 2, 1},
+		"sip":         {1, 2, 1},
+		"ssl":         {0, 1, 0},
+		"msrdp":       {0, 1, 0},
+		"source_addr": {0, 2, -1},
+		"dest_addr":   {0, 2, -1},
+		"sticky":      {0, 2, -1},
+		"cookie":      {0, 5, -1},
+	}
+
+	// persist cookie <method> and the arguments it takes after the method
+	persistCookieMethods = subcommandArgs{"insert": {0, 2}, "rewrite": {0, 2}, "passive": {0, 1}, "hash": {1, 4}}
+
+	// the expiration of a persistence cookie besides seconds: [Xd]HH:MM:SS or
+	// XdXhXmXs
+	persistExpirationRegex = regexp.MustCompile(`^(\d+d)?(\d{1,2}:\d{2}:\d{2}|\d+h\d+m\d+s)$`)
+
+	// the methods whose records persist add, lookup and delete work on
+	persistRecordMethods = []string{"uie", "universal", "hash", "carp", "sip", "ssl", "source_addr", "dest_addr", "cookie"}
+
+	persistLookupFields = []string{"all", "node", "port", "pool"}
+)
+
+// persist <method> ?args? | persist add|lookup|delete <method> <key> ?arg?
+func checkPersist(p *Parser, cmd *ast.CommandInvocation) {
+	method, ok := literalWord(cmd.Arguments[0])
+	if !ok {
+		return
+	}
+	args := cmd.Arguments[1:]
+
+	switch method {
+	case "add", "lookup", "delete":
+		checkPersistRecord(p, cmd, method, args)
+		return
+	case "cookie":
+		checkPersistCookie(p, cmd, args)
+		return
+	}
+
+	spec, ok := persistMethods[method]
+	if !ok {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid persistence method '%s' for persist, expected one of %s", []any{method, strings.Join(persistMethodNames(), ", "), cmd.Token}...)
+		return
+	}
+	if !checkArgCount(p, cmd, "persist "+method, CommandSpec{MinArgs: spec.minArgs, MaxArgs: spec.maxArgs}, len(args)) {
+		return
+	}
+
+	for i, arg := range args {
+		word, ok := persistWord(arg)
+		if !ok {
+			continue
+		}
+		switch {
+		case i == spec.timeout:
+			checkPersistTimeout(p, cmd, method, i+2, word)
+		case spec.timeout < 0 && i == 0 && net.ParseIP(word) != nil:
+			// source_addr, dest_addr and sticky take an optional mask first
+		case spec.timeout < 0:
+			checkPersistTimeout(p, cmd, method, i+2, word)
+		}
+	}
+}
+
+// persist cookie ?insert|rewrite|passive|hash? ?args?
+func checkPersistCookie(p *Parser, cmd *ast.CommandInvocation, args []ast.Expression) {
+	if len(args) == 0 {
+		return
+	}
+	method, ok := literalWord(args[0])
+	if !ok {
+		return
+	}
+	bounds, ok := persistCookieMethods[method]
+	if !ok {
+		valid := []string{}
+		for name := range persistCookieMethods {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid cookie method '%s' for persist cookie, expected one of %s", []any{method, strings.Join(valid, ", "), cmd.Token}...)
+		return
+	}
+
+	args = args[1:]
+	if !checkArgCount(p, cmd, "persist cookie "+method, CommandSpec{MinArgs: bounds[0], MaxArgs: bounds[1]}, len(args)) {
+		return
+	}
+	// the timeout follows the cookie name of insert and rewrite, and the
+	// offset and length of hash
+	if (method == "insert" || method == "rewrite") && len(args) == 2 {
+		if word, ok := persistWord(args[1]); ok && !persistExpirationRegex.MatchString(word) {
+			if seconds, err := strconv.Atoi(word); err != nil || seconds < 0 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "persist cookie %s expects an expiration in seconds, [Xd]HH:MM:SS or XdXhXmXs for argument 4, got '%s'", []any{method, word, cmd.Token}...)
+			}
+		}
+	}
+	if method == "hash" {
+		for i, arg := range args[1:] {
+			if word, ok := persistWord(arg); ok {
+				checkPersistTimeout(p, cmd, "cookie hash", i+4, word)
+			}
+		}
+	}
+}
+
+// persist add <method> <key> ?timeout?, persist lookup <method> <key> ?field?
+// and persist delete <method> <key>
+func checkPersistRecord(p *Parser, cmd *ast.CommandInvocation, subcommand string, args []ast.Expression) {
+	spec := CommandSpec{MinArgs: 2, MaxArgs: 3}
+	if subcommand == "delete" {
+		spec.MaxArgs = 2
+	}
+	if !checkArgCount(p, cmd, "persist "+subcommand, spec, len(args)) {
+		return
+	}
+
+	if method, ok := literalWord(args[0]); ok && !containsString(persistRecordMethods, method) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid persistence method '%s' for persist %s, expected one of %s", []any{method, subcommand, strings.Join(persistRecordMethods, ", "), cmd.Token}...)
+	}
+	if len(args) < 3 {
+		return
+	}
+	word, ok := persistWord(args[2])
+	if !ok {
+		return
+	}
+	switch subcommand {
+	case "add":
+		checkPersistTimeout(p, cmd, "add", 4, word)
+	case "lookup":
+		if !containsString(persistLookupFields, word) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid field '%s' for persist lookup, expected one of %s", []any{word, strings.Join(persistLookupFields, ", "), cmd.Token}...)
+		}
+	}
+}
+
+func checkPersistTimeout(p *Parser, cmd *ast.CommandInvocation, method string, position int, word string) {
+	if seconds, err := strconv.Atoi(word); err != nil || seconds < 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "persist %s expects a timeout in seconds for argument %d, got '%s'", []any{method, position, word, cmd.Token}...)
+	}
+}
+
+// returns the text of a persist argument known before runtime, including a
+// negative number such as -5, which parses as a minus sign and the number
+func persistWord(arg ast.Expression) (string, bool) {
+	if prefix, ok := arg.(*ast.PrefixExpression); ok && prefix.Operator == "-" {
+		if number, ok := prefix.Right.(*ast.NumberLiteral); ok {
+			return "-" + number.Token.Literal, true
+		}
+	}
+	return literalWord(arg)
+}
+
+func persistMethodNames() []string {
+	names := []string{"add", "delete", "lookup"}
+	for name := range persistMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reports a wrong number of arguments to a subcommand, named by command
+func checkArgCount(p *Parser, cmd *ast.CommandInvocation, command string, spec CommandSpec, argCount int) bool {
+	if argCount < spec.MinArgs || (spec.MaxArgs >= 0 && argCount > spec.MaxArgs) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects %s, got %d", []any{command, spec.describeArgs(), argCount, cmd.Token}...)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+var (
+	httpRequestEvents  = []string{"HTTP_REQUEST", "HTTP_REQUEST_DATA", "HTTP_REQUEST_SEND", "HTTP_REQUEST_RELEASE"}
+	httpResponseEvents = []string{"HTTP_RESPONSE", "HTTP_RESPONSE_DATA", "HTTP_RESPONSE_CONTINUE", "HTTP_RESPONSE_RELEASE"}
+	httpEvents         = append(append([]string{}, httpRequestEvents...), httpResponseEvents...)
+
+	// events that run before the server side connection is set up, where a
+	// load balancing decision can still be made
+	loadBalancingEvents = []string{
+		"CLIENT_ACCEPTED", "CLIENT_DATA", "SSL_CLIENTHELLO", "CLIENTSSL_HANDSHAKE",
+		"HTTP_REQUEST", "HTTP_REQUEST_DATA", "DNS_REQUEST", "WS_REQUEST", "CATEGORY_MATCHED",
+		"LB_SELECTED", "LB_FAILED",
+	}
+)
+
+// the events commands parsed by their own prefix functions are legal in.
+// registered commands carry their events in their CommandSpec instead
+var commandEvents = map[string][]string{
+	"HTTP::respond":  {"HTTP_REQUEST", "HTTP_REQUEST_DATA", "HTTP_RESPONSE", "HTTP_RESPONSE_DATA", "HTTP_RESPONSE_CONTINUE", "LB_FAILED"},
+	"HTTP::redirect": {"HTTP_REQUEST", "HTTP_REQUEST_DATA", "HTTP_RESPONSE", "HTTP_RESPONSE_DATA", "HTTP_RESPONSE_CONTINUE", "LB_FAILED"},
+	"HTTP::uri":      httpRequestEvents,
+	"HTTP::path":     httpRequestEvents,
+	"HTTP::query":    httpRequestEvents,
+	"HTTP::method":   httpRequestEvents,
+	"HTTP::host":     httpRequestEvents,
+	"HTTP::status":   httpResponseEvents,
+	"HTTP::header":   httpEvents,
+	"HTTP::cookie":   httpEvents,
+	"HTTP::collect":  httpEvents,
+	"HTTP::payload":  httpEvents,
+	"HTTP::release":  httpEvents,
+	"LB::select":     loadBalancingEvents,
+	"pool":           loadBalancingEvents,
+	"node":           loadBalancingEvents,
+}
+
+// reports commands of a when block that aren't available in its event. only
+// events of the connection flow are checked, commands in other events such as
+// ACCESS_* or ASM_* ones are trusted
+func (p *Parser) checkEventContexts(program *ast.Program) {
+	ast.Inspect(program, func(node ast.Node) bool {
+		when, ok := node.(*ast.WhenExpression)
+		if !ok {
+			return true
+		}
+
+		event := when.Event.String()
+		if event == "RULE_INIT" {
+			p.checkRuleInit(when.Block)
+			return false
+		}
+		p.checkStaticInit(event, when.Block)
+		if _, ok := connectionFlow[event]; !ok {
+			return false
+		}
+
+		ast.Inspect(when.Block, func(node ast.Node) bool {
+			name, tok, ok := commandName(node)
+			if !ok {
+				return true
+			}
+			if events, ok := commandEvents[name]; ok && !containsString(events, event) {
+				p.reportDiagnostic(diagnostic.CommandNotInEvent, "%s is not available in %s, expected one of %s", []any{name, event, strings.Join(events, ", "), tok}...)
+			}
+			return true
+		})
+		return false
+	})
+}
+
+var (
+	// namespaces whose commands work on the connection or request of the event
+	connectionNamespaces = []string{"HTTP", "HTTP2", "SSL", "TCP", "UDP", "WS"}
+
+	// other commands that need a connection
+	connectionCommands = []string{
+		"IP::client_addr", "IP::local_addr", "IP::remote_addr", "IP::server_addr",
+		"LB::select", "LB::server", "drop", "node", "persist", "pool", "reject", "snat",
+		"snatpool", "virtual",
+	}
+)
+
+// reports commands that need a connection in RULE_INIT, which runs once when
+// the rule is loaded, before any connection exists
+func (p *Parser) checkRuleInit(block *ast.BlockStatement) {
+	ast.Inspect(block, func(node ast.Node) bool {
+		name, tok, ok := commandName(node)
+		switch n := node.(type) {
+		case *ast.CommandInvocation:
+			name, tok, ok = n.Command, n.Token, true
+		case *ast.IpExpression:
+			name, tok, ok = n.String(), n.Token, true
+		}
+		if !ok {
+			return true
+		}
+
+		namespace, _, _ := strings.Cut(name, "::")
+		if containsString(connectionCommands, name) || strings.Contains(name, "::") && containsString(connectionNamespaces, namespace) {
+			p.reportDiagnostic(diagnostic.CommandNotInEvent, "%s needs a connection, RULE_INIT runs once when the rule is loaded and has none", []any{name, tok}...)
+		}
+		return true
+	})
+}
+
+// notes static variables an event sets to a constant every time it runs, which
+// RULE_INIT can set once instead. assignments under a condition are left
+// alone, they usually toggle state
+func (p *Parser) checkStaticInit(event string, block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		set, ok := stmt.(*ast.SetStatement)
+		if !ok || set == nil {
+			continue
+		}
+		name, ok := literalWord(set.Name)
+		if !ok || !strings.HasPrefix(name, "static::") {
+			continue
+		}
+		if _, ok := literalWord(set.Value); ok {
+			p.reportSeverity(diagnostic.Info, diagnostic.StaticInitInEvent, "%s is set to a constant on every %s, set it once in RULE_INIT instead", []any{name, event, set.Token}...)
+		}
+	}
+}
+
+// returns the command a node invokes and the token it starts at
+func commandName(node ast.Node) (string, token.Token, bool) {
+	switch n := node.(type) {
+	case *ast.HttpExpression:
+		if n.Command != nil {
+			return n.Command.Value, n.Command.Token, true
+		}
+	case *ast.HttpRespondExpression:
+		return n.Token.Literal, n.Token, true
+	case *ast.LoadBalancerExpression:
+		if n.Command != nil {
+			return n.Command.Value, n.Command.Token, true
+		}
+	case *ast.CallExpression:
+		if fn, ok := n.Function.(*ast.Identifier); ok {
+			return fn.Value, fn.Token, true
+		}
+	case *ast.NodeStatement:
+		return n.Token.Literal, n.Token, true
+	}
+	return "", token.Token{}, false
+}
@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// weights of the constructs in EventCost.Score
+const (
+	regexCost       = 3
+	tableCost       = 2
+	classSearchCost = 2
+	stringMapCost   = 1
+	nestedLoopCost  = 5
+)
+
+// EventCost counts the constructs of an event handler that are expensive to
+// run on the data plane, where they are paid for on every event
+type EventCost struct {
+	Event       string
+	Rule        string // the ltm rule the handler is defined in, if any
+	Line        int
+	Regex       int // regexp, regsub, matches_regex and switch -regexp
+	Table       int // table commands
+	ClassSearch int // class search, class match, matchclass and findclass
+	StringMap   int // string map, every map of a chain counts
+	NestedLoops int // foreach, for and while inside another loop
+}
+
+// Score weighs the counted constructs, higher scores risk more CPU
+func (c EventCost) Score() int {
+	return c.Regex*regexCost + c.Table*tableCost + c.ClassSearch*classSearchCost +
+		c.StringMap*stringMapCost + c.NestedLoops*nestedLoopCost
+}
+
+// returns the expensive constructs of every when block in source order
+func (p *Parser) EventCosts() []EventCost {
+	costs := []EventCost{}
+	for _, recorder := range p.costs {
+		costs = append(costs, recorder.cost)
+	}
+	return costs
+}
+
+// counts expensive constructs from the tokens of a handler body
+type costRecorder struct {
+	cost    EventCost
+	command string // the first word of the current command
+	args    int    // words of the current command seen so far
+	depth   int    // brace depth within the handler body
+	loops   []int  // brace depths of the loops the current command is in
+}
+
+func newCostRecorder(event, rule string, line int) *costRecorder {
+	return &costRecorder{cost: EventCost{Event: event, Rule: rule, Line: line}}
+}
+
+func (r *costRecorder) record(prev, tok token.Token) {
+	switch tok.Type {
+	case token.LBRACE:
+		r.depth++
+	case token.RBRACE:
+		r.depth--
+	}
+
+	if isCommandStart(prev, tok) {
+		// a command at the depth of a loop or above it is past that loop
+		for len(r.loops) > 0 && r.loops[len(r.loops)-1] >= r.depth {
+			r.loops = r.loops[:len(r.loops)-1]
+		}
+		r.command, r.args = tok.Literal, 0
+
+		switch tok.Literal {
+		case "regexp", "regsub":
+			r.cost.Regex++
+		case "table":
+			r.cost.Table++
+		case "matchclass", "findclass":
+			r.cost.ClassSearch++
+		case "foreach", "for", "while":
+			if len(r.loops) > 0 {
+				r.cost.NestedLoops++
+			}
+			r.loops = append(r.loops, r.depth)
+		}
+		return
+	}
+
+	r.args++
+	switch {
+	case tok.Literal == "matches_regex":
+		r.cost.Regex++
+	case r.command == "switch" && prev.Type == token.MINUS && tok.Literal == "regexp":
+		r.cost.Regex++
+	case r.args == 1 && r.command == "class" && (tok.Literal == "search" || tok.Literal == "match"):
+		r.cost.ClassSearch++
+	case r.args == 1 && r.command == "string" && tok.Literal == "map":
+		r.cost.StringMap++
+	}
+}
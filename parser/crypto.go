@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// a key as AES::key returns it: the key length in bits and the key in hex
+var aesKeyRegex = regexp.MustCompile(`^AES (128|192|256) [0-9A-Fa-f]+$`)
+
+var aesKeyLengths = []string{"128", "192", "256"}
+
+// a CRYPTO:: command: its options, whether each takes a value, the options it
+// can't do without and the algorithms -alg accepts
+type cryptoCommand struct {
+	options    map[string]bool
+	required   [][]string // each entry lists options of which one is needed
+	algorithms []string
+}
+
+var (
+	cipherAlgorithms = []string{
+		"aes-128-cbc", "aes-192-cbc", "aes-256-cbc", "aes-128-ecb", "aes-192-ecb", "aes-256-ecb",
+		"bf-cbc", "bf-ecb", "des-cbc", "des-ecb", "des-ede3-cbc", "rc4", "rsa-pub", "rsa-priv",
+	}
+	signatureAlgorithms = []string{
+		"rsa-sha1", "rsa-sha256", "rsa-sha384", "rsa-sha512",
+		"hmac-md5", "hmac-sha1", "hmac-sha256", "hmac-sha384", "hmac-sha512",
+	}
+	hashAlgorithms = []string{"md5", "sha1", "sha256", "sha384", "sha512"}
+
+	cryptoCommands = map[string]cryptoCommand{
+		"CRYPTO::encrypt": {
+			options:    map[string]bool{"-alg": true, "-ctx": true, "-key": true, "-keyhex": true, "-iv": true, "-ivhex": true, "-padding": true},
+			required:   [][]string{{"-alg", "-ctx"}},
+			algorithms: cipherAlgorithms,
+		},
+		"CRYPTO::decrypt": {
+			options:    map[string]bool{"-alg": true, "-ctx": true, "-key": true, "-keyhex": true, "-iv": true, "-ivhex": true, "-padding": true},
+			required:   [][]string{{"-alg", "-ctx"}},
+			algorithms: cipherAlgorithms,
+		},
+		"CRYPTO::sign": {
+			options:    map[string]bool{"-alg": true, "-ctx": true, "-key": true, "-keyhex": true},
+			required:   [][]string{{"-alg", "-ctx"}, {"-key", "-keyhex", "-ctx"}},
+			algorithms: signatureAlgorithms,
+		},
+		"CRYPTO::verify": {
+			options:    map[string]bool{"-alg": true, "-ctx": true, "-key": true, "-keyhex": true, "-signature": true},
+			required:   [][]string{{"-alg", "-ctx"}, {"-key", "-keyhex", "-ctx"}, {"-signature"}},
+			algorithms: signatureAlgorithms,
+		},
+		"CRYPTO::hash": {
+			options:    map[string]bool{"-alg": true, "-ctx": true, "-final": false},
+			algorithms: hashAlgorithms,
+		},
+		"CRYPTO::keygen": {
+			options:    map[string]bool{"-alg": true, "-len": true, "-passphrase": true, "-salthex": true, "-rounds": true},
+			required:   [][]string{{"-alg"}},
+			algorithms: []string{"random", "rsa-512", "rsa-1024", "rsa-2048", "rsa-4096"},
+		},
+	}
+)
+
+// AES::key ?128|192|256?
+func checkAesKey(p *Parser, cmd *ast.CommandInvocation) {
+	if len(cmd.Arguments) == 0 {
+		return
+	}
+	if length, ok := literalWord(cmd.Arguments[0]); ok && !containsString(aesKeyLengths, length) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid key length '%s' for AES::key, expected one of %s", []any{length, strings.Join(aesKeyLengths, ", "), cmd.Token}...)
+	}
+}
+
+// AES::encrypt <key> <data> and AES::decrypt <key> <data>. a key written into
+// the rule must have the form AES::key returns
+func checkAesCipher(p *Parser, cmd *ast.CommandInvocation) {
+	key, ok := literalWord(cmd.Arguments[0])
+	if !ok || strings.ContainsAny(key, "$[") {
+		return
+	}
+	if !aesKeyRegex.MatchString(key) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects a key generated by AES::key, got '%s'", []any{cmd.Command, key, cmd.Token}...)
+	}
+}
+
+// CRYPTO::<command> ?-option value ...? ?data?
+func checkCrypto(p *Parser, cmd *ast.CommandInvocation) {
+	spec := cryptoCommands[cmd.Command]
+	seen := map[string]bool{}
+
+	args := cmd.Arguments
+	for len(args) > 0 {
+		option, ok := literalWord(args[0])
+		if !ok || !strings.HasPrefix(option, "-") {
+			break
+		}
+		args = args[1:]
+		takesValue, ok := spec.options[option]
+		if !ok {
+			valid := []string{}
+			for name := range spec.options {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for %s, expected one of %s", []any{option, cmd.Command, strings.Join(valid, ", "), cmd.Token}...)
+			return
+		}
+		seen[option] = true
+		if !takesValue {
+			continue
+		}
+		if len(args) == 0 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "%s %s expects a value", []any{cmd.Command, option, cmd.Token}...)
+			return
+		}
+		if algorithm, ok := literalWord(args[0]); ok && option == "-alg" && !containsString(spec.algorithms, strings.ToLower(algorithm)) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid algorithm '%s' for %s, expected one of %s", []any{algorithm, cmd.Command, strings.Join(spec.algorithms, ", "), cmd.Token}...)
+		}
+		args = args[1:]
+	}
+
+	for _, options := range spec.required {
+		found := false
+		for _, option := range options {
+			found = found || seen[option]
+		}
+		if !found {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "%s expects %s", []any{cmd.Command, strings.Join(options, " or "), cmd.Token}...)
+		}
+	}
+
+	if len(args) > 1 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects at most one data argument after its options, got %d", []any{cmd.Command, len(args), cmd.Token}...)
+	}
+}
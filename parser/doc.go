@@ -0,0 +1,22 @@
+// Package parser parses F5 BIG-IP iRules and checks them for mistakes.
+//
+// Validate is the simplest way in: it parses a rule and returns its parse
+// tree, its findings sorted by line, and statistics about the rule.
+// ValidateWithOptions does the same with options of its own, such as the
+// targeted TMOS version, so rules can be validated concurrently with
+// different settings. For finer control, create a Parser with New from a
+// lexer and call ParseProgram, then read Diagnostics, EventHandlers and
+// Procs.
+//
+// # Stability
+//
+// The library API follows semantic versioning, starting with v1.0.0. Within
+// a major version the exported identifiers of the ast, diagnostic, lexer,
+// parser and token packages and config.Options are only added to, never
+// removed or changed in a breaking way, and the codes of findings keep their
+// meaning. The rest of the config package backs the command line and may
+// change at any time.
+// The wording of finding messages, the debug output and the findings
+// reported for a given rule may change in any release, as checks are added
+// and improved.
+package parser
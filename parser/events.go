@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+const (
+	defaultEventPriority = 500
+	minEventPriority     = 0
+	maxEventPriority     = 1000
+)
+
+// EventHandler is a when block as it is scheduled by TMOS
+type EventHandler struct {
+	Event    string
+	Priority int
+	Line     int
+	Rule     string // the ltm rule the handler is defined in, if any
+}
+
+// returns every when block of the parsed rule in source order
+func (p *Parser) EventHandlers() []EventHandler {
+	return p.eventHandlers
+}
+
+// returns the handlers defined in the given ltm rule, or outside of any
+// ltm rule when the name is empty
+func ruleEventHandlers(handlers []EventHandler, rule string) []EventHandler {
+	inRule := []EventHandler{}
+	for _, handler := range handlers {
+		if handler.Rule == rule {
+			inRule = append(inRule, handler)
+		}
+	}
+	return inRule
+}
+
+// parses the optional 'priority <n>' and 'timing on|off' words between the
+// event name and the body of a when block
+func (p *Parser) parseWhenOptions(expr *ast.WhenExpression) bool {
+	expr.Priority = p.defaultPriority
+
+	for p.peekTokenIs(token.IDENT) {
+		switch p.peekToken.Literal {
+		case "priority":
+			p.nextToken()
+			priority, ok := p.parsePriorityValue()
+			if !ok {
+				return false
+			}
+			expr.Priority = priority
+		case "timing":
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) || (p.curToken.Literal != "on" && p.curToken.Literal != "off") {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "timing expects on or off, got %s", []any{p.curToken.Literal, p.curToken}...)
+				return false
+			}
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// parses a rule wide 'priority <n>' that applies to the when blocks after it
+func (p *Parser) parsePriorityStatement() ast.Statement {
+	stmt := &ast.PriorityStatement{Token: p.curToken}
+
+	priority, ok := p.parsePriorityValue()
+	if !ok {
+		return nil
+	}
+	stmt.Value = priority
+	p.defaultPriority = priority
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parsePriorityStatement - Default priority now %d\n", priority)
+	}
+	return stmt
+}
+
+// expects the current token to be 'priority' and reads the number after it
+func (p *Parser) parsePriorityValue() (int, bool) {
+	pos := p.curToken
+	if !p.expectPeek(token.NUMBER) {
+		return 0, false
+	}
+
+	priority, err := strconv.Atoi(p.curToken.Literal)
+	if err != nil || priority < minEventPriority || priority > maxEventPriority {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "priority must be between %d and %d, got %s", []any{minEventPriority, maxEventPriority, p.curToken.Literal, pos}...)
+		return 0, false
+	}
+	return priority, true
+}
+
+// EventOrder returns, for every event handled more than once, its handlers in
+// the order they run: lowest priority first, then in the order given
+func EventOrder(handlers []EventHandler) map[string][]EventHandler {
+	byEvent := map[string][]EventHandler{}
+	for _, handler := range handlers {
+		byEvent[handler.Event] = append(byEvent[handler.Event], handler)
+	}
+
+	order := map[string][]EventHandler{}
+	for event, handlers := range byEvent {
+		if len(handlers) < 2 {
+			continue
+		}
+		sort.SliceStable(handlers, func(i, j int) bool {
+			return handlers[i].Priority < handlers[j].Priority
+		})
+		order[event] = handlers
+	}
+	return order
+}
+
+// PriorityTies warns about handlers of the same event that share a priority,
+// their relative order is not something a rule should rely on
+func PriorityTies(order map[string][]EventHandler) []diagnostic.Diagnostic {
+	diagnostics := []diagnostic.Diagnostic{}
+
+	events := []string{}
+	for event := range order {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	for _, event := range events {
+		handlers := order[event]
+		for i := 1; i < len(handlers); i++ {
+			if handlers[i].Priority == handlers[i-1].Priority {
+				diagnostics = append(diagnostics, diagnostic.Diagnostic{
+					Code:     diagnostic.PriorityTie,
+					Message:  fmt.Sprintf("%s is also handled on line %d with the same priority %d", event, handlers[i-1].Line, handlers[i].Priority),
+					Line:     handlers[i].Line,
+					Severity: diagnostic.Warning,
+				})
+			}
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,110 @@
+package parser_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+func ExampleValidate() {
+	result := parser.Validate(`when HTTP_REQUEST {
+  if { [HTTP::host] eq "example.com" } {
+    HTTP::redirect "https://www.example.com[HTTP::uri]"
+  }
+  log local0. "client $clinet"
+}`)
+
+	for _, d := range result.Diagnostics {
+		fmt.Println(d)
+	}
+	fmt.Println("events:", result.Stats.Events, "HTTP commands:", result.Stats.Commands["HTTP"])
+	// Output:
+	// [semantic S201 warning] variable clinet is read in HTTP_REQUEST but never set, Line: 5, Column: 23
+	// events: 1 HTTP commands: 3
+}
+
+func ExampleValidateWithOptions() {
+	rule := `when HTTP_REQUEST { HTTP2::push "/style.css" }`
+
+	result := parser.ValidateWithOptions(rule, config.Options{TmosVersion: "13.1"})
+	for _, d := range result.Diagnostics {
+		fmt.Println(d)
+	}
+	// Output:
+	// [semantic S203] HTTP2::push requires TMOS 14.1 or later, targeting 13.1, Line: 1, Column: 21
+}
+
+func ExampleParser_ParseProgram() {
+	p := parser.New(lexer.New(`when HTTP_REQUEST priority 100 {
+  switch -glob [HTTP::uri] {
+    "/api/*" { pool api_pool }
+    default { pool web_pool }
+  }
+}`))
+	program := p.ParseProgram()
+
+	for _, handler := range p.EventHandlers() {
+		fmt.Println(handler.Event, handler.Priority)
+	}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if stmt, ok := node.(*ast.SwitchStatement); ok {
+			fmt.Println("cases:", len(stmt.Cases), "default:", stmt.Default != nil)
+		}
+		return true
+	})
+	// Output:
+	// HTTP_REQUEST 100
+	// cases: 1 default: true
+}
+
+// requestID requires every HTTP_REQUEST handler to insert an X-Request-ID
+// header
+type requestID struct{}
+
+func (requestID) Name() string { return "request-id" }
+
+func (requestID) Check(program *ast.Program) []diagnostic.Diagnostic {
+	findings := []diagnostic.Diagnostic{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		when, ok := node.(*ast.WhenExpression)
+		if !ok || when.Event.String() != "HTTP_REQUEST" {
+			return true
+		}
+		inserted := false
+		ast.Inspect(when.Block, func(node ast.Node) bool {
+			if header, ok := node.(*ast.HttpExpression); ok && header.Method != nil && header.Method.Value == "insert" {
+				inserted = inserted || strings.HasPrefix(header.Argument.String(), "X-Request-ID")
+			}
+			return true
+		})
+		if !inserted {
+			findings = append(findings, diagnostic.Diagnostic{
+				Message:  "HTTP_REQUEST doesn't set X-Request-ID",
+				Line:     when.Token.Line,
+				Column:   when.Token.Column,
+				Severity: diagnostic.Warning,
+			})
+		}
+		return false
+	})
+	return findings
+}
+
+func ExampleRegisterAnalyzer() {
+	parser.RegisterAnalyzer(requestID{})
+	defer parser.UnregisterAnalyzer("request-id")
+
+	result := parser.Validate(`when HTTP_REQUEST {
+  HTTP::header insert X-Forwarded-Proto https
+}`)
+	for _, d := range result.Diagnostics {
+		fmt.Println(d)
+	}
+	// Output:
+	// [semantic S219 warning] request-id: HTTP_REQUEST doesn't set X-Request-ID, Line: 1, Column: 1
+}
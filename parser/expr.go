@@ -0,0 +1,417 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the precedences of expr operators, loosest first
+const (
+	_ int = iota
+	exprLowest
+	exprTernary  // ?:
+	exprOr       // || or
+	exprAnd      // && and
+	exprBitOr    // |
+	exprBitXor   // ^
+	exprBitAnd   // &
+	exprIn       // in ni
+	exprEquality // == != eq ne and the iRules string operators
+	exprCompare  // < > <= >= lt gt le ge
+	exprShift    // << >>
+	exprSum      // + -
+	exprProduct  // * / %
+	exprPower    // **
+	exprUnary    // - + ~ !
+)
+
+// the binary operators of expr. iRules adds word operators such as contains
+// and starts_with next to the Tcl ones
+var exprOperators = map[string]int{
+	"||": exprOr, "or": exprOr,
+	"&&": exprAnd, "and": exprAnd,
+	"|":  exprBitOr,
+	"^":  exprBitXor,
+	"&":  exprBitAnd,
+	"in": exprIn, "ni": exprIn,
+	"==": exprEquality, "!=": exprEquality, "eq": exprEquality, "ne": exprEquality,
+	"equals": exprEquality, "contains": exprEquality, "starts_with": exprEquality,
+	"ends_with": exprEquality, "matches_glob": exprEquality, "matches_regex": exprEquality,
+	"<": exprCompare, ">": exprCompare, "<=": exprCompare, ">=": exprCompare,
+	"lt": exprCompare, "gt": exprCompare, "le": exprCompare, "ge": exprCompare,
+	"<<": exprShift, ">>": exprShift,
+	"+": exprSum, "-": exprSum,
+	"*": exprProduct, "/": exprProduct, "%": exprProduct,
+	"**": exprPower,
+}
+
+// the math functions of expr with the least and most arguments they take,
+// -1 when there is no limit
+var mathFunctions = map[string][2]int{
+	"abs": {1, 1}, "acos": {1, 1}, "asin": {1, 1}, "atan": {1, 1}, "atan2": {2, 2},
+	"bool": {1, 1}, "ceil": {1, 1}, "cos": {1, 1}, "cosh": {1, 1}, "double": {1, 1},
+	"entier": {1, 1}, "exp": {1, 1}, "floor": {1, 1}, "fmod": {2, 2}, "hypot": {2, 2},
+	"int": {1, 1}, "isqrt": {1, 1}, "log": {1, 1}, "log10": {1, 1}, "max": {1, -1},
+	"min": {1, -1}, "pow": {2, 2}, "rand": {0, 0}, "round": {1, 1}, "sin": {1, 1},
+	"sinh": {1, 1}, "sqrt": {1, 1}, "srand": {1, 1}, "tan": {1, 1}, "tanh": {1, 1},
+	"wide": {1, 1},
+}
+
+// the bare words expr accepts as booleans
+var exprBooleans = []string{"true", "false", "yes", "no", "on", "off"}
+
+// parses expr arg ?arg ...?. a single braced argument is parsed as the
+// expression, otherwise the words up to the end of the command are
+func (p *Parser) parseExprCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseExprCommand Start - Line: %d\n", p.curToken.Line)
+	}
+	expr := &ast.ExprExpression{Token: p.curToken}
+
+	if p.catchArgumentEnd() {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: expr expects an expression", []any{expr.Token}...)
+		return expr
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		expr.Braced = true
+		if p.peekTokenIs(token.RBRACE) {
+			p.nextToken()
+			p.reportDiagnostic(diagnostic.SyntaxError, "empty expression", []any{expr.Token}...)
+			return expr
+		}
+		p.nextToken() // move past '{'
+		expr.Expression = p.parseExprExpression(exprLowest)
+		if expr.Expression == nil || !p.peekTokenIs(token.RBRACE) {
+			if expr.Expression != nil {
+				p.reportExprEnd()
+			}
+			p.skipExprTo(token.RBRACE)
+			return expr
+		}
+		p.nextToken() // move to the closing '}'
+	} else {
+		first := p.curToken
+		expr.Expression = p.parseExprExpression(exprLowest)
+		if expr.Expression == nil || !p.exprCommandEnd() {
+			if expr.Expression != nil {
+				p.reportExprEnd()
+			}
+			p.skipExprTo(token.RBRACKET)
+		}
+
+		// only substitutions are made twice, a constant expression is the
+		// same either way
+		if strings.ContainsAny(p.l.Source(first.Offset, p.peekToken.Offset), "$[") {
+			p.reportWarning(diagnostic.UnbracedExpression, "expr should be enclosed in braces, otherwise it is substituted twice", []any{expr.Token}...)
+			// the closing brace goes before the bracket ending the substitution
+			if p.peekTokenIs(token.RBRACKET) && p.peekToken.Line == first.Line && first.Column > 0 {
+				p.suggestFix("Enclose the expression in braces",
+					diagnostic.TextEdit{Start: tokenStart(first), End: tokenStart(first), NewText: "{"},
+					diagnostic.TextEdit{Start: tokenStart(p.peekToken), End: tokenStart(p.peekToken), NewText: "}"})
+			}
+		}
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseExprCommand End - Expression: %v\n", expr.Expression)
+	}
+	return expr
+}
+
+// parses an expression of expr made of operators binding tighter than
+// precedence. the current token is left on the last token of the expression
+func (p *Parser) parseExprExpression(precedence int) ast.Expression {
+	left := p.parseExprOperand()
+	if left == nil {
+		return nil
+	}
+
+	for {
+		if p.peekTokenIs(token.QUESTION) && precedence < exprTernary {
+			left = p.parseConditionalExpression(left)
+			if left == nil {
+				return nil
+			}
+			continue
+		}
+
+		operatorPrecedence, ok := p.peekExprOperator()
+		if !ok || operatorPrecedence <= precedence {
+			return left
+		}
+		p.nextToken()
+		infix := &ast.InfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
+		if !p.nextExprOperand() {
+			return nil
+		}
+
+		// ** is right associative, so 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		if operatorPrecedence == exprPower {
+			operatorPrecedence--
+		}
+		infix.Right = p.parseExprExpression(operatorPrecedence)
+		if infix.Right == nil {
+			return nil
+		}
+		left = infix
+	}
+}
+
+// parses the ? consequence : alternative following condition
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	p.nextToken() // move to '?'
+	expr := &ast.ConditionalExpression{Token: p.curToken, Condition: condition}
+
+	if !p.nextExprOperand() {
+		return nil
+	}
+	expr.Consequence = p.parseExprExpression(exprLowest)
+	if expr.Consequence == nil {
+		return nil
+	}
+	if !p.peekTokenIs(token.COLON) {
+		p.reportDiagnostic(diagnostic.SyntaxError, "missing ':' after the '?' of a conditional expression", []any{expr.Token}...)
+		return nil
+	}
+	p.nextToken() // move to ':'
+	if !p.nextExprOperand() {
+		return nil
+	}
+
+	// the alternative may be a conditional itself, as in a ? b : c ? d : e
+	expr.Alternative = p.parseExprExpression(exprTernary - 1)
+	if expr.Alternative == nil {
+		return nil
+	}
+	return expr
+}
+
+// returns the precedence of the binary operator following the current token
+func (p *Parser) peekExprOperator() (int, bool) {
+	if p.peekTokenIs(token.STRING) {
+		return 0, false
+	}
+	precedence, ok := exprOperators[p.peekToken.Literal]
+	return precedence, ok
+}
+
+// parses a single operand of expr: a number, variable, string, command
+// substitution, math function call, parenthesized or unary expression
+func (p *Parser) parseExprOperand() ast.Expression {
+	switch p.curToken.Type {
+	case token.MINUS, token.PLUS, token.BANG, token.TILDE:
+		return p.parseExprUnary()
+	case token.NUMBER:
+		return p.parseExprNumber()
+	case token.STRING:
+		return p.parseStringLiteral()
+	case token.LBRACE:
+		return p.parseBracedStringLiteral()
+	case token.LBRACKET:
+		return p.parseArrayLiteral()
+	case token.TRUE, token.FALSE:
+		return p.parseBoolean()
+	case token.LPAREN:
+		p.nextToken()
+		inner := p.parseExprExpression(exprLowest)
+		if inner == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return &ast.ParenthesizedExpression{Expression: inner}
+	case token.IDENT:
+		return p.parseExprWord()
+	}
+
+	p.reportDiagnostic(diagnostic.SyntaxError, "unexpected '%s' in expression, expected an operand", []any{p.curToken.Literal, p.curToken}...)
+	return nil
+}
+
+// moves to the operand following the current operator, reporting a missing one
+func (p *Parser) nextExprOperand() bool {
+	switch p.peekToken.Type {
+	case token.RBRACE, token.RBRACKET, token.RPAREN, token.COMMA, token.COLON, token.EOF:
+		p.reportDiagnostic(diagnostic.SyntaxError, "missing operand after '%s'", []any{p.curToken.Literal, p.curToken}...)
+		return false
+	}
+	p.nextToken()
+	return true
+}
+
+func (p *Parser) parseExprUnary() ast.Expression {
+	expr := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+	if !p.nextExprOperand() {
+		return nil
+	}
+	expr.Right = p.parseExprExpression(exprUnary)
+	if expr.Right == nil {
+		return nil
+	}
+	return expr
+}
+
+// parses a number. the lexer splits numbers such as 0x1F and 1e3 into
+// several tokens, so the adjacent ones are joined
+func (p *Parser) parseExprNumber() ast.Expression {
+	tok := p.curToken
+	for p.peekIsAdjacent() && (p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.NUMBER)) {
+		p.nextToken()
+		tok.Literal += p.curToken.Literal
+	}
+
+	number := &ast.NumberLiteral{Token: tok}
+	if value, err := strconv.ParseInt(tok.Literal, 0, 64); err == nil {
+		number.Value = value
+	} else if _, err := strconv.ParseFloat(tok.Literal, 64); err != nil {
+		p.reportDiagnostic(diagnostic.SyntaxError, "invalid number '%s' in expression", []any{tok.Literal, tok}...)
+		return nil
+	}
+	return number
+}
+
+// parses a word of expr: a variable, a math function call or a boolean
+func (p *Parser) parseExprWord() ast.Expression {
+	tok := p.curToken
+
+	if strings.HasPrefix(tok.Literal, "$") {
+		variable := &ast.Identifier{Token: tok, Value: tok.Literal}
+		// an array element such as $counts(host)
+		if p.peekTokenIs(token.LPAREN) && p.peekIsAdjacent() {
+			depth := 0
+			for {
+				p.nextToken()
+				variable.Value += p.curToken.Literal
+				if p.curTokenIs(token.LPAREN) {
+					depth++
+				} else if p.curTokenIs(token.RPAREN) {
+					depth--
+				}
+				if depth == 0 || p.curTokenIs(token.EOF) {
+					break
+				}
+			}
+		}
+		return variable
+	}
+
+	if p.peekTokenIs(token.LPAREN) && p.peekIsAdjacent() {
+		return p.parseMathFunction()
+	}
+
+	if containsString(exprBooleans, strings.ToLower(tok.Literal)) {
+		return &ast.Identifier{Token: tok, Value: tok.Literal}
+	}
+
+	// a number such as .5 starts with a dot, which the lexer reads as a word
+	if _, err := strconv.ParseFloat(tok.Literal, 64); err == nil {
+		return &ast.NumberLiteral{Token: tok}
+	}
+
+	p.reportDiagnostic(diagnostic.SyntaxError, "invalid bareword \"%s\" in expression, strings must be quoted", []any{tok.Literal, tok}...)
+	return nil
+}
+
+// parses a call of a math function such as min($a, $b)
+func (p *Parser) parseMathFunction() ast.Expression {
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() // move to '('
+	call := &ast.CallExpression{Token: p.curToken, Function: name}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+	} else {
+		for {
+			p.nextToken()
+			argument := p.parseExprExpression(exprLowest)
+			if argument == nil {
+				return nil
+			}
+			call.Arguments = append(call.Arguments, argument)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	arity, ok := mathFunctions[name.Value]
+	if !ok {
+		valid := []string{}
+		for function := range mathFunctions {
+			valid = append(valid, function)
+		}
+		sort.Strings(valid)
+		p.reportDiagnostic(diagnostic.InvalidCommand, "unknown math function '%s', expected one of %s", []any{name.Value, strings.Join(valid, ", "), name.Token}...)
+		return call
+	}
+	if count := len(call.Arguments); count < arity[0] || (arity[1] >= 0 && count > arity[1]) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects %s, got %d", []any{name.Value, describeArity(arity), count, name.Token}...)
+	}
+	return call
+}
+
+func describeArity(arity [2]int) string {
+	switch {
+	case arity[1] < 0 && arity[0] == 1:
+		return "at least 1 argument"
+	case arity[1] < 0:
+		return fmt.Sprintf("at least %d arguments", arity[0])
+	case arity[0] == arity[1] && arity[0] == 1:
+		return "1 argument"
+	case arity[0] == arity[1]:
+		return fmt.Sprintf("%d arguments", arity[0])
+	default:
+		return fmt.Sprintf("%d to %d arguments", arity[0], arity[1])
+	}
+}
+
+// reports whether the words of an unbraced expr end after the current token
+func (p *Parser) exprCommandEnd() bool {
+	return p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE)
+}
+
+// reports the token left over after a complete expression
+func (p *Parser) reportExprEnd() {
+	if p.peekTokenIs(token.EOF) {
+		p.reportDiagnostic(diagnostic.SyntaxError, "unterminated expression", []any{p.curToken}...)
+		return
+	}
+	p.reportDiagnostic(diagnostic.SyntaxError, "unexpected '%s' in expression, expected an operator", []any{p.peekToken.Literal, p.peekToken}...)
+}
+
+// skips the rest of a malformed expression up to the token closing it, which
+// is left as the current token for braces and as the peek token for brackets
+func (p *Parser) skipExprTo(end token.TokenType) {
+	depth := 0
+	for !p.peekTokenIs(token.EOF) {
+		if depth == 0 && p.peekTokenIs(end) {
+			if end == token.RBRACE {
+				p.nextToken()
+			}
+			return
+		}
+		if end == token.RBRACKET && depth == 0 && p.peekIsCommandEnd() {
+			return
+		}
+		switch p.peekToken.Type {
+		case token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RBRACE, token.RBRACKET:
+			depth--
+		}
+		p.nextToken()
+	}
+}
@@ -0,0 +1,360 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the indentation of one level of nesting
+const formatIndent = 4
+
+// the spaces between the brace closing a block and else or elseif
+var elseSpacingRegex = regexp.MustCompile(`^\}\s+(else|elseif)\b`)
+
+// the lines whose last brace may open the body of a block moved by the
+// braces style: when, if, elseif and else
+var blockHeaderRegex = regexp.MustCompile(`^(\}\s*)?(when|if|elseif|else)\b`)
+
+// the state of the rule at the start of a line while it is formatted
+type formatter struct {
+	depth        int   // braces open
+	inQuote      bool  // in a quoted string spanning lines
+	continuation bool  // the previous line ended in a backslash
+	verbatim     int   // the depth a braced value kept as is was opened at, -1 outside one
+	switches     []int // the depths inside the bodies of the switch commands open
+}
+
+// FormatRule indents a rule by the nesting of its braces, four spaces a level,
+// with closing braces lined up with the line opening the block. trailing
+// spaces and runs of blank lines are dropped, and the spacing inside command
+// substitutions such as [ HTTP::header  value Host ] is normalized. comments,
+// quoted strings spanning lines and the braced values of set and content are
+// kept as they are. a rule whose braces or quotes don't balance is returned
+// with an error
+func FormatRule(input string) (string, error) {
+	return FormatRuleWithStyle(input, config.Style{})
+}
+
+// FormatRuleWithStyle formats a rule as FormatRule does, in the layout of a
+// style: the spaces a level, whether the brace opening the body of when, if,
+// elseif and else ends its line or starts the next one after a backslash
+// continuing the header, and whether the cases of a switch line up with it
+func FormatRuleWithStyle(input string, style config.Style) (string, error) {
+	indentWidth := style.Indent
+	if indentWidth == 0 {
+		indentWidth = formatIndent
+	}
+	f := &formatter{verbatim: -1}
+	out := []string{}
+	blank := false
+	header := -1 // the line of out holding a block header whose body brace is on the next line
+	headerPrefix := ""
+
+	for i, line := range strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n") {
+		if f.inQuote || (f.verbatim >= 0 && f.depth > f.verbatim) {
+			out = append(out, line)
+		} else if trimmed := strings.TrimSpace(line); trimmed == "" {
+			blank = len(out) > 0
+		} else {
+			if blank {
+				out = append(out, "")
+				blank = false
+			}
+			depth := f.depth - (len(trimmed) - len(strings.TrimLeft(trimmed, "}")))
+			indent := depth
+			if style.SwitchCases == "aligned" {
+				for _, switchDepth := range f.switches {
+					if switchDepth <= depth {
+						indent--
+					}
+				}
+			}
+			if f.continuation {
+				indent++
+			}
+			prefix := strings.Repeat(" ", max(indent, 0)*indentWidth)
+			comment := strings.HasPrefix(trimmed, "#")
+			if !comment {
+				trimmed = elseSpacingRegex.ReplaceAllString(normalizeBrackets(trimmed), "} $1")
+			}
+
+			// a newline ends a tcl command, so a body brace moved to the next
+			// line follows a backslash continuing the header
+			switch {
+			case style.Braces == "same-line" && trimmed == "{" && header == len(out)-1:
+				out[header] = strings.TrimSuffix(out[header], " \\") + " {"
+			case style.Braces == "next-line" && trimmed == "{" && header == len(out)-1:
+				if !strings.HasSuffix(out[header], " \\") {
+					out[header] += " \\"
+				}
+				out = append(out, headerPrefix+"{")
+			case style.Braces == "next-line" && !f.continuation && isBlockHeader(strings.TrimSuffix(trimmed, "{")):
+				out = append(out, prefix+strings.TrimSpace(strings.TrimSuffix(trimmed, "{"))+" \\", prefix+"{")
+			default:
+				out = append(out, prefix+trimmed)
+			}
+			header = -1
+			if !comment && !f.continuation && isBlockHeader(strings.TrimSuffix(trimmed, "\\")+" ") {
+				header, headerPrefix = len(out)-1, prefix
+			}
+		}
+
+		if err := f.scan(line); err != nil {
+			return "", fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+
+	switch {
+	case f.inQuote:
+		return "", fmt.Errorf("unterminated quoted string")
+	case f.depth > 0:
+		return "", fmt.Errorf("unbalanced braces: %d { left open", f.depth)
+	}
+	return strings.Join(out, "\n") + "\n", nil
+}
+
+// reports whether a line followed by the brace opening a body, given without
+// it, is the header of a when, if, elseif or else block. the line has to end
+// in a space, end its braced words and hold the condition of if and elseif
+func isBlockHeader(line string) bool {
+	match := blockHeaderRegex.FindStringSubmatch(line)
+	if match == nil || !strings.HasSuffix(line, " ") || strings.Contains(line, "#") {
+		return false
+	}
+	rest := strings.TrimSpace(line[len(match[0]):])
+	if (match[2] == "if" || match[2] == "elseif") && rest == "" {
+		return false
+	}
+	depth := 0
+	for _, c := range rest {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// follows the braces and quotes of a line. braces count wherever they are,
+// in quoted strings and comments alike, as tcl counts them in a braced body
+func (f *formatter) scan(line string) error {
+	commandStart := !f.inQuote && !f.continuation
+	wordStart := !f.inQuote
+	words := []string{}
+	word := ""
+	f.continuation = false
+
+	// the body of a switch starting the line, followed when the line leaves
+	// it open
+	fields := strings.Fields(line)
+	switchLine := commandStart && f.verbatim < 0 && len(fields) > 0 && fields[0] == "switch"
+	lineDepth, switchBody := f.depth, -1
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			if i == len(line)-1 {
+				f.continuation = true
+			}
+			i++
+			word += "\\"
+			commandStart, wordStart = false, false
+			continue
+		}
+		if c == '{' || c == '}' {
+			if c == '{' {
+				if wordStart && f.verbatim < 0 && !f.inQuote && isVerbatimValue(line, words) {
+					f.verbatim = f.depth
+				}
+				if wordStart && switchLine && !f.inQuote && f.depth == lineDepth {
+					switchBody = f.depth + 1
+				}
+				f.depth++
+			} else {
+				f.depth--
+				if f.depth < 0 {
+					return fmt.Errorf("unbalanced braces: } without {")
+				}
+				if f.depth <= f.verbatim {
+					f.verbatim = -1
+				}
+				if switchBody > f.depth {
+					switchBody = -1
+				}
+				f.leaveSwitches()
+			}
+			commandStart, wordStart = c == '{', c == '{'
+			continue
+		}
+		if f.inQuote {
+			if c == '"' {
+				f.inQuote = false
+			}
+			continue
+		}
+		if f.verbatim >= 0 {
+			continue
+		}
+
+		switch {
+		case c == ' ' || c == '\t':
+			if word != "" {
+				words = append(words, word)
+				word = ""
+			}
+			wordStart = true
+		case c == ';':
+			commandStart, wordStart = true, true
+		case c == '#' && commandStart:
+			// the rest of the line is a comment, where only braces count
+			for _, r := range line[i+1:] {
+				switch r {
+				case '{':
+					f.depth++
+				case '}':
+					f.depth--
+				}
+			}
+			if f.depth < 0 {
+				return fmt.Errorf("unbalanced braces: } without {")
+			}
+			f.leaveSwitches()
+			i = len(line)
+		case c == '"' && wordStart:
+			f.inQuote = true
+			commandStart, wordStart = false, false
+		case c == '[':
+			commandStart, wordStart = false, true
+		default:
+			word += string(c)
+			commandStart, wordStart = false, false
+		}
+	}
+
+	if switchBody > 0 && f.depth >= switchBody {
+		f.switches = append(f.switches, switchBody)
+	}
+	return nil
+}
+
+// forgets the switch bodies the braces closed so far have left
+func (f *formatter) leaveSwitches() {
+	for len(f.switches) > 0 && f.switches[len(f.switches)-1] > f.depth {
+		f.switches = f.switches[:len(f.switches)-1]
+	}
+}
+
+// reports whether the brace following words opens a value kept as it is: the
+// value of set or of HTTP::respond content, such as a page spanning lines
+func isVerbatimValue(line string, words []string) bool {
+	if len(words) > 0 && words[len(words)-1] == "content" {
+		return true
+	}
+	fields := strings.Fields(line)
+	return len(words) == 2 && len(fields) > 0 && fields[0] == "set"
+}
+
+// removes the spaces after the opening and before the closing bracket of the
+// command substitutions of a line and collapses the spaces between their
+// words. brackets are only touched when their first word is a known command,
+// leaving patterns such as the character class [ a-z] alone
+func normalizeBrackets(line string) string {
+	var out strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) {
+			out.WriteString(line[i : i+2])
+			i++
+			continue
+		}
+		if c != '[' || (i > 0 && !strings.ContainsRune(" \t\"[{(", rune(line[i-1]))) {
+			out.WriteByte(c)
+			continue
+		}
+		end := closingBracket(line, i)
+		inner := strings.TrimSpace(line[i+1 : max(end, i+1)])
+		if end < 0 || !isFormatCommand(strings.Fields(inner + " ")[0]) {
+			out.WriteByte(c)
+			continue
+		}
+		out.WriteString("[" + collapseSpaces(normalizeBrackets(inner)) + "]")
+		i = end
+	}
+	return out.String()
+}
+
+// returns the index of the bracket closing the one at start on the same line,
+// or -1. brackets in quoted strings and braces don't count
+func closingBracket(line string, start int) int {
+	depth, braces, inQuote := 0, 0, false
+	for i := start; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && braces == 0:
+			inQuote = !inQuote
+		case inQuote:
+		case c == '{':
+			braces++
+		case c == '}':
+			braces--
+		case braces > 0:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// collapses runs of spaces between the words of a command, leaving quoted
+// strings and braced words alone
+func collapseSpaces(command string) string {
+	var out strings.Builder
+	braces, inQuote, space := 0, false, false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		if c == ' ' || c == '\t' {
+			if braces == 0 && !inQuote {
+				space = true
+				continue
+			}
+		}
+		if space {
+			out.WriteByte(' ')
+			space = false
+		}
+		switch {
+		case c == '\\' && i+1 < len(command):
+			out.WriteByte(c)
+			i++
+			c = command[i]
+		case c == '"' && braces == 0:
+			inQuote = !inQuote
+		case c == '{' && !inQuote:
+			braces++
+		case c == '}' && !inQuote:
+			braces--
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// reports whether a word names a command whose substitution may be respaced
+func isFormatCommand(word string) bool {
+	if _, ok := LookupCommand(word); ok {
+		return true
+	}
+	return strings.Contains(word, "::") || token.LookupIdent(word) != token.IDENT || containsString(substitutionCommands, word)
+}
@@ -0,0 +1,228 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the subcommands of HTTP::header and the arguments each takes after it
+var httpHeaderSubcommands = subcommandArgs{
+	"at": {1, 1}, "count": {0, 1}, "exists": {1, 1}, "insert": {2, -1},
+	"insert_modssl_fields": {0, -1}, "is_keepalive": {0, 0}, "is_redirect": {0, 0},
+	"names": {0, 0}, "remove": {1, 1}, "replace": {1, 2}, "sanitize": {0, -1},
+	"value": {1, 1}, "values": {1, 1},
+}
+
+// HTTP::header subcommands whose first argument is a header name
+var headerNameSubcommands = []string{"exists", "remove", "replace", "value", "values"}
+
+// the subcommands of HTTP::cookie and the arguments each takes after it
+var httpCookieSubcommands = subcommandArgs{
+	"attribute": {1, -1}, "comment": {1, 2}, "commenturl": {1, 2}, "count": {0, 0},
+	"decrypt": {2, 3}, "domain": {1, 3}, "encrypt": {2, 3}, "exists": {1, 1},
+	"expires": {1, 3}, "httponly": {1, 2}, "insert": {4, -1}, "maxage": {1, 2},
+	"names": {0, 0}, "path": {1, 3}, "ports": {1, 2}, "remove": {1, 1},
+	"samesite": {1, 2}, "sanitize": {0, -1}, "secure": {1, 2}, "value": {1, 2},
+	"version": {1, 2},
+}
+
+// the options of HTTP::cookie insert, given as pairs with their values
+var cookieInsertOptions = []string{"domain", "name", "path", "value", "version"}
+
+// the values each HTTP::cookie attribute subcommand may set
+var cookieAttributeValues = map[string][]string{
+	"httponly": {"disable", "enable"},
+	"samesite": {"lax", "none", "strict"},
+	"secure":   {"disable", "enable"},
+}
+
+// the http commands rewriting a part of the request when given a value
+var mutableHttpCommands = []string{"HTTP::path", "HTTP::query", "HTTP::uri", "HTTP::version"}
+
+// the http commands that only return what the client or the server sent
+var readOnlyHttpCommands = []string{
+	"HTTP::host", "HTTP::is_keepalive", "HTTP::method", "HTTP::password",
+	"HTTP::status", "HTTP::username",
+}
+
+// parses the value a command such as HTTP::uri rewrites the request with, and
+// reports a value given to any other command, which is read-only. HTTP::uri
+// and HTTP::path may return the normalized uri with -normalized
+func (p *Parser) parseHttpValue(expr *ast.HttpExpression) {
+	command := expr.Command.Value
+	args := p.parseCommandArguments()
+	if (command == "HTTP::uri" || command == "HTTP::path") && len(args) > 0 && isWord(args[0], "-normalized") {
+		args = args[1:]
+	}
+
+	switch {
+	case len(args) == 0:
+	case !containsString(mutableHttpCommands, command):
+		p.reportDiagnostic(diagnostic.InvalidCommand, "%s is read-only and takes no value, got %d argument(s)", []any{command, len(args), expr.Token}...)
+	case len(args) > 1:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects at most 1 argument(s), got %d", []any{command, len(args), expr.Token}...)
+	default:
+		expr.Argument = args[0]
+		if command == "HTTP::uri" || command == "HTTP::path" {
+			p.checkURIRewrite(expr)
+		}
+	}
+}
+
+// parses HTTP::header subcommand ?arg ...? and its shorthand HTTP::header
+// name, which returns the value of the header
+func (p *Parser) parseHttpHeaderCommand() ast.Expression {
+	return p.parseHttpSubcommand(httpHeaderSubcommands, p.checkHeaderArguments)
+}
+
+// parses HTTP::cookie subcommand ?arg ...? and its shorthand HTTP::cookie
+// name, which returns the value of the cookie
+func (p *Parser) parseHttpCookieCommand() ast.Expression {
+	return p.parseHttpSubcommand(httpCookieSubcommands, p.checkCookieArguments)
+}
+
+// parses an http command taking a subcommand, or a name as a shorthand for
+// reading a value. check is given the arguments following a valid subcommand,
+// or the name alone with an empty subcommand. a bare command inside an
+// expression is parsed as before, up to the first operator
+func (p *Parser) parseHttpSubcommand(subcommands subcommandArgs, check func(command token.Token, subcommand string, args []ast.Expression)) ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseHttpSubcommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	if !isCommandStart(p.prevToken, p.curToken) {
+		return p.parseHttpCommand()
+	}
+	expr := &ast.HttpExpression{Token: p.curToken, Command: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	command := expr.Token.Literal
+	cmd := &ast.CommandInvocation{Token: expr.Token, Command: command}
+	cmd.Arguments = p.parseCommandArguments()
+	args := cmd.Arguments
+	if len(args) == 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects a subcommand or a name", []any{command, expr.Token}...)
+		return expr
+	}
+
+	name, literal := literalWord(args[0])
+	bounds, isSubcommand := subcommands[name]
+	switch {
+	case literal && isSubcommand:
+		expr.Method = &ast.Identifier{Token: tokenOf(args[0]), Value: name}
+		args = args[1:]
+		if checkArgCount(p, cmd, command+" "+name, CommandSpec{MinArgs: bounds[0], MaxArgs: bounds[1]}, len(args)) {
+			check(expr.Token, name, args)
+		}
+	case len(args) > 1:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for %s, expected one of %s", []any{args[0].String(), command, strings.Join(sortedSubcommands(subcommands), ", "), expr.Token}...)
+	default:
+		check(expr.Token, "", args)
+	}
+
+	switch len(args) {
+	case 0:
+	case 1:
+		expr.Argument = args[0]
+	default:
+		expr.Argument = &ast.ArrayLiteral{Token: tokenOf(args[0]), Elements: args}
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseHttpSubcommand End - Method: %v, Argument: %v\n", expr.Method, expr.Argument)
+	}
+	return expr
+}
+
+// checks the header names given to an HTTP::header subcommand. insert takes
+// name and value pairs, optionally after lws
+func (p *Parser) checkHeaderArguments(command token.Token, subcommand string, args []ast.Expression) {
+	switch {
+	case subcommand == "insert":
+		if len(args) > 0 && isWord(args[0], "lws") {
+			args = args[1:]
+		}
+		if len(args)%2 != 0 || len(args) == 0 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::header insert expects header name and value pairs, got %d argument(s)", []any{len(args), command}...)
+			return
+		}
+		for i := 0; i < len(args); i += 2 {
+			p.checkHeaderName(command, args[i])
+		}
+	case subcommand == "" || containsString(headerNameSubcommands, subcommand):
+		p.checkHeaderName(command, args[0])
+	}
+}
+
+// checks the arguments of an HTTP::cookie subcommand: the cookie name, the
+// option pairs of insert and the values of the attribute subcommands
+func (p *Parser) checkCookieArguments(command token.Token, subcommand string, args []ast.Expression) {
+	switch subcommand {
+	case "count", "names", "sanitize", "attribute":
+		return
+	case "insert":
+		p.checkCookieInsert(command, args)
+		return
+	}
+	p.checkCookieName(command, args[0])
+	if values, ok := cookieAttributeValues[subcommand]; ok && len(args) > 1 {
+		if value, ok := literalWord(args[1]); ok && !containsString(values, value) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid value '%s' for HTTP::cookie %s, expected one of %s", []any{value, subcommand, strings.Join(values, ", "), command}...)
+		}
+	}
+}
+
+// checks HTTP::cookie insert name name value value ?option value ...?
+func (p *Parser) checkCookieInsert(command token.Token, args []ast.Expression) {
+	if len(args)%2 != 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::cookie insert expects option and value pairs, got %d argument(s)", []any{len(args), command}...)
+		return
+	}
+	given := []string{}
+	for i := 0; i < len(args); i += 2 {
+		option, ok := literalWord(args[i])
+		if !ok {
+			continue
+		}
+		if !containsString(cookieInsertOptions, option) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for HTTP::cookie insert, expected one of %s", []any{option, strings.Join(cookieInsertOptions, ", "), command}...)
+			continue
+		}
+		if option == "name" {
+			p.checkCookieName(command, args[i+1])
+		}
+		given = append(given, option)
+	}
+	for _, required := range []string{"name", "value"} {
+		if !containsString(given, required) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::cookie insert is missing the %s option", []any{required, command}...)
+		}
+	}
+}
+
+// reports a literal cookie name that isn't an http token
+func (p *Parser) checkCookieName(command token.Token, arg ast.Expression) {
+	if name, ok := literalWord(arg); ok && !headerTokenRegex.MatchString(name) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid cookie name '%s' for HTTP::cookie", []any{name, command}...)
+	}
+}
+
+// reports a literal header name that isn't an http token, such as one with a
+// trailing colon
+func (p *Parser) checkHeaderName(command token.Token, arg ast.Expression) {
+	if name, ok := literalWord(arg); ok && !headerTokenRegex.MatchString(name) && !p.isCustomHeader(name) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid header name '%s' for HTTP::header", []any{name, command}...)
+	}
+}
+
+// returns the names of the subcommands, sorted
+func sortedSubcommands(subcommands subcommandArgs) []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
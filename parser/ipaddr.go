@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the options IP::addr parse takes before the bytes of the address
+var ipAddrParseOptions = []string{"-ipv4", "-ipv6", "-swap"}
+
+// parses IP::addr addr1 equals addr2, IP::addr addr mask mask and IP::addr
+// parse ?-ipv4|-ipv6? ?-swap? bytes ?offset?. an address compared may carry
+// a prefix length or netmask, as in 10.0.0.0/8 or "$ip mask 255.255.0.0"
+func (p *Parser) parseIpAddrCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseIpAddrCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	cmd := &ast.CommandInvocation{Token: p.curToken, Command: p.curToken.Literal}
+	cmd.Arguments = p.joinPrefixLengths(p.parseCommandArguments())
+	args := cmd.Arguments
+
+	if len(args) > 0 && isWord(args[0], "parse") {
+		args = args[1:]
+		for len(args) > 0 {
+			option, ok := literalWord(args[0])
+			if !ok || !strings.HasPrefix(option, "-") {
+				break
+			}
+			if !containsString(ipAddrParseOptions, option) {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for IP::addr parse, expected one of %s", []any{option, strings.Join(ipAddrParseOptions, ", "), cmd.Token}...)
+			}
+			args = args[1:]
+		}
+		if len(args) < 1 || len(args) > 2 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: IP::addr parse expects the bytes of an address and an optional offset, got %d argument(s)", []any{len(args), cmd.Token}...)
+		}
+		return cmd
+	}
+
+	if len(args) != 3 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"IP::addr addr1 equals addr2\" or \"IP::addr addr mask mask\", got %d argument(s)", []any{len(args), cmd.Token}...)
+		return cmd
+	}
+	operator, literal := literalWord(args[1])
+	switch {
+	case !literal:
+	case operator == "equals":
+		p.checkIpAddrOperand(cmd.Token, args[0])
+		p.checkIpAddrOperand(cmd.Token, args[2])
+	case operator == "mask":
+		p.checkIpAddrOperand(cmd.Token, args[0])
+		if mask, ok := addressWord(args[2]); ok {
+			p.checkNetmask(cmd.Token, mask)
+		}
+	default:
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid operator '%s' for IP::addr, expected equals or mask", []any{operator, cmd.Token}...)
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseIpAddrCommand End - Arguments: %d\n", len(cmd.Arguments))
+	}
+	return cmd
+}
+
+// joins a prefix length to the word before it, as in [IP::client_addr]/24 or
+// $ip/24, whose address, slash and length lex as three words
+func (p *Parser) joinPrefixLengths(args []ast.Expression) []ast.Expression {
+	joined := []ast.Expression{}
+	for i := 0; i < len(args); i++ {
+		slash, isSlash := args[i].(*ast.StringLiteral)
+		if isSlash && slash.Value == "/" && len(joined) > 0 && i+1 < len(args) {
+			length, isNumber := args[i+1].(*ast.NumberLiteral)
+			afterWord := strings.TrimSpace(p.l.Source(slash.Token.Offset-1, slash.Token.Offset)) != ""
+			if isNumber && afterWord && length.Token.Offset == slash.Token.Offset+1 {
+				address := joined[len(joined)-1]
+				joined[len(joined)-1] = &ast.CidrLiteral{Token: tokenOf(address), Address: address.String(), Mask: length.Token.Literal}
+				i++
+				continue
+			}
+		}
+		joined = append(joined, args[i])
+	}
+	return joined
+}
+
+// checks an address compared by IP::addr: an address or network, or a
+// quoted "addr mask mask"
+func (p *Parser) checkIpAddrOperand(pos token.Token, arg ast.Expression) {
+	if cidr, ok := arg.(*ast.CidrLiteral); ok {
+		// a literal network is checked when it is parsed. the address a
+		// prefix length is joined to is only known at runtime, and may be
+		// an IPv6 one
+		if strings.ContainsAny(cidr.Address, "$[") {
+			if length, err := strconv.Atoi(cidr.Mask); err != nil || length > 128 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid prefix length /%s for %s, expected 0 to 128", []any{cidr.Mask, cidr.Address, pos}...)
+			}
+		}
+		return
+	}
+	if fields := strings.Fields(strings.Trim(arg.String(), `"`)); len(fields) == 3 && fields[1] == "mask" {
+		if !strings.ContainsAny(fields[2], "$[") {
+			p.checkNetmask(pos, fields[2])
+		}
+		return
+	}
+	word, ok := addressWord(arg)
+	if !ok {
+		return
+	}
+	if address, mask, found := strings.Cut(word, "/"); found {
+		p.checkCidrMask(&ast.CidrLiteral{Token: pos, Address: address, Mask: mask})
+		word = address
+	}
+	if net.ParseIP(routeDomainRegex.ReplaceAllString(word, "")) == nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid address '%s' for IP::addr", []any{word, pos}...)
+	}
+}
+
+// reports a netmask that isn't an address or whose ones aren't contiguous
+func (p *Parser) checkNetmask(pos token.Token, mask string) {
+	ip := net.ParseIP(mask)
+	if ip == nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid netmask '%s' for IP::addr, expected one such as 255.255.0.0", []any{mask, pos}...)
+		return
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if _, size := net.IPMask(ip).Size(); size == 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid netmask %s for IP::addr, its ones aren't contiguous", []any{mask, pos}...)
+	}
+}
+
+// returns the text of an address known before runtime. networks are checked
+// when they are parsed
+func addressWord(arg ast.Expression) (string, bool) {
+	if ip, ok := arg.(*ast.IpAddressLiteral); ok {
+		return ip.Value, true
+	}
+	return literalWord(arg)
+}
+
+// parses a network such as 10.0.0.0/8 or 10.0.0.0/255.0.0.0 from its address
+// on. the mask has to be a prefix length within the bits of the address, or a
+// netmask of contiguous ones
+func (p *Parser) parseCidrLiteral() ast.Expression {
+	cidr := &ast.CidrLiteral{Token: p.curToken, Address: p.curToken.Literal}
+	p.nextToken() // move to '/'
+	if !p.peekIsAdjacent() || (!p.peekTokenIs(token.NUMBER) && !p.peekTokenIs(token.IP_ADDRESS)) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "network %s/ is missing its prefix length or netmask", []any{cidr.Address, cidr.Token}...)
+		return cidr
+	}
+	p.nextToken()
+	cidr.Mask = p.curToken.Literal
+	p.checkCidrMask(cidr)
+	return cidr
+}
+
+func (p *Parser) checkCidrMask(cidr *ast.CidrLiteral) {
+	address := net.ParseIP(routeDomainRegex.ReplaceAllString(cidr.Address, ""))
+	if address == nil {
+		return
+	}
+	bits := 128
+	if address.To4() != nil {
+		bits = 32
+	}
+
+	if mask := net.ParseIP(cidr.Mask); mask != nil {
+		if mask.To4() == nil || bits != 32 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid netmask %s for %s, expected an IPv4 netmask", []any{cidr.Mask, cidr.Address, cidr.Token}...)
+			return
+		}
+		if _, size := net.IPMask(mask.To4()).Size(); size == 0 {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid netmask %s for %s, its ones aren't contiguous", []any{cidr.Mask, cidr.Address, cidr.Token}...)
+		}
+		return
+	}
+
+	if length, err := strconv.Atoi(cidr.Mask); err != nil || length < 0 || length > bits {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid prefix length /%s for %s, expected 0 to %d", []any{cidr.Mask, cidr.Address, bits, cidr.Token}...)
+	}
+}
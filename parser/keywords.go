@@ -1,6 +1,36 @@
 package parser
 
+import "github.com/elkrammer/irule-validator/token"
+
 var (
+	validResolvFlags = map[string]bool{
+		"inet": true, "inet6": true,
+		"-a": true, "-aaaa": true, "-txt": true, "-mx": true, "-ptr": true,
+	}
+
+	validCategoryLookupTypes = map[string]bool{
+		"request_default":                    true,
+		"request_default_and_custom":         true,
+		"custom":                             true,
+		"custom_default":                     true,
+		"request_default_and_custom_and_ssl": true,
+	}
+
+	// the third word of an iStats key: counter, gauge or string
+	validIstatsTypes = map[string]bool{"c": true, "g": true, "s": true}
+
+	// namespaces and events that are only accepted when their module is
+	// enabled with --module
+	moduleEvents = map[string][]token.TokenType{
+		"mqtt": {
+			token.MQTT_CLIENT_DATA, token.MQTT_SERVER_DATA,
+			token.MQTT_CLIENT_INGRESS, token.MQTT_SERVER_INGRESS,
+			token.MQTT_CLIENT_EGRESS, token.MQTT_SERVER_EGRESS,
+			token.MQTT_CLIENT_SHUTDOWN, token.MQTT_SERVER_SHUTDOWN,
+		},
+		"mr": {token.MR_INGRESS, token.MR_EGRESS, token.MR_FAILED},
+	}
+
 	reservedKeywords = map[string]bool{
 		"when": true, "if": true, "else": true, "elseif": true, "foreach": true, "for": true,
 		"switch": true, "case": true, "default": true, "return": true, "set": true,
@@ -29,27 +59,54 @@ var (
 		"client_addr", "server_addr", "ip2rd", "rd2ip", "replace", "matches_regex",
 		"exists", "whereis", "drop", "regsub",
 	}
-	validStringOperations = map[string]bool{
-		"contains":  true,
-		"equals":    true,
-		"tolower":   true,
-		"toupper":   true,
-		"length":    true,
-		"substring": true,
-		"match":     true,
-		"map":       true,
-		"replace":   true,
-		"trim":      true,
-		"compare":   true,
-		"findstr":   true,
-		"reverse":   true,
-		"repeat":    true,
-		"range":     true,
-		"index":     true,
-		"last":      true,
+	// argument signatures of the string subcommands, following Tcl's own
+	// usage. options are given as flag -> whether it takes a value
+	stringSignatures = map[string]stringSignature{
+		"bytelength": {min: 1, max: 1, usage: "string bytelength string"},
+		"compare":    {min: 2, max: 2, options: map[string]bool{"-nocase": false, "-length": true}, usage: "string compare ?-nocase? ?-length int? string1 string2"},
+		"equal":      {min: 2, max: 2, options: map[string]bool{"-nocase": false, "-length": true}, usage: "string equal ?-nocase? ?-length int? string1 string2"},
+		"first":      {min: 2, max: 3, usage: "string first needleString haystackString ?startIndex?"},
+		"index":      {min: 2, max: 2, usage: "string index string charIndex"},
+		"is":         {min: 2, max: 2, optionsAfter: 1, options: map[string]bool{"-strict": false, "-failindex": true}, usage: "string is class ?-strict? ?-failindex var? str"},
+		"last":       {min: 2, max: 3, usage: "string last needleString haystackString ?startIndex?"},
+		"length":     {min: 1, max: 1, usage: "string length string"},
+		"map":        {min: 2, max: 2, options: map[string]bool{"-nocase": false}, usage: "string map ?-nocase? charMap string"},
+		"match":      {min: 2, max: 2, options: map[string]bool{"-nocase": false}, usage: "string match ?-nocase? pattern string"},
+		"range":      {min: 3, max: 3, usage: "string range string first last"},
+		"repeat":     {min: 2, max: 2, usage: "string repeat string count"},
+		"replace":    {min: 3, max: 4, usage: "string replace string first last ?string?"},
+		"reverse":    {min: 1, max: 1, usage: "string reverse string"},
+		"tolower":    {min: 1, max: 3, usage: "string tolower string ?first? ?last?"},
+		"totitle":    {min: 1, max: 3, usage: "string totitle string ?first? ?last?"},
+		"toupper":    {min: 1, max: 3, usage: "string toupper string ?first? ?last?"},
+		"trim":       {min: 1, max: 2, usage: "string trim string ?chars?"},
+		"trimleft":   {min: 1, max: 2, usage: "string trimleft string ?chars?"},
+		"trimright":  {min: 1, max: 2, usage: "string trimright string ?chars?"},
+		"wordend":    {min: 2, max: 2, usage: "string wordend string index"},
+		"wordstart":  {min: 2, max: 2, usage: "string wordstart string index"},
+		// not Tcl, but accepted by earlier versions of the validator
+		"contains":  {min: 2, max: 2, usage: "string contains string1 string2"},
+		"equals":    {min: 2, max: 2, usage: "string equals string1 string2"},
+		"findstr":   {min: 2, max: 4, usage: "string findstr string search ?skip? ?terminator?"},
+		"substring": {min: 2, max: 4, usage: "string substring string search ?skip? ?terminator?"},
 	}
+	// Tcl and iRules commands a substitution inside a string may start with,
+	// besides registered commands and common identifiers
+	substitutionCommands = []string{
+		"active_members", "active_nodes", "after", "append", "array", "concat", "decode_uri",
+		"domain", "encoding", "getfield", "htonl", "htons", "ip2rd", "incr", "info", "join",
+		"lappend", "lassign", "lindex", "linsert", "list", "llength", "lrange", "lreplace",
+		"lsearch", "lset", "lsort", "members", "ntohl", "ntohs", "rd2ip", "regexp", "split",
+		"string", "subst", "substr", "urlcatblindnet", "urlcatquery", "virtual", "whereis",
+	}
+	// the switches of regsub and regexp and whether each takes a value
 	validRegsubFlags = map[string]bool{
-		"all":    true,
-		"nocase": true,
+		"-all": false, "-expanded": false, "-line": false, "-linestop": false,
+		"-lineanchor": false, "-nocase": false, "-start": true,
+	}
+	validRegexpFlags = map[string]bool{
+		"-about": false, "-all": false, "-expanded": false, "-indices": false,
+		"-inline": false, "-line": false, "-linestop": false, "-lineanchor": false,
+		"-nocase": false, "-start": true,
 	}
 )
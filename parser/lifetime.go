@@ -0,0 +1,370 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the order in which the events of a connection run. variables live as long as
+// the connection, so an event sees what events of the same or a lower stage
+// set. events missing here aren't tied to a stage and aren't checked
+var connectionFlow = map[string]int{
+	"CLIENT_ACCEPTED":      10,
+	"SSL_CLIENTHELLO":      20,
+	"CLIENTSSL_HANDSHAKE":  25,
+	"TCP_REQUEST":          30,
+	"HTTP_REQUEST":         30,
+	"DNS_REQUEST":          30,
+	"WS_REQUEST":           32,
+	"CATEGORY_MATCHED":     35,
+	"LB_SELECTED":          40,
+	"SERVER_CONNECTED":     50,
+	"SSL_SERVERHELLO":      55,
+	"SERVERSSL_HANDSHAKE":  60,
+	"TCP_RESPONSE":         70,
+	"HTTP_RESPONSE":        70,
+	"DNS_RESPONSE":         70,
+	"WS_RESPONSE":          72,
+	"WS_CLIENT_FRAME":      80,
+	"WS_SERVER_FRAME":      80,
+	"WS_CLIENT_FRAME_DONE": 80,
+	"WS_SERVER_FRAME_DONE": 80,
+	"WS_CLIENT_DATA":       80,
+	"WS_SERVER_DATA":       80,
+}
+
+// commands whose word arguments may name variables they set. every bare word
+// passed to them is taken as set, which errs on the side of fewer findings
+var variableSetters = map[string]bool{
+	"set": true, "incr": true, "append": true, "lappend": true, "lassign": true,
+	"foreach": true, "regsub": true, "regexp": true, "scan": true, "binary": true,
+	"upvar": true, "global": true, "variable": true, "info": true, "unset": true,
+}
+
+// $name and ${name} references inside a quoted string
+var stringVariableRegex = regexp.MustCompile(`\$\{([^}]+)\}|\$((?:::)?[\p{L}\w]+(?:::[\p{L}\w]+)*)`)
+
+// the variables an event handler or a proc sets and reads
+type handlerVariables struct {
+	event   string
+	proc    string // set for the body of a proc, whose variables are its own
+	rule    string
+	sets    map[string]bool
+	reads   []variableRead
+	setting bool // inside the arguments of a command that sets variables
+
+	// the setting of the commands around the open command substitutions
+	substitutions []bool
+}
+
+type variableRead struct {
+	name string
+	tok  token.Token
+}
+
+func newHandlerVariables(event, rule string) *handlerVariables {
+	return &handlerVariables{event: event, rule: rule, sets: map[string]bool{}}
+}
+
+func newProcVariables(proc, rule string, parameters []*ast.Identifier) *handlerVariables {
+	h := &handlerVariables{proc: proc, rule: rule, sets: map[string]bool{}}
+	for _, param := range parameters {
+		h.sets[param.Value] = true
+	}
+	return h
+}
+
+// where the variables are used, for messages
+func (h *handlerVariables) scope() string {
+	if h.proc != "" {
+		return "proc " + h.proc
+	}
+	return h.event
+}
+
+// records the variables set and read by a token of the handler body
+func (h *handlerVariables) record(prev, tok token.Token) {
+	if isCommandStart(prev, tok) {
+		h.setting = variableSetters[tok.Literal]
+		if h.setting {
+			return
+		}
+	}
+
+	switch {
+	case tok.Type == token.LBRACKET:
+		h.substitutions = append(h.substitutions, h.setting)
+	case tok.Type == token.RBRACKET:
+		h.setting = false
+		if n := len(h.substitutions); n > 0 {
+			h.setting = h.substitutions[n-1]
+			h.substitutions = h.substitutions[:n-1]
+		}
+	case tok.Type == token.STRING:
+		for _, loc := range stringVariableRegex.FindAllStringIndex(tok.Literal, -1) {
+			if !isEscaped(tok.Literal, loc[0]) {
+				h.read(tok.Literal[loc[0]:loc[1]], embeddedToken(tok, tok.Literal, loc[0]))
+			}
+		}
+	case strings.HasPrefix(tok.Literal, "$"):
+		h.read(tok.Literal, tok)
+	case h.setting:
+		if name := variableName(tok.Literal); name != "" {
+			h.sets[name] = true
+		}
+	}
+}
+
+// reports whether a token is the first word of a command
+func isCommandStart(prev, tok token.Token) bool {
+	return tok.LineStart || prev.Type == token.LBRACKET || prev.Type == token.SEMICOLON || prev.Type == token.LBRACE
+}
+
+// reports whether the character at offset is escaped by an odd number of
+// backslashes, such as the $ of \$name
+func isEscaped(value string, offset int) bool {
+	backslashes := 0
+	for i := offset - 1; i >= 0 && value[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+func (h *handlerVariables) read(reference string, tok token.Token) {
+	if name := variableName(reference); name != "" {
+		h.reads = append(h.reads, variableRead{name: name, tok: tok})
+	}
+}
+
+// returns the plain name of a variable reference such as $name, ${name},
+// $name(key), $static::name, $::name or $app::name
+func variableName(reference string) string {
+	name := strings.Trim(strings.TrimPrefix(reference, "$"), "{}")
+	if i := strings.IndexAny(name, "(."); i >= 0 {
+		name = name[:i]
+	}
+	if strings.HasSuffix(name, "::") {
+		return ""
+	}
+	return name
+}
+
+func isStaticVariable(name string) bool {
+	return strings.HasPrefix(name, "static::")
+}
+
+// reports whether a variable lives in a namespace rather than in the
+// connection, as static::, ::global and app::name do. such variables are
+// shared by every rule and event
+func isNamespaceVariable(name string) bool {
+	return strings.Contains(name, "::")
+}
+
+// reports variables that are read in an event but only set in events that run
+// later in the connection, or in RULE_INIT. handlers of different ltm rules
+// are checked separately. static:: variables are shared by every rule and
+// are expected to be initialized in RULE_INIT. variables that are never set
+// aren't reported as they may be set by commands the parser doesn't know about
+func checkVariableLifetimes(handlers []*handlerVariables) []diagnostic.Diagnostic {
+	diagnostics := []diagnostic.Diagnostic{}
+
+	setIn := map[string]map[string][]string{} // rule -> variable -> events
+	staticSetIn := map[string][]string{}      // static variable -> events of any rule
+	for _, h := range handlers {
+		if h.proc != "" {
+			continue
+		}
+		if setIn[h.rule] == nil {
+			setIn[h.rule] = map[string][]string{}
+		}
+		for name := range h.sets {
+			setIn[h.rule][name] = append(setIn[h.rule][name], h.event)
+			if isStaticVariable(name) {
+				staticSetIn[name] = append(staticSetIn[name], h.event)
+			}
+		}
+	}
+
+	for _, h := range handlers {
+		if h.proc != "" || h.event == "RULE_INIT" {
+			continue
+		}
+		stage, staged := connectionFlow[h.event]
+
+		reported := map[string]bool{}
+		for _, read := range h.reads {
+			if h.sets[read.name] || reported[read.name] {
+				continue
+			}
+
+			var message string
+			events := setIn[h.rule][read.name]
+			switch {
+			case isStaticVariable(read.name):
+				initialized := staticSetIn[read.name]
+				if len(initialized) > 0 && !containsString(initialized, "RULE_INIT") {
+					message = fmt.Sprintf("variable %s is read in %s but only set in %s, initialize it in RULE_INIT so it has a value before the first connection", read.name, h.event, strings.Join(uniqueSorted(initialized), ", "))
+				}
+			case isNamespaceVariable(read.name) || !staged || len(events) == 0:
+			case onlyRuleInit(events):
+				message = fmt.Sprintf("variable %s is read in %s but only set in RULE_INIT, which doesn't share variables with connections; use static::%s", read.name, h.event, read.name)
+			case containsString(events, "RULE_INIT"):
+				message = fmt.Sprintf("variable %s is read in %s and set in RULE_INIT, whose value connections never see; use static::%s", read.name, h.event, read.name)
+			case !setBefore(events, stage):
+				message = fmt.Sprintf("variable %s is read in %s but only set in %s, which runs later in the connection", read.name, h.event, strings.Join(uniqueSorted(events), ", "))
+			}
+			if message == "" {
+				continue
+			}
+			reported[read.name] = true
+
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{
+				Code:     diagnostic.UnreachableVariable,
+				Message:  message,
+				Line:     read.tok.Line,
+				Column:   read.tok.Column,
+				Severity: diagnostic.Warning,
+			})
+		}
+	}
+
+	return diagnostics
+}
+
+// reports whether one of the events runs at or before the given stage. events
+// without a stage may run at any time and count as well
+func setBefore(events []string, stage int) bool {
+	for _, event := range events {
+		if event == "RULE_INIT" {
+			continue
+		}
+		if eventStage, ok := connectionFlow[event]; !ok || eventStage <= stage {
+			return true
+		}
+	}
+	return false
+}
+
+func onlyRuleInit(events []string) bool {
+	for _, event := range events {
+		if event != "RULE_INIT" {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// reports variables that are read but never set. the events of an ltm rule
+// share their variables, so a variable set in any of them counts, while a proc
+// only sees its parameters and what it sets itself. static:: and other
+// namespace variables are shared by every rule and count when set anywhere,
+// typically in RULE_INIT.
+// reads already reported by checkVariableUsage are left out
+func (p *Parser) checkUndeclaredVariables() {
+	eventSets := map[string]map[string]bool{} // rule -> variables
+	namespaceSets := map[string]bool{}
+	for _, h := range p.variables {
+		if eventSets[h.rule] == nil {
+			eventSets[h.rule] = map[string]bool{}
+		}
+		for name := range h.sets {
+			if isNamespaceVariable(name) {
+				namespaceSets[name] = true
+			} else if h.proc == "" {
+				eventSets[h.rule][name] = true
+			}
+		}
+	}
+
+	reportedLines := map[int]bool{}
+	for _, d := range p.diagnostics {
+		if d.Code == diagnostic.UndeclaredVariable {
+			reportedLines[d.Line] = true
+		}
+	}
+
+	for _, h := range p.variables {
+		reported := map[string]bool{}
+		for _, read := range h.reads {
+			name := read.name
+			declared := h.sets[name] || namespaceSets[name] || (h.proc == "" && eventSets[h.rule][name])
+			if declared || reported[name] || reportedLines[read.tok.Line] {
+				continue
+			}
+			reported[name] = true
+
+			visible := []map[string]bool{h.sets, namespaceSets}
+			if h.proc == "" {
+				visible = append(visible, eventSets[h.rule])
+			}
+			switch suggestion := closestName(name, visible...); {
+			case suggestion != "":
+				p.reportWarning(diagnostic.UndeclaredVariable, "variable %s is read in %s but never set, did you mean %s?", []any{name, h.scope(), suggestion, read.tok}...)
+			case isStaticVariable(name):
+				p.reportWarning(diagnostic.UndeclaredVariable, "variable %s is read in %s but never set, static variables are usually set in RULE_INIT", []any{name, h.scope(), read.tok}...)
+			default:
+				p.reportWarning(diagnostic.UndeclaredVariable, "variable %s is read in %s but never set", []any{name, h.scope(), read.tok}...)
+			}
+		}
+	}
+}
+
+// returns the variable a misspelled name most likely meant: the closest of the
+// given ones, a typo or two away. empty when none is close enough
+func closestName(name string, sets ...map[string]bool) string {
+	closest, best := "", 3
+	for _, set := range sets {
+		for candidate := range set {
+			distance := editDistance(name, candidate)
+			if distance < best && distance*2 < len(name) || distance == best && candidate < closest {
+				closest, best = candidate, distance
+			}
+		}
+	}
+	return closest
+}
+
+// returns the number of inserted, deleted, replaced or swapped adjacent
+// characters that turn a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
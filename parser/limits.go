@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the limits a BIG-IP enforces on the definition of an iRule, beyond which
+// it is rejected when the configuration is loaded
+type ruleLimits struct {
+	Since string // first TMOS release enforcing these limits
+	Size  int    // bytes of the rule definition
+	Depth int    // nesting of blocks, bounded by the tcl recursion limit
+}
+
+// the limits of every release, oldest first. older releases stored a rule in
+// a 64KB field
+var platformLimits = []ruleLimits{
+	{Since: "", Size: 65535, Depth: 1000},
+	{Since: "11.0", Size: 4 << 20, Depth: 1000},
+}
+
+// a rule using more than this share of a limit is reported as approaching it
+const limitWarningPercent = 90
+
+// returns the limits of the targeted TMOS version, those of the latest
+// release without a target
+func (p *Parser) ruleLimits() ruleLimits {
+	limits := platformLimits[0]
+	for _, l := range platformLimits[1:] {
+		if p.opts.TmosVersionAtLeast(l.Since) {
+			limits = l
+		}
+	}
+	return limits
+}
+
+// reports rules that exceed or approach the size and nesting limits of the
+// targeted platform. every ltm rule of a bigip.conf is measured on its own
+func (p *Parser) checkRuleLimits(program *ast.Program, end token.Token) {
+	rules := 0
+	for _, stmt := range program.Statements {
+		rule, ok := stmt.(*ast.LtmRule)
+		if !ok || rule.Body == nil {
+			continue
+		}
+		rules++
+		size := len(p.l.Source(rule.Body.Token.Offset, rule.Body.End.Offset+1))
+		p.checkRuleLimit(rule.Name.Value, rule.Token, size, blockDepth(rule.Body))
+	}
+	if rules == 0 && len(program.Statements) > 0 {
+		p.checkRuleLimit("rule", 1, len(p.l.Source(0, end.Offset)), blockDepth(program))
+	}
+}
+
+// reports a rule over or close to a limit. pos is the token or line the
+// findings are reported at
+func (p *Parser) checkRuleLimit(name string, pos any, size, depth int) {
+	limits := p.ruleLimits()
+	target := "TMOS " + p.opts.TmosVersion
+	if p.opts.TmosVersion == "" {
+		target = "the latest TMOS"
+	}
+
+	switch {
+	case size > limits.Size:
+		p.reportDiagnostic(diagnostic.RuleLimit, "%s is %d bytes, over the limit of %d bytes of %s", []any{name, size, limits.Size, target, pos}...)
+	case size*100 > limits.Size*limitWarningPercent:
+		p.reportWarning(diagnostic.RuleLimit, "%s is %d bytes, close to the limit of %d bytes of %s", []any{name, size, limits.Size, target, pos}...)
+	}
+	switch {
+	case depth > limits.Depth:
+		p.reportDiagnostic(diagnostic.RuleLimit, "%s nests blocks %d deep, over the limit of %d of %s", []any{name, depth, limits.Depth, target, pos}...)
+	case depth*100 > limits.Depth*limitWarningPercent:
+		p.reportWarning(diagnostic.RuleLimit, "%s nests blocks %d deep, close to the limit of %d of %s", []any{name, depth, limits.Depth, target, pos}...)
+	}
+}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// parses while {condition} {body}
+func (p *Parser) parseWhileStatement() ast.Statement {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseWhileStatement Start - Line: %d\n", p.curToken.Line)
+	}
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	p.nextToken() // move to the condition
+	stmt.Condition = p.parseLoopCondition("while")
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseWhileStatement: Expected LBRACE, got %v", p.peekToken.Literal)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseWhileStatement End - Condition: %v\n", stmt.Condition)
+	}
+	return stmt
+}
+
+// parses for {init} {condition} {next} {body}. init runs before the
+// condition is first tested, so the variables it sets are declared for it
+func (p *Parser) parseForStatement() ast.Statement {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseForStatement Start - Line: %d\n", p.curToken.Line)
+	}
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseForStatement: Expected { before the start script, got %v", p.curToken.Literal)
+		return nil
+	}
+	stmt.Init = p.parseBlockStatement()
+
+	p.nextToken() // move to the condition
+	stmt.Condition = p.parseLoopCondition("for")
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseForStatement: Expected { before the next script, got %v", p.peekToken.Literal)
+		return nil
+	}
+	stmt.Next = p.parseBlockStatement()
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseForStatement: Expected { before the body, got %v", p.peekToken.Literal)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseForStatement End - Condition: %v\n", stmt.Condition)
+	}
+	return stmt
+}
+
+// parses the condition of a loop. a condition outside braces is substituted
+// once, before the loop starts, so it never changes between iterations
+func (p *Parser) parseLoopCondition(loop string) ast.Expression {
+	if !p.curTokenIs(token.LBRACE) {
+		start := p.curToken
+		condition := p.parseExpression(LOWEST)
+		p.reportWarning(diagnostic.UnbracedExpression, "%s condition should be enclosed in braces, otherwise it is evaluated only once", []any{loop, start}...)
+		// only a condition of a single word can be braced without knowing where
+		// the others end
+		if end, ok := tokenEnd(start); ok && p.curToken == start {
+			p.suggestFix("Enclose the condition in braces",
+				diagnostic.TextEdit{Start: tokenStart(start), End: tokenStart(start), NewText: "{"},
+				diagnostic.TextEdit{Start: end, End: end, NewText: "}"})
+		}
+		return condition
+	}
+
+	if p.peekTokenIs(token.RBRACE) {
+		p.reportDiagnostic(diagnostic.SyntaxError, "%s expects a condition", []any{loop, p.curToken}...)
+		return nil
+	}
+	p.nextToken() // move past '{'
+	condition := p.parseExpression(LOWEST)
+	if condition == nil {
+		return nil
+	}
+	if !p.expectPeek(token.RBRACE) {
+		p.reportError("parseLoopCondition: Expected } after %s condition, got %s", loop, p.peekToken.Literal)
+		return nil
+	}
+	return condition
+}
+
+// parses the body of a loop in a scope of its own, inside which break and
+// continue are valid
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.symbolTable.EnterScope()
+	defer p.symbolTable.ExitScope()
+	p.symbolTable.Mark(LOOP)
+
+	return p.parseBlockStatement()
+}
+
+// parses the body of a switch case, inside which break and continue are valid
+// as in a loop
+func (p *Parser) parseCaseBody() *ast.BlockStatement {
+	p.symbolTable.EnterScope()
+	defer p.symbolTable.ExitScope()
+	p.symbolTable.Mark(LOOP)
+
+	return p.parseBlockStatement()
+}
+
+// parses the body of an event or proc, which a break or continue can't leave
+func (p *Parser) parseRoutineBody() *ast.BlockStatement {
+	p.symbolTable.EnterScope()
+	defer p.symbolTable.ExitScope()
+	p.symbolTable.Mark(ROUTINE)
+
+	return p.parseBlockStatement()
+}
+
+// reports a break or continue outside of a loop
+func (p *Parser) checkLoopControl() {
+	if !p.symbolTable.InLoop() {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invoked \"%s\" outside of a loop", []any{p.curToken.Literal, p.curToken}...)
+	}
+}
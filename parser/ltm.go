@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// matches rule_name and /Partition/rule_name
+var ltmRuleNameRegex = regexp.MustCompile(`^(?:(?:/[\w.-]+){2,}|[\w.-]+)$`)
+
+// properties bigip.conf stores next to the definition of a rule. they aren't
+// part of the iRule and are skipped
+var ltmRuleProperties = map[string]bool{
+	"app-service":            true,
+	"definition-checksum":    true,
+	"definition-signature":   true,
+	"description":            true,
+	"ignore-verification":    true,
+	"signing-key":            true,
+	"verification-signature": true,
+}
+
+// IsLtmRuleProperty reports whether a word starts a property line of an ltm
+// rule stanza rather than iRule code
+func IsLtmRuleProperty(word string) bool {
+	return ltmRuleProperties[word]
+}
+
+// the parser state an ltm rule doesn't share with the rules around it
+type ruleScope struct {
+	rule              string
+	declaredVariables map[string]bool
+	procs             map[string]ProcSignature
+	procCalls         []ProcCall
+	defaultPriority   int
+}
+
+// parses ltm rule <name> { ... } as found in bigip.conf. every rule is checked
+// on its own, as if it were in a file of its own
+func (p *Parser) parseLtmRule() ast.Statement {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseLtmRule Start - Current token: %s, Line: %d\n", p.curToken.Type, p.l.CurrentLine())
+	}
+	stmt := &ast.LtmRule{Token: p.curToken}
+
+	if p.braceCount > 0 || p.currentRule != "" {
+		p.reportError("parseLtmRule: ltm rule is only allowed at the top level")
+	}
+
+	if !p.expectPeek(token.RULE) {
+		p.reportError("parseLtmRule: expected RULE, got %v", p.curToken.Literal)
+		return nil
+	}
+
+	name, ok := p.parseLtmRuleName()
+	if !ok {
+		return nil
+	}
+	stmt.Name = name
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseLtmRule: expected LBRACE, got %v", p.curToken.Literal)
+		return nil
+	}
+
+	scope := p.enterRuleScope(name.Value)
+	stmt.Body = p.parseLtmRuleBody()
+	p.exitRuleScope(scope)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseLtmRule End - Current token: %s, Line: %d\n", p.curToken.Type, p.l.CurrentLine())
+	}
+
+	return stmt
+}
+
+// the lexer splits a name like /Common/my_rule at its slashes, so the tokens
+// up to the opening brace are joined again
+func (p *Parser) parseLtmRuleName() (*ast.Identifier, bool) {
+	if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.SLASH) {
+		p.reportError("parseLtmRule: expected rule name, got %v", p.peekToken.Literal)
+		return nil, false
+	}
+	p.nextToken()
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	for p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.SLASH) {
+		p.nextToken()
+		name.Value += p.curToken.Literal
+	}
+
+	if !ltmRuleNameRegex.MatchString(name.Value) {
+		p.reportDiagnostic(diagnostic.InvalidIdentifier, "parseLtmRule: invalid rule name '%s', expected name or /Partition/name", []any{name.Value, name.Token}...)
+	}
+	return name, true
+}
+
+// parses the body of an ltm rule like a block, skipping the property lines
+// bigip.conf adds to the iRule
+func (p *Parser) parseLtmRuleBody() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
+
+	p.symbolTable.EnterScope()
+	defer p.symbolTable.ExitScope()
+
+	p.braceCount++
+	p.nextToken() // consume opening brace
+
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.IDENT) && ltmRuleProperties[p.curToken.Literal] {
+			for !p.peekIsCommandEnd() && !p.peekTokenIs(token.RBRACE) {
+				p.nextToken()
+			}
+		} else if stmt := p.parseStatement(); stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.nextToken()
+	}
+
+	if p.curTokenIs(token.RBRACE) {
+		block.End = p.curToken
+	}
+	p.braceCount--
+	return block
+}
+
+func (p *Parser) enterRuleScope(rule string) ruleScope {
+	outer := ruleScope{
+		rule:              p.currentRule,
+		declaredVariables: p.declaredVariables,
+		procs:             p.procs,
+		procCalls:         p.procCalls,
+		defaultPriority:   p.defaultPriority,
+	}
+
+	p.currentRule = rule
+	p.declaredVariables = make(map[string]bool)
+	p.procs = make(map[string]ProcSignature)
+	p.procCalls = nil
+	p.defaultPriority = defaultEventPriority
+	return outer
+}
+
+// runs the checks that need the whole rule and restores the enclosing scope.
+// the procs of the rule and its calls into other rules stay visible
+func (p *Parser) exitRuleScope(outer ruleScope) {
+	p.resolveLocalProcCalls()
+	p.diagnostics = append(p.diagnostics, PriorityTies(EventOrder(ruleEventHandlers(p.eventHandlers, p.currentRule)))...)
+
+	p.ruleProcs[p.currentRule] = p.procs
+	for name, signature := range p.procs {
+		outer.procs[name] = signature
+	}
+	for _, call := range p.procCalls {
+		if call.Rule != "" {
+			outer.procCalls = append(outer.procCalls, call)
+		}
+	}
+
+	p.currentRule = outer.rule
+	p.declaredVariables = outer.declaredVariables
+	p.procs = outer.procs
+	p.procCalls = outer.procCalls
+	p.defaultPriority = outer.defaultPriority
+}
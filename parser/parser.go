@@ -1,13 +1,18 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/elkrammer/irule-validator/ast"
 	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/elkrammer/irule-validator/lexer"
 	"github.com/elkrammer/irule-validator/token"
 )
@@ -15,6 +20,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	TERNARY     // ?:
 	LOGICAL     // && or ||
 	EQUALS      // ==
 	LESSGREATER // > or <
@@ -30,6 +36,8 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:      EQUALS,
 	token.LT:          LESSGREATER,
 	token.GT:          LESSGREATER,
+	token.LT_EQ:       LESSGREATER,
+	token.GT_EQ:       LESSGREATER,
 	token.PLUS:        SUM,
 	token.MINUS:       SUM,
 	token.SLASH:       PRODUCT,
@@ -39,6 +47,7 @@ var precedences = map[token.TokenType]int{
 	token.OR:          LOGICAL,
 	token.CONTAINS:    CONTAINS,
 	token.STARTS_WITH: EQUALS,
+	token.QUESTION:    TERNARY,
 }
 
 var validWhenEvents = []token.TokenType{
@@ -58,6 +67,17 @@ var validWhenEvents = []token.TokenType{
 	token.DNS_RESPONSE,
 	token.SSL_CLIENTHELLO,
 	token.SSL_SERVERHELLO,
+	token.NAME_RESOLVED,
+	token.CATEGORY_MATCHED,
+	token.WS_REQUEST,
+	token.WS_RESPONSE,
+	token.WS_CLIENT_FRAME,
+	token.WS_SERVER_FRAME,
+	token.WS_CLIENT_FRAME_DONE,
+	token.WS_SERVER_FRAME_DONE,
+	token.WS_CLIENT_DATA,
+	token.WS_SERVER_DATA,
+	token.STREAM_MATCHED,
 }
 
 type (
@@ -66,8 +86,8 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	diagnostics []diagnostic.Diagnostic
 
 	curToken  token.Token
 	prevToken token.Token
@@ -76,19 +96,42 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
-	braceCount          int
-	declaredVariables   map[string]bool
-	symbolTable         *SymbolTable
-	currentLine         int
-	lastKnownLine       int
-	isParsingClassMatch bool
+	braceCount           int
+	declaredVariables    map[string]bool
+	symbolTable          *SymbolTable
+	currentLine          int
+	lastKnownLine        int
+	lastKnownColumn      int
+	isParsingClassMatch  bool
+	isParsingCasePattern bool
+	isParsingBracedWord  bool // nothing is substituted in a braced word
+	procs                map[string]ProcSignature
+	procCalls            []ProcCall
+	ruleProcs            map[string]map[string]ProcSignature // the procs of every ltm rule, keyed by rule
+	defaultPriority      int
+	eventHandlers        []EventHandler
+	currentEvent         string // the event whose body is being parsed
+	currentRule          string // the ltm rule whose body is being parsed
+	variables            []*handlerVariables
+	currentVariables     *handlerVariables // the variables of the event being parsed
+	costs                []*costRecorder
+	currentCost          *costRecorder  // the expensive constructs of the event being parsed
+	seenTokens           []token.Token  // every token parsed, kept in test mode
+	unknownNamespaces    map[string]int // commands of unknown namespaces, keyed by namespace
+	opts                 config.Options // the options of the lexer
+	registrationErr      error          // parse functions registered twice for a token
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:                 l,
-		errors:            []string{},
+		opts:              l.Options(),
+		diagnostics:       []diagnostic.Diagnostic{},
 		declaredVariables: make(map[string]bool),
+		procs:             make(map[string]ProcSignature),
+		ruleProcs:         make(map[string]map[string]ProcSignature),
+		unknownNamespaces: make(map[string]int),
+		defaultPriority:   defaultEventPriority,
 		symbolTable:       NewSymbolTable(),
 		currentLine:       1,
 		lastKnownLine:     1,
@@ -101,114 +144,141 @@ func New(l *lexer.Lexer) *Parser {
 	// initialize prevToken to an "empty" token or a special "start of file" token
 	p.prevToken = token.Token{Type: token.ILLEGAL, Literal: "", Line: p.l.CurrentLine()}
 
-	// check for lexer errors
-	if lexerErrors := l.Errors(); len(lexerErrors) > 0 {
-		p.errors = append(p.errors, lexerErrors...)
-	}
-
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
-	p.registerPrefix(token.ASTERISK, p.parsePrefixExpression)
-	p.registerPrefix(token.BANG, p.parsePrefixExpression)
-	p.registerPrefix(token.DOLLAR, p.parseVariableOrArrayAccess)
-	p.registerPrefix(token.FALSE, p.parseBoolean)
-	p.registerPrefix(token.IDENT, p.parseIdentifier)
-	p.registerPrefix(token.LBRACE, p.parseBracedStringLiteral)
-	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
-	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
-	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
-	p.registerPrefix(token.NUMBER, p.parseNumberLiteral)
-	p.registerPrefix(token.SET, p.parseSetExpression)
-	p.registerPrefix(token.STRING, p.parseStringLiteral)
-	p.registerPrefix(token.TRUE, p.parseBoolean)
-	p.registerPrefix(token.WHEN, p.parseWhenExpression)
-	p.registerPrefix(token.SLASH, p.parseSlashExpression)
-	p.registerPrefix(token.REGEX, p.parseRegexLiteral)
-	p.registerPrefix(token.REGSUB, p.parseRegsubCommand)
-
-	// http commands
-	p.registerPrefix(token.HTTP_HEADER, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_METHOD, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_PATH, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_QUERY, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_REDIRECT, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_RESPOND, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_URI, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_HOST, p.parseHttpCommand)
-	p.registerPrefix(token.HTTP_COOKIE, p.parseHttpCommand)
-
-	// load balancer commands
-	p.registerPrefix(token.LB_SELECTED, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_FAILED, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_QUEUED, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_COMPLETED, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_MODE, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_SELECT, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_RESELECT, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_DETACH, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_SERVER, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_POOL, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_STATUS, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_ALIVE, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_PERSIST, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_METHOD, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_SCORE, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_PRIORITY, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_CONNECT, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_BIAS, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_SNAT, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_LIMIT, p.parseLoadBalancerCommand)
-	p.registerPrefix(token.LB_CLASS, p.parseLoadBalancerCommand)
-
-	// SSL Commands
-	p.registerPrefix(token.SSL_CIPHER, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_CIPHER_BITS, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_CLIENTHELLO, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_SERVERHELLO, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_CERT, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_VERIFY_RESULT, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_SESSIONID, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_RENEGOTIATE, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_SESSIONVALID, p.parseSSLCommand)
-	p.registerPrefix(token.SSL_SESSIONUPDATES, p.parseSSLCommand)
-
-	// IP Commands
-	p.registerPrefix(token.IP_CLIENT_ADDR, p.parseIpExpression)
-	p.registerPrefix(token.IP_SERVER_ADDR, p.parseIpExpression)
-	p.registerPrefix(token.IP_REMOTE_ADDR, p.parseIpExpression)
-	p.registerPrefix(token.IP_ADDRESS, p.parseIpAddressLiteral)
-
-	p.registerPrefix(token.SWITCH, p.parseSwitchExpression)
-	p.registerPrefix(token.DEFAULT, p.parseDefaultExpression)
-
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
-	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
-	p.registerInfix(token.EQ, p.parseInfixExpression)
-	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
-	p.registerInfix(token.LPAREN, p.parseCallExpression)
-	p.registerInfix(token.GT, p.parseInfixExpression)
-	p.registerInfix(token.LT, p.parseInfixExpression)
-	p.registerInfix(token.MINUS, p.parseInfixExpression)
-	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
-	p.registerInfix(token.PLUS, p.parseInfixExpression)
-	p.registerInfix(token.SLASH, p.parseInfixExpression)
-	p.registerInfix(token.STARTS_WITH, p.parseInfixExpression)
-	p.registerInfix(token.ENDS_WITH, p.parseInfixExpression)
-	p.registerInfix(token.MATCHES, p.parseInfixExpression)
-	p.registerInfix(token.AND, p.parseInfixExpression)
-	p.registerInfix(token.OR, p.parseInfixExpression)
-	p.registerInfix(token.CONTAINS, p.parseInfixExpression)
-	p.registerInfix(token.AND, p.parseInfixExpression)
-	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registrationErr = errors.Join(
+		p.registerPrefix(token.ASTERISK, p.parsePrefixExpression),
+		p.registerPrefix(token.BANG, p.parsePrefixExpression),
+		p.registerPrefix(token.FALSE, p.parseBoolean),
+		p.registerPrefix(token.IDENT, p.parseIdentifier),
+		p.registerPrefix(token.LBRACE, p.parseBracedStringLiteral),
+		p.registerPrefix(token.LBRACKET, p.parseArrayLiteral),
+		p.registerPrefix(token.LPAREN, p.parseGroupedExpression),
+		p.registerPrefix(token.MINUS, p.parsePrefixExpression),
+		p.registerPrefix(token.NUMBER, p.parseNumberLiteral),
+		p.registerPrefix(token.SET, p.parseSetExpression),
+		p.registerPrefix(token.STRING, p.parseStringLiteral),
+		p.registerPrefix(token.TRUE, p.parseBoolean),
+		p.registerPrefix(token.WHEN, p.parseWhenExpression),
+		p.registerPrefix(token.SLASH, p.parseSlashExpression),
+		p.registerPrefix(token.REGEX, p.parseRegexLiteral),
+		p.registerPrefix(token.REGSUB, p.parseRegsubCommand),
+
+		// http commands
+		p.registerPrefix(token.HTTP_HEADER, p.parseHttpHeaderCommand),
+		p.registerPrefix(token.HTTP_METHOD, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_PATH, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_QUERY, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_REDIRECT, p.parseHttpRedirectCommand),
+		p.registerPrefix(token.HTTP_RESPOND, p.parseHttpRespondCommand),
+		p.registerPrefix(token.HTTP_URI, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_HOST, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_COOKIE, p.parseHttpCookieCommand),
+		p.registerPrefix(token.HTTP_COLLECT, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_RELEASE, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_PAYLOAD, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_VERSION, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_STATUS, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_USERNAME, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_PASSWORD, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_PROXY, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_CLASS, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_COMPRESS, p.parseHttpCommand),
+		p.registerPrefix(token.HTTP_FILTER, p.parseHttpCommand),
+
+		// load balancer commands
+		p.registerPrefix(token.LB_SELECTED, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_FAILED, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_QUEUED, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_COMPLETED, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_MODE, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_SELECT, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_RESELECT, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_DETACH, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_SERVER, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_POOL, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_STATUS, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_ALIVE, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_PERSIST, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_METHOD, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_SCORE, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_PRIORITY, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_CONNECT, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_BIAS, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_SNAT, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_LIMIT, p.parseLoadBalancerCommand),
+		p.registerPrefix(token.LB_CLASS, p.parseLoadBalancerCommand),
+
+		p.registerPrefix(token.CALL, p.parseCallCommand),
+		p.registerPrefix(token.RESOLVER_REF, p.parseResolverReference),
+
+		// SSL Commands
+		p.registerPrefix(token.SSL_CIPHER, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_CIPHER_BITS, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_CLIENTHELLO, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_SERVERHELLO, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_CERT, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_VERIFY_RESULT, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_SESSIONID, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_RENEGOTIATE, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_SESSIONVALID, p.parseSSLCommand),
+		p.registerPrefix(token.SSL_SESSIONUPDATES, p.parseSSLCommand),
+
+		// IP Commands
+		p.registerPrefix(token.IP_CLIENT_ADDR, p.parseIpExpression),
+		p.registerPrefix(token.IP_SERVER_ADDR, p.parseIpExpression),
+		p.registerPrefix(token.IP_REMOTE_ADDR, p.parseIpExpression),
+		p.registerPrefix(token.IP_ADDRESS, p.parseIpAddressLiteral),
+
+		p.registerPrefix(token.SWITCH, p.parseSwitchExpression),
+		p.registerPrefix(token.DEFAULT, p.parseDefaultExpression),
+
+		p.registerInfix(token.ASTERISK, p.parseInfixExpression),
+		p.registerInfix(token.EQ, p.parseInfixExpression),
+		p.registerInfix(token.LBRACKET, p.parseIndexExpression),
+		p.registerInfix(token.LPAREN, p.parseCallExpression),
+		p.registerInfix(token.GT, p.parseInfixExpression),
+		p.registerInfix(token.LT, p.parseInfixExpression),
+		p.registerInfix(token.LT_EQ, p.parseInfixExpression),
+		p.registerInfix(token.GT_EQ, p.parseInfixExpression),
+		p.registerInfix(token.MINUS, p.parseInfixExpression),
+		p.registerInfix(token.NOT_EQ, p.parseInfixExpression),
+		p.registerInfix(token.PLUS, p.parseInfixExpression),
+		p.registerInfix(token.SLASH, p.parseInfixExpression),
+		p.registerInfix(token.STARTS_WITH, p.parseInfixExpression),
+		p.registerInfix(token.ENDS_WITH, p.parseInfixExpression),
+		p.registerInfix(token.MATCHES, p.parseInfixExpression),
+		p.registerInfix(token.AND, p.parseInfixExpression),
+		p.registerInfix(token.OR, p.parseInfixExpression),
+		p.registerInfix(token.CONTAINS, p.parseInfixExpression),
+		p.registerInfix(token.QUESTION, p.parseTernaryExpression),
+	)
+
+	if p.opts.DebugMode {
+		p.reportUnreachableParseFns()
+	}
 
 	return p
 }
 
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Phase() == diagnostic.Lexer {
+			errors = append(errors, fmt.Sprintf("   [Lexer] %s, Line: %d", d.Message, d.Line))
+		} else {
+			errors = append(errors, fmt.Sprintf("   %s, Line: %d", d.Message, d.Line))
+		}
+	}
+	return errors
+}
+
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	p.reportError("peekError: Expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	p.reportDiagnostic(diagnostic.UnexpectedToken, "peekError: Expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
 func (p *Parser) nextToken() {
@@ -217,8 +287,20 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 	p.currentLine = p.curToken.Line
 
+	if p.opts.TestMode {
+		p.seenTokens = append(p.seenTokens, p.curToken)
+	}
+
+	if p.currentVariables != nil && !p.isParsingBracedWord {
+		p.currentVariables.record(p.prevToken, p.curToken)
+	}
+	if p.currentCost != nil {
+		p.currentCost.record(p.prevToken, p.curToken)
+	}
+
 	if p.peekToken.Line > 0 {
 		p.lastKnownLine = p.peekToken.Line
+		p.lastKnownColumn = p.peekToken.Column
 	}
 
 	if p.curToken.Type == token.LBRACE {
@@ -229,7 +311,7 @@ func (p *Parser) nextToken() {
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Starting to parse program\n")
 	}
 	program := &ast.Program{}
@@ -237,42 +319,62 @@ func (p *Parser) ParseProgram() *ast.Program {
 	p.braceCount = 0
 
 	for !p.curTokenIs(token.EOF) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: Current token: %s, Brace count: %d\n", p.curToken.Type, p.braceCount)
 		}
 		stmt := p.parseStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
-		} else {
+		} else if p.opts.DebugMode {
 			fmt.Printf("   ERROR: Failed to parse statement at token: %+v\n", p.curToken)
 		}
 
+		p.skipSemicolons()
 		p.nextToken()
 	}
 
-	// check for lexer errors after parsing
-	lexerErrors := p.l.Errors()
-	if len(lexerErrors) > 0 {
-		p.errors = append(p.errors, lexerErrors...)
+	// procs may be defined after the events that call them
+	p.resolveLocalProcCalls()
+	p.diagnostics = append(p.diagnostics, PriorityTies(EventOrder(ruleEventHandlers(p.eventHandlers, "")))...)
+	p.diagnostics = append(p.diagnostics, checkVariableLifetimes(p.variables)...)
+	p.checkUndeclaredVariables()
+	p.checkEventContexts(program)
+	p.checkPolicyCandidate(program)
+	p.checkRuleLimits(program, p.curToken)
+	p.runAnalyzers(program)
+	if p.opts.TestMode {
+		p.checkExpectations()
 	}
 
+	// check for lexer errors after parsing
+	p.diagnostics = append(p.diagnostics, p.l.Diagnostics()...)
+
 	// handle any remaining open blocks at EOF
 	if p.braceCount != 0 {
-		p.reportError("Unbalanced braces: depth at end of parsing is %d", p.braceCount)
+		p.reportDiagnostic(diagnostic.UnbalancedBraces, "Unbalanced braces: depth at end of parsing is %d", []any{p.braceCount, p.lastKnownLine}...)
 	}
+	p.dropDisabledChecks()
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Finished parsing program, total statements: %d\n", len(program.Statements))
 	}
 	return program
 }
 
 func (p *Parser) parseStatement() ast.Statement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStatement - Current token: %s, Peek token: %s\n", p.curToken.Type, p.peekToken.Type)
 	}
 
+	// an empty command, as in a block opening with ;
+	if p.curTokenIs(token.SEMICOLON) {
+		return nil
+	}
+
 	var stmt ast.Statement
+	// the token starting the statement, taken before parsing the rest of it
+	// moves curToken on
+	start := p.curToken
 
 	switch p.curToken.Type {
 	case token.SET:
@@ -283,10 +385,26 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.RETURN:
 		stmt = p.parseReturnStatement()
 	case token.IDENT:
+		if p.curToken.Literal == "priority" && p.peekTokenIs(token.NUMBER) {
+			stmt = p.parsePriorityStatement()
+			break
+		}
+		if p.curToken.Literal == "assert" {
+			stmt = &ast.ExpressionStatement{Token: start, Expression: p.parseAssertCommand()}
+			break
+		}
+		switch p.curToken.Literal {
+		case "while":
+			return p.parseWhileStatement()
+		case "for":
+			return p.parseForStatement()
+		case "break", "continue":
+			p.checkLoopControl()
+		}
 		return p.parseExpressionStatement()
 	case token.WHEN:
 		stmt = &ast.ExpressionStatement{
-			Token:      p.curToken,
+			Token:      start,
 			Expression: p.parseWhenExpression(),
 		}
 	case token.IF:
@@ -296,11 +414,18 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.ELSEIF:
 		stmt = p.parseIfStatement()
 	case token.LBRACE:
+		if !isCommandStart(p.prevToken, p.curToken) {
+			// a braced argument of a command, such as the message of log local0. {...}
+			stmt = &ast.ExpressionStatement{Token: start, Expression: p.parseBracedWord()}
+			break
+		}
 		stmt = p.parseBlockStatement()
 	case token.SWITCH:
 		stmt = p.parseSwitchStatement()
 	case token.LTM:
 		stmt = p.parseLtmRule()
+	case token.PROC:
+		stmt = p.parseProcStatement()
 	default:
 		stmt = p.parseExpressionStatement()
 	}
@@ -310,40 +435,41 @@ func (p *Parser) parseStatement() ast.Statement {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStatement End - Parsed: %T\n", stmt)
 	}
 	return stmt
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Start parseReturnStatement\n")
 	}
 
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
-	p.nextToken() // consume the 'return' token
-
-	// check if the next token is a semicolon or a closing brace - if so, it's a bare return statement
-	if p.curTokenIs(token.SEMICOLON) || p.curTokenIs(token.RBRACE) {
+	// a bare return ends at the end of its command or block, whose closing
+	// brace is left to the block
+	if p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACE) {
 		return stmt
 	}
 
+	p.nextToken() // consume the 'return' token
+
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: End parseReturnStatement\n")
 	}
 	return stmt
 }
 
 func (p *Parser) parseSetStatement() *ast.SetStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetStatement Start\n")
 	}
 	stmt := &ast.SetStatement{Token: p.curToken}
@@ -374,22 +500,26 @@ func (p *Parser) parseSetStatement() *ast.SetStatement {
 		// this is a simple identifier
 		isValid, err := p.isValidIRuleIdentifier(p.curToken.Literal, "variable")
 		if !isValid {
-			p.reportError("parseSetStatement: Invalid identifier %s: %v", p.curToken.Literal, err)
+			p.reportDiagnostic(diagnostic.InvalidIdentifier, "parseSetStatement: Invalid identifier %s: %v", p.curToken.Literal, err)
 			return nil
 		}
 		variableName = p.curToken.Literal
-		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		stmt.Name = ident
+		if p.peekIsArrayKey() {
+			stmt.Name = p.parseArrayAccess(ident)
+		}
 	}
 
 	// add the variable to the declared variables map
 	if variableName != "" {
 		p.declaredVariables[variableName] = true
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSetStatement Added variable %s to declared variables\n", variableName)
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetStatement Statement Name: %v.\n", stmt.Name)
 	}
 
@@ -402,11 +532,13 @@ func (p *Parser) parseSetStatement() *ast.SetStatement {
 		} else {
 			stmt.Value = p.parseArrayLiteral()
 		}
+	} else if p.curTokenIs(token.LBRACE) {
+		stmt.Value = p.parseBracedWord()
 	} else {
 		stmt.Value = p.parseExpression(LOWEST)
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetStatement name type: %T\n", stmt.Name)
 		fmt.Printf("DEBUG: parseSetStatement statement name: %v\n", stmt.Name)
 		fmt.Printf("DEBUG: parseSetStatement value type: %T\n", stmt.Value)
@@ -418,7 +550,7 @@ func (p *Parser) parseSetStatement() *ast.SetStatement {
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseExpressionStatement Start, current token: %s, Line: %d\n", p.curToken.Type, p.currentLine)
 	}
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
@@ -429,6 +561,14 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 			stmt.Expression = p.parsePoolStatement()
 		case "node":
 			stmt.Expression = p.parseNodeStatement()
+		case "snat":
+			stmt.Expression = p.parseSnatStatement()
+		case "snatpool":
+			stmt.Expression = p.parseSnatpoolStatement()
+		case "virtual":
+			stmt.Expression = p.parseVirtualStatement()
+		case "use":
+			stmt.Expression = p.parseUseStatement()
 		default:
 			stmt.Expression = p.parseExpression(LOWEST)
 		}
@@ -436,7 +576,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 		stmt.Expression = p.parseExpression(LOWEST)
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseExpressionStatement - Parsed expression: %T\n", stmt.Expression)
 	}
 
@@ -444,7 +584,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 		p.nextToken()
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseExpressionStatement End, expression type: %T\n", stmt.Expression)
 	}
 
@@ -452,7 +592,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseExpression Start - Current token: %s, Type: %s, Precedence: %d\n", p.curToken.Literal, p.curToken.Type, precedence)
 	}
 
@@ -460,7 +600,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	// check for matches_regex as the current token
 	if p.curTokenIs(token.IDENT) && p.curToken.Literal == "matches_regex" {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseExpression encountered matches_regex as current token\n")
 		}
 		return p.parseMatchesRegexExpression(nil)
@@ -468,8 +608,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	switch {
 	case p.curTokenIs(token.STRING):
-		stringLit := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
-		return p.parseStringLiteralContents(stringLit)
+		expr := p.parseStringLiteral()
+		if stringLit, ok := expr.(*ast.StringLiteral); ok {
+			return p.parseStringLiteralContents(stringLit)
+		}
+		return expr
 	case p.curTokenIs(token.IDENT) && p.curToken.Literal == "string":
 		leftExp = p.parseStringOperation()
 	case p.curTokenIs(token.CLASS):
@@ -493,7 +636,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 			if p.curTokenIs(token.RBRACE) || p.curTokenIs(token.RBRACKET) || p.curTokenIs(token.EOF) {
 				return nil
 			}
-			p.noPrefixParseFnError(p.curToken.Type)
+			p.noPrefixParseFnError(p.curToken)
 			return nil
 		}
 		leftExp = prefix()
@@ -501,7 +644,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	// check if leftExp is an InvalidIdentifier
 	if invalidIdent, ok := leftExp.(*ast.InvalidIdentifier); ok {
-		p.reportError("parseExpression: Got *ast.InvalidIdentifier: %s", invalidIdent.Value)
+		p.reportDiagnostic(diagnostic.InvalidIdentifier, "parseExpression: Got *ast.InvalidIdentifier: %s", invalidIdent.Value)
 		return leftExp
 	}
 
@@ -516,7 +659,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	if precedence < CALL {
 		for !p.peekTokenIs(token.SEMICOLON) && !p.peekTokenIs(token.EOF) && precedence < p.peekPrecedence() {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseExpression loop - Current: %s, Peek: %s, Precedence: %d, Peek Precedence: %d\n", p.curToken.Literal, p.peekToken.Literal, precedence, p.peekPrecedence())
 			}
 
@@ -530,14 +673,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 			}
 
 			p.nextToken()
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseExpression Parsing infix expression, operator: %s\n", p.curToken.Literal)
 			}
 			leftExp = infix(leftExp)
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseExpression End, current token: %s, result type: %T\n", p.curToken.Literal, leftExp)
 	}
 
@@ -547,13 +690,44 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 func (p *Parser) parseIdentifier() ast.Expression {
 	value := p.curToken.Literal
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIdentifier called with value: %s\n", value)
 	}
 
+	if value == "IP::addr" {
+		return p.parseIpAddrCommand()
+	}
+
+	// Tcl builtins such as md5 are plain words when they aren't the first
+	// word of a command, as in CRYPTO::hash -alg sha256
+	if spec, ok := LookupCommand(value); ok && (strings.Contains(value, "::") || isCommandStart(p.prevToken, p.curToken)) {
+		return p.parseRegisteredCommand(spec)
+	}
+	if p.isCustomCommand(value) && (strings.Contains(value, "::") || isCommandStart(p.prevToken, p.curToken)) {
+		return p.parseRegisteredCommand(CommandSpec{Name: value, MaxArgs: -1})
+	}
+
+	// only the first word of a command, as an argument these are plain words
+	if isCommandStart(p.prevToken, p.curToken) {
+		switch value {
+		case "catch":
+			return p.parseCatchExpression()
+		case "expr":
+			return p.parseExprCommand()
+		case "regexp":
+			return p.parseRegexpCommand()
+		case "table":
+			return p.parseTableCommand()
+		}
+	}
+
 	if strings.HasPrefix(value, "$") {
 		// this is a variable
-		return &ast.Identifier{Token: p.curToken, Value: value}
+		ident := &ast.Identifier{Token: p.curToken, Value: value}
+		if p.peekIsArrayKey() {
+			return p.parseArrayAccess(ident)
+		}
+		return ident
 	}
 
 	context := "standalone"
@@ -562,30 +736,45 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	}
 
 	isValid, err := p.isValidIRuleIdentifier(value, context)
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIdentifier: isValid: %v, %v, identifier: %s\n", isValid, err, value)
 	}
 
 	if !isValid || err != nil {
-		p.reportError("parseIdentifier: Invalid identifier: %s", value)
+		namespace, unknown := unknownNamespace(value)
+		if unknown {
+			p.unknownNamespaces[namespace]++
+		}
+		if isUnsupportedCommand(value) {
+			return p.parseUnsupportedCommand()
+		}
+		if unknown {
+			p.reportDiagnostic(diagnostic.UnknownNamespace, "unknown command namespace %s in %s, expected one of %s", []any{namespace, value, strings.Join(knownNamespaces(), ", "), p.curToken}...)
+			return &ast.Identifier{Token: p.curToken, Value: value}
+		}
+		p.reportDiagnostic(diagnostic.InvalidIdentifier, "parseIdentifier: Invalid identifier: %s", value)
 		return &ast.InvalidIdentifier{Token: p.curToken, Value: value}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIdentifier: %s is a valid identifier\n", value)
 	}
 	return &ast.Identifier{Token: p.curToken, Value: value}
 }
 
-func isValidHeaderName(s string) bool {
-	if config.DebugMode {
+func (p *Parser) isValidHeaderName(s string) bool {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: isValidHeaderName called with value: %s\n", s)
 	}
 
+	if p.isCustomHeader(s) {
+		return true
+	}
+
 	// check against a list of common headers
 	for _, header := range commonHeaders {
 		if strings.EqualFold(s, header) {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: isValidHeaderName: %s is a valid common header name\n", s)
 			}
 			return true
@@ -597,7 +786,7 @@ func isValidHeaderName(s string) bool {
 		return true
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("   ERROR: isValidHeaderName: %s is not a valid header name\n", s)
 	}
 	return false
@@ -646,9 +835,10 @@ func (p *Parser) parseBoolean() ast.Expression {
 
 func (p *Parser) parseStringLiteral() ast.Expression {
 	token := p.curToken
-	value := token.Literal[1 : len(token.Literal)-1] // remove quotes
+	value := token.Literal // the lexer has already removed the quotes
 
-	if strings.Contains(value, "\\") || strings.Contains(value, "${") {
+	// case patterns sit in a braced switch body where no substitution happens
+	if (!p.isParsingCasePattern && (strings.ContainsAny(value, "\\[") || stringVariableRegex.MatchString(value))) || strings.Contains(value, "${") {
 		return p.parseInterpolatedString(token, value)
 	}
 
@@ -673,10 +863,48 @@ func (p *Parser) parseInterpolatedString(token token.Token, value string) ast.Ex
 				p.reportError("parseInterpolatedString: Unterminated interpolation in string")
 				return nil
 			}
-			expr := p.parseExpression(LOWEST)
-			parts = append(parts, expr)
+			parts = append(parts, &ast.Identifier{Token: embeddedToken(token, value, i), Value: "$" + value[i+2:i+end]})
 			i += end
+		} else if loc := stringVariableRegex.FindStringIndex(value[i:]); loc != nil && loc[0] == 0 {
+			if currentPart != "" {
+				parts = append(parts, &ast.StringLiteral{Token: token, Value: currentPart})
+				currentPart = ""
+			}
+			var part ast.Expression = &ast.Identifier{Token: embeddedToken(token, value, i), Value: value[i : i+loc[1]]}
+			i += loc[1] - 1
+			if end := matchingParen(value, i+1); end > 0 {
+				part = p.parseEmbeddedArrayAccess(part.(*ast.Identifier), token, value, i+1, end)
+				i = end
+			}
+			parts = append(parts, part)
+		} else if value[i] == '[' {
+			if currentPart != "" {
+				parts = append(parts, &ast.StringLiteral{Token: token, Value: currentPart})
+				currentPart = ""
+			}
+			end := matchingBracket(value, i)
+			pos := token
+			pos.Line, pos.Column = embeddedPosition(token, value, i)
+			if end == -1 {
+				p.reportDiagnostic(diagnostic.SyntaxError, "unbalanced brackets in string: '%s' is missing its closing ]", []any{firstLine(value[i:]), pos}...)
+				return nil
+			}
+			if strings.TrimSpace(value[i+1:end]) == "" {
+				p.reportWarning(diagnostic.SuspectSubstitution, "empty command substitution [] in string", []any{pos}...)
+				i = end
+				continue
+			}
+			p.checkSubstitutionCommand(value[i+1:end], pos)
+			if expr := p.parseEmbeddedCommand(value[i:end+1], pos.Line, pos.Column); expr != nil {
+				parts = append(parts, expr)
+			}
+			i = end
 		} else {
+			if value[i] == ']' {
+				pos := token
+				pos.Line, pos.Column = embeddedPosition(token, value, i)
+				p.reportWarning(diagnostic.SuspectSubstitution, "unbalanced brackets in string: ] without an opening [ is kept as text", []any{pos}...)
+			}
 			currentPart += string(value[i])
 		}
 	}
@@ -688,8 +916,96 @@ func (p *Parser) parseInterpolatedString(token token.Token, value string) ast.Ex
 	return &ast.InterpolatedString{Token: token, Parts: parts}
 }
 
+// reports a command substitution in a string whose first word isn't a known
+// command. Tcl fails on it at runtime
+func (p *Parser) checkSubstitutionCommand(script string, pos token.Token) {
+	name := strings.Fields(script)[0]
+	if strings.Contains(name, "::") || strings.ContainsAny(name[:1], "$[{\"") {
+		// namespaced commands are checked by the parser of their namespace
+		return
+	}
+	if _, ok := LookupCommand(name); ok || p.isCustomCommand(name) || token.LookupIdent(name) != token.IDENT ||
+		containsString(commonIdentifiers, name) || containsString(substitutionCommands, name) {
+		return
+	}
+	p.reportWarning(diagnostic.SuspectSubstitution, "unknown command '%s' in string substitution", []any{name, pos}...)
+}
+
+// returns the first line of a string
+func firstLine(value string) string {
+	if i := strings.IndexByte(value, '\n'); i >= 0 {
+		return value[:i]
+	}
+	return value
+}
+
+// returns the line and column of the char at offset in the contents of a
+// string token. the token starts at the opening quote
+func embeddedPosition(tok token.Token, value string, offset int) (int, int) {
+	before := value[:offset]
+	line := tok.Line + strings.Count(before, "\n")
+	if newline := strings.LastIndex(before, "\n"); newline >= 0 {
+		return line, utf8.RuneCountInString(before[newline+1:]) + 1
+	}
+	if tok.Column == 0 {
+		return line, 0
+	}
+	return line, tok.Column + 1 + utf8.RuneCountInString(before)
+}
+
+// returns a token for a word embedded in a string, placed where the word is
+func embeddedToken(tok token.Token, value string, offset int) token.Token {
+	pos := tok
+	pos.Line, pos.Column = embeddedPosition(tok, value, offset)
+	return pos
+}
+
+// returns the index of the bracket closing the one at start, or -1
+func matchingBracket(value string, start int) int {
+	depth := 0
+	for i := start; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parses a command substitution embedded in a string with a parser of its own
+// and folds its findings back in, shifted to the line the string starts on
+func (p *Parser) parseEmbeddedCommand(script string, line, column int) ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseEmbeddedCommand Start - Script: %s, Line: %d, Column: %d\n", script, line, column)
+	}
+
+	sub := New(lexer.NewWithOptions(script, p.opts))
+	sub.declaredVariables = p.declaredVariables
+	sub.procs = p.procs
+	sub.unknownNamespaces = p.unknownNamespaces
+	expr := sub.parseExpression(LOWEST)
+
+	p.procCalls = append(p.procCalls, shiftProcCalls(sub.procCalls, line-1)...)
+	for _, d := range append(sub.diagnostics, sub.l.Diagnostics()...) {
+		if d.Line == 1 && d.Column > 0 {
+			d.Column += column - 1
+		}
+		d.Line += line - 1
+		p.diagnostics = append(p.diagnostics, d)
+	}
+
+	return expr
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseGroupedExpression Start. Token: %v\n", p.curToken.Literal)
 	}
 
@@ -706,7 +1022,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseGroupedExpression End. Expr: %v\n", exp)
 	}
 	return exp
@@ -720,6 +1036,20 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
 
+// reports whether the peek token begins a new command. like TCL, an unescaped
+// newline terminates a command the same way a semicolon does.
+// moves past the semicolons ending the command just parsed, so the command
+// following it on the same line starts a statement of its own
+func (p *Parser) skipSemicolons() {
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
+func (p *Parser) peekIsCommandEnd() bool {
+	return p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.EOF) || p.peekToken.LineStart
+}
+
 func (p *Parser) expectPeek(t token.TokenType) bool {
 	if p.peekTokenIs(t) {
 		p.prevToken = p.curToken
@@ -731,16 +1061,94 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
+// registering a second parse function for a token would silently replace the
+// first one, so it is refused with an error and the first one kept
+func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) error {
+	if _, ok := p.prefixParseFns[tokenType]; ok {
+		return fmt.Errorf("prefix parse function for %s registered twice", tokenType)
+	}
 	p.prefixParseFns[tokenType] = fn
+	return nil
 }
 
-func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
+func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) error {
+	if _, ok := p.infixParseFns[tokenType]; ok {
+		return fmt.Errorf("infix parse function for %s registered twice", tokenType)
+	}
 	p.infixParseFns[tokenType] = fn
+	return nil
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	p.reportError("No prefix parse function for %s found", t)
+// returns the token types that have a parse function the lexer never gives
+// them a chance to run, sorted by type
+func (p *Parser) unreachableParseFns() []string {
+	unreachable := []string{}
+	for tokenType := range p.prefixParseFns {
+		if !lexerProduces(tokenType) {
+			unreachable = append(unreachable, "prefix "+string(tokenType))
+		}
+	}
+	for tokenType := range p.infixParseFns {
+		if !lexerProduces(tokenType) {
+			unreachable = append(unreachable, "infix "+string(tokenType))
+		}
+	}
+	sort.Strings(unreachable)
+	return unreachable
+}
+
+// sample inputs for token types whose literal varies
+var tokenSamples = map[token.TokenType]string{
+	token.IDENT:        "name",
+	token.NUMBER:       "42",
+	token.STRING:       `"text"`,
+	token.REGEX:        "{^/api}",
+	token.IP_ADDRESS:   "10.0.0.1",
+	token.RESOLVER_REF: "@10.0.0.53",
+	token.CALL:         "call",
+	token.EOF:          "",
+}
+
+// reports whether the lexer emits tokens of the given type, by lexing the
+// literals the type is known by
+func lexerProduces(t token.TokenType) bool {
+	samples := append([]string{string(t)}, token.KeywordsFor(t)...)
+	if sample, ok := tokenSamples[t]; ok {
+		samples = append(samples, sample)
+	}
+	for _, keywords := range []map[string]token.TokenType{lexer.HttpKeywords, lexer.LbKeywords, lexer.SSLKeywords} {
+		for literal, tokenType := range keywords {
+			if tokenType == t {
+				samples = append(samples, literal)
+			}
+		}
+	}
+
+	for _, sample := range samples {
+		if lexer.New(sample).NextToken().Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+var reportUnreachableOnce sync.Once
+
+// lists parse functions registered twice and unreachable parse functions
+// once per run in debug mode
+func (p *Parser) reportUnreachableParseFns() {
+	reportUnreachableOnce.Do(func() {
+		if p.registrationErr != nil {
+			fmt.Printf("DEBUG: %v\n", p.registrationErr)
+		}
+		for _, fn := range p.unreachableParseFns() {
+			fmt.Printf("DEBUG: %s parse function is unreachable, the lexer never produces the token\n", fn)
+		}
+	})
+}
+
+func (p *Parser) noPrefixParseFnError(t token.Token) {
+	p.reportDiagnostic(diagnostic.UnexpectedToken, "No prefix parse function for %s found", []any{t.Type, t}...)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -760,7 +1168,7 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBlockStatement Start - Current token: %s, Brace count: %d\n", p.curToken.Literal, p.braceCount)
 	}
 	block := &ast.BlockStatement{Token: p.curToken}
@@ -772,40 +1180,42 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.braceCount++
 	p.nextToken() // consume opening brace
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBlockStatement Entering block statement. Brace count: %d\n", p.braceCount)
 	}
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseBlockStatement loop - Current token: %s, Brace count: %d\n", p.curToken.Literal, p.braceCount)
 		}
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseBlockStatement: Added statement to block, type: %T\n", stmt)
 			}
-		} else if config.DebugMode {
+		} else if p.opts.DebugMode {
 			fmt.Printf("   ERROR: parseBlockStatement Failed to parse statement at token: %+v\n", p.curToken)
 		}
 
+		p.skipSemicolons()
 		p.nextToken()
 	}
 
 	if p.curTokenIs(token.RBRACE) {
+		block.End = p.curToken
 		p.braceCount--
 	} else if p.curTokenIs(token.EOF) {
 		p.braceCount--
 	}
 
 	if p.curTokenIs(token.EOF) && p.braceCount > 0 {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("parseBlockStatement: Unexpected EOF, expected '}'. Brace count: %d Line: %d", p.braceCount, p.lastKnownLine)
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBlockStatement End, statements: %d\n", len(block.Statements))
 	}
 
@@ -834,17 +1244,17 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseCallExpression - Function: %T\n", function)
 	}
 
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = []ast.Expression{}
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseCallExpression - Arguments: %T\n", exp.Arguments)
 	}
 
-	for !p.peekTokenIs(token.SEMICOLON) && !p.peekTokenIs(token.EOF) {
+	for !p.peekIsCommandEnd() && !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		arg := p.parseExpression(LOWEST)
 		if arg != nil {
@@ -852,19 +1262,46 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseCallExpression - Function: %v, Arguments: %d\n", function, len(exp.Arguments))
 	}
 	return exp
 }
 
+// parses condition ? consequence : alternative. the alternative may be a
+// conditional itself, so a ? b : c ? d : e groups to the right
+func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression {
+	if condition == nil {
+		return nil
+	}
+	expr := &ast.ConditionalExpression{Token: p.curToken, Condition: condition}
+
+	p.nextToken()
+	expr.Consequence = p.parseExpression(LOWEST)
+	if expr.Consequence == nil {
+		return nil
+	}
+	if !p.peekTokenIs(token.COLON) {
+		p.reportDiagnostic(diagnostic.SyntaxError, "missing ':' after the '?' of a conditional expression", []any{expr.Token}...)
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+
+	expr.Alternative = p.parseExpression(LOWEST)
+	if expr.Alternative == nil {
+		return nil
+	}
+	return expr
+}
+
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseInfixExpression Start - Left: %T, Operator: %s\n", left, p.curToken.Literal)
 	}
 
 	if left == nil {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseInfixExpression - Left expression is nil\n")
 		}
 		return nil
@@ -882,7 +1319,7 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression.Right = p.parseExpression(precedence)
 
 	if expression.Right == nil {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseInfixExpression - Right expression is nil\n")
 		}
 		p.reportError("parseInfixExpression: Invalid right-hand side of infix expression")
@@ -890,13 +1327,13 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	if !isValidOperatorForTypes(expression.Operator, expression.Left, expression.Right) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseInfixExpression: isValidOperatorForTypes FALSE for '%v'\n", expression)
 		}
 		p.reportError("parseInfixExpression: Invalid operator %s for types %T and %T", expression.Operator, expression.Left, expression.Right)
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseInfixExpression End - Operator: %s, Left: %T, Right: %T\n", expression.Operator, expression.Left, expression.Right)
 	}
 
@@ -906,7 +1343,7 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 func (p *Parser) parseSetExpression() ast.Expression {
 	stmt := &ast.SetStatement{Token: p.curToken}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetExpression - Starting\n")
 	}
 
@@ -925,28 +1362,28 @@ func (p *Parser) parseSetExpression() ast.Expression {
 		variableName := ident.Value
 		if strings.HasPrefix(variableName, "$") {
 			p.declareVariable(variableName)
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseSetExpression - Declared variable: %s\n", variableName)
 			}
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetExpression - Name: %s\n", stmt.Name)
 	}
 
 	// parse the value
-	if !p.peekTokenIs(token.EOF) {
+	if !p.peekIsCommandEnd() {
 		p.nextToken() // move to the value
 		stmt.Value = p.parseExpression(LOWEST)
 	}
 
-	// consume any remaining tokens until EOF or semicolon
-	for !p.curTokenIs(token.EOF) && !p.curTokenIs(token.SEMICOLON) {
+	// consume any remaining tokens until the end of the command
+	for !p.peekIsCommandEnd() && !p.peekTokenIs(token.RBRACKET) && !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSetExpression - Value parsed: %T\n", stmt.Value)
 		fmt.Printf("DEBUG: parseSetExpression - Completed: %v\n", stmt)
 	}
@@ -955,7 +1392,7 @@ func (p *Parser) parseSetExpression() ast.Expression {
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseArrayLiteral Start. Current token: %s\n", p.curToken.Literal)
 	}
 
@@ -964,7 +1401,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 	p.nextToken() // move past the opening bracket [
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseArrayLiteral after opening bracket. Current token: %s, Type: %s\n", p.curToken.Literal, p.curToken.Type)
 	}
 
@@ -1006,7 +1443,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 			expr = p.parseClassCommand()
 			if expr != nil {
 				array.Elements = append(array.Elements, expr)
-				if config.DebugMode {
+				if p.opts.DebugMode {
 					fmt.Printf("DEBUG: parseArrayLiteral - isClass; Added element: %T, curTokenIs: %s\n", expr, p.curToken.Literal)
 				}
 				// after parsing a class command, we expect to be at the closing bracket
@@ -1025,6 +1462,10 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 				return nil
 			}
 			expr = nestedExpr
+		} else if p.curTokenIs(token.HTTP_HEADER) {
+			expr = p.parseHttpHeaderCommand()
+		} else if p.curTokenIs(token.HTTP_COOKIE) {
+			expr = p.parseHttpCookieCommand()
 		} else if p.isHttpKeyword(p.curToken.Type) {
 			expr = p.parseHttpCommand()
 		} else if p.isSSLKeyword(p.curToken.Type) {
@@ -1037,7 +1478,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 		if expr != nil {
 			array.Elements = append(array.Elements, expr)
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseArrayLiteral - Added element: %T, curTokenIs: %s\n", expr, p.curToken.Literal)
 			}
 		} else {
@@ -1071,7 +1512,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		for i, elem := range array.Elements {
 			fmt.Printf("DEBUG: parseArrayLiteral - Element %d: %T\n", i, elem)
 		}
@@ -1081,54 +1522,34 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 }
 
 func (p *Parser) parseSSLCommand() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSSLCommand Start. Current token: %s\n", p.curToken.Literal)
 	}
 	command := &ast.SSLExpression{Token: p.curToken}
 	var commandParts []string
 
-	for !p.peekTokenIs(token.RBRACKET) && !p.peekTokenIs(token.EOF) {
-		if config.DebugMode {
+	for {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSSLCommand loop. Current token: %s\n", p.curToken.Literal)
 		}
 		commandParts = append(commandParts, p.curToken.Literal)
+		if p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE) || p.peekIsCommandEnd() {
+			break
+		}
 		p.nextToken()
 	}
 
 	command.Command = &ast.Identifier{Token: command.Token, Value: strings.Join(commandParts, " ")}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSSLCommand Command: %s\n", command.Command.Value)
 	}
 
 	return command
 }
 
-func (p *Parser) parseVariableOrArrayAccess() ast.Expression {
-	p.nextToken() // consume '$'
-	if !p.curTokenIs(token.IDENT) {
-		p.reportError("parseVariableOrArrayAccess: Expected identifier after $, got %s instead", p.curToken.Type)
-		return nil
-	}
-
-	varExp := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal, IsVariable: true}
-
-	if p.peekTokenIs(token.LPAREN) {
-		p.nextToken() // consume '('
-		p.nextToken() // move to index
-		index := p.parseExpression(LOWEST)
-		if !p.expectPeek(token.RPAREN) {
-			p.reportError("parseVariableOrArrayAccess:  Expected RPAREN, got %s", p.curToken.Type)
-			return nil
-		}
-		return &ast.IndexExpression{Left: varExp, Index: index}
-	}
-
-	return varExp
-}
-
 func (p *Parser) ParseIRule() *ast.IRuleNode {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: ParseIRule Start\n")
 	}
 	irule := &ast.IRuleNode{}
@@ -1142,14 +1563,14 @@ func (p *Parser) ParseIRule() *ast.IRuleNode {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: ParseIRule End\n")
 	}
 	return irule
 }
 
 func (p *Parser) parseWhenNode() *ast.WhenNode {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWhenNode Start\n")
 	}
 	when := &ast.WhenNode{}
@@ -1167,14 +1588,14 @@ func (p *Parser) parseWhenNode() *ast.WhenNode {
 
 	when.Statements = p.parseBlockStatements()
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWhenNode End\n")
 	}
 	return when
 }
 
 func (p *Parser) parseBlockStatements() []ast.Statement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBlockStatementS (with an S) Start\n")
 	}
 	statements := []ast.Statement{}
@@ -1189,7 +1610,7 @@ func (p *Parser) parseBlockStatements() []ast.Statement {
 		p.nextToken()
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG:End parseBlockStatementS (with an S)\n")
 	}
 
@@ -1197,63 +1618,49 @@ func (p *Parser) parseBlockStatements() []ast.Statement {
 }
 
 func (p *Parser) parseHttpCommand() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseHttpCommand Start - Current Token: %s\n", p.curToken.Literal)
 	}
 
 	expr := &ast.HttpExpression{Token: p.curToken}
 	fullCommand := p.curToken.Literal
 
-	// check if the command is a valid HTTP keyword
-	if _, isValidHttpCommand := lexer.HttpKeywords[fullCommand]; isValidHttpCommand {
+	// check if the command is a valid HTTP keyword. read-only commands such
+	// as HTTP::is_keepalive have no token of their own
+	_, isValidHttpCommand := lexer.HttpKeywords[fullCommand]
+	isValidHttpCommand = isValidHttpCommand || containsString(readOnlyHttpCommands, fullCommand)
+	if isValidHttpCommand {
 		expr.Command = &ast.Identifier{Token: p.curToken, Value: fullCommand}
+	} else if isUnsupportedCommand(fullCommand) {
+		return p.parseUnsupportedCommand()
 	} else {
-		p.reportError("parseHttpCommand: Invalid HTTP command: %s", fullCommand)
-		if config.DebugMode {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "parseHttpCommand: Invalid HTTP command: %s", []any{fullCommand, expr.Token}...)
+		if p.opts.DebugMode {
 			fmt.Printf("   ERROR: parseHttpCommand - Invalid HTTP command detected: %s\n", fullCommand)
 		}
 		return nil
 	}
 
 	switch {
-	case lexer.HttpKeywords[fullCommand] != token.ILLEGAL:
+	case isValidHttpCommand:
 		expr.Command = &ast.Identifier{Token: p.curToken, Value: fullCommand}
-	case fullCommand == "HTTP::header":
-		expr.Command = &ast.Identifier{Token: p.curToken, Value: "HTTP::header"}
-		if p.peekTokenIs(token.IDENT) {
-			p.nextToken()
-			switch p.curToken.Literal {
-			case "names":
-				expr.Argument = &ast.Identifier{Token: p.curToken, Value: "names"}
-			case "exists":
-				expr.Argument = &ast.Identifier{Token: p.curToken, Value: "exists"}
-				if p.peekTokenIs(token.STRING) {
-					p.nextToken()
-					expr.Argument = &ast.ArrayLiteral{
-						Token: p.curToken,
-						Elements: []ast.Expression{
-							&ast.Identifier{Token: p.curToken, Value: "exists"},
-							&ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal},
-						},
-					}
-				}
-			default:
-				expr.Argument = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
-			}
-		} else if p.peekTokenIs(token.STRING) {
-			p.nextToken()
-			expr.Argument = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
-		}
 	default:
-		p.reportError("parseHttpCommand: Invalid HTTP command or header: %s", fullCommand)
-		if config.DebugMode {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "parseHttpCommand: Invalid HTTP command or header: %s", []any{fullCommand, expr.Token}...)
+		if p.opts.DebugMode {
 			fmt.Printf("   ERROR: parseHttpCommand - Invalid HTTP command or header detected: %s\n", fullCommand)
 		}
 		return nil
 	}
 
+	// commands such as HTTP::uri rewrite the request when given a value,
+	// others such as HTTP::method only return what was sent
+	if isCommandStart(p.prevToken, p.curToken) && (containsString(mutableHttpCommands, fullCommand) || containsString(readOnlyHttpCommands, fullCommand)) {
+		p.parseHttpValue(expr)
+		return expr
+	}
+
 	// check for additional arguments
-	for p.peekTokenIs(token.STRING) {
+	for p.peekTokenIs(token.STRING) && !p.peekIsCommandEnd() {
 		p.nextToken()
 		if expr.Argument == nil {
 			expr.Argument = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
@@ -1273,14 +1680,14 @@ func (p *Parser) parseHttpCommand() ast.Expression {
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseHttpCommand End - Command: %s, Argument: %v\n", expr.Command.Value, expr.Argument)
 	}
 	return expr
 }
 
 func (p *Parser) parseIfStatement() *ast.IfStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIfStatement Start - curToken: %s\n", p.curToken.Literal)
 	}
 	stmt := &ast.IfStatement{Token: p.curToken}
@@ -1293,7 +1700,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 
 	p.nextToken() // consume '{'
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIfStatement - Parsing condition, current token: %s\n", p.curToken.Literal)
 	}
 
@@ -1303,7 +1710,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 		return nil
 	}
 	stmt.Condition = condition
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIfStatement - After parsing condition, current token: %s\n", p.curToken.Literal)
 	}
 
@@ -1321,7 +1728,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 			return nil
 		}
 		stmt.Condition = condition
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseIfStatement - Parsed comparison, condition: %v\n", condition)
 		}
 	}
@@ -1337,7 +1744,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 		p.nextToken() // move to the right side of comparison
 		comparison.Right = p.parseExpression(LOWEST)
 		stmt.Condition = comparison
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseIfStatement - Parsed comparison, operator: %s, right: %T\n", comparison.Operator, comparison.Right)
 		}
 	}
@@ -1413,7 +1820,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseIfStatement End - Current token: %s, Peek token: %s\n", p.curToken.Literal, p.peekToken.Literal)
 	}
 
@@ -1421,7 +1828,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 }
 
 func (p *Parser) parseWhenExpression() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWhenExpression Start\n")
 	}
 	expr := &ast.WhenExpression{Token: p.curToken}
@@ -1429,6 +1836,9 @@ func (p *Parser) parseWhenExpression() ast.Expression {
 	// check if the next token is a valid expression token
 	if p.isValidWhenEvent(token.TokenType(p.peekToken.Literal)) {
 		p.nextToken() // advance to the event token
+	} else if module := eventModule(token.TokenType(p.peekToken.Literal)); module != "" {
+		p.reportDiagnostic(diagnostic.ModuleDisabled, "event %s requires --module %s", []any{p.peekToken.Literal, module, p.peekToken}...)
+		return nil
 	} else {
 		p.reportError("parseWhenExpression: Expected HTTP_REQUEST or LB_SELECTED")
 		return nil
@@ -1436,14 +1846,26 @@ func (p *Parser) parseWhenExpression() ast.Expression {
 
 	expr.Event = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if !p.parseWhenOptions(expr) {
+		return nil
+	}
+	p.eventHandlers = append(p.eventHandlers, EventHandler{Event: expr.Event.String(), Priority: expr.Priority, Line: expr.Token.Line, Rule: p.currentRule})
+
 	if !p.expectPeek(token.LBRACE) {
 		p.reportError("parseWhenExpression: Expected LBRACE")
 		return nil
 	}
 
-	expr.Block = p.parseBlockStatement()
+	outerEvent, outerVariables, outerCost := p.currentEvent, p.currentVariables, p.currentCost
+	p.currentEvent = expr.Event.String()
+	p.currentVariables = newHandlerVariables(p.currentEvent, p.currentRule)
+	p.variables = append(p.variables, p.currentVariables)
+	p.currentCost = newCostRecorder(p.currentEvent, p.currentRule, expr.Token.Line)
+	p.costs = append(p.costs, p.currentCost)
+	expr.Block = p.parseRoutineBody()
+	p.currentEvent, p.currentVariables, p.currentCost = outerEvent, outerVariables, outerCost
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWhenExpression End\n")
 	}
 
@@ -1451,119 +1873,112 @@ func (p *Parser) parseWhenExpression() ast.Expression {
 }
 
 func (p *Parser) parseSwitchStatement() *ast.SwitchStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Start parseSwitchStatement at line %d\n", p.lastKnownLine)
 	}
 	switchStmt := &ast.SwitchStatement{Token: p.curToken}
 	switchStmt.IsRegex = false
 	switchStmt.IsGlob = false
 
-	// let the lexer know we are in a switch statement to check for comments as they are invalid in this context
-	p.l.EnterSwitchBlock()
-	defer p.l.ExitSwitchBlock()
-
 	// parse switch options and value
 	p.nextToken() // move past 'switch'
 
-	// handle options like -glob
-	for p.curTokenIs(token.MINUS) {
-		option := p.curToken.Literal
-		p.nextToken() // move past the option
-		if p.curTokenIs(token.IDENT) {
-			option += p.curToken.Literal
-			switchStmt.Options = append(switchStmt.Options, option)
-			if option == "-regex" {
-				switchStmt.IsRegex = true
-			} else if option == "-glob" {
-				switchStmt.IsGlob = true
-			}
-			p.nextToken() // move past the option value
-		}
-	}
+	p.parseSwitchOptions(switchStmt)
 
-	if config.DebugMode {
-		fmt.Printf("DEBUG: Switch type - isRegex: %v, isGlob: %v\n", switchStmt.IsRegex, switchStmt.IsGlob)
-	}
-
-	// handle the -- separator if present
-	if p.curTokenIs(token.MINUS) && p.peekTokenIs(token.MINUS) {
-		p.nextToken() // move past first -
-		p.nextToken() // move past second -
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: Switch type - isRegex: %v, isGlob: %v, noCase: %v\n", switchStmt.IsRegex, switchStmt.IsGlob, switchStmt.NoCase)
 	}
 
 	// parse the switch value (which might be a string operation)
 	switchStmt.Value = p.parseExpression(LOWEST)
 
+	// the lexer has just read the brace opening the cases. let it know so it
+	// reports comments between them, which are invalid in this context
+	if p.peekTokenIs(token.LBRACE) {
+		p.l.EnterSwitchBlock()
+	}
 	if !p.expectPeek(token.LBRACE) {
 		p.reportError("parseSwitchStatement: expected LBRACE")
 		return nil
 	}
 
 	switchStmt.Cases = []*ast.CaseStatement{}
+	// the last case read, while its body is -
+	var fallThrough *ast.CaseStatement
 
 	p.nextToken() // move past the opening brace
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 
 		// handle comments in switch statements
+		// the lexer has already skipped the comment text
 		if p.curTokenIs(token.SKIP_TO_NEXT_CASE) {
-			for !p.curTokenIs(token.STRING) &&
-				!p.curTokenIs(token.DEFAULT) &&
-				!p.curTokenIs(token.RBRACE) &&
-				!p.curTokenIs(token.EOF) {
-				p.nextToken()
-			}
+			p.nextToken()
 			continue
 		}
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSwitchStatement: Switch loop - Current token: %s, Literal: %s, Line: %d\n", p.curToken.Type, p.curToken.Literal, p.lastKnownLine)
 		}
 
 		if p.curTokenIs(token.DEFAULT) {
-			switchStmt.Default = p.parseDefaultCase()
-		} else if p.curTokenIs(token.STRING) {
-			// string-based syntax i.e. "/api*"
-			if config.DebugMode {
-				fmt.Printf("DEBUG: parseSwitchStatement: Before calling parseStringCaseStatement - Token: %+v\n", p.curToken)
-			}
-			caseStmt := p.parseStringCaseStatement()
-			if caseStmt != nil {
-				switchStmt.Cases = append(switchStmt.Cases, caseStmt)
-				caseStmt.Line = p.curToken.Line
-				if config.DebugMode {
-					fmt.Printf("DEBUG: parseSwitchStatement: StringCase Adding case statement with pattern '%s' at line %d\n", caseStmt.Value, caseStmt.Line)
-				}
+			if defaultCase := p.parseDefaultCase(); defaultCase != nil {
+				switchStmt.Default = defaultCase
+			} else {
+				p.skipToNextCase()
 			}
+			fallThrough = nil
 		} else {
-			p.reportError("parseSwitchStatement: Invalid case statement starting with token: %s", p.curToken.Literal)
-			return nil // error occurred in parsing case statement
+			if p.opts.DebugMode {
+				fmt.Printf("DEBUG: parseSwitchStatement: Before calling parseCaseStatement - Token: %+v\n", p.curToken)
+			}
+			caseStmt := p.parseCaseStatement()
+			if caseStmt == nil {
+				// the error is reported, carry on with the next case
+				p.skipToNextCase()
+				p.nextToken()
+				continue
+			}
+			switchStmt.Cases = append(switchStmt.Cases, caseStmt)
+			caseStmt.Line = p.curToken.Line
+			fallThrough = nil
+			if caseStmt.FallThrough {
+				fallThrough = caseStmt
+			}
+			if p.opts.DebugMode {
+				fmt.Printf("DEBUG: parseSwitchStatement: Adding case statement with pattern '%s' at line %d\n", caseStmt.Value, caseStmt.Line)
+			}
 		}
 
 		// ensure we're moving forward after each case
 		p.nextToken()
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Println("DEBUG: parseStringCaseStatement: Cases before validation:")
 		for i, caseStmt := range switchStmt.Cases {
 			fmt.Printf("  Case %d: Pattern '%s' at line %d\n", i, caseStmt.Value, caseStmt.Line)
 		}
 	}
+	if fallThrough != nil {
+		pattern, _ := literalWord(fallThrough.Value)
+		p.reportDiagnostic(diagnostic.SyntaxError, "no body specified for switch pattern '%s'", []any{pattern, fallThrough.Token}...)
+	}
 	if err := p.validateSwitchPatterns(switchStmt); err != nil {
 		p.reportError("validateSwitchPatterns: %s", err.Error())
-		return nil
 	}
+	p.checkSwitchValueType(switchStmt)
 
 	if !p.curTokenIs(token.RBRACE) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSwitchStatement expected RBRACE. Got=%s\n", p.curToken.Literal)
 		}
 		p.peekError(token.RBRACE)
 		return nil
 	}
+	switchStmt.End = p.curToken
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: End parseSwitchStatement, total cases: %d\n", len(switchStmt.Cases))
 	}
 	return switchStmt
@@ -1578,7 +1993,7 @@ func (p *Parser) parseDefaultExpression() ast.Expression {
 }
 
 func (p *Parser) parseDefaultCase() *ast.CaseStatement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Start parseDefaultCase\n")
 	}
 	defaultCase := &ast.CaseStatement{Token: p.curToken, Value: nil}
@@ -1588,9 +2003,9 @@ func (p *Parser) parseDefaultCase() *ast.CaseStatement {
 		return nil
 	}
 
-	defaultCase.Consequence = p.parseBlockStatement()
+	defaultCase.Consequence = p.parseCaseBody()
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: End parseDefaultCase\n")
 	}
 	return defaultCase
@@ -1615,11 +2030,14 @@ func (p *Parser) parseIpExpression() ast.Expression {
 }
 
 func (p *Parser) parseIpAddressLiteral() ast.Expression {
+	if p.peekTokenIs(token.SLASH) && p.peekIsAdjacent() {
+		return p.parseCidrLiteral()
+	}
 	return &ast.IpAddressLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseLoadBalancerCommand() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Start parseLoadBalancerCommand\n")
 	}
 
@@ -1638,27 +2056,24 @@ func (p *Parser) parseLoadBalancerCommand() ast.Expression {
 			commandParts = append(commandParts, p.curToken.Literal)
 		}
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseLoadBalancerCommand Adding to command %s\n", p.curToken.Literal)
 		}
 
-		// stop parsing if we encounter an 'if' statement or other control structures
-		if p.peekTokenIs(token.IF) || p.peekTokenIs(token.LBRACE) {
+		// stop parsing if we encounter an 'if' statement, other control structures
+		// or the end of the command. a closing bracket is left to the
+		// enclosing command substitution
+		if p.peekTokenIs(token.IF) || p.peekTokenIs(token.LBRACE) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.RBRACKET) || p.peekIsCommandEnd() {
 			break
 		}
 
 		p.nextToken()
-
-		// break if we've reached the end of this command
-		if p.curTokenIs(token.RBRACKET) {
-			break
-		}
 	}
 
 	// combine all parts into a single command string
 	command.Command = &ast.Identifier{Token: command.Token, Value: strings.Join(commandParts, " ")}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseLoadBalancerCommand End. Command: %v\n", command.Command.Value)
 	}
 
@@ -1698,31 +2113,47 @@ func (p *Parser) isValidWhenEvent(t token.TokenType) bool {
 			return true
 		}
 	}
+	if module := eventModule(t); module != "" {
+		return p.opts.ModuleEnabled(module)
+	}
 	return false
 }
 
+// returns the optional module that provides the given event, if any
+func eventModule(t token.TokenType) string {
+	for module, events := range moduleEvents {
+		for _, event := range events {
+			if t == event {
+				return module
+			}
+		}
+	}
+	return ""
+}
+
 func (p *Parser) parseStringOperation() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStringOperation Start\n")
 	}
 	stringOp := &ast.StringOperation{Token: p.curToken}
 
 	p.nextToken() // move past 'string'
 	operation := p.curToken.Literal
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStringOperation Operation: %v\n", stringOp.Operation)
 	}
 
 	// validate the operation
-	if !validStringOperations[operation] {
-		p.reportError("parseStringOperation: Invalid string operation: %s", operation)
+	signature, ok := stringSignatures[operation]
+	if !ok {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for string, expected one of %s", []any{operation, strings.Join(stringSubcommands(), ", "), p.curToken}...)
 		return nil
 	}
 
 	stringOp.Operation = operation
 
 	var args []ast.Expression
-	for p.peekToken.Type != token.RBRACKET && p.peekToken.Type != token.EOF {
+	for p.peekToken.Type != token.RBRACKET && !p.peekIsCommandEnd() {
 		p.nextToken()
 		if p.curTokenIs(token.MINUS) && p.peekTokenIs(token.IDENT) {
 			args = append(args, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal + p.peekToken.Literal})
@@ -1743,16 +2174,12 @@ func (p *Parser) parseStringOperation() ast.Expression {
 	stringOp.Arguments = args
 
 	// perform checks based on the operation
-	switch operation {
-	case "match":
-		if len(args) != 2 {
-			p.errors = append(p.errors, fmt.Sprintf("line %d: 'string match' expects 2 arguments", p.curToken.Line))
-		} else {
-			p.checkVariableUsage(args[1], "second argument of 'string match'")
-		}
+	operands, ok := p.checkStringArguments(stringOp, signature)
+	if ok && operation == "match" {
+		p.checkVariableUsage(operands[1], "second argument of 'string match'")
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStringOperation Arguments: %v\n", stringOp.Arguments)
 		fmt.Printf("DEBUG: parseStringOperation End\n")
 	}
@@ -1760,7 +2187,7 @@ func (p *Parser) parseStringOperation() ast.Expression {
 }
 
 func (p *Parser) parseMapArgument() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseMapArgument Start\n")
 	}
 	mapArg := &ast.MapLiteral{Token: p.curToken}
@@ -1789,7 +2216,7 @@ func (p *Parser) parseMapArgument() ast.Expression {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseMapArgument End\n")
 	}
 
@@ -1797,7 +2224,7 @@ func (p *Parser) parseMapArgument() ast.Expression {
 }
 
 func (p *Parser) parsePoolStatement() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parsePoolStatement Start - Current token: %s, Line: %d\n", p.curToken.Type, p.currentLine)
 	}
 
@@ -1808,82 +2235,31 @@ func (p *Parser) parsePoolStatement() ast.Expression {
 		Function: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
 	}
 
-	if !p.expectPeek(token.IDENT) {
-		p.reportError("parsePoolStatement: Expected IDENT, got %v", p.curToken.Literal)
+	// the name may be the full path of the pool, as in /Common/web_pool
+	argument := p.parseObjectName(poolStmt.Token)
+	if argument == nil {
 		return nil
 	}
-
-	argument := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	poolStmt.Arguments = append(poolStmt.Arguments, argument)
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parsePoolStatement End\n")
 	}
 	return poolStmt
 }
 
-func (p *Parser) parseClassCommand() ast.Expression {
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseClassCommand Start - curToken: %s (Type: %s), peekToken: %s (Type: %s)\n",
-			p.curToken.Literal, p.curToken.Type, p.peekToken.Literal, p.peekToken.Type)
-	}
-
-	p.isParsingClassMatch = true
-	defer func() { p.isParsingClassMatch = false }()
-
-	cmd := &ast.ClassCommand{Token: p.curToken}
-
-	// advance to the subcommand
-	if !p.expectPeek(token.MATCH) {
-		p.reportError("parseClassCommand: Expected 'match', got %s", p.curToken.Literal)
-		return nil
-	}
-
-	cmd.Subcommand = p.curToken.Literal
-
-	// parse the variable
-	if !p.expectPeek(token.IDENT) {
-		p.reportError("parseClassCommand: Expected variable, got %s", p.curToken.Literal)
-		return nil
-	}
-	variable := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	cmd.Arguments = append(cmd.Arguments, variable)
-
-	// parse the operator
-	if !p.expectPeek(token.EQ) {
-		p.reportError("parseClassCommand: Expected operator '==', got %s", p.curToken.Literal)
-		return nil
-	}
-	operator := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	cmd.Arguments = append(cmd.Arguments, operator)
-
-	// parse the value
-	if !p.expectPeek(token.IDENT) {
-		p.reportError("parseClassCommand: Expected value, got %s", p.curToken.Literal)
-		return nil
-	}
-	value := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	cmd.Arguments = append(cmd.Arguments, value)
-
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseClassCommand End - Subcommand: %s, Arguments: %v\n", cmd.Subcommand, cmd.Arguments)
-	}
-
-	return cmd
-}
-
 func (p *Parser) parseStringLiteralContents(s *ast.StringLiteral) ast.Expression {
 	if s == nil || s.Value == "" {
 		return nil
 	}
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseStringLiteralContents Start - Value: %s\n", s.Value)
 	}
 	return s
 }
 
 func (p *Parser) parseForEachStatement() ast.Statement {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseForEachStatement Start\n")
 	}
 	stmt := &ast.ForEachStatement{Token: p.curToken}
@@ -1894,7 +2270,7 @@ func (p *Parser) parseForEachStatement() ast.Statement {
 	}
 
 	stmt.Variable = p.curToken.Literal
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseForEachStatement Variable: %v\n", stmt.Variable)
 	}
 	p.declareVariable(stmt.Variable)
@@ -1908,7 +2284,7 @@ func (p *Parser) parseForEachStatement() ast.Statement {
 			for _, elem := range listLiteral.Elements {
 				if ident, ok := elem.(*ast.Identifier); ok {
 					if isValid, _ := p.isValidIRuleIdentifier(ident.Value, "header"); !isValid {
-						p.reportError("parseForEachStatement: Invalid header name in foreach loop: %s", ident.Value)
+						p.reportDiagnostic(diagnostic.InvalidIdentifier, "parseForEachStatement: Invalid header name in foreach loop: %s", ident.Value)
 					}
 				}
 			}
@@ -1918,7 +2294,7 @@ func (p *Parser) parseForEachStatement() ast.Statement {
 		stmt.List = p.parseExpression(LOWEST)
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseForEachStatement List: %+v\n", stmt.List)
 	}
 
@@ -1927,8 +2303,8 @@ func (p *Parser) parseForEachStatement() ast.Statement {
 		return nil
 	}
 
-	stmt.Body = p.parseBlockStatement()
-	if config.DebugMode {
+	stmt.Body = p.parseLoopBody()
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseForEachStatement Body: %+v\n", stmt.Body)
 		fmt.Printf("DEBUG: parseForEachStatement End, Final Statement: %+v\n", stmt)
 	}
@@ -1937,36 +2313,36 @@ func (p *Parser) parseForEachStatement() ast.Statement {
 }
 
 func (p *Parser) parseListLiteral() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseListLiteral Start. Current token: %s\n", p.curToken.Literal)
 	}
 	list := &ast.ListLiteral{Token: p.curToken}
 	list.Elements = []ast.Expression{}
 
 	p.nextToken() // move past '{'
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseListLiteral after opening brace. Current token: %s\n", p.curToken.Literal)
 	}
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseListLiteral parsing element. Current token: %s\n", p.curToken.Literal)
 		}
 		elem := p.parseExpression(LOWEST)
 		if elem != nil {
 			list.Elements = append(list.Elements, elem)
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral added element: %T\n", elem)
 			}
 		} else {
 			p.reportError("parseListLiteral: Failed to parse statement")
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral failed to parse element\n")
 			}
 		}
 
 		if p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral breaking loop. Peek token: %v\n", p.peekToken.Literal)
 			}
 			break
@@ -1974,7 +2350,7 @@ func (p *Parser) parseListLiteral() ast.Expression {
 
 		// if the peek token is empty, move to the next token
 		if p.peekToken.Literal == "" {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral encountered empty peek token, moving to next\n")
 			}
 			p.nextToken()
@@ -1983,12 +2359,12 @@ func (p *Parser) parseListLiteral() ast.Expression {
 
 		// if we're not at the end of the list, expect a comma or space
 		if !p.peekTokenIs(token.COMMA) && !p.peekTokenIs(token.SPACE) {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral unexpected token. Peek token: %s\n", p.peekToken.Literal)
 			}
 			p.nextToken()
 		} else {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: parseListLiteral consuming separator. Peek token: %s\n", p.peekToken.Literal)
 			}
 			p.nextToken() // consume the comma or space
@@ -1996,7 +2372,7 @@ func (p *Parser) parseListLiteral() ast.Expression {
 	}
 
 	if p.curTokenIs(token.EOF) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("WARNING: parseListLiteral reached EOF before finding closing brace\n")
 		}
 		p.reportError("parseListLiteral: Unexpected EOF, missing closing brace")
@@ -2008,14 +2384,18 @@ func (p *Parser) parseListLiteral() ast.Expression {
 		return list
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseListLiteral End. List elements: %d\n", len(list.Elements))
 	}
 	return list
 }
 
+// a variable name, optionally qualified by namespaces as in static::limit,
+// ::global or app::config::mode
+var variableNameRegex = regexp.MustCompile(`^(?:::)?(?:[\p{L}_][\p{L}0-9_]*::)*[\p{L}_][\p{L}0-9_]*$`)
+
 func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string) (bool, error) {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: isValidIRuleIdentifier - Start. Value=%v, Context=%v\n", value, identifierContext)
 	}
 
@@ -2024,7 +2404,7 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 		if identifierContext == "variable" {
 			return false, fmt.Errorf("ERROR: isValidIRuleIdentifier - '%s' is a reserved keyword and should not be used as a variable name", value)
 		}
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - Using reserved keyword '%s' in context '%s'\n", value, identifierContext)
 		}
 		return true, nil
@@ -2032,7 +2412,7 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 
 	// check if it's a variable (starts with $)
 	if strings.HasPrefix(value, "$") {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a variable\n", value)
 		}
 		return true, nil
@@ -2040,7 +2420,7 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 
 	// check if it's a common iRule identifier or command
 	if isCommonIRuleIdentifier(value) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a common iRule identifier or command\n", value)
 		}
 		return true, nil
@@ -2050,8 +2430,8 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 	switch identifierContext {
 	case "variable":
 		// stricter check for variable names
-		if regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`).MatchString(value) {
-			if config.DebugMode {
+		if variableNameRegex.MatchString(value) {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid variable identifier\n", value)
 			}
 			return true, nil
@@ -2060,8 +2440,8 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 
 	case "standalone", "class_match", "class_lookup", "pool_name", "event_name", "profile_name",
 		"vs_name", "node_name", "monitor_name", "ssl_profile", "table_name", "proc_name":
-		if regexp.MustCompile(`^[a-zA-Z0-9_-]+$`).MatchString(value) {
-			if config.DebugMode {
+		if regexp.MustCompile(`^[\p{L}0-9_-]+$`).MatchString(value) {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid identifier in context %s\n", value, identifierContext)
 			}
 			return true, nil
@@ -2071,21 +2451,26 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 		if identifierContext == "standalone" {
 			// allow single-letter identifiers and check against common headers (case-insensitive)
 			if len(value) == 1 && regexp.MustCompile(`^[a-zA-Z]$`).MatchString(value) {
-				if config.DebugMode {
+				if p.opts.DebugMode {
 					fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid single-letter identifier\n", value)
 				}
 				return true, nil
 			}
 			// check against common headers (case-insensitive)
 			for _, header := range commonHeaders {
-				if strings.EqualFold(value, header) {
-					if config.DebugMode {
+				if strings.EqualFold(value, header) || p.isCustomHeader(value) {
+					if p.opts.DebugMode {
 						fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid common header\n", value)
 					}
 					return true, nil
 				}
 			}
 
+			// global and static variables named as arguments, as in incr ::hits
+			if (strings.HasPrefix(value, "::") || strings.HasPrefix(value, "static::")) && variableNameRegex.MatchString(value) {
+				return true, nil
+			}
+
 			// check for command patterns (e.g., HTTP::*)
 			if strings.Contains(value, "::") {
 				parts := strings.Split(value, "::")
@@ -2093,7 +2478,7 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 					validPrefixes := []string{"HTTP", "TCP", "SSL", "LB"}
 					for _, prefix := range validPrefixes {
 						if strings.EqualFold(parts[0], prefix) {
-							if config.DebugMode {
+							if p.opts.DebugMode {
 								fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid command pattern\n", value)
 							}
 							return true, nil
@@ -2104,8 +2489,8 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 		}
 
 	case "header":
-		if isValidHeaderName(value) {
-			if config.DebugMode {
+		if p.isValidHeaderName(value) {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid HTTP header name\n", value)
 			}
 			return true, nil
@@ -2118,19 +2503,19 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 
 	// check if it's a valid command or keyword
 	if _, ok := lexer.HttpKeywords[value]; ok {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid HTTP keyword\n", value)
 		}
 		return true, nil
 	}
 	if _, ok := lexer.LbKeywords[value]; ok {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid LB keyword\n", value)
 		}
 		return true, nil
 	}
 	if _, ok := lexer.SSLKeywords[value]; ok {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid SSL keyword\n", value)
 		}
 		return true, nil
@@ -2143,7 +2528,7 @@ func (p *Parser) isValidIRuleIdentifier(value string, identifierContext string)
 
 	// check if it's a valid logging facility
 	if isValidLoggingFacility(value) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: isValidIRuleIdentifier - %s is a valid logging facility\n", value)
 		}
 		return true, nil
@@ -2201,8 +2586,11 @@ func isRegsubExpression(expr ast.Expression) bool {
 }
 
 func isIpAddressLiteral(expr ast.Expression) bool {
-	_, ok := expr.(*ast.IpAddressLiteral)
-	return ok
+	switch expr.(type) {
+	case *ast.IpAddressLiteral, *ast.CidrLiteral:
+		return true
+	}
+	return false
 }
 
 func isIdentifier(expr ast.Expression) bool {
@@ -2298,31 +2686,53 @@ func (p *Parser) isValidCustomIdentifier(s string) bool {
 	return false
 }
 
+// records a finding that doesn't fail validation, see reportDiagnostic
+func (p *Parser) reportWarning(code diagnostic.Code, format string, args ...any) {
+	p.reportSeverity(diagnostic.Warning, code, format, args...)
+}
+
+// records a finding of the given severity, see reportDiagnostic
+func (p *Parser) reportSeverity(severity diagnostic.Severity, code diagnostic.Code, format string, args ...any) {
+	p.reportDiagnostic(code, format, args...)
+	p.diagnostics[len(p.diagnostics)-1].Severity = severity
+}
+
+// attaches a fix to the finding reported last
+func (p *Parser) suggestFix(message string, edits ...diagnostic.TextEdit) {
+	p.diagnostics[len(p.diagnostics)-1].Fix = &diagnostic.SuggestedFix{Message: message, Edits: edits}
+}
+
 func (p *Parser) reportError(format string, args ...any) {
-	var line int
-	var msg string
+	p.reportDiagnostic(diagnostic.SyntaxError, format, args...)
+}
+
+// records a finding with the given code. if the last argument is a token it
+// is used as the position of the finding, if it is an int it is used as the
+// line number. otherwise the finding is placed at the last known position
+func (p *Parser) reportDiagnostic(code diagnostic.Code, format string, args ...any) {
+	line, column := p.lastKnownLine, p.lastKnownColumn
+	msg := format
 
 	if len(args) > 0 {
-		if lastArg, ok := args[len(args)-1].(int); ok {
-			// if the last argument is an int, use it as the line number
-			line = lastArg
-			msg = fmt.Sprintf(format, args[:len(args)-1]...)
-		} else {
-			// if the last argument is not an int, use all args for the message
-			line = p.lastKnownLine
-			msg = fmt.Sprintf(format, args...)
+		switch lastArg := args[len(args)-1].(type) {
+		case token.Token:
+			line, column = lastArg.Line, lastArg.Column
+			args = args[:len(args)-1]
+		case int:
+			line, column = lastArg, 0
+			args = args[:len(args)-1]
 		}
-	} else {
-		line = p.lastKnownLine
-		msg = format
+		msg = fmt.Sprintf(format, args...)
 	}
 
-	lineMsg := fmt.Sprintf("   %s, Line: %d", msg, line)
-	p.errors = append(p.errors, lineMsg)
+	p.diagnostics = append(p.diagnostics, diagnostic.Diagnostic{Code: code, Message: msg, Line: line, Column: column})
 }
 
+// parses node address ?port?. an IPv4 address may carry its port as in
+// 10.0.0.1:8080, and either kind of address a route domain as in 10.0.0.1%2.
+// an address or port spelled out in the rule is checked
 func (p *Parser) parseNodeStatement() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseNodeStatement Start - Current token: %s, Line: %d\n", p.curToken.Type, p.l.CurrentLine())
 	}
 
@@ -2332,66 +2742,54 @@ func (p *Parser) parseNodeStatement() ast.Expression {
 		Token: p.curToken,
 	}
 
-	// expect the next token to be an IP address
-	if !p.expectPeek(token.IP_ADDRESS) {
-		p.reportError("parseNodeStatement: expected IP_ADDRESS, got %v", p.curToken.Literal)
+	if p.peekIsWordEnd() {
+		p.reportError("parseNodeStatement: expected an address, got %v", p.peekToken.Literal)
 		return nil
 	}
-	nodeStmt.IPAddress = p.curToken.Literal
-
-	// expect the next token to be a port number, but don't require it
-	if p.peekTokenIs(token.NUMBER) {
-		p.nextToken()
-		nodeStmt.Port = p.curToken.Literal
-	}
-
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseNodeStatement End - IP: %s, Port: %s\n", nodeStmt.IPAddress, nodeStmt.Port)
-	}
-
-	return nodeStmt
-}
-
-func (p *Parser) parseLtmRule() ast.Statement {
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseLtmRule Start - Current token: %s, Line: %d\n", p.curToken.Type, p.l.CurrentLine())
-	}
-	stmt := &ast.LtmRule{Token: p.curToken}
-
-	if !p.expectPeek(token.RULE) {
-		p.reportError("parseLtmRule: expected RULE, got %v", p.curToken.Literal)
-		return nil
+	p.nextToken()
+	if p.isSubstitutedWord() {
+		nodeStmt.IPAddress = p.parseCommandArgument().String()
+	} else {
+		address := p.curToken
+		nodeStmt.IPAddress, nodeStmt.Port = splitNodeAddress(p.readAdjacentWord())
+		p.checkNodeAddress(nodeStmt.IPAddress, address)
+		if nodeStmt.Port != "" {
+			p.checkNodePort(nodeStmt.Port, address)
+		}
 	}
 
-	if !p.expectPeek(token.IDENT) {
-		p.reportError("parseLtmRule: expected IDENT, got %v", p.curToken.Literal)
-		return nil
+	// the port, unless it came with the address
+	if nodeStmt.Port == "" && !p.peekIsWordEnd() {
+		p.nextToken()
+		if p.isSubstitutedWord() {
+			nodeStmt.Port = p.parseCommandArgument().String()
+		} else {
+			port := p.curToken
+			nodeStmt.Port = p.readAdjacentWord()
+			p.checkNodePort(nodeStmt.Port, port)
+		}
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
-	if !p.expectPeek(token.LBRACE) {
-		p.reportError("parseLtmRule: expected LBRACE, got %v", p.curToken.Literal)
-		return nil
+	if extra := p.parseCommandArguments(); len(extra) > 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: node expects an address and an optional port, got %d extra argument(s)", []any{len(extra), nodeStmt.Token}...)
 	}
 
-	stmt.Body = p.parseBlockStatement()
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseLtmRule End - Current token: %s, Line: %d\n", p.curToken.Type, p.l.CurrentLine())
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseNodeStatement End - IP: %s, Port: %s\n", nodeStmt.IPAddress, nodeStmt.Port)
 	}
 
-	return stmt
+	return nodeStmt
 }
 
 func (p *Parser) parseSlashExpression() ast.Expression {
 	startToken := p.curToken // the opening '/' token
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseSlashExpression Start - Current token: %v\n", p.curToken)
 	}
 
 	// standalone '/' case
 	if !p.peekTokenIs(token.IDENT) {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSlashExpression End (Standalone Case) - '/' not followed by IDENT. Current token: %v\n", p.curToken)
 		}
 		return &ast.StringLiteral{Token: startToken, Value: startToken.Literal}
@@ -2409,7 +2807,7 @@ func (p *Parser) parseSlashExpression() ast.Expression {
 		closingSlashToken := p.peekToken
 		wordValue := startToken.Literal + identToken.Literal + closingSlashToken.Literal
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSlashExpression Detected '/IDENT/' pattern. Treating as single word: '%s'\n", wordValue)
 		}
 
@@ -2417,7 +2815,7 @@ func (p *Parser) parseSlashExpression() ast.Expression {
 
 		node := &ast.Identifier{Token: startToken, Value: wordValue} // Or WordLiteral
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSlashExpression End ('/IDENT/' Case) - Parsed: %s. Current token left on closing SLASH: %v\n", wordValue, p.curToken)
 		}
 		return node // correct state: curToken is on the last token ('/') of the unit.
@@ -2426,7 +2824,7 @@ func (p *Parser) parseSlashExpression() ast.Expression {
 		// CASE: /IDENT (not followed by '/')
 		wordValue := startToken.Literal + identToken.Literal
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSlashExpression Detected '/IDENT' pattern (no trailing slash). Treating as word: '%s'\n", wordValue)
 		}
 
@@ -2434,105 +2832,56 @@ func (p *Parser) parseSlashExpression() ast.Expression {
 		// this IS the correct state - leave curToken on the last token of the unit (the IDENT).
 		node := &ast.Identifier{Token: startToken, Value: wordValue}
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseSlashExpression End ('/IDENT' Case) - Parsed: %s. Current token left on IDENT: %v\n", wordValue, p.curToken)
 		}
 		return node // correct state: curToken is on the last token ('IDENT') of the unit.
 	}
 }
 
-func isValidGlobPattern(pattern string) bool {
+func (p *Parser) isValidGlobPattern(pattern string) bool {
 	result := len(pattern) > 0
-	if config.DebugMode {
-		fmt.Printf("DEBUG: isValidGlobPattern(%s) = %v\n", pattern, result)
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: p.isValidGlobPattern(%s) = %v\n", pattern, result)
 	}
 	return result
 }
 
-func isValidRegexPattern(pattern string) bool {
+func (p *Parser) isValidRegexPattern(pattern string) bool {
 	_, err := regexp.Compile(pattern)
 	result := err == nil
-	if config.DebugMode {
-		fmt.Printf("DEBUG: isValidRegexPattern(%s) = %v\n", pattern, result)
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: p.isValidRegexPattern(%s) = %v\n", pattern, result)
 	}
 	return result
 }
 
-func (p *Parser) parseStringCaseStatement() *ast.CaseStatement {
-	if config.DebugMode {
-		fmt.Printf("DEBUG: Start parseStringCaseStatement at line %d\n", p.currentLine)
-	}
-
-	caseStmt := &ast.CaseStatement{Token: p.curToken, Line: p.curToken.Line}
-
-	pattern := p.parseExpression(LOWEST)
-	startPattern, ok := pattern.(*ast.StringLiteral)
-	if !ok {
-		p.reportError("parseStringCaseStatement: Expected string literal for case pattern, got %T", pattern)
-		return nil
-	}
-
-	// check for range case
-	if p.peekTokenIs(token.MINUS) {
-		p.nextToken() // consume the '-'
-		p.nextToken() // move to the end range token
-		endPattern := p.parseExpression(LOWEST)
-		endStringLiteral, ok := endPattern.(*ast.StringLiteral)
-		if !ok {
-			p.reportError("parseStringCaseStatement: Expected string literal for range end, got %T", endPattern)
-			return nil
-		}
-
-		// create a MultiPattern for the range
-		caseStmt.Value = &ast.MultiPattern{
-			Patterns: []ast.Expression{
-				startPattern,
-				endStringLiteral,
-			},
-		}
-	} else {
-		caseStmt.Value = startPattern
-	}
-
-	if !p.expectPeek(token.LBRACE) {
-		p.reportError("parseStringCaseStatement: Expected '{' after case pattern")
-		return nil
-	}
-
-	caseStmt.Consequence = p.parseBlockStatement()
-
-	if config.DebugMode {
-		fmt.Printf("DEBUG: End parseStringCaseStatement, created case with pattern '%v' at line %d\n", caseStmt.Value, caseStmt.Line)
-	}
-
-	return caseStmt
-}
-
-func isGlobPattern(pattern string) bool {
+func (p *Parser) isGlobPattern(pattern string) bool {
 	result := strings.ContainsAny(pattern, "*?") && !strings.ContainsAny(pattern, "(){}|^$+\\")
-	if config.DebugMode {
-		fmt.Printf("DEBUG: isGlobPattern(%s) = %v\n", pattern, result)
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: p.isGlobPattern(%s) = %v\n", pattern, result)
 	}
 	return result
 }
 
-func isRegexPattern(pattern string) bool {
-	result := strings.ContainsAny(pattern, "^$+(){}|") || strings.Contains(pattern, ".*")
-	if config.DebugMode {
-		fmt.Printf("DEBUG: isRegexPattern(%s) = %v\n", pattern, result)
+func (p *Parser) isRegexPattern(pattern string) bool {
+	// .* is also how a glob matches the rest of an address, as in 10.0.*
+	result := strings.ContainsAny(pattern, "^$+(){}|") || strings.Contains(pattern, ".*") && !addressPatternRegex.MatchString(pattern)
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: p.isRegexPattern(%s) = %v\n", pattern, result)
 	}
 	return result
 }
 
 func (p *Parser) validateSwitchPatterns(switchStmt *ast.SwitchStatement) error {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: Start validateSwitchPatterns - isRegex: %v, isGlob: %v\n", switchStmt.IsRegex, switchStmt.IsGlob)
 	}
 	for i, caseStmt := range switchStmt.Cases {
 		var pattern []string
 		line := p.lastKnownLine
 
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: validateSwitchPatterns Case %d - Token: %+v, Line: %d\n", i, caseStmt.Token, line)
 		}
 
@@ -2542,7 +2891,7 @@ func (p *Parser) validateSwitchPatterns(switchStmt *ast.SwitchStatement) error {
 			if v.Token.Line > 0 {
 				line = v.Token.Line
 			}
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: StringLiteral pattern: %s, Token: %+v\n", pattern, v.Token)
 			}
 		case *ast.GlobPattern:
@@ -2550,7 +2899,7 @@ func (p *Parser) validateSwitchPatterns(switchStmt *ast.SwitchStatement) error {
 			if v.Token.Line > 0 {
 				line = v.Token.Line
 			}
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: GlobPattern pattern: %s, Token: %+v\n", pattern, v.Token)
 			}
 		case *ast.RegexPattern:
@@ -2558,7 +2907,7 @@ func (p *Parser) validateSwitchPatterns(switchStmt *ast.SwitchStatement) error {
 			if v.Token.Line > 0 {
 				line = v.Token.Line
 			}
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: GlobPattern pattern: %s, Token: %+v\n", pattern, v.Token)
 			}
 		case *ast.MultiPattern:
@@ -2577,35 +2926,35 @@ func (p *Parser) validateSwitchPatterns(switchStmt *ast.SwitchStatement) error {
 		}
 
 		for _, pattern := range pattern {
-			if config.DebugMode {
+			if p.opts.DebugMode {
 				fmt.Printf("DEBUG: Validating pattern: '%s' at line %d\n", pattern, line)
 			}
 
 			if switchStmt.IsRegex {
-				if isGlobPattern(pattern) {
-					p.reportError("Invalid regex pattern (looks like a glob pattern): %s", []any{pattern, line}...)
+				if p.isGlobPattern(pattern) {
+					p.reportDiagnostic(diagnostic.InvalidPattern, "Invalid regex pattern (looks like a glob pattern): %s", []any{pattern, line}...)
 				}
-				if !isValidRegexPattern(pattern) {
-					p.reportError("Invalid regex pattern: %s", []any{pattern, line}...)
+				if !p.isValidRegexPattern(pattern) {
+					p.reportDiagnostic(diagnostic.InvalidPattern, "Invalid regex pattern: %s", []any{pattern, line}...)
 				}
 			} else if switchStmt.IsGlob {
-				if isRegexPattern(pattern) {
-					p.reportError("Invalid glob pattern (looks like a regex pattern): %s", []any{pattern, line}...)
-				} else if !isValidGlobPattern(pattern) {
-					p.reportError("Invalid glob pattern: %s Line: %d", pattern, line)
+				if p.isRegexPattern(pattern) {
+					p.reportDiagnostic(diagnostic.InvalidPattern, "Invalid glob pattern (looks like a regex pattern): %s", []any{pattern, line}...)
+				} else if !p.isValidGlobPattern(pattern) {
+					p.reportDiagnostic(diagnostic.InvalidPattern, "Invalid glob pattern: %s Line: %d", pattern, line)
 				}
 			}
 		}
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Println("DEBUG: End validateSwitchPatterns - All patterns valid")
 	}
 	return nil
 }
 
 func (p *Parser) parseMatchesRegexExpression(left ast.Expression) ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseMatchesRegexExpression Start\n")
 	}
 
@@ -2622,8 +2971,8 @@ func (p *Parser) parseMatchesRegexExpression(left ast.Expression) ast.Expression
 
 	regexPattern := p.curToken.Literal
 
-	if !isValidRegexPattern(regexPattern) {
-		p.reportError("parseMatchesRegexExpression: Invalid regex pattern: %s", regexPattern)
+	if !p.isValidRegexPattern(regexPattern) {
+		p.reportDiagnostic(diagnostic.InvalidPattern, "parseMatchesRegexExpression: Invalid regex pattern: %s", regexPattern)
 		return nil
 	}
 
@@ -2632,7 +2981,7 @@ func (p *Parser) parseMatchesRegexExpression(left ast.Expression) ast.Expression
 		Value: p.curToken.Literal,
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseMatchesRegexExpression End, pattern: %s\n", expression.Right)
 	}
 
@@ -2650,147 +2999,23 @@ func (p *Parser) checkVariableUsage(arg ast.Expression, context string) {
 			// it's a variable reference, check if it's declared
 			varName := expr.Value[1:] // remove the $
 			if !p.declaredVariables[varName] {
-				p.reportError("checkVariableUsage: undeclared variable %s used in %s", expr.Value, context)
+				p.reportDiagnostic(diagnostic.UndeclaredVariable, "checkVariableUsage: undeclared variable %s used in %s", expr.Value, context)
 			}
 		} else {
 			// it's not a variable reference, but it should be
 			if p.declaredVariables[expr.Value] {
-				p.reportError("checkVariableUsage: %s should be referenced as $%s in %s", expr.Value, expr.Value, context)
+				p.reportDiagnostic(diagnostic.UndeclaredVariable, "checkVariableUsage: %s should be referenced as $%s in %s", expr.Value, expr.Value, context)
 			} else {
-				p.reportError("checkVariableUsage: expected variable reference in %s, got %s", context, expr.Value)
+				p.reportDiagnostic(diagnostic.UndeclaredVariable, "checkVariableUsage: expected variable reference in %s, got %s", context, expr.Value)
 			}
 		}
 	default:
-		p.reportError("checkVariableUsage: expected variable reference in %s", context)
+		p.reportDiagnostic(diagnostic.UndeclaredVariable, "checkVariableUsage: expected variable reference in %s", context)
 	}
 }
 
-func (p *Parser) parseRegsubCommand() ast.Expression {
-	// store the REGSUB token for the AST node before consuming it
-	regsubToken := p.curToken
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand Start. Current Token (REGSUB): '%v'\n", regsubToken)
-	}
-	regsubExpr := &ast.RegsubExpression{Token: regsubToken}
-	p.nextToken() // now p.curToken points to the token *after* 'regsub' (e.g., a flag or the pattern)
-
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand After consuming REGSUB. Next Token is now: %v\n", p.curToken)
-	}
-
-	// --- flag parsing ---
-	flagsEnded := false
-	for p.curTokenIs(token.MINUS) && !flagsEnded {
-		if config.DebugMode {
-			fmt.Printf("DEBUG: parseRegsubCommand Flag Loop Top: cur=%v, peek=%v\n", p.curToken, p.peekToken)
-		}
-
-		isDoubleDash := p.peekTokenIs(token.MINUS) || (p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "-")
-
-		if isDoubleDash {
-			if config.DebugMode {
-				fmt.Println("DEBUG: parseRegsubCommand Flag Loop: Detected '--'")
-			}
-			p.nextToken() // consume first '-'
-			p.nextToken() // consume second '-'
-			if config.DebugMode {
-				fmt.Printf("DEBUG: parseRegsubCommand Flag Loop: After consuming '--', curToken is now %v\n", p.curToken)
-			}
-			flagsEnded = true
-			break
-		} else if p.peekTokenIs(token.IDENT) { // check for '-flag'
-			nextToken := p.peekToken
-			if _, isValid := validRegsubFlags[nextToken.Literal]; isValid {
-				p.nextToken() // consume '-'
-				if config.DebugMode {
-					fmt.Printf("DEBUG: parseRegsubCommand Flag Loop: Parsing flag: %s\n", p.curToken.Literal)
-				}
-				// current token is now the flag name IDENT
-				regsubExpr.Flags = append(regsubExpr.Flags, p.curToken.Literal)
-				p.nextToken() // consume flag IDENT
-				if config.DebugMode {
-					fmt.Printf("DEBUG: parseRegsubCommand Flag Loop: After parsing flag, curToken is now %v\n", p.curToken)
-				}
-			} else {
-				// '-' followed by IDENT, but not a valid flag -> end of flags
-				if config.DebugMode {
-					fmt.Printf("DEBUG: parseRegsubCommand Flag Loop: '-' followed by non-flag IDENT '%s', ending flags.\n", nextToken.Literal)
-				}
-				flagsEnded = true
-				break
-			}
-		} else {
-			// '-' not followed by '--' or IDENT -> end of flags
-			if config.DebugMode {
-				fmt.Printf("DEBUG: parseRegsubCommand Flag Loop: '-' not followed by '-' or IDENT, ending flags.\n")
-			}
-			flagsEnded = true
-			break
-		}
-	}
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: Flags Parsed. Flags = %v. Next Token After Flags: %v\n", regsubExpr.Flags, p.curToken)
-	}
-
-	// parse pattern
-	regsubExpr.Pattern = p.parseCommandArgument()
-
-	if regsubExpr.Pattern == nil {
-		p.reportError("parseRegsubCommand: Failed parsing pattern; got token '%s' (%s) at Line %d", p.curToken.Literal, p.curToken.Type, p.curToken.Line)
-		return nil
-	}
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: Pattern Parsed. Pattern = '%s'. Next Token Before Advance: %v\n", regsubExpr.Pattern.String(), p.curToken)
-	}
-	p.nextToken() // advance past the parsed pattern expression
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: After Pattern Advance. Next Token: %v\n", p.curToken)
-	}
-
-	// parse input string
-	regsubExpr.InputString = p.parseCommandArgument()
-	if regsubExpr.InputString == nil {
-		p.reportError("parseRegsubCommand: Failed parsing input string; got token '%s' (%s) at Line %d", p.curToken.Literal, p.curToken.Type, p.curToken.Line)
-		return nil
-	}
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: InputString Parsed. InputString = '%s'. Next Token Before Advance: %v\n", regsubExpr.InputString.String(), p.curToken)
-	}
-	p.nextToken() // advance past the parsed input string expression
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: After InputString Advance. Next Token: %v\n", p.curToken)
-	}
-
-	// parse replacement
-	regsubExpr.Replacement = p.parseCommandArgument()
-	if regsubExpr.Replacement == nil {
-		p.reportError("parseRegsubCommand: Failed parsing replacement; got token '%s' (%s) at Line %d", p.curToken.Literal, p.curToken.Type, p.curToken.Line)
-		return nil
-	}
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: Replacement Parsed. Replacement = '%s'. Next Token Before Advance: %v\n", regsubExpr.Replacement.String(), p.curToken)
-	}
-	p.nextToken() // advance past the parsed replacement expression
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: After Replacement Advance. Next Token: %v\n", p.curToken)
-	}
-
-	// parse result variable
-	if !p.curTokenIs(token.IDENT) {
-		p.reportError("parseRegsubCommand: Expected result variable identifier, got token '%s' (%s) at Line %d", p.curToken.Literal, p.curToken.Type, p.curToken.Line)
-		return nil
-	}
-	regsubExpr.ResultVar = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	if config.DebugMode {
-		fmt.Printf("DEBUG: parseRegsubCommand: ResultVar Parsed. ResultVar = '%v'. Current Token (ResultVar IDENT): %v\n", regsubExpr.ResultVar.Value, p.curToken)
-		fmt.Printf("DEBUG: parseRegsubCommand End. Current Token is ']': '%v'\n", p.curToken)
-	}
-
-	return regsubExpr
-}
-
 func (p *Parser) parseComparisonExpression(left ast.Expression) ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseComparisonExpression Start - Left: %T, Current token: %s\n", left, p.curToken.Literal)
 	}
 
@@ -2814,7 +3039,7 @@ func (p *Parser) parseComparisonExpression(left ast.Expression) ast.Expression {
 	}
 	expression.Right = right
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseComparisonExpression End - Operator: %s, Left: %T, Right: %T\n", expression.Operator, expression.Left, expression.Right)
 	}
 
@@ -2827,9 +3052,11 @@ func isCommandInvocation(expr ast.Expression) bool {
 }
 
 // handles {...} as a single, verbatim string literal. it respects nested braces but does NOT parse internal Tcl list structure.
+// the value is the source text between the braces, where tcl only replaces a
+// backslash-newline and the whitespace after it with a space
 func (p *Parser) parseBracedStringLiteral() ast.Expression {
 	startToken := p.curToken
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBracedStringLiteral Start. Current token: %v\n", p.curToken)
 	}
 
@@ -2840,7 +3067,6 @@ func (p *Parser) parseBracedStringLiteral() ast.Expression {
 
 	p.nextToken() // consume the opening '{'
 
-	var literalValue string // buffer to build the string content
 	braceDepth := 1
 
 	for braceDepth > 0 {
@@ -2859,7 +3085,6 @@ func (p *Parser) parseBracedStringLiteral() ast.Expression {
 			}
 		}
 
-		literalValue += p.curToken.Literal
 		p.nextToken() // move to the next token within the braces
 	}
 
@@ -2867,8 +3092,9 @@ func (p *Parser) parseBracedStringLiteral() ast.Expression {
 	if !p.curTokenIs(token.RBRACE) {
 		p.reportError("Internal Error: parseBracedStringLiteral loop exited but not on RBRACE. Current: %v", p.curToken)
 	}
+	literalValue := bracedNewlineRegex.ReplaceAllString(p.l.Source(startToken.Offset+1, p.curToken.Offset), " ")
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseBracedStringLiteral End. Value: '%s'. Current Token (should be RBRACE): %v\n", literalValue, p.curToken)
 	}
 
@@ -2878,8 +3104,19 @@ func (p *Parser) parseBracedStringLiteral() ast.Expression {
 	}
 }
 
+// a backslash-newline in a braced word and the whitespace after it
+var bracedNewlineRegex = regexp.MustCompile(`\\\n[ \t]*`)
+
+// parses a braced word whose text is used as is, such as a log message or a
+// switch pattern. variables in it aren't substituted, so they aren't reads
+func (p *Parser) parseBracedWord() ast.Expression {
+	p.isParsingBracedWord = true
+	defer func() { p.isParsingBracedWord = false }()
+	return p.parseBracedStringLiteral()
+}
+
 func (p *Parser) parseCommandArgument() ast.Expression {
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseCommandArgument Start - Current token: %v\n", p.curToken)
 	}
 
@@ -2888,19 +3125,19 @@ func (p *Parser) parseCommandArgument() ast.Expression {
 
 	if prefix != nil {
 		// handles '[', '{', '$', quotes, numbers if registered correctly
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseCommandArgument Found prefix handler for token type %s\n", p.curToken.Type)
 		}
 		argument = prefix()
 	} else {
-		if config.DebugMode {
+		if p.opts.DebugMode {
 			fmt.Printf("DEBUG: parseCommandArgument No prefix handler for %s. Attempting to parse as unquoted word.\n", p.curToken.Type)
 		}
 
 		// Check if the current token *can* start a word
 		canStartWord := false
 		switch p.curToken.Type {
-		case token.IDENT, token.SLASH, token.MINUS, token.PLUS, token.ASTERISK:
+		case token.IDENT, token.SLASH, token.MINUS, token.PLUS, token.ASTERISK, token.PERCENT:
 			canStartWord = true
 		}
 
@@ -2920,7 +3157,7 @@ func (p *Parser) parseCommandArgument() ast.Expression {
 		return nil
 	}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseCommandArgument End - Current token: %v\n", p.curToken)
 	}
 
@@ -2930,15 +3167,42 @@ func (p *Parser) parseCommandArgument() ast.Expression {
 // parses an unquoted Tcl word which might span multiple tokens.
 func (p *Parser) parseWordLiteral() ast.Expression {
 	startToken := p.curToken
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWordLiteral Start - Current token: %v\n", p.curToken)
 	}
 
 	wordValue := p.curToken.Literal
+	// format strings such as %A or %Y-%m-%d lex as several tokens
+	if p.curTokenIs(token.PERCENT) {
+		for p.peekIsAdjacent() && !p.peekTokenIs(token.RBRACKET) && !p.peekIsCommandEnd() {
+			p.nextToken()
+			wordValue += p.curToken.Literal
+		}
+	}
 	node := &ast.Identifier{Token: startToken, Value: wordValue}
 
-	if config.DebugMode {
+	if p.opts.DebugMode {
 		fmt.Printf("DEBUG: parseWordLiteral End - Value: '%s'. Current token: %v\n", wordValue, p.curToken)
 	}
 	return node
 }
+
+// returns where a token starts
+func tokenStart(tok token.Token) diagnostic.Position {
+	return diagnostic.Position{Line: tok.Line, Column: tok.Column}
+}
+
+// returns where a token ends. only words are spelled in the input as they
+// are in their literal, strings lose their quotes
+func tokenEnd(tok token.Token) (diagnostic.Position, bool) {
+	if tok.Type == token.STRING || tok.Column == 0 {
+		return diagnostic.Position{}, false
+	}
+	return diagnostic.Position{Line: tok.Line, Column: tok.Column + utf8.RuneCountInString(tok.Literal)}, true
+}
+
+// returns an edit replacing a word token with text
+func replaceToken(tok token.Token, text string) diagnostic.TextEdit {
+	end, _ := tokenEnd(tok)
+	return diagnostic.TextEdit{Start: tokenStart(tok), End: end, NewText: text}
+}
@@ -3,8 +3,13 @@ package parser
 import (
 	"fmt"
 	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/token"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -22,6 +27,20 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	t.FailNow()
 }
 
+// checks the parser reported diagnostics with the codes given, in order
+func assertDiagnosticCodes(t *testing.T, p *Parser, want []diagnostic.Code) {
+	t.Helper()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %d: %v", len(want), len(diagnostics), diagnostics)
+	}
+	for i, code := range want {
+		if diagnostics[i].Code != code {
+			t.Errorf("diagnostics[%d] wrong code. expected=%s, got=%v", i, code, diagnostics[i])
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -42,6 +61,38 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+// the lexer hands over a quoted string without its quotes, so its first and
+// last characters are part of the value
+func TestStringLiteralValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a"`, "a"},
+		{`"/api/"`, "/api/"},
+		{`"x y z"`, "x y z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%s: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.StringLiteral)
+		if !ok {
+			t.Fatalf("%s: exp not *ast.StringLiteral. Got=%T", tt.input, stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("%s: literal.Value not %q. Got=%q", tt.input, tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestSetStatements(t *testing.T) {
 	tests := []struct {
 		input              string
@@ -143,6 +194,9 @@ func TestReturnStatements(t *testing.T) {
 	if !ok {
 		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. Got=%T", program.Statements[0])
 	}
+	if whenStmt.Token.Literal != "when" || whenStmt.Token.Line != 2 {
+		t.Errorf("statement token wrong. expected when on line 2, got %q on line %d", whenStmt.Token.Literal, whenStmt.Token.Line)
+	}
 
 	whenExp, ok := whenStmt.Expression.(*ast.WhenExpression)
 	if !ok {
@@ -254,6 +308,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"2 * (3 + 4) - 5 / 2",
 			"2 * (3 + 4) - 5 / 2",
 		},
+		{
+			"1 <= 3 ? 4 + 1 : 5",
+			"1 <= 3 ? 4 + 1 : 5",
+		},
 	}
 
 	for _, tt := range tests {
@@ -269,6 +327,45 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestConditionalExpression(t *testing.T) {
+	input := `when HTTP_REQUEST {
+		set a 7
+		if { $a > 5 ? $a < 9 : $a == 0 ? 1 : 0 } { log local0. "in range" }
+	}`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	var conditional *ast.ConditionalExpression
+	ast.Inspect(program, func(n ast.Node) bool {
+		if c, ok := n.(*ast.ConditionalExpression); ok && conditional == nil {
+			conditional = c
+		}
+		return true
+	})
+	if conditional == nil {
+		t.Fatalf("program does not contain a ConditionalExpression: %s", program.String())
+	}
+	if conditional.Condition.String() != "$a > 5" || conditional.Consequence.String() != "$a < 9" {
+		t.Errorf("conditional wrong. got=%q", conditional.String())
+	}
+	// ?: groups to the right
+	alternative, ok := conditional.Alternative.(*ast.ConditionalExpression)
+	if !ok {
+		t.Fatalf("alternative is not a ConditionalExpression. got=%T", conditional.Alternative)
+	}
+	if alternative.String() != "$a == 0 ? 1 : 0" {
+		t.Errorf("alternative wrong. got=%q", alternative.String())
+	}
+
+	p = New(lexer.New(`when HTTP_REQUEST { if { 1 ? 2 } { log local0. "x" } }`))
+	p.ParseProgram()
+	if len(p.Diagnostics()) == 0 || p.Diagnostics()[0].Code != diagnostic.SyntaxError {
+		t.Errorf("expected a syntax error for a missing ':', got %v", p.Diagnostics())
+	}
+}
+
 func testNumberLiteral(t *testing.T, nl ast.Expression, value int64) bool {
 	num, ok := nl.(*ast.NumberLiteral)
 	if !ok {
@@ -554,7 +651,7 @@ func TestF5IRuleConstructs(t *testing.T) {
 		},
 		{
 			input:              "HTTP::respond 200 content \"Hello, World!\"",
-			expectedStatements: 4,
+			expectedStatements: 1,
 			checkFunc:          checkHttpRespond,
 		},
 		{
@@ -636,13 +733,16 @@ func checkHttpRespond(t *testing.T, stmt ast.Statement) {
 		t.Fatalf("stmt not *ast.ExpressionStatement. got=%T", stmt)
 	}
 
-	expr, ok := exprStmt.Expression.(*ast.HttpExpression)
+	expr, ok := exprStmt.Expression.(*ast.HttpRespondExpression)
 	if !ok {
-		t.Fatalf("stmt.Expression not *ast.HttpExpression. got=%T", exprStmt.Expression)
+		t.Fatalf("stmt.Expression not *ast.HttpRespondExpression. got=%T", exprStmt.Expression)
 	}
 
-	if expr.Command.String() != "HTTP::respond" {
-		t.Errorf("callExpr.Function not 'HTTP::respond'. got=%q", expr.Token.Literal)
+	if expr.Token.Literal != "HTTP::respond" {
+		t.Errorf("expr.Token not 'HTTP::respond'. got=%q", expr.Token.Literal)
+	}
+	if expr.Status.String() != "200" || expr.Content == nil {
+		t.Errorf("expected status 200 with content. got=%s", expr.String())
 	}
 }
 
@@ -826,6 +926,269 @@ func TestSwitchStatementPatternValidation(t *testing.T) {
 	}
 }
 
+func TestSwitchOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Exact match ignoring case",
+			input: `when HTTP_REQUEST {
+				switch -exact -nocase -- [HTTP::host] {
+					"example.com" { pool web }
+					default { pool other }
+				}
+			}`,
+		},
+		{
+			name: "Match variable with regexp",
+			input: `when HTTP_REQUEST {
+				switch -regexp -matchvar parts -- [HTTP::uri] {
+					"^/users/(\d+)$" { log local0. "user [lindex $parts 1]" }
+				}
+			}`,
+		},
+		{
+			name: "Unknown option",
+			input: `when HTTP_REQUEST {
+				switch -all [HTTP::uri] { "/" { pool web } }
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Conflicting match modes",
+			input: `when HTTP_REQUEST {
+				switch -glob -exact [HTTP::uri] { "/" { pool web } }
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Index variable without regexp",
+			input: `when HTTP_REQUEST {
+				switch -glob -indexvar idx [HTTP::uri] { "/*" { pool web } }
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Last pattern without body",
+			input: `when HTTP_REQUEST {
+				switch [HTTP::uri] {
+					"/a" { pool web }
+					"/b" -
+				}
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name: "Address patterns on an address",
+			input: `when HTTP_REQUEST {
+				switch -glob [IP::client_addr] {
+					"10.0.*" { pool internal }
+					"192.168.1.[0-9]" { pool lab }
+				}
+			}`,
+		},
+		{
+			name: "Path pattern on an address",
+			input: `when HTTP_REQUEST {
+				switch -glob [IP::client_addr] {
+					"10.0.*" { pool internal }
+					"/api/*" { pool api }
+				}
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidPattern},
+		},
+		{
+			name: "Address pattern on a path",
+			input: `when HTTP_REQUEST {
+				switch -glob [string tolower [HTTP::path]] {
+					"/api*" { pool api }
+					"10.1.2.*" { pool internal }
+				}
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidPattern},
+		},
+		{
+			name: "Path pattern on a host",
+			input: `when HTTP_REQUEST {
+				switch -glob [HTTP::host] {
+					"*.example.com" { pool web }
+					"/static/*" { pool static }
+				}
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidPattern},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestSwitchFallThrough(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  switch -glob [HTTP::uri] {
+    "/api*" -
+    /v1/* - /v2/* {
+      pool api
+    }
+    /static/* { pool static }
+    "/old*" -
+    default { pool web }
+  }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", p.Diagnostics())
+	}
+
+	when := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	switchStmt, ok := when.Block.Statements[0].(*ast.SwitchStatement)
+	if !ok {
+		t.Fatalf("When block does not contain a SwitchStatement. got=%T", when.Block.Statements[0])
+	}
+
+	expected := []struct {
+		pattern     string
+		fallThrough bool
+		body        string
+	}{
+		{"/api*", true, "pool(api)"},
+		{"/v1/*", true, "pool(api)"},
+		{"/v2/*", false, "pool(api)"},
+		{"/static/*", false, "pool(static)"},
+		{"/old*", true, "pool(web)"},
+	}
+	if len(switchStmt.Cases) != len(expected) {
+		t.Fatalf("expected %d cases, got %d", len(expected), len(switchStmt.Cases))
+	}
+	for i, want := range expected {
+		c := switchStmt.Cases[i]
+		if pattern, _ := literalWord(c.Value); pattern != want.pattern {
+			t.Errorf("case %d: expected pattern %q, got %q", i, want.pattern, pattern)
+		}
+		if c.FallThrough != want.fallThrough {
+			t.Errorf("case %d: expected FallThrough=%v, got %v", i, want.fallThrough, c.FallThrough)
+		}
+		if body := switchStmt.Body(i); body == nil || !strings.Contains(body.String(), want.body) {
+			t.Errorf("case %d: expected body containing %q, got %v", i, want.body, body)
+		}
+	}
+}
+
+func TestSwitchErrorRecovery(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  switch -glob [HTTP::uri] {
+    "/a" pool a
+    "/b" { pool b }
+    - { pool c }
+    "^/d.*" { pool d }
+    default { pool e }
+  }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	// every malformed case is reported and the cases after them still validated
+	lines := map[int]bool{}
+	for _, d := range p.Diagnostics() {
+		lines[d.Line] = true
+	}
+	for _, line := range []int{3, 5, 6} {
+		if !lines[line] {
+			t.Errorf("expected a diagnostic on line %d, got %v", line, p.Diagnostics())
+		}
+	}
+
+	when := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	switchStmt, ok := when.Block.Statements[0].(*ast.SwitchStatement)
+	if !ok {
+		t.Fatalf("When block does not contain a SwitchStatement. got=%T", when.Block.Statements[0])
+	}
+	if len(switchStmt.Cases) != 2 {
+		t.Errorf("expected the 2 well formed cases, got %d", len(switchStmt.Cases))
+	}
+	if switchStmt.Default == nil {
+		t.Errorf("switchStmt.Default is nil")
+	}
+}
+
+func TestNestedSwitch(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  switch -glob [HTTP::host] {
+    "api*" {
+      # comments are fine inside a case body
+      switch -glob [HTTP::uri] {
+        "/v1/*" {
+          switch [HTTP::method] {
+            GET { pool v1_read }
+            default { pool v1_write }
+          }
+        }
+        "/v2*" - "/v3*" { pool v2 }
+        default { pool api }
+      }
+      pool api
+    }
+    # but not between the patterns of the outer switch
+    "www*" { pool www }
+    default { pool web }
+  }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Code != diagnostic.CommentInSwitch || diagnostics[0].Line != 17 {
+		t.Fatalf("expected a single CommentInSwitch diagnostic on line 17, got %v", diagnostics)
+	}
+
+	when := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	outer, ok := when.Block.Statements[0].(*ast.SwitchStatement)
+	if !ok {
+		t.Fatalf("When block does not contain a SwitchStatement. got=%T", when.Block.Statements[0])
+	}
+	if len(outer.Cases) != 2 || outer.Default == nil {
+		t.Fatalf("expected 2 cases and a default in the outer switch, got %d cases", len(outer.Cases))
+	}
+	if len(when.Block.Statements) != 1 {
+		t.Fatalf("expected the outer switch to be the only statement, got %d", len(when.Block.Statements))
+	}
+
+	middle, ok := outer.Cases[0].Consequence.Statements[0].(*ast.SwitchStatement)
+	if !ok {
+		t.Fatalf("first outer case does not start with a SwitchStatement. got=%T", outer.Cases[0].Consequence.Statements[0])
+	}
+	if len(outer.Cases[0].Consequence.Statements) != 2 {
+		t.Errorf("expected the nested switch and pool in the first outer case, got %d statements", len(outer.Cases[0].Consequence.Statements))
+	}
+	if len(middle.Cases) != 3 || middle.Default == nil {
+		t.Fatalf("expected 3 cases and a default in the nested switch, got %d cases", len(middle.Cases))
+	}
+
+	inner, ok := middle.Cases[0].Consequence.Statements[0].(*ast.SwitchStatement)
+	if !ok {
+		t.Fatalf("first nested case does not contain a SwitchStatement. got=%T", middle.Cases[0].Consequence.Statements[0])
+	}
+	if len(inner.Cases) != 1 || inner.Default == nil {
+		t.Errorf("expected 1 case and a default in the innermost switch, got %d cases", len(inner.Cases))
+	}
+}
+
 func TestMatchesRegexExpression(t *testing.T) {
 	input := `
 when HTTP_REQUEST {
@@ -975,3 +1338,3751 @@ func TestRegsubVariations(t *testing.T) {
 		})
 	}
 }
+
+func TestArrays(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		elements []string
+		expected []string
+	}{
+		{
+			name:     "Element with a literal key",
+			input:    "set counts(total) 0\nset x $counts(total)",
+			elements: []string{"counts(total)", "$counts(total)"},
+		},
+		{
+			name:     "Key with an embedded variable",
+			input:    "set client [IP::client_addr]\nset seen($client) 1\nlog local0. \"seen $seen($client)\"",
+			elements: []string{"seen($client)", "$seen($client)"},
+		},
+		{
+			name:     "Undeclared variable in a key",
+			input:    "set seen($clinet) 1",
+			elements: []string{"seen($clinet)"},
+			expected: []string{"variable clinet is read in HTTP_REQUEST but never set"},
+		},
+		{
+			name:  "Array commands",
+			input: "array set hdrs {a 1 b 2}\nforeach n [array names hdrs] { log local0. \"$n [array size hdrs]\" }\narray unset hdrs a*",
+		},
+		{
+			name:     "Unknown array subcommand",
+			input:    "array keys hdrs",
+			expected: []string{"invalid subcommand 'keys' for array, expected one of anymore, donesearch, exists, get, names, nextelement, set, size, startsearch, statistics, unset"},
+		},
+		{
+			name:     "Array set without a list",
+			input:    "array set hdrs",
+			expected: []string{"wrong # args: array set expects 2 argument(s), got 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New("when HTTP_REQUEST {\n" + tt.input + "\n}"))
+			program := p.ParseProgram()
+
+			var messages []string
+			for _, d := range p.Diagnostics() {
+				messages = append(messages, d.Message)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("wrong diagnostics. expected=%q, got=%q", tt.expected, messages)
+			}
+
+			var elements []string
+			ast.Inspect(program, func(node ast.Node) bool {
+				if access, ok := node.(*ast.ArrayAccess); ok {
+					elements = append(elements, access.String())
+				}
+				return true
+			})
+			if !reflect.DeepEqual(elements, tt.elements) {
+				t.Errorf("wrong array elements. expected=%q, got=%q", tt.elements, elements)
+			}
+		})
+	}
+}
+
+func TestBracedWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Log message",
+			input:    `log local0. {no $substitution [here]}`,
+			expected: "no $substitution [here]",
+		},
+		{
+			name:     "Whitespace is kept",
+			input:    `set msg {a  b {c d}}`,
+			expected: "a  b {c d}",
+		},
+		{
+			name:     "Escaped braces stay escaped",
+			input:    `set msg {open \{ close}`,
+			expected: `open \{ close`,
+		},
+		{
+			name:     "Backslash-newline",
+			input:    "set msg {one \\\n    two}",
+			expected: "one  two",
+		},
+		{
+			name:     "Switch pattern",
+			input:    "switch -glob [HTTP::uri] {\n {/api/*} { pool api }\n}",
+			expected: "/api/*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New("when HTTP_REQUEST {\n " + tt.input + "\n}"))
+			program := p.ParseProgram()
+			if len(p.Diagnostics()) != 0 {
+				t.Fatalf("unexpected diagnostics: %v", p.Diagnostics())
+			}
+
+			var value *string
+			ast.Inspect(program, func(node ast.Node) bool {
+				if lit, ok := node.(*ast.StringLiteral); ok && lit.Token.Type == token.LBRACE && value == nil {
+					value = &lit.Value
+				}
+				return true
+			})
+			if value == nil {
+				t.Fatalf("no braced word in %s", program.String())
+			}
+			if *value != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, *value)
+			}
+		})
+	}
+}
+
+func TestRegexpCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expected  []string
+		matchVars []string
+	}{
+		{
+			name:      "Captures",
+			input:     `set n [regexp -nocase {^/api/(v[0-9]+)/(\w+)} [HTTP::uri] -> version resource]`,
+			matchVars: []string{"->", "version", "resource"},
+		},
+		{
+			name:      "Escapes in a braced pattern",
+			input:     `regexp -start 3 {(\d+)\.html$} [HTTP::uri] -> page`,
+			matchVars: []string{"->", "page"},
+		},
+		{
+			name:  "Without match variables",
+			input: `if { [regexp {^/x} [HTTP::uri]] } { pool web }`,
+		},
+		{
+			name:      "More variables than capture groups",
+			input:     `regexp {^/api/([a-z]+)} [HTTP::uri] all one two`,
+			expected:  []string{"regexp sets 3 match variables but the pattern has only 1 capture group(s)"},
+			matchVars: []string{"all", "one", "two"},
+		},
+		{
+			name:      "Match variables with -inline",
+			input:     `set l [regexp -inline {^/a} [HTTP::uri] x]`,
+			expected:  []string{"regexp match variables not allowed when using -inline"},
+			matchVars: []string{"x"},
+		},
+		{
+			name:     "Unbalanced parenthesis",
+			input:    `regsub {a(b} [HTTP::uri] b result`,
+			expected: []string{"couldn't compile regular expression pattern 'a(b' of regsub: missing closing )"},
+		},
+		{
+			name:     "Unknown switch",
+			input:    `regsub -bogus {a} [HTTP::uri] b result`,
+			expected: []string{"invalid switch '-bogus' for regsub, expected one of -all, -expanded, -line, -lineanchor, -linestop, -nocase, -start"},
+		},
+		{
+			name:     "Regsub without a result variable",
+			input:    `regsub {a} [HTTP::uri] b`,
+			expected: []string{`wrong # args: should be "regsub ?switches? exp string subSpec varName"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New("when HTTP_REQUEST {\n " + tt.input + "\n}"))
+			program := p.ParseProgram()
+
+			var messages []string
+			for _, d := range p.Diagnostics() {
+				messages = append(messages, d.Message)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("wrong diagnostics. expected=%q, got=%q", tt.expected, messages)
+			}
+
+			var matchVars []string
+			ast.Inspect(program, func(n ast.Node) bool {
+				if re, ok := n.(*ast.RegexpExpression); ok {
+					for _, v := range re.MatchVars {
+						matchVars = append(matchVars, v.Value)
+					}
+				}
+				return true
+			})
+			if !reflect.DeepEqual(matchVars, tt.matchVars) {
+				t.Errorf("wrong match variables. expected=%q, got=%q", tt.matchVars, matchVars)
+			}
+			for _, name := range tt.matchVars {
+				if !p.declaredVariables[name] {
+					t.Errorf("match variable %s is not declared", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCatchExpression(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedResult string
+		expectedCodes  []diagnostic.Code
+	}{
+		{
+			name: "Result variable read in the handler",
+			input: `when HTTP_REQUEST {
+				if { [catch { set target [HTTP::header value X-Target] } err] } {
+					log local0. "lookup failed: $err"
+				}
+			}`,
+			expectedResult: "err",
+		},
+		{
+			name:  "Without a result variable",
+			input: `when HTTP_REQUEST { catch { HTTP::respond 200 content "ok" } }`,
+		},
+		{
+			name: "Result and options variables",
+			input: `when HTTP_REQUEST {
+				set rc [catch {HTTP::header remove X-Debug} result options]
+				log local0. "$rc $result $options"
+			}`,
+			expectedResult: "result",
+		},
+		{
+			name:          "Missing script",
+			input:         `when HTTP_REQUEST { catch }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:           "Too many arguments",
+			input:          `when HTTP_REQUEST { catch { log local0. hi } result options extra }`,
+			expectedResult: "result",
+			expectedCodes:  []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+
+			var catch *ast.CatchExpression
+			ast.Inspect(program, func(node ast.Node) bool {
+				if c, ok := node.(*ast.CatchExpression); ok && catch == nil {
+					catch = c
+				}
+				return true
+			})
+			if catch == nil {
+				t.Fatalf("no catch expression in %s", program.String())
+			}
+			result := ""
+			if catch.ResultVar != nil {
+				result = catch.ResultVar.Value
+			}
+			if result != tt.expectedResult {
+				t.Errorf("result variable wrong. expected=%q, got=%q", tt.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "While and for loops",
+			input: `when HTTP_REQUEST {
+				set i 0
+				while { $i < 3 } {
+					incr i
+					if { $i == 2 } { continue }
+				}
+				for { set j 0 } { $j < 5 } { incr j } {
+					switch $j {
+						"4" { break }
+					}
+				}
+			}`,
+		},
+		{
+			name: "Break in a foreach",
+			input: `when HTTP_REQUEST {
+				foreach name [HTTP::header names] {
+					if { $name eq "Host" } { break }
+				}
+			}`,
+		},
+		{
+			name:          "Break outside of a loop",
+			input:         `when HTTP_REQUEST { if { [HTTP::uri] eq "/" } { break } }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Continue in a proc called from a loop",
+			input: `proc skip {} { continue }
+			when HTTP_REQUEST {
+				while { 1 } { call skip }
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Unbraced condition",
+			input: `when HTTP_REQUEST {
+				set i 3
+				while $i { incr i -1 }
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.UnbracedExpression},
+		},
+		{
+			name:          "Empty condition",
+			input:         `when HTTP_REQUEST { for { set i 0 } {} { incr i } { log local0. $i } }`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name:  "Break and continue in switch bodies",
+			input: `when HTTP_REQUEST { switch [HTTP::host] { "a" { break } default { continue } } }`,
+		},
+		{
+			name:          "Break after a switch",
+			input:         `when HTTP_REQUEST { switch [HTTP::host] { "a" { pool a } } ; break }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+
+	program := New(lexer.New(`when HTTP_REQUEST { for { set i 0 } { $i < 2 } { incr i } { log local0. $i } }`)).ParseProgram()
+	when := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	loop, ok := when.Block.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("When block does not contain a ForStatement. got=%T", when.Block.Statements[0])
+	}
+	if loop.Init == nil || loop.Condition == nil || loop.Next == nil || loop.Body == nil {
+		t.Errorf("ForStatement is missing a part: %+v", loop)
+	}
+}
+
+func TestExprCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expected      string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:     "Modulo",
+			input:    `set x [expr {$a % 5}]`,
+			expected: "expr {$a % 5}",
+		},
+		{
+			name:     "Power shifts and bitwise operators",
+			input:    `set x [expr {$a ** 2 + ($b << 1) - ($a >> 1) | $a & ~$b ^ 1}]`,
+			expected: "expr {$a ** 2 + ($b << 1) - ($a >> 1) | $a & ~$b ^ 1}",
+		},
+		{
+			name:     "Math functions",
+			input:    `set x [expr {min($a, $b) + max($a, $b, 3) + round(1.5) + floor(2.5) + rand()}]`,
+			expected: "expr {min($a, $b) + max($a, $b, 3) + round(1.5) + floor(2.5) + rand()}",
+		},
+		{
+			name:     "Ternary",
+			input:    `set x [expr {$a > 5 ? "big" : $a > 2 ? "medium" : "small"}]`,
+			expected: `expr {$a > 5 ? "big" : $a > 2 ? "medium" : "small"}`,
+		},
+		{
+			name:     "Word operators and nested commands",
+			input:    `set x [expr {[HTTP::uri] starts_with "/api" && $a <= 3 || $a ne "x"}]`,
+			expected: `expr {[[HTTP::uri]] starts_with "/api" && $a <= 3 || $a ne "x"}`,
+		},
+		{
+			name:          "Missing operand",
+			input:         `set x [expr {$a +}]`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name:          "Missing operator",
+			input:         `set x [expr {$a $b}]`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name:          "Unquoted string",
+			input:         `set x [expr {$a eq foo}]`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name:          "Unknown math function and wrong argument count",
+			input:         `set x [expr {sqroot($a) + pow($a)}]`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand, diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unbraced expression",
+			input:         `set x [expr $a + 1]`,
+			expected:      "expr $a + 1",
+			expectedCodes: []diagnostic.Code{diagnostic.UnbracedExpression},
+		},
+		{
+			name:          "Unbraced command substitution",
+			input:         `set x [expr [string length $a] * 2]`,
+			expectedCodes: []diagnostic.Code{diagnostic.UnbracedExpression},
+		},
+		{
+			name:     "Unbraced constant expression",
+			input:    `set x [expr 1/0]`,
+			expected: "expr 1 / 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "when HTTP_REQUEST {\n set a 7\n set b 3\n " + tt.input + "\n}"
+			p := New(lexer.New(input))
+			program := p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+
+			if tt.expected == "" {
+				return
+			}
+			var expr *ast.ExprExpression
+			ast.Inspect(program, func(n ast.Node) bool {
+				if e, ok := n.(*ast.ExprExpression); ok {
+					expr = e
+				}
+				return expr == nil
+			})
+			if expr == nil {
+				t.Fatalf("program does not contain an ExprExpression: %s", program.String())
+			}
+			if expr.String() != tt.expected {
+				t.Errorf("expr wrong. expected=%q, got=%q", tt.expected, expr.String())
+			}
+		})
+	}
+}
+
+func TestStringSignatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"Range", `set x [string range $s 0 end-1]`, nil},
+		{"Range missing last", `set x [string range $s 0]`, []string{`wrong # args: should be "string range string first last"`}},
+		{"Tolower with indices", `set x [string tolower $s 0 3]`, nil},
+		{"Tolower without string", `set x [string tolower]`, []string{`wrong # args: should be "string tolower string ?first? ?last?"`}},
+		{"Map with option", `set x [string map -nocase {"/api" "/"} $s]`, nil},
+		{"Map without string", `set x [string map {"/api" "/"}]`, []string{`wrong # args: should be "string map ?-nocase? charMap string"`}},
+		{"Match with option", `set x [string match -nocase "/api*" $s]`, nil},
+		{"Match with unknown option", `set x [string match -exact "/api*" $s]`, []string{"invalid option '-exact' for string match, expected one of -nocase"}},
+		{"Compare with length", `set x [string compare -length 3 $s $s]`, nil},
+		{"Length option without value", `set x [string equal -length]`, []string{`wrong # args: should be "string equal ?-nocase? ?-length int? string1 string2"`}},
+		{"Is with options after the class", `set x [string is integer -strict $s]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New("when HTTP_REQUEST {\n set s abc\n " + tt.input + "\n}"))
+			p.ParseProgram()
+
+			var messages []string
+			for _, d := range p.Diagnostics() {
+				messages = append(messages, d.Message)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("wrong diagnostics. expected=%q, got=%q", tt.expected, messages)
+			}
+		})
+	}
+}
+
+func TestTclBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"Format", `set x [format "%d items for %-10s %5.2f%%" $n [HTTP::host] $n]`, nil},
+		{"Format missing argument", `set x [format "%d items for %s" $n]`, []string{"not enough arguments for all format specifiers, expected 2, got 1"}},
+		{"Format star width", `set x [format "%*d" 5 $n]`, nil},
+		{"Format bad specifier", `set x [format "%q" $n]`, []string{`bad field specifier "q"`}},
+		{"Format literal not a number", `set x [format "%d" abc]`, []string{`expected integer but got "abc" for %d`}},
+		{"Format unused argument", `set x [format "%s" $n $n]`, []string{`format string "%s" uses 1 of its 2 arguments`}},
+		{"Scan into variables", `scan $s "%d.%d" major minor`, nil},
+		{"Scan as list", `set x [scan $s "%d.%d"]`, nil},
+		{"Scan suppressed conversion", `scan $s "%d %*s %d" a b`, nil},
+		{"Scan variable count", `scan $s "%d.%d" major`, []string{"different numbers of variable names and field specifiers, expected 2, got 1"}},
+		{"Clock", `set x [clock format [clock seconds] -format "%Y-%m-%d %H:%M:%S" -gmt 1]`, nil},
+		{"Clock unquoted format", `set x [clock format [clock seconds] -format %A]`, nil},
+		{"Clock clicks", `set x [clock clicks -milliseconds]`, nil},
+		{"Clock unknown subcommand", `set x [clock now]`, []string{"invalid subcommand 'now' for clock, expected one of clicks, format, microseconds, milliseconds, scan, seconds"}},
+		{"Clock unknown option", `set x [clock format $n -fmt "%Y"]`, []string{"invalid option '-fmt' for clock format, expected one of -format, -gmt"}},
+		{"Clock unknown conversion", `set x [clock format $n -format "%K"]`, []string{`unknown clock format conversion "%K"`}},
+		{"Clock gmt boolean", `set x [clock format [clock seconds] -format "%H" -gmt yes]`, nil},
+		{"Clock gmt not a boolean", `set x [clock format [clock seconds] -gmt utc]`, []string{`expected boolean value but got "utc" for clock format -gmt`}},
+		{"Clock value not a number", `set x [clock format now -format "%H"]`, []string{`expected integer but got "now" as a clock value`}},
+		{"Clock scan base", `set x [clock scan "09:00" -base [clock seconds] -gmt 0]`, nil},
+		{"After", `after 100`, nil},
+		{"After with script", `set id [after 1000 { log local0. "later" }]`, nil},
+		{"After cancel", `after cancel $n`, nil},
+		{"After without delay", `after soon`, []string{"after expects a number of milliseconds, cancel or info, got 'soon'"}},
+		{"After periodic without script", `after 10 -periodic`, []string{"after -periodic expects a script to run"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New("when HTTP_REQUEST {\n set n 3\n set s [HTTP::host]\n " + tt.input + "\n}"))
+			p.ParseProgram()
+
+			var messages []string
+			for _, d := range p.Diagnostics() {
+				messages = append(messages, d.Message)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("wrong diagnostics. expected=%q, got=%q", tt.expected, messages)
+			}
+		})
+	}
+}
+
+func TestSuggestedFixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Unbraced expr",
+			input:    "when HTTP_REQUEST {\n set i 1\n set x [expr $i + 1]\n log local0. $x\n}",
+			expected: "when HTTP_REQUEST {\n set i 1\n set x [expr {$i + 1}]\n log local0. $x\n}",
+		},
+		{
+			name:     "Unbraced loop condition",
+			input:    "when HTTP_REQUEST {\n set i 3\n while $i { incr i -1 }\n}",
+			expected: "when HTTP_REQUEST {\n set i 3\n while {$i} { incr i -1 }\n}",
+		},
+		{
+			name:     "Puts in an event",
+			input:    "when HTTP_REQUEST {\n puts \"request\"\n}",
+			expected: "when HTTP_REQUEST {\n log local0. \"request\"\n}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+
+			fixes := []*diagnostic.SuggestedFix{}
+			for _, d := range p.Diagnostics() {
+				if d.Fix != nil {
+					fixes = append(fixes, d.Fix)
+				}
+			}
+			if len(fixes) != 1 {
+				t.Fatalf("Expected 1 fix, got %d: %v", len(fixes), p.Diagnostics())
+			}
+
+			lines := strings.Split(tt.input, "\n")
+			// later edits first so the earlier positions stay valid
+			for i := len(fixes[0].Edits) - 1; i >= 0; i-- {
+				edit := fixes[0].Edits[i]
+				if edit.Start.Line != edit.End.Line {
+					t.Fatalf("edit spans lines: %+v", edit)
+				}
+				line := []rune(lines[edit.Start.Line-1])
+				line = append(line[:edit.Start.Column-1], append([]rune(edit.NewText), line[edit.End.Column-1:]...)...)
+				lines[edit.Start.Line-1] = string(line)
+			}
+			if got := strings.Join(lines, "\n"); got != tt.expected {
+				t.Errorf("fix applied wrong. expected=%q, got=%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewlineTerminatesCommand(t *testing.T) {
+	input := `
+when HTTP_REQUEST {
+    LB::reselect
+    pool api_pool
+}
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	whenExpr := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	if len(whenExpr.Block.Statements) != 2 {
+		t.Fatalf("when block does not contain 2 statements. got=%d", len(whenExpr.Block.Statements))
+	}
+
+	lbExpr, ok := whenExpr.Block.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.LoadBalancerExpression)
+	if !ok {
+		t.Fatalf("first statement is not ast.LoadBalancerExpression. got=%T", whenExpr.Block.Statements[0])
+	}
+
+	if lbExpr.Command.Value != "LB::reselect" {
+		t.Errorf("lbExpr.Command not 'LB::reselect'. got=%q", lbExpr.Command.Value)
+	}
+
+	checkPoolCommand(t, whenExpr.Block.Statements[1])
+}
+
+func TestSemicolonTerminatesCommand(t *testing.T) {
+	input := "when HTTP_REQUEST {\n    set a 1; set b 2\n    pool web_pool;;\n}"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	whenExpr := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhenExpression)
+	if len(whenExpr.Block.Statements) != 3 {
+		t.Fatalf("when block does not contain 3 statements. got=%d", len(whenExpr.Block.Statements))
+	}
+	for i, name := range []string{"a", "b"} {
+		set, ok := whenExpr.Block.Statements[i].(*ast.SetStatement)
+		if !ok {
+			t.Fatalf("statement %d is not ast.SetStatement. got=%T", i, whenExpr.Block.Statements[i])
+		}
+		if set.Name.String() != name {
+			t.Errorf("statement %d sets %q, expected %q", i, set.Name.String(), name)
+		}
+	}
+}
+
+func TestUnexpectedTokenCollapsed(t *testing.T) {
+	input := `when HTTP_REQUEST {
+    if { [HTTP::uri] eq "/" extra } {
+        drop
+    }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	found := []diagnostic.Diagnostic{}
+	for _, d := range diagnostic.Normalize(p.Diagnostics()) {
+		if d.Line == 2 && d.Column == 29 {
+			found = append(found, d)
+		}
+	}
+	if len(found) != 1 || found[0].Code != diagnostic.SyntaxError {
+		t.Errorf("Expected a single %s at 2:29, got %v", diagnostic.SyntaxError, found)
+	}
+}
+
+func TestDiagnosticCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedCode diagnostic.Code
+	}{
+		{
+			name: "Invalid glob pattern is a semantic finding",
+			input: `switch -glob [HTTP::uri] {
+				"^/api.*" { pool api_pool }
+			}`,
+			expectedCode: diagnostic.InvalidPattern,
+		},
+		{
+			name:         "Unclosed block is reported as unbalanced braces",
+			input:        `when HTTP_REQUEST { pool api_pool`,
+			expectedCode: diagnostic.UnbalancedBraces,
+		},
+		{
+			name:         "Node and pool in the same block",
+			input:        `when HTTP_REQUEST { pool api_pool; node 10.0.0.1 }`,
+			expectedCode: diagnostic.NodePoolConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			found := false
+			for _, d := range p.Diagnostics() {
+				if d.Code == tt.expectedCode {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected a %s diagnostic, got %v", tt.expectedCode, p.Diagnostics())
+			}
+		})
+	}
+}
+
+func TestUnknownNamespace(t *testing.T) {
+	input := `when HTTP_REQUEST {
+		set host [HTTPS::host]
+		FOO::bar
+		log local0. "[FOO::baz]"
+		set static::limit [HTTP::header Limit]
+		set port [TCP::client_port]
+	}`
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	for _, d := range p.Diagnostics() {
+		if d.Code != diagnostic.UnknownNamespace {
+			t.Errorf("Expected only %s diagnostics, got %v", diagnostic.UnknownNamespace, d)
+		}
+		if !strings.Contains(d.Message, "expected one of AES,") {
+			t.Errorf("Expected the known namespaces to be listed, got %q", d.Message)
+		}
+	}
+
+	expected := map[string]int{"HTTPS": 1, "FOO": 2}
+	if !reflect.DeepEqual(p.UnknownNamespaces(), expected) {
+		t.Errorf("UnknownNamespaces wrong. expected=%v, got=%v", expected, p.UnknownNamespaces())
+	}
+}
+
+func TestHttp2Commands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		tmosVersion   string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Valid HTTP2 commands",
+			input: `when HTTP_REQUEST {
+				if { [HTTP2::active] } {
+					log local0. "stream [HTTP2::stream]"
+					HTTP2::disable
+				}
+			}`,
+		},
+		{
+			name:          "Wrong number of arguments",
+			input:         `when HTTP_REQUEST { set v [HTTP2::version extra] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Command newer than the targeted TMOS version",
+			input:         `when HTTP_REQUEST { HTTP2::push "/style.css" }`,
+			tmosVersion:   "13.1.3",
+			expectedCodes: []diagnostic.Code{diagnostic.VersionMismatch},
+		},
+		{
+			name:        "Command available in the targeted TMOS version",
+			input:       `when HTTP_REQUEST { HTTP2::push "/style.css" }`,
+			tmosVersion: "15.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.NewWithOptions(tt.input, config.Options{TmosVersion: tt.tmosVersion})
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestWebsocketCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		tmosVersion   string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Valid websocket iRule",
+			input: `when WS_CLIENT_FRAME {
+				if { [WS::frame type] == 1 } {
+					WS::collect frame 1024
+				}
+			}
+			when WS_SERVER_DATA {
+				WS::payload replace 0 [WS::payload length] ""
+				WS::release
+			}`,
+		},
+		{
+			name:          "Missing frame subcommand",
+			input:         `when WS_CLIENT_FRAME { WS::frame }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Too many arguments",
+			input:         `when WS_REQUEST { WS::disconnect 1000 "bye" extra }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Command newer than the targeted TMOS version",
+			input:         `when WS_CLIENT_FRAME { WS::release }`,
+			tmosVersion:   "11.6",
+			expectedCodes: []diagnostic.Code{diagnostic.VersionMismatch},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.NewWithOptions(tt.input, config.Options{TmosVersion: tt.tmosVersion})
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestModuleGatedCommands(t *testing.T) {
+	input := `when MQTT_CLIENT_INGRESS {
+		set id [MQTT::client_id]
+		if { [MQTT::type] eq "PUBLISH" } {
+			MQTT::topic replace "devices/$id"
+		}
+	}
+	when MR_INGRESS {
+		log local0. "routing message"
+	}`
+
+	tests := []struct {
+		name          string
+		modules       []string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:    "Modules enabled",
+			modules: []string{"mqtt", "mr"},
+		},
+		{
+			name:          "Message routing module disabled",
+			modules:       []string{"mqtt"},
+			expectedCodes: []diagnostic.Code{diagnostic.ModuleDisabled},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Modules = tt.modules
+			defer func() { config.Modules = nil }()
+
+			l := lexer.New(input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+
+	l := lexer.New(`when HTTP_REQUEST { MQTT::drop }`)
+	p := New(l)
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Code != diagnostic.ModuleDisabled {
+		t.Errorf("Expected a single %s diagnostic for MQTT::drop without --module, got %v", diagnostic.ModuleDisabled, diagnostics)
+	}
+}
+
+func TestResolvCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:  "Resolver path",
+			input: `when HTTP_REQUEST { set ips [RESOLV::lookup @/Common/dns_resolver -a [HTTP::host]] }`,
+		},
+		{
+			name:  "Resolver address with address family",
+			input: `when CLIENT_ACCEPTED { set name [RESOLV::lookup @10.0.0.53 inet -ptr [IP::client_addr]] }`,
+		},
+		{
+			name:  "Without a resolver",
+			input: `when CLIENT_ACCEPTED { set ips [RESOLV::lookup -aaaa "example.com"] }`,
+		},
+		{
+			name: "NAME lookup and response",
+			input: `when CLIENT_ACCEPTED { NAME::lookup [IP::client_addr] }
+			when NAME_RESOLVED { log local0. "name: [NAME::response]" }`,
+		},
+		{
+			name:          "Resolver path without an object name",
+			input:         `when HTTP_REQUEST { set ips [RESOLV::lookup @/Common -a [HTTP::host]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidResolver},
+		},
+		{
+			name:          "Empty resolver reference",
+			input:         `when HTTP_REQUEST { set ips [RESOLV::lookup @ -a [HTTP::host]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidResolver},
+		},
+		{
+			name:          "Unknown record type",
+			input:         `when HTTP_REQUEST { set ips [RESOLV::lookup @/Common/dns_resolver -srv [HTTP::host]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestCategoryCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Category lookup and matched event",
+			input: `when HTTP_REQUEST {
+				set categories [CATEGORY::lookup "http://[HTTP::host][HTTP::uri]" request_default_and_custom]
+			}
+			when CATEGORY_MATCHED {
+				log local0. "[CATEGORY::matchtype] [CATEGORY::result]"
+			}`,
+		},
+		{
+			name:  "Lookup type held in a variable",
+			input: "when HTTP_REQUEST {\n set url [HTTP::uri]\n set lookup_type request_default\n set categories [URLCAT::lookup $url $lookup_type]\n}",
+		},
+		{
+			name:          "Unknown lookup type",
+			input:         `when HTTP_REQUEST { set categories [CATEGORY::lookup [HTTP::uri] everything] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Missing url",
+			input:         `when HTTP_REQUEST { set categories [CATEGORY::lookup] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestCallCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCalls []ProcCall
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Local proc",
+			input: `proc normalize { uri {lower 1} } {
+				return [string tolower $uri]
+			}
+			when HTTP_REQUEST { set uri [call normalize [HTTP::uri]] }`,
+		},
+		{
+			name:          "Qualified library call",
+			input:         `when HTTP_REQUEST { call /Common/library_rule::log_request [HTTP::uri] }`,
+			expectedCalls: []ProcCall{{Rule: "/Common/library_rule", Proc: "log_request", Args: 1, Line: 1}},
+		},
+		{
+			name:          "Library call without a partition",
+			input:         `when HTTP_REQUEST { call library_rule::log_request }`,
+			expectedCalls: []ProcCall{{Rule: "library_rule", Proc: "log_request", Line: 1}},
+		},
+		{
+			name:          "Undefined local proc",
+			input:         `when HTTP_REQUEST { call normalize [HTTP::uri] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.UndefinedProc},
+		},
+		{
+			name:          "Partition without a rule",
+			input:         `when HTTP_REQUEST { call /Common::log_request }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Optional parameter left out",
+			input: `proc normalize { uri {lower 1} } {
+				return [string tolower $uri]
+			}
+			when HTTP_REQUEST { set uri [call normalize [HTTP::uri] 0] }
+			when HTTP_RESPONSE { call normalize [HTTP::header Location] }`,
+		},
+		{
+			name: "Too many arguments",
+			input: `proc normalize { uri } {
+				return [string tolower $uri]
+			}
+			when HTTP_REQUEST { set uri [call normalize [HTTP::uri] 1] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Missing argument",
+			input: `proc redirect_to { host uri } {
+				HTTP::redirect "https://$host$uri"
+			}
+			when HTTP_REQUEST { call redirect_to [HTTP::host] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Variadic proc",
+			input: `proc log_all { level args } {
+				log local0.$level [join $args]
+			}
+			when HTTP_REQUEST { call log_all info [HTTP::host] [HTTP::uri] }`,
+		},
+		{
+			name: "Variadic proc without its required argument",
+			input: `proc log_all { level args } {
+				log local0.$level [join $args]
+			}
+			when HTTP_REQUEST { call log_all }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+
+			calls := p.ProcCalls()
+			if len(calls) != len(tt.expectedCalls) {
+				t.Fatalf("Expected %d calls, got %d: %v", len(tt.expectedCalls), len(calls), calls)
+			}
+			for i, call := range tt.expectedCalls {
+				if calls[i] != call {
+					t.Errorf("calls[%d] wrong. expected=%+v, got=%+v", i, call, calls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveProcCalls(t *testing.T) {
+	library := map[string][]string{
+		"/Common/library_rule": {"log_request", "normalize"},
+	}
+	calls := []ProcCall{
+		{Rule: "/Common/library_rule", Proc: "log_request", Line: 1},
+		{Rule: "library_rule", Proc: "normalize", Line: 2},
+		{Rule: "/Common/library_rule", Proc: "missing", Line: 3},
+		{Rule: "/Common/other_rule", Proc: "log_request", Line: 4},
+	}
+
+	diagnostics := ResolveProcCalls(calls, library)
+	if len(diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %d: %v", len(diagnostics), diagnostics)
+	}
+	for i, line := range []int{3, 4} {
+		if diagnostics[i].Code != diagnostic.UndefinedProc || diagnostics[i].Line != line {
+			t.Errorf("diagnostics[%d] wrong. expected %s on line %d, got %v", i, diagnostic.UndefinedProc, line, diagnostics[i])
+		}
+	}
+}
+
+func TestResolveLibraryCalls(t *testing.T) {
+	library := Library{}
+	rule := New(lexer.New("proc helper {uri {code 302}} { return \"$uri $code\" }\nproc log_all {args} { log local0. $args }"))
+	rule.ParseProgram()
+	library.Add("library_rule", rule)
+	conf := New(lexer.New("ltm rule /Common/shared {\nproc mask {ip} { return $ip }\n}"))
+	conf.ParseProgram()
+	library.Add("bigip", conf)
+
+	calls := []ProcCall{
+		{Rule: "/Common/library_rule", Proc: "helper", Args: 1, Line: 1},
+		{Rule: "library_rule", Proc: "helper", Args: 2, Line: 2},
+		{Rule: "library_rule", Proc: "log_all", Args: 5, Line: 3},
+		{Rule: "/Common/shared", Proc: "mask", Args: 1, Line: 4},
+		{Rule: "/Common/library_rule", Proc: "helper", Args: 3, Line: 5},
+		{Rule: "shared", Proc: "mask", Line: 6},
+		{Rule: "/Common/library_rule", Proc: "missing", Line: 7},
+		{Rule: "/Common/bigip", Proc: "mask", Args: 1, Line: 8},
+	}
+
+	expected := []struct {
+		code diagnostic.Code
+		line int
+	}{
+		{diagnostic.InvalidCommand, 5},
+		{diagnostic.InvalidCommand, 6},
+		{diagnostic.UndefinedProc, 7},
+		{diagnostic.UndefinedProc, 8},
+	}
+	diagnostics := ResolveLibraryCalls(calls, library)
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d: %v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, e := range expected {
+		if diagnostics[i].Code != e.code || diagnostics[i].Line != e.line {
+			t.Errorf("diagnostics[%d] wrong. expected %s on line %d, got %v", i, e.code, e.line, diagnostics[i])
+		}
+	}
+}
+
+func TestStreamCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Rewriting response bodies",
+			input: `when HTTP_REQUEST {
+				STREAM::disable
+			}
+			when HTTP_RESPONSE {
+				if { [HTTP::header value Content-Type] contains "text" } {
+					STREAM::expression "@http://example.com@https://example.com@ #src=\"/#src=\"/static/#"
+					STREAM::enable
+				}
+			}
+			when STREAM_MATCHED {
+				log local0. "matched [STREAM::match]"
+				STREAM::replace "https://example.com"
+			}`,
+		},
+		{
+			name:          "Unterminated pair",
+			input:         `when HTTP_RESPONSE { STREAM::expression "@http://@https://" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Empty search pattern",
+			input:         `when HTTP_RESPONSE { STREAM::expression "@@https://@" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Replace outside STREAM_MATCHED",
+			input:         `when HTTP_RESPONSE { STREAM::replace "" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.CommandNotInEvent},
+		},
+		{
+			name:          "Expression without arguments",
+			input:         `when HTTP_RESPONSE { STREAM::expression }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestStringSubstitutions(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Known commands",
+			input: `when HTTP_REQUEST {
+				log local0. "[IP::client_addr] [string tolower [HTTP::host]] [lindex [split [HTTP::uri] "?"] 0]"
+				log local0. "pool [LB::server pool], escaped \[literal\]"
+			}`,
+		},
+		{
+			name:          "Missing closing bracket",
+			input:         `when HTTP_REQUEST { log local0. "uri [HTTP::uri" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+		{
+			name:          "Stray closing bracket",
+			input:         `when HTTP_REQUEST { log local0. "client [IP::client_addr]] connected" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.SuspectSubstitution},
+		},
+		{
+			name:          "Unknown command",
+			input:         `when HTTP_REQUEST { log local0. "user [usre_name]" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.SuspectSubstitution},
+		},
+		{
+			name:          "Empty substitution",
+			input:         `when HTTP_REQUEST { log local0. "nothing []" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.SuspectSubstitution},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestCryptoCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Signing a payload",
+			input: `when RULE_INIT {
+				set static::aes_key [AES::key 256]
+				set static::hmac_key "secret"
+			}
+			when HTTP_REQUEST {
+				set token [b64encode [AES::encrypt $static::aes_key [HTTP::uri]]]
+				set uri [AES::decrypt $static::aes_key [b64decode $token]]
+				set digest [sha256 [HTTP::uri]]
+				set etag [md5 $uri]
+				set sig [CRYPTO::sign -alg hmac-sha256 -key $static::hmac_key [HTTP::uri]]
+				set valid [CRYPTO::verify -alg hmac-sha256 -key $static::hmac_key -signature $sig [HTTP::uri]]
+				set hash [CRYPTO::hash -alg sha256 $uri]
+				set iv [CRYPTO::keygen -alg random -len 128]
+				set algo md5
+			}`,
+		},
+		{
+			name:          "Invalid key length",
+			input:         `when RULE_INIT { set static::key [AES::key 512] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Literal AES key",
+			input:         `when HTTP_REQUEST { set data [AES::encrypt "secret" [HTTP::uri]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Missing data",
+			input:         `when HTTP_REQUEST { set digest [md5] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Hash algorithm for a signature",
+			input:         `when HTTP_REQUEST { set sig [CRYPTO::sign -alg sha256 -key "secret" [HTTP::uri]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Verify without a signature",
+			input:         `when HTTP_REQUEST { set ok [CRYPTO::verify -alg hmac-sha256 -key "secret" [HTTP::uri]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown option",
+			input:         `when HTTP_REQUEST { set hash [CRYPTO::hash -algo sha256 [HTTP::uri]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestIstatsCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:  "Key with a command substitution",
+			input: `when HTTP_REQUEST { ISTATS::incr "ltm.virtual [virtual name] c my_counter" 1 }`,
+		},
+		{
+			name:  "Key with a variable",
+			input: `when HTTP_REQUEST {
+				set pool_name "web"
+				ISTATS::set "ltm.pool $pool_name g active" 3
+			}`,
+		},
+		{
+			name:  "Nested quotes in a substitution",
+			input: `when HTTP_REQUEST { log local0. "hits: [ISTATS::get "ltm.virtual [virtual name] c my_counter"]" }`,
+		},
+		{
+			name:          "Unknown stat type",
+			input:         `when HTTP_REQUEST { ISTATS::incr "ltm.virtual [virtual name] x my_counter" 1 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Key is not a triple",
+			input:         `when HTTP_REQUEST { ISTATS::incr "my_counter" 1 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Missing increment",
+			input:         `when HTTP_REQUEST { ISTATS::incr "ltm.virtual [virtual name] c my_counter" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Findings inside a substitution keep their line",
+			input: `when HTTP_REQUEST {
+				log local0. "uri: [HTTP::uri]
+				helper: [call normalize [HTTP::uri]]"
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.UndefinedProc},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+			if diagnostics := p.Diagnostics(); len(diagnostics) == 1 && diagnostics[0].Code == diagnostic.UndefinedProc && diagnostics[0].Line != 3 {
+				t.Errorf("Expected the embedded call to be reported on line 3, got %d", diagnostics[0].Line)
+			}
+		})
+	}
+}
+
+func TestTableCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Rate limiting",
+			input: `when HTTP_REQUEST {
+				set key [IP::client_addr]
+				set count [table incr -subtable "rate:$key" -notouch hits]
+				if { [table lookup -notouch -subtable blocked $key] eq "yes" } { reject }
+				table set -subtable blocked $key yes 300 indef
+				table delete -subtable rate -all
+				set active [table keys -subtable blocked -count]
+			}`,
+		},
+		{
+			name:  "Word spelled like the command",
+			input: `when HTTP_REQUEST { log local0. table }`,
+		},
+		{
+			name:          "Unknown subcommand",
+			input:         `when HTTP_REQUEST { table lookp [IP::client_addr] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Option of another subcommand",
+			input:         `when HTTP_REQUEST { table lookup -excl [IP::client_addr] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Missing value",
+			input:         `when HTTP_REQUEST { table set -subtable rate [IP::client_addr] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Invalid timeout",
+			input:         `when HTTP_REQUEST { table add [IP::client_addr] 1 forever }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Keys without a subtable",
+			input:         `when HTTP_REQUEST { set n [table keys -count] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestClassCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Data group lookups",
+			input: `when HTTP_REQUEST {
+				if { [class match [IP::client_addr] equals allowed_clients] } { return }
+				set target [class match -value -- [HTTP::uri] starts_with uri_map]
+				set pool_name [class lookup [string tolower [HTTP::host]] host_map]
+				set hits [class search -all -name host_map ends_with [HTTP::host]]
+				set all [class get -nocase host_map "*.example.com"]
+				set names [class names host_map]
+				set first [class element -value 0 host_map]
+				if { [class exists host_map] && [class size host_map] > 0 } { pool $pool_name }
+			}`,
+		},
+		{
+			name: "Iterating a data group",
+			input: `when RULE_INIT {
+				set id [class startsearch host_map]
+				while { [class anymore host_map $id] } {
+					log local0. [class nextelement -name host_map $id]
+				}
+				class donesearch host_map $id
+			}`,
+		},
+		{
+			name:          "Unknown subcommand",
+			input:         `when HTTP_REQUEST { set v [class fetch host_map] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown operator",
+			input:         `when HTTP_REQUEST { set v [class match [HTTP::uri] matches uri_map] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Invalid option",
+			input:         `when HTTP_REQUEST { set v [class names -all host_map] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Conflicting result options",
+			input:         `when HTTP_REQUEST { set v [class search -name -value host_map equals [HTTP::host]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Lookup without a data group",
+			input:         `when HTTP_REQUEST { set v [class lookup [HTTP::host]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Element index",
+			input:         `when HTTP_REQUEST { set v [class element first host_map] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:  "Quoted data group name",
+			input: `when HTTP_REQUEST { set v [class match [HTTP::host] equals "host_map"] }`,
+		},
+		{
+			name:          "Quoted literal in the data group position",
+			input:         `when HTTP_REQUEST { set v [class match [HTTP::uri] starts_with "/api"] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name: "Reversed operands",
+			input: `when HTTP_REQUEST {
+				set dg host_map
+				set v [class match "example.com" equals $dg]
+			}`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestClassReversedOperandsMessage(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`set v [class match "/api" starts_with [HTTP::uri]]`, "[HTTP::uri]"},
+		{`set v [class match "/api" starts_with [string tolower [HTTP::uri]]]`, "[string tolower [HTTP::uri]]"},
+		{"class match \"/api\" starts_with [HTTP::uri]\n pool a", "[HTTP::uri]"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New("when HTTP_REQUEST {\n " + tt.input + "\n}"))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got %v", tt.input, diagnostics)
+		}
+		expected := "against the data group " + tt.expected + ", the operands look reversed"
+		if !strings.Contains(diagnostics[0].Message, expected) {
+			t.Errorf("%s: expected the message to contain %q, got %q", tt.input, expected, diagnostics[0].Message)
+		}
+	}
+}
+
+func TestPersistCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Persistence methods",
+			input: `when HTTP_REQUEST {
+				persist uie [HTTP::cookie JSESSIONID] 1800
+				persist cookie insert app_cookie 3600
+				persist cookie insert app_cookie 1d01h00m00s
+				persist cookie rewrite app_cookie 1d01:00:00
+				persist cookie insert app_cookie 01:00:00
+				persist source_addr 255.255.255.0 600
+				persist carp [HTTP::header X-User]
+				persist none
+			}`,
+		},
+		{
+			name: "Persistence records",
+			input: `when HTTP_RESPONSE {
+				persist add uie [HTTP::cookie JSESSIONID] 1800
+				set owner [persist lookup uie [HTTP::cookie JSESSIONID] node]
+				persist delete uie [HTTP::cookie JSESSIONID]
+			}`,
+		},
+		{
+			name:          "Unknown method",
+			input:         `when HTTP_REQUEST { persist sourceaddr 600 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Missing key",
+			input:         `when HTTP_REQUEST { persist uie }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Invalid timeout",
+			input:         `when HTTP_REQUEST { persist cookie insert app_cookie 1h }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Negative timeout",
+			input:         `when HTTP_REQUEST { persist uie [IP::client_addr] -5 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown cookie method",
+			input:         `when HTTP_REQUEST { persist cookie bake }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown lookup field",
+			input:         `when HTTP_REQUEST { persist lookup uie [HTTP::cookie JSESSIONID] owner }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestURIRewrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Safe rewrites",
+			input: `when HTTP_REQUEST {
+				HTTP::uri "/v2[HTTP::uri]"
+				HTTP::path [string map {"/old/" "/new/"} [HTTP::path]]
+				HTTP::uri [string map {"/api" ""} [HTTP::uri]]
+				set target "/v3/"
+				HTTP::uri $target
+				set query [URI::encode [URI::decode [HTTP::query]]]
+				if { [HTTP::uri] starts_with "/api" } { return }
+			}`,
+		},
+		{
+			name:          "Relative literal",
+			input:         `when HTTP_REQUEST { HTTP::uri "api/v2" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.URIRewrite},
+		},
+		{
+			name:          "Map dropping the slash",
+			input:         `when HTTP_REQUEST { HTTP::uri [string map {"/old" "new"} [HTTP::uri]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.URIRewrite},
+		},
+		{
+			name:          "Map removing the slash",
+			input:         `when HTTP_REQUEST { HTTP::path [string map {"/api/" ""} [HTTP::path]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.URIRewrite},
+		},
+		{
+			name:          "Encoding the request URI",
+			input:         `when HTTP_REQUEST { HTTP::uri "/login?next=[URI::encode [HTTP::uri]]" }`,
+			expectedCodes: []diagnostic.Code{diagnostic.URIRewrite},
+		},
+		{
+			name:          "Encoding twice",
+			input:         `when HTTP_REQUEST { set next [URI::encode [URI::encode [HTTP::host]]] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.URIRewrite},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+			for i, d := range p.Diagnostics() {
+				if d.Severity != diagnostic.Warning {
+					t.Errorf("diagnostics[%d] expected a warning, got %v", i, d)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	RegisterCommand(CommandSpec{
+		Name:     "EXAMPLE::collect",
+		MinArgs:  1,
+		MaxArgs:  2,
+		ArgTypes: []ArgType{WordArg, NumberArg},
+		Events:   []string{"TCP_REQUEST", "CLIENT_ACCEPTED"},
+	})
+	defer delete(commandRegistry, "EXAMPLE::collect")
+
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:  "Registered command",
+			input: `when TCP_REQUEST { EXAMPLE::collect payload 512 }`,
+		},
+		{
+			name:  "Variable for a number argument",
+			input: "when CLIENT_ACCEPTED {\n set length 10\n EXAMPLE::collect payload $length\n}",
+		},
+		{
+			name:  "Braced variables are single words",
+			input: "when CLIENT_ACCEPTED {\n set kind payload\n set length 10\n EXAMPLE::collect ${kind} ${length}\n}",
+		},
+		{
+			name:          "Too many braced variables",
+			input:         "when CLIENT_ACCEPTED {\n set length 10\n EXAMPLE::collect payload ${length} ${length}\n}",
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:  "Command substitution",
+			input: `when TCP_REQUEST { log local0. "collected: [EXAMPLE::collect payload]" }`,
+		},
+		{
+			name:          "Too many arguments",
+			input:         `when TCP_REQUEST { EXAMPLE::collect payload 512 1024 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Word instead of a number",
+			input:         `when TCP_REQUEST { EXAMPLE::collect payload all }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Number instead of a word",
+			input:         `when TCP_REQUEST { EXAMPLE::collect 512 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Used in the wrong event",
+			input:         `when HTTP_REQUEST { EXAMPLE::collect payload 512 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.CommandNotInEvent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestTcpCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Ports in a log line",
+			input: `when CLIENT_ACCEPTED {
+				log local0. "[IP::client_addr]:[TCP::client_port] -> [TCP::local_port]"
+				set port [TCP::remote_port serverside]
+			}`,
+		},
+		{
+			name: "Collect and inspect the payload",
+			input: `when TCP_REQUEST {
+				TCP::collect 15
+				if { [TCP::payload] contains "quit" } {
+					TCP::respond "bye\r\n"
+					TCP::close
+				}
+				TCP::payload replace 0 [TCP::payload length] ""
+				TCP::release
+			}`,
+		},
+		{
+			name:          "Collect length is not a number",
+			input:         `when CLIENT_ACCEPTED { TCP::collect all }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Respond without data",
+			input:         `when TCP_REQUEST { TCP::respond }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Close takes no arguments",
+			input:         `when TCP_REQUEST { TCP::close now }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown side",
+			input:         `when CLIENT_ACCEPTED { set port [TCP::local_port backside] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Incomplete payload replace",
+			input:         `when TCP_REQUEST { TCP::payload replace 0 10 }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestDnsCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Answer a question",
+			input: `when DNS_REQUEST {
+				if { [DNS::question type] eq "AAAA" } {
+					DNS::answer insert "[DNS::question name]. 60 [DNS::question class] AAAA ::1"
+					DNS::rcode NOERROR
+					DNS::return
+				}
+			}`,
+		},
+		{
+			name: "Rewrite answer records",
+			input: `when DNS_RESPONSE {
+				foreach rr [DNS::answer] {
+					if { [DNS::type $rr] eq "A" } {
+						DNS::ttl $rr 30
+					}
+				}
+			}`,
+		},
+		{
+			name:          "Unknown question subcommand",
+			input:         `when DNS_REQUEST { set q [DNS::question domain] }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Insert without a record",
+			input:         `when DNS_RESPONSE { DNS::answer insert }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Unknown response code",
+			input:         `when DNS_REQUEST { DNS::rcode NOTFOUND }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "TTL is not a number",
+			input:         `when DNS_RESPONSE { foreach rr [DNS::answer] { DNS::ttl $rr forever } }`,
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Used outside a DNS event",
+			input:         `when HTTP_REQUEST { DNS::return }`,
+			expectedCodes: []diagnostic.Code{diagnostic.CommandNotInEvent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestEventContexts(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedCode     diagnostic.Code
+		expectedMessages []string
+	}{
+		{
+			name:  "Request commands in HTTP_REQUEST",
+			input: `when HTTP_REQUEST { if { [HTTP::uri] eq "/" } { HTTP::respond 200 content ok } else { pool web } }`,
+		},
+		{
+			name:             "Respond after the server connected",
+			input:            `when SERVER_CONNECTED { HTTP::respond 503 content "down" }`,
+			expectedMessages: []string{"HTTP::respond is not available in SERVER_CONNECTED"},
+		},
+		{
+			name:             "Load balancing in a response",
+			input:            `when HTTP_RESPONSE { if { [HTTP::status] == 500 } { LB::select } }`,
+			expectedMessages: []string{"LB::select is not available in HTTP_RESPONSE"},
+		},
+		{
+			name:             "Request data in a response",
+			input:            "when HTTP_RESPONSE {\n log local0. \"[HTTP::host] [HTTP::status]\"\n}",
+			expectedMessages: []string{"HTTP::host is not available in HTTP_RESPONSE"},
+		},
+		{
+			name:  "Bare return before the next event",
+			input: "when HTTP_REQUEST { if {1} { return } }\nwhen HTTP_RESPONSE { set s [HTTP::status] }",
+		},
+		{
+			name:  "Events outside the connection flow are trusted",
+			input: `when NAME_RESOLVED { log local0. "status [HTTP::status]" }`,
+		},
+		{
+			name:             "Connection commands in RULE_INIT",
+			input:            "when RULE_INIT {\n set static::host [HTTP::host]\n set static::client [IP::client_addr]\n pool web\n}",
+			expectedMessages: []string{"HTTP::host needs a connection", "IP::client_addr needs a connection", "pool needs a connection"},
+		},
+		{
+			name:  "Static initialization in RULE_INIT",
+			input: "when RULE_INIT {\n set static::limit 100\n set static::debug 0\n}",
+		},
+		{
+			name:             "Static constants set on every request",
+			input:            "when HTTP_REQUEST {\n set static::limit 100\n set static::host [HTTP::host]\n if { [HTTP::uri] eq \"/debug\" } { set static::debug 1 }\n}",
+			expectedCode:     diagnostic.StaticInitInEvent,
+			expectedMessages: []string{"static::limit is set to a constant on every HTTP_REQUEST"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			expectedCode := tt.expectedCode
+			if expectedCode == "" {
+				expectedCode = diagnostic.CommandNotInEvent
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != expectedCode || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, expectedCode, message, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOutline(t *testing.T) {
+	input := `proc log_uri {uri} {
+    log local0. $uri
+}
+when HTTP_REQUEST {
+    if { [HTTP::uri] starts_with "/api" } {
+        switch -glob [HTTP::host] {
+            "a*" { pool a }
+            default { pool b }
+        }
+    } elseif { [HTTP::uri] eq "/x" } {
+        foreach h [HTTP::header values Host] { log local0. $h }
+    } else {
+        call log_uri [HTTP::uri]
+    }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	var describe func(items []ast.OutlineItem) []string
+	describe = func(items []ast.OutlineItem) []string {
+		described := []string{}
+		for _, item := range items {
+			entry := fmt.Sprintf("%s %s %d:%d-%d:%d", item.Kind, item.Name, item.Start.Line, item.Start.Column, item.End.Line, item.End.Column)
+			if children := describe(item.Children); len(children) > 0 {
+				entry += " [" + strings.Join(children, ", ") + "]"
+			}
+			described = append(described, entry)
+		}
+		return described
+	}
+
+	expected := "proc log_uri 1:1-3:1, event HTTP_REQUEST 4:1-15:1 [if  5:5-14:5 [switch  6:9-9:9 [case a* 7:13-7:27, case default 8:13-8:30], foreach h 11:9-11:63]]"
+	if got := strings.Join(describe(ast.Outline(program)), ", "); got != expected {
+		t.Errorf("wrong outline.\nexpected=%s\ngot=     %s", expected, got)
+	}
+}
+
+func TestLtmRule(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedRules []string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name: "Built-in https redirect",
+			input: `ltm rule /Common/_sys_https_redirect {
+    nodelay
+    when HTTP_REQUEST {
+       HTTP::redirect https://[getfield [HTTP::host] ":" 1][HTTP::uri]
+    }
+    definition-signature WsYy2M6xMlGlRPCi1WdTfUv7RcXUN2BKqg1d0j8gsaRLbCNYWt6vV7FxfKmXUjGbJYN9cj2XfD/kG/UaXf8tEfF+AMw5t/LFWS7q3wZR5gwxoE/6p0ipnKF25xKc5gW3X6Os2Q0EGd9Hs3Z0iLHm0mGk4kXg5J4QS8v5pQ==
+}`,
+			expectedRules: []string{"/Common/_sys_https_redirect"},
+		},
+		{
+			name: "Rules exported one after the other",
+			input: `ltm rule /Common/pool_select {
+when CLIENT_ACCEPTED {
+  set default_pool [LB::server pool]
+}
+when HTTP_REQUEST {
+  if { [HTTP::uri] starts_with "/api" } {
+    pool api_pool
+  } else {
+    pool $default_pool
+  }
+}
+}
+ltm rule /Common/strip_server {
+    when HTTP_RESPONSE {
+        HTTP::header remove Server
+    }
+    app-service none
+}`,
+			expectedRules: []string{"/Common/pool_select", "/Common/strip_server"},
+		},
+		{
+			name: "Partition-qualified targets",
+			input: `ltm rule /Common/app_select {
+    when HTTP_REQUEST {
+        if { [HTTP::uri] starts_with "/api" } {
+            pool /Common/app/api_pool
+        } else {
+            pool /Common/web_pool
+        }
+    }
+    when CLIENT_ACCEPTED {
+        snatpool /Common/snat_pool
+    }
+}
+ltm rule /Common/maintenance {
+    when HTTP_REQUEST {
+        node /Common/maintenance_node 80
+    }
+}`,
+			expectedRules: []string{"/Common/app_select", "/Common/maintenance"},
+		},
+		{
+			name: "Rule without a partition",
+			input: `ltm rule redirect_rule {
+  when HTTP_REQUEST {
+    switch -glob [string tolower [HTTP::uri]] {
+      "/a*" { pool a_pool }
+      default { pool b_pool }
+    }
+  }
+}`,
+			expectedRules: []string{"redirect_rule"},
+		},
+		{
+			name: "Priority ties are only checked within a rule",
+			input: `ltm rule /Common/a {
+    when HTTP_REQUEST { pool a_pool }
+}
+ltm rule /Common/b {
+    when HTTP_REQUEST { pool b_pool }
+    when HTTP_REQUEST { pool c_pool }
+}`,
+			expectedRules: []string{"/Common/a", "/Common/b"},
+			expectedCodes: []diagnostic.Code{diagnostic.PriorityTie},
+		},
+		{
+			name: "Procs are local to their rule",
+			input: `ltm rule /Common/a {
+    proc helper {} { return 1 }
+    when HTTP_REQUEST { call helper }
+}
+ltm rule /Common/b {
+    when HTTP_REQUEST { call helper }
+}`,
+			expectedRules: []string{"/Common/a", "/Common/b"},
+			expectedCodes: []diagnostic.Code{diagnostic.UndefinedProc},
+		},
+		{
+			name: "Errors in the rule body",
+			input: `ltm rule /Common/a {
+    when HTTP_REQUEST {
+        TCP::close now
+    }
+}`,
+			expectedRules: []string{"/Common/a"},
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Invalid rule name",
+			input:         `ltm rule Common/a { }`,
+			expectedRules: []string{"Common/a"},
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidIdentifier},
+		},
+		{
+			name: "Nested in an event",
+			input: `when HTTP_REQUEST {
+    ltm rule /Common/a { }
+}`,
+			expectedCodes: []diagnostic.Code{diagnostic.SyntaxError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+
+			rules := []string{}
+			for _, stmt := range program.Statements {
+				if rule, ok := stmt.(*ast.LtmRule); ok {
+					rules = append(rules, rule.Name.Value)
+				}
+			}
+			if tt.expectedRules != nil && strings.Join(rules, " ") != strings.Join(tt.expectedRules, " ") {
+				t.Errorf("Expected rules %v, got %v", tt.expectedRules, rules)
+			}
+		})
+	}
+}
+
+func TestVariableLifetimes(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedLines []int
+	}{
+		{
+			name: "Set in the request, read in the response",
+			input: `when HTTP_REQUEST { set host [HTTP::host] }
+when HTTP_RESPONSE { log local0. "response for $host" }`,
+		},
+		{
+			name: "Set when the client connects",
+			input: `when CLIENT_ACCEPTED { set client [IP::client_addr] }
+when HTTP_REQUEST { if { $client eq "10.0.0.1" } { pool a_pool } }`,
+		},
+		{
+			name: "Set in the response, read in the request",
+			input: `when HTTP_REQUEST { log local0. "status $status" }
+when HTTP_RESPONSE { set status [HTTP::status] }`,
+			expectedLines: []int{1},
+		},
+		{
+			name: "Set by incr in a later event",
+			input: `when CLIENT_ACCEPTED {
+  if { $requests > 10 } { reject }
+}
+when HTTP_REQUEST { incr requests }`,
+			expectedLines: []int{2},
+		},
+		{
+			name: "Guarded by info exists",
+			input: `when HTTP_REQUEST { if { [info exists status] } { log local0. $status } }
+when HTTP_RESPONSE { set status [HTTP::status] }`,
+		},
+		{
+			name: "Set in RULE_INIT",
+			input: `when RULE_INIT { set limit 10 }
+when HTTP_REQUEST { if { $limit > 1 } { pool a_pool } }`,
+			expectedLines: []int{2},
+		},
+		{
+			name: "Static variable from RULE_INIT",
+			input: `when RULE_INIT { set static::limit 10 }
+when HTTP_REQUEST { if { $static::limit > 1 } { pool a_pool } }`,
+		},
+		{
+			name: "Static variable only set in a connection event",
+			input: `when CLIENT_ACCEPTED { set static::limit [table lookup limit] }
+when HTTP_REQUEST { if { $static::limit > 1 } { pool a_pool } }
+when HTTP_RESPONSE { log local0. "limit $static::limit" }`,
+			expectedLines: []int{2, 3},
+		},
+		{
+			name: "Static variable initialized in another rule",
+			input: `ltm rule /Common/init {
+    when RULE_INIT { set static::limit 10 }
+}
+ltm rule /Common/use {
+    when CLIENT_ACCEPTED { set static::limit [table lookup limit] }
+    when HTTP_REQUEST { if { $static::limit > 1 } { pool a_pool } }
+}`,
+		},
+		{
+			name: "Set in RULE_INIT and in an earlier event",
+			input: `when RULE_INIT { set limit 10 }
+when CLIENT_ACCEPTED { set limit 5 }
+when HTTP_REQUEST { if { $limit > 1 } { pool a_pool } }`,
+			expectedLines: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedLines) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedLines), len(diagnostics), diagnostics)
+			}
+			for i, line := range tt.expectedLines {
+				if diagnostics[i].Code != diagnostic.UnreachableVariable || !diagnostics[i].IsWarning() || diagnostics[i].Line != line {
+					t.Errorf("diagnostics[%d] expected a %s warning on line %d, got %v", i, diagnostic.UnreachableVariable, line, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUndeclaredVariables(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedMessages []string
+		expectedColumns  []int
+	}{
+		{
+			name:             "Never set",
+			input:            `when HTTP_REQUEST { log local0. $unknown }`,
+			expectedMessages: []string{"variable unknown is read in HTTP_REQUEST but never set"},
+		},
+		{
+			name: "Set by a command in a substitution",
+			input: `when HTTP_REQUEST {
+  if { [regsub -nocase /test [HTTP::uri] /new new_uri] > 0 } { HTTP::uri $new_uri }
+  foreach name [HTTP::header names] { log local0. "$name [HTTP::header value $name]" }
+}`,
+		},
+		{
+			name: "Proc parameters and locals",
+			input: `proc greet {name {greeting hello}} {
+  set message "$greeting $name"
+  return "$message $host"
+}`,
+			expectedMessages: []string{"variable host is read in proc greet but never set"},
+		},
+		{
+			name: "Event variables are not visible in procs",
+			input: `when HTTP_REQUEST { set host [HTTP::host] }
+proc show {} { log local0. $host }`,
+			expectedMessages: []string{"variable host is read in proc show but never set"},
+		},
+		{
+			name: "Static variable from RULE_INIT",
+			input: `when HTTP_REQUEST { if { [HTTP::uri] eq $static::path } { pool a_pool } }
+when RULE_INIT { set static::path "/" }`,
+		},
+		{
+			name:             "Static variable never set",
+			input:            `when HTTP_REQUEST { log local0. "limit $static::limit" }`,
+			expectedMessages: []string{"variable static::limit is read in HTTP_REQUEST but never set"},
+		},
+		{
+			name: "Global and namespace variables",
+			input: `when RULE_INIT {
+  set ::hits 0
+  set app::config::mode strict
+}
+when HTTP_REQUEST {
+  incr ::hits
+  log local0. "$::hits ${app::config::mode} $::hist"
+}
+proc show {} { return $app::config::mode }`,
+			expectedMessages: []string{"variable ::hist is read in HTTP_REQUEST but never set, did you mean ::hits?"},
+		},
+		{
+			name: "Separate ltm rules",
+			input: `ltm rule /Common/a {
+    when HTTP_REQUEST { log local0. $status }
+}
+ltm rule /Common/b {
+    when HTTP_RESPONSE { set status [HTTP::status] }
+}`,
+			expectedMessages: []string{"variable status is read in HTTP_REQUEST but never set"},
+		},
+		{
+			name: "Misspelled variable in a string",
+			input: `when HTTP_REQUEST {
+  set response [HTTP::uri]
+  log local0. "value: ${response} $resposne \$escaped"
+}`,
+			expectedMessages: []string{"variable resposne is read in HTTP_REQUEST but never set, did you mean response?"},
+			expectedColumns:  []int{35},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.UndeclaredVariable || !diagnostics[i].IsWarning() || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected a %s warning %q, got %v", i, diagnostic.UndeclaredVariable, message, diagnostics[i])
+				}
+			}
+			for i, column := range tt.expectedColumns {
+				if diagnostics[i].Column != column {
+					t.Errorf("diagnostics[%d] expected column %d, got %d", i, column, diagnostics[i].Column)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameVariable(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		renames  int
+		err      string
+	}{
+		{
+			name:     "References and set",
+			input:    "when HTTP_REQUEST {\n  set host [HTTP::host]\n  if { $host eq \"a\" } { HTTP::header replace Host ${host} }\n}",
+			expected: "when HTTP_REQUEST {\n  set hostname [HTTP::host]\n  if { $hostname eq \"a\" } { HTTP::header replace Host ${hostname} }\n}",
+			renames:  3,
+		},
+		{
+			name:     "Interpolations and array elements",
+			input:    "when HTTP_REQUEST {\n  set host(main) 1\n  log local0. \"host=$host(main) ${host} \\$host $hosts\"\n}",
+			expected: "when HTTP_REQUEST {\n  set hostname(main) 1\n  log local0. \"host=$hostname(main) ${hostname} \\$host $hosts\"\n}",
+			renames:  3,
+		},
+		{
+			name:     "Words that aren't the variable",
+			input:    "when HTTP_REQUEST {\n  # host header\n  set value host\n  HTTP::header value host\n  foreach host [HTTP::header names] { incr host }\n}",
+			expected: "when HTTP_REQUEST {\n  # host header\n  set value host\n  HTTP::header value host\n  foreach hostname [HTTP::header names] { incr hostname }\n}",
+			renames:  2,
+		},
+		{
+			name:     "Result variables",
+			input:    "when HTTP_REQUEST {\n  regsub -all {^/old} [HTTP::uri] /new host\n  if { [catch { HTTP::uri $host } host] } { log local0. [info exists host] }\n}",
+			expected: "when HTTP_REQUEST {\n  regsub -all {^/old} [HTTP::uri] /new hostname\n  if { [catch { HTTP::uri $hostname } hostname] } { log local0. [info exists hostname] }\n}",
+			renames:  4,
+		},
+		{
+			name:     "Braced literals",
+			input:    "when HTTP_REQUEST {\n  set host [HTTP::host]\n  log local0. {literal $host}\n  switch $host {\n    {$host} { log local0. $host }\n  }\n  while { $host ne \"\" } { foreach h {$host} { set host \"\" } }\n}",
+			expected: "when HTTP_REQUEST {\n  set hostname [HTTP::host]\n  log local0. {literal $host}\n  switch $hostname {\n    {$host} { log local0. $hostname }\n  }\n  while { $hostname ne \"\" } { foreach h {$host} { set hostname \"\" } }\n}",
+			renames:  5,
+		},
+		{
+			name:  "New name already used",
+			input: "when HTTP_REQUEST { set host [HTTP::host]; set hostname $host }",
+			err:   "variable hostname is already used",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renamed, renames, err := RenameVariable(tt.input, "host", "hostname")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if renamed != tt.expected {
+				t.Errorf("renamed rule wrong.\nexpected:\n%s\ngot:\n%s", tt.expected, renamed)
+			}
+			if len(renames) != tt.renames {
+				t.Errorf("expected %d renames, got %d: %v", tt.renames, len(renames), renames)
+			}
+		})
+	}
+}
+
+func TestEventCosts(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  set names [HTTP::header names]
+  set values [HTTP::header values Accept]
+  switch -regexp [HTTP::uri] {
+    "^/api" { pool api_pool }
+  }
+  if { [HTTP::uri] matches_regex {^/static} } { pool static_pool }
+  set count [table incr "requests:[IP::client_addr]"]
+  foreach name $names {
+    foreach value $values {
+      log local0. "$name $value"
+    }
+  }
+  foreach other $names { log local0. $other }
+}
+when HTTP_RESPONSE {
+  set path [string map {"/old" "/new"} [string map {"a" "b"} [HTTP::header Location]]]
+  set client [IP::client_addr]
+  if { [class match $client equals allowed_clients] } { return }
+}
+when CLIENT_ACCEPTED {
+  log local0. "connected"
+}`
+
+	expected := []EventCost{
+		{Event: "HTTP_REQUEST", Line: 1, Regex: 2, Table: 1, NestedLoops: 1},
+		{Event: "HTTP_RESPONSE", Line: 16, StringMap: 2, ClassSearch: 1},
+		{Event: "CLIENT_ACCEPTED", Line: 21},
+	}
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	costs := p.EventCosts()
+	if len(costs) != len(expected) {
+		t.Fatalf("Expected %d event costs, got %d: %+v", len(expected), len(costs), costs)
+	}
+	for i, cost := range costs {
+		if cost != expected[i] {
+			t.Errorf("costs[%d] wrong. expected=%+v, got=%+v", i, expected[i], cost)
+		}
+	}
+
+	if score := costs[0].Score(); score != 2*regexCost+tableCost+nestedLoopCost {
+		t.Errorf("Expected HTTP_REQUEST to score %d, got %d", 2*regexCost+tableCost+nestedLoopCost, score)
+	}
+	if score := costs[2].Score(); score != 0 {
+		t.Errorf("Expected CLIENT_ACCEPTED to score 0, got %d", score)
+	}
+}
+
+func TestPutsLint(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		severity         string
+		expectedSeverity []diagnostic.Severity
+	}{
+		{
+			name:  "Allowed in RULE_INIT",
+			input: `when RULE_INIT { puts "rule loaded" }`,
+		},
+		{
+			name:  "Allowed in a proc",
+			input: `proc debug {msg} { puts $msg }`,
+		},
+		{
+			name:             "Warning in an event",
+			input:            `when HTTP_REQUEST { puts -nonewline stderr "uri [HTTP::uri]" }`,
+			expectedSeverity: []diagnostic.Severity{diagnostic.Warning},
+		},
+		{
+			name:             "Nested in an if",
+			input:            `when HTTP_REQUEST { if { 1 } { puts hello } }`,
+			expectedSeverity: []diagnostic.Severity{diagnostic.Warning},
+		},
+		{
+			name:             "Reported as an error",
+			input:            `when HTTP_RESPONSE { puts hello }`,
+			severity:         "error",
+			expectedSeverity: []diagnostic.Severity{""},
+		},
+		{
+			name:             "Reported as a note",
+			input:            `when HTTP_RESPONSE { puts hello }`,
+			severity:         "info",
+			expectedSeverity: []diagnostic.Severity{diagnostic.Info},
+		},
+		{
+			name:     "Turned off",
+			input:    `when HTTP_RESPONSE { puts hello }`,
+			severity: "off",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.PutsSeverity = tt.severity
+			defer func() { config.PutsSeverity = "" }()
+
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedSeverity) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedSeverity), len(diagnostics), diagnostics)
+			}
+			for i, severity := range tt.expectedSeverity {
+				if diagnostics[i].Code != diagnostic.PutsInEvent || diagnostics[i].Severity != severity {
+					t.Errorf("diagnostics[%d] expected %s with severity %q, got %v", i, diagnostic.PutsInEvent, severity, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyCandidate(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		expectedMessage string
+	}{
+		{
+			name: "Host and path chain",
+			input: `when HTTP_REQUEST {
+    if { [HTTP::host] equals "api.example.com" } {
+        pool api_pool
+    } elseif { [string tolower [HTTP::path]] starts_with "/static" } {
+        pool static_pool
+    } else {
+        HTTP::redirect "https://www.example.com/"
+    }
+}`,
+			expectedMessage: "rule could be an LTM policy with 3 rules: http-host host equals api.example.com → forward to pool api_pool; http-uri path starts-with /static → forward to pool static_pool; default → redirect to https://www.example.com/",
+		},
+		{
+			name: "Glob switch",
+			input: `when HTTP_REQUEST {
+    switch -glob [HTTP::uri] {
+        "/old*" { HTTP::redirect "https://www.example.com/new" }
+        "*.php" { reject }
+        "/admin" { pool admin_pool }
+        default { pool web_pool }
+    }
+}`,
+			expectedMessage: "rule could be an LTM policy with 4 rules: http-uri starts-with /old → redirect to https://www.example.com/new; http-uri ends-with .php → reset traffic; http-uri equals /admin → forward to pool admin_pool; default → forward to pool web_pool",
+		},
+		{
+			name: "Regex switch",
+			input: `when HTTP_REQUEST {
+    switch -regex [HTTP::uri] {
+        "^/api/v[0-9]+" { pool api_pool }
+    }
+}`,
+		},
+		{
+			name: "Redirect built from the request",
+			input: `when HTTP_REQUEST {
+    if { [HTTP::host] equals "example.com" } {
+        HTTP::redirect "https://www.example.com[HTTP::uri]"
+    }
+}`,
+		},
+		{
+			name: "Other statements",
+			input: `when HTTP_REQUEST {
+    set host [HTTP::host]
+    if { $host equals "example.com" } {
+        pool web_pool
+    }
+}`,
+		},
+		{
+			name: "Other events",
+			input: `when CLIENT_ACCEPTED {
+    if { [IP::client_addr] equals "10.0.0.1" } {
+        pool admin_pool
+    }
+}`,
+		},
+	}
+
+	config.SuggestPolicies = true
+	defer func() { config.SuggestPolicies = false }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			messages := []string{}
+			for _, d := range p.Diagnostics() {
+				if d.Code == diagnostic.PolicyCandidate {
+					if d.Severity != diagnostic.Info {
+						t.Errorf("expected an info finding, got %v", d)
+					}
+					messages = append(messages, d.Message)
+				}
+			}
+			if tt.expectedMessage == "" {
+				if len(messages) != 0 {
+					t.Fatalf("expected no policy suggestion, got %v", messages)
+				}
+				return
+			}
+			if len(messages) != 1 || messages[0] != tt.expectedMessage {
+				t.Fatalf("expected %q, got %v", tt.expectedMessage, messages)
+			}
+		})
+	}
+}
+
+func TestDiagnosticColumns(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  TCP::respond
+  log local0. "x [TCP::close now] y"
+  set größe "Grüße ✓"
+  log local0. "$größe ✓ [TCP::close now]"
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	expected := []struct {
+		line   int
+		column int
+	}{
+		{2, 3},
+		{3, 19},
+		{5, 26},
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d: %v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, tt := range expected {
+		if diagnostics[i].Line != tt.line || diagnostics[i].Column != tt.column {
+			t.Errorf("diagnostics[%d] wrong position. expected=%d:%d, got=%d:%d", i, tt.line, tt.column, diagnostics[i].Line, diagnostics[i].Column)
+		}
+	}
+}
+
+func TestDiagnosticsAtOffendingToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		code   diagnostic.Code
+		line   int
+		column int
+	}{
+		{
+			name:   "Invalid http command",
+			input:  "when HTTP_REQUEST {\n    HTTP::bogus\n}",
+			code:   diagnostic.InvalidCommand,
+			line:   2,
+			column: 5,
+		},
+		{
+			name:   "Semicolon ending a command early",
+			input:  "when HTTP_REQUEST {\n    set a ; set b 2\n}",
+			code:   diagnostic.UnexpectedToken,
+			line:   2,
+			column: 11,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) == 0 {
+				t.Fatalf("Expected a %s diagnostic, got none", tt.code)
+			}
+			d := diagnostics[0]
+			if d.Code != tt.code || d.Line != tt.line || d.Column != tt.column {
+				t.Errorf("diagnostics[0] wrong. expected=%s at %d:%d, got=%s at %d:%d", tt.code, tt.line, tt.column, d.Code, d.Line, d.Column)
+			}
+		})
+	}
+}
+
+func TestParseFnsAreReachable(t *testing.T) {
+	p := New(lexer.New(""))
+
+	if unreachable := p.unreachableParseFns(); len(unreachable) != 0 {
+		t.Errorf("parse functions registered for tokens the lexer never produces: %v", unreachable)
+	}
+}
+
+func TestDuplicateParseFnRegistration(t *testing.T) {
+	p := New(lexer.New(""))
+
+	if p.registrationErr != nil {
+		t.Fatalf("parse functions registered twice: %v", p.registrationErr)
+	}
+	if err := p.registerPrefix(token.IDENT, p.parseIdentifier); err == nil {
+		t.Errorf("registering a second prefix parse function for IDENT should fail")
+	}
+	if err := p.registerInfix(token.AND, p.parseInfixExpression); err == nil {
+		t.Errorf("registering a second infix parse function for AND should fail")
+	}
+}
+
+func TestValidateStats(t *testing.T) {
+	input := `
+proc log_client {} {
+	log local0. "client [IP::client_addr]"
+}
+when CLIENT_ACCEPTED {
+	call log_client
+	TCP::collect
+}
+when HTTP_REQUEST {
+	if { [HTTP::host] eq "example.com" } {
+		HTTP::redirect "https://www.example.com/"
+	}
+}`
+
+	result := Validate(input)
+	if len(result.Diagnostics) != 0 {
+		t.Fatalf("Expected no diagnostics, got %v", result.Diagnostics)
+	}
+
+	stats := result.Stats
+	if stats.Events != 2 {
+		t.Errorf("stats.Events wrong. expected=2, got=%d", stats.Events)
+	}
+	if stats.Procs != 1 {
+		t.Errorf("stats.Procs wrong. expected=1, got=%d", stats.Procs)
+	}
+	if stats.Statements < 6 {
+		t.Errorf("stats.Statements wrong. expected at least 6, got=%d", stats.Statements)
+	}
+	if len(stats.Expensive) != 2 || stats.Expensive[1].Event != "HTTP_REQUEST" || stats.Expensive[1].Score() != 0 {
+		t.Errorf("stats.Expensive wrong. expected two handlers without expensive constructs, got=%+v", stats.Expensive)
+	}
+
+	expectedCommands := map[string]int{"HTTP": 2, "IP": 1, "TCP": 1}
+	for namespace, count := range expectedCommands {
+		if stats.Commands[namespace] != count {
+			t.Errorf("stats.Commands[%q] wrong. expected=%d, got=%d", namespace, count, stats.Commands[namespace])
+		}
+	}
+	if len(stats.Commands) != len(expectedCommands) {
+		t.Errorf("Expected %d namespaces, got %v", len(expectedCommands), stats.Commands)
+	}
+
+	if stats.Nodes["WhenExpression"] != 2 || stats.Nodes["IfStatement"] != 1 {
+		t.Errorf("stats.Nodes wrong. expected 2 WhenExpression and 1 IfStatement, got=%v", stats.Nodes)
+	}
+	if stats.MaxDepth != 2 {
+		t.Errorf("stats.MaxDepth wrong. expected=2, got=%d", stats.MaxDepth)
+	}
+
+	merged := Stats{}
+	merged.Merge(stats)
+	merged.Merge(stats)
+	if merged.Statements != 2*stats.Statements || merged.Nodes["IfStatement"] != 2 || merged.Commands["HTTP"] != 4 || merged.MaxDepth != 2 {
+		t.Errorf("merged stats wrong. got=%+v", merged)
+	}
+}
+
+func TestValidateWithOptions(t *testing.T) {
+	input := `when HTTP_REQUEST { HTTP2::push "/style.css" }`
+
+	// rules validated side by side keep the options they were given
+	versions := map[string]int{"13.1.3": 1, "15.1": 0, "": 0}
+	var wg sync.WaitGroup
+	for version, expected := range versions {
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := ValidateWithOptions(input, config.Options{TmosVersion: version})
+				if len(result.Diagnostics) != expected {
+					t.Errorf("TMOS %q: expected %d diagnostics, got %v", version, expected, result.Diagnostics)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestRuleLimits(t *testing.T) {
+	// an event handler of about size bytes
+	handler := func(size int) string {
+		line := "  if { [HTTP::uri] eq \"/path\" } { pool a_pool }\n"
+		return "when HTTP_REQUEST {\n" + strings.Repeat(line, size/len(line)) + "}\n"
+	}
+	// an event handler nesting if blocks depth deep
+	nested := func(depth int) string {
+		return "when HTTP_REQUEST {\n" + strings.Repeat("if { 1 } {\n", depth-1) + "pool a_pool\n" + strings.Repeat("}\n", depth)
+	}
+
+	tests := []struct {
+		name             string
+		input            string
+		tmosVersion      string
+		expectedErrors   []string
+		expectedWarnings []string
+	}{
+		{
+			name:        "Small rule",
+			input:       handler(1000),
+			tmosVersion: "10.2",
+		},
+		{
+			name:             "Close to the size limit",
+			input:            handler(62000),
+			tmosVersion:      "10.2",
+			expectedWarnings: []string{"close to the limit of 65535 bytes of TMOS 10.2"},
+		},
+		{
+			name:           "Over the size limit",
+			input:          handler(70000),
+			tmosVersion:    "10.2.4",
+			expectedErrors: []string{"over the limit of 65535 bytes of TMOS 10.2.4"},
+		},
+		{
+			name:        "Size limit of newer releases",
+			input:       handler(70000),
+			tmosVersion: "15.1",
+		},
+		{
+			name:        "Latest release without a target",
+			input:       handler(70000),
+			tmosVersion: "",
+		},
+		{
+			name:             "Each ltm rule on its own",
+			input:            "ltm rule /Common/small {\n" + handler(40000) + "}\nltm rule /Common/large {\n" + handler(60000) + "}\n",
+			tmosVersion:      "10.2",
+			expectedWarnings: []string{"/Common/large is"},
+		},
+		{
+			name:           "Nesting over the limit",
+			input:          nested(1001),
+			expectedErrors: []string{"rule nests blocks 1001 deep, over the limit of 1000 of the latest TMOS"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ValidateWithOptions(tt.input, config.Options{TmosVersion: tt.tmosVersion})
+
+			var errors, warnings []string
+			for _, d := range result.Diagnostics {
+				switch {
+				case d.Code != diagnostic.RuleLimit:
+					t.Errorf("unexpected diagnostic %v", d)
+				case d.IsWarning():
+					warnings = append(warnings, d.Message)
+				default:
+					errors = append(errors, d.Message)
+				}
+			}
+			if len(errors) != len(tt.expectedErrors) || len(warnings) != len(tt.expectedWarnings) {
+				t.Fatalf("expected %d errors and %d warnings, got %q and %q", len(tt.expectedErrors), len(tt.expectedWarnings), errors, warnings)
+			}
+			for i, message := range tt.expectedErrors {
+				if !strings.Contains(errors[i], message) {
+					t.Errorf("errors[%d] expected to contain %q, got %q", i, message, errors[i])
+				}
+			}
+			for i, message := range tt.expectedWarnings {
+				if !strings.Contains(warnings[i], message) {
+					t.Errorf("warnings[%d] expected to contain %q, got %q", i, message, warnings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEventPriorities(t *testing.T) {
+	input := `priority 200
+when HTTP_REQUEST {
+	log local0. "rule default"
+}
+when HTTP_REQUEST priority 100 {
+	log local0. "runs first"
+}
+when HTTP_REQUEST timing on priority 200 {
+	log local0. "ties with the first block"
+}
+when CLIENT_ACCEPTED {
+	log local0. "handled once"
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d: %v", len(diagnostics), diagnostics)
+	}
+	if diagnostics[0].Code != diagnostic.PriorityTie || !diagnostics[0].IsWarning() || diagnostics[0].Line != 8 {
+		t.Errorf("Expected a %s warning on line 8, got %v", diagnostic.PriorityTie, diagnostics[0])
+	}
+
+	order := EventOrder(p.EventHandlers())
+	if len(order) != 1 {
+		t.Fatalf("Expected only HTTP_REQUEST to be reported, got %v", order)
+	}
+
+	expectedLines := []int{5, 2, 8}
+	handlers := order["HTTP_REQUEST"]
+	if len(handlers) != len(expectedLines) {
+		t.Fatalf("Expected %d HTTP_REQUEST handlers, got %d", len(expectedLines), len(handlers))
+	}
+	for i, line := range expectedLines {
+		if handlers[i].Line != line {
+			t.Errorf("handlers[%d] wrong. expected line %d, got line %d (priority %d)", i, line, handlers[i].Line, handlers[i].Priority)
+		}
+	}
+}
+
+func TestInvalidEventPriority(t *testing.T) {
+	l := lexer.New(`when HTTP_REQUEST priority 1001 { log local0. "never" }`)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) == 0 || diagnostics[0].Code != diagnostic.InvalidCommand {
+		t.Fatalf("Expected an %s diagnostic, got %v", diagnostic.InvalidCommand, diagnostics)
+	}
+}
+
+func TestTestModeAssertions(t *testing.T) {
+	input := `when HTTP_REQUEST {
+	# expect: pool api_pool
+	if { [HTTP::uri] starts_with "/api" } {
+		pool api_pool
+	}
+	assert { [HTTP::uri] ne "" } "uri is set"
+}`
+
+	tests := []struct {
+		name          string
+		input         string
+		testMode      bool
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:     "Expectations met in test mode",
+			input:    input,
+			testMode: true,
+		},
+		{
+			name:          "Assert outside test mode",
+			input:         input,
+			expectedCodes: []diagnostic.Code{diagnostic.TestOnlyCommand},
+		},
+		{
+			name: "Unmet expectation",
+			input: `when HTTP_REQUEST {
+				# expect: HTTP::redirect "https://[HTTP::host][HTTP::uri]"
+				pool api_pool
+			}`,
+			testMode:      true,
+			expectedCodes: []diagnostic.Code{diagnostic.UnmetExpectation},
+		},
+		{
+			name: "Expectation with a quoted message",
+			input: `when HTTP_REQUEST {
+				# expect: log local0. "uri is set"
+				log local0. "uri is set"
+			}`,
+			testMode: true,
+		},
+		{
+			name: "Unmet expectation with a quoted message",
+			input: `when HTTP_REQUEST {
+				# expect: log local0. "uri is set"
+				log local0. "uri is empty"
+			}`,
+			testMode:      true,
+			expectedCodes: []diagnostic.Code{diagnostic.UnmetExpectation},
+		},
+		{
+			name:          "Expectations ignored outside test mode",
+			input:         `when HTTP_REQUEST { # expect: pool missing_pool` + "\n" + `}`,
+			expectedCodes: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.TestMode = tt.testMode
+			defer func() { config.TestMode = false }()
+
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestHttpRespond(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedMessages []string
+		expectedHeaders  int
+	}{
+		{
+			name:            "Content, header pairs and flags",
+			input:           `when HTTP_REQUEST { HTTP::respond 200 content {<html>ok</html>} "Content-Type" "text/html" noserver Connection Close }`,
+			expectedHeaders: 2,
+		},
+		{
+			name:            "Version and a content substitution",
+			input:           `when HTTP_REQUEST { HTTP::respond 503 -version auto content [ifile get maintenance] Retry-After 60 -reset }`,
+			expectedHeaders: 1,
+		},
+		{
+			name:            "Redirect with a Location header",
+			input:           `when HTTP_REQUEST { HTTP::respond 301 Location "https://[HTTP::host][HTTP::uri]" }`,
+			expectedHeaders: 1,
+		},
+		{
+			name:             "Missing status",
+			input:            `when HTTP_REQUEST { HTTP::respond }`,
+			expectedMessages: []string{"wrong # args: should be \"HTTP::respond status"},
+		},
+		{
+			name:             "Invalid status",
+			input:            `when HTTP_REQUEST { HTTP::respond 999 content "x" }`,
+			expectedMessages: []string{"invalid HTTP::respond status '999'"},
+		},
+		{
+			name:             "Header without a value",
+			input:            `when HTTP_REQUEST { HTTP::respond 200 content "x" "Content-Type" }`,
+			expectedMessages: []string{"HTTP::respond header Content-Type has no value"},
+			expectedHeaders:  0,
+		},
+		{
+			name:             "Redirect without a Location header",
+			input:            `when HTTP_REQUEST { HTTP::respond 302 noserver }`,
+			expectedMessages: []string{"HTTP::respond 302 redirects but sets no Location header"},
+		},
+		{
+			name:             "Invalid version and content twice",
+			input:            `when HTTP_REQUEST { HTTP::respond 200 -version 2.0 content x content y }`,
+			expectedMessages: []string{"invalid HTTP::respond -version '2.0'", "HTTP::respond is given content twice"},
+		},
+		{
+			name:             "Invalid header name",
+			input:            `when HTTP_REQUEST { HTTP::respond 200 "Content Type" "text/html" }`,
+			expectedMessages: []string{"invalid header name 'Content Type' for HTTP::respond"},
+			expectedHeaders:  1,
+		},
+		{
+			name:             "Redirect arguments",
+			input:            `when HTTP_REQUEST { HTTP::redirect "/a" "/b" }`,
+			expectedMessages: []string{"wrong # args: HTTP::redirect expects 1 argument(s), got 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+
+			var respond *ast.HttpRespondExpression
+			ast.Inspect(program, func(node ast.Node) bool {
+				if r, ok := node.(*ast.HttpRespondExpression); ok {
+					respond = r
+				}
+				return true
+			})
+			if respond != nil && len(respond.Headers) != tt.expectedHeaders {
+				t.Errorf("expected %d headers, got %d: %s", tt.expectedHeaders, len(respond.Headers), respond.String())
+			}
+		})
+	}
+}
+
+func TestHttpHeader(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedMessages []string
+		expectedMethod   string
+	}{
+		{
+			name:           "Insert pairs after lws",
+			input:          `when HTTP_REQUEST { HTTP::header insert lws "X-Forwarded-For" [IP::client_addr] X-Id 1 }`,
+			expectedMethod: "insert",
+		},
+		{
+			name:           "Replace, remove and sanitize",
+			input:          "when HTTP_REQUEST {\n HTTP::header replace Host \"example.com\"\n HTTP::header remove Cookie\n HTTP::header sanitize Host Accept\n}",
+			expectedMethod: "sanitize",
+		},
+		{
+			name:           "Value, count and at in substitutions",
+			input:          "when HTTP_REQUEST {\n set n [HTTP::header count Accept]\n set first [HTTP::header at 0]\n set host [HTTP::header value Host]\n log local0. \"$n $first $host\"\n}",
+			expectedMethod: "value",
+		},
+		{
+			name:           "Insert a braced variable",
+			input:          "when HTTP_REQUEST {\n set c 1\n HTTP::header insert X-A ${c}\n}",
+			expectedMethod: "insert",
+		},
+		{
+			name:  "Header name shorthand",
+			input: `when HTTP_REQUEST { set host [HTTP::header Host] }`,
+		},
+		{
+			name:             "Insert without a value",
+			input:            `when HTTP_REQUEST { HTTP::header insert "X-Foo" }`,
+			expectedMessages: []string{"wrong # args: HTTP::header insert expects at least 2 argument(s), got 1"},
+			expectedMethod:   "insert",
+		},
+		{
+			name:             "Insert with an odd number of words after lws",
+			input:            `when HTTP_REQUEST { HTTP::header insert lws X-Foo }`,
+			expectedMessages: []string{"HTTP::header insert expects header name and value pairs, got 1 argument(s)"},
+			expectedMethod:   "insert",
+		},
+		{
+			name:             "Too many arguments",
+			input:            `when HTTP_REQUEST { HTTP::header remove Cookie Host }`,
+			expectedMessages: []string{"wrong # args: HTTP::header remove expects 1 argument(s), got 2"},
+			expectedMethod:   "remove",
+		},
+		{
+			name:             "Invalid subcommand",
+			input:            `when HTTP_REQUEST { HTTP::header inserts X-Foo bar }`,
+			expectedMessages: []string{"invalid subcommand 'inserts' for HTTP::header"},
+		},
+		{
+			name:             "Invalid header name",
+			input:            `when HTTP_REQUEST { HTTP::header remove "Cookie:" }`,
+			expectedMessages: []string{"invalid header name 'Cookie:' for HTTP::header"},
+			expectedMethod:   "remove",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+
+			method := ""
+			ast.Inspect(program, func(node ast.Node) bool {
+				if h, ok := node.(*ast.HttpExpression); ok && h.Command.Value == "HTTP::header" && h.Method != nil {
+					method = h.Method.Value
+				}
+				return true
+			})
+			if method != tt.expectedMethod {
+				t.Errorf("expected the last subcommand to be %q, got %q", tt.expectedMethod, method)
+			}
+		})
+	}
+}
+
+func TestHttpCookie(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedMessages []string
+	}{
+		{
+			name:  "Insert with options",
+			input: `when HTTP_RESPONSE { HTTP::cookie insert name "session" value [HTTP::cookie value session] path "/" domain ".example.com" }`,
+		},
+		{
+			name:  "Attributes and reads",
+			input: "when HTTP_RESPONSE {\n HTTP::cookie secure session enable\n HTTP::cookie httponly session enable\n HTTP::cookie samesite session strict\n set id [HTTP::cookie JSESSIONID]\n set n [HTTP::cookie count]\n}",
+		},
+		{
+			name:  "Insert with braced variables",
+			input: "when HTTP_RESPONSE {\n set cookieName session\n set country ca\n HTTP::cookie insert name ${cookieName} value ${country} path \"/\" domain \"example.com\"\n}",
+		},
+		{
+			name:             "Insert without a value",
+			input:            `when HTTP_RESPONSE { HTTP::cookie insert name "session" }`,
+			expectedMessages: []string{"wrong # args: HTTP::cookie insert expects at least 4 argument(s), got 2"},
+		},
+		{
+			name:             "Insert with an unknown option",
+			input:            `when HTTP_RESPONSE { HTTP::cookie insert name a value b secure 1 }`,
+			expectedMessages: []string{"invalid option 'secure' for HTTP::cookie insert"},
+		},
+		{
+			name:             "Insert missing the value option",
+			input:            `when HTTP_RESPONSE { HTTP::cookie insert name a path "/" }`,
+			expectedMessages: []string{"HTTP::cookie insert is missing the value option"},
+		},
+		{
+			name:             "Remove without a name",
+			input:            `when HTTP_RESPONSE { HTTP::cookie remove }`,
+			expectedMessages: []string{"wrong # args: HTTP::cookie remove expects 1 argument(s), got 0"},
+		},
+		{
+			name:             "Invalid attribute value",
+			input:            `when HTTP_RESPONSE { HTTP::cookie httponly session on }`,
+			expectedMessages: []string{"invalid value 'on' for HTTP::cookie httponly"},
+		},
+		{
+			name:             "Invalid subcommand",
+			input:            `when HTTP_RESPONSE { HTTP::cookie secrue session enable }`,
+			expectedMessages: []string{"invalid subcommand 'secrue' for HTTP::cookie"},
+		},
+		{
+			name:             "Invalid cookie name",
+			input:            `when HTTP_REQUEST { set v [HTTP::cookie exists "a b"] }`,
+			expectedMessages: []string{"invalid cookie name 'a b' for HTTP::cookie"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHttpValue(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedMessages []string
+	}{
+		{
+			name:  "Setters and getters",
+			input: "when HTTP_REQUEST {\n HTTP::uri [string map {\"//\" \"/\"} [HTTP::uri]]\n HTTP::query \"a=1\"\n HTTP::version \"1.0\"\n set m [HTTP::method]\n set p [HTTP::path -normalized]\n}",
+		},
+		{
+			name:             "Value for a read-only command",
+			input:            `when HTTP_REQUEST { HTTP::method "POST" }`,
+			expectedMessages: []string{"HTTP::method is read-only and takes no value, got 1 argument(s)"},
+		},
+		{
+			name:             "Value for the host",
+			input:            `when HTTP_REQUEST { HTTP::host "x" }`,
+			expectedMessages: []string{"HTTP::host is read-only and takes no value, got 1 argument(s)"},
+		},
+		{
+			name:             "Value for keep-alive",
+			input:            "when HTTP_REQUEST {\n if { [HTTP::is_keepalive] } { log local0. \"keep-alive\" }\n HTTP::is_keepalive 1\n}",
+			expectedMessages: []string{"HTTP::is_keepalive is read-only and takes no value, got 1 argument(s)"},
+		},
+		{
+			name:             "Too many values",
+			input:            `when HTTP_REQUEST { HTTP::path "/a" "/b" }`,
+			expectedMessages: []string{"wrong # args: HTTP::path expects at most 1 argument(s), got 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || !strings.HasPrefix(diagnostics[i].Message, message) {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedCommands(t *testing.T) {
+	input := "when HTTP_REQUEST {\n ACCESS::session data get \"session.user.name\"\n HTTP::disable\n log local0. [X509::whole [SSL::cert 0]]\n}"
+	commands := []string{"ACCESS::session", "HTTP::disable", "X509::whole"}
+
+	for _, lenient := range []bool{false, true} {
+		result := ValidateWithOptions(input, config.Options{Lenient: lenient})
+		if len(result.Diagnostics) != len(commands) {
+			t.Fatalf("lenient=%v: expected %d diagnostics, got %d: %v", lenient, len(commands), len(result.Diagnostics), result.Diagnostics)
+		}
+		for i, d := range result.Diagnostics {
+			if d.Code != diagnostic.UnsupportedConstruct || !strings.HasPrefix(d.Message, commands[i]+" is not supported") {
+				t.Errorf("lenient=%v: diagnostics[%d] expected %s for %s, got %v", lenient, i, diagnostic.UnsupportedConstruct, commands[i], d)
+			}
+			if d.IsError() == lenient {
+				t.Errorf("lenient=%v: diagnostics[%d] has severity %q", lenient, i, d.Severity)
+			}
+		}
+		if diagnostic.HasErrors(result.Diagnostics) == lenient {
+			t.Errorf("lenient=%v: expected errors=%v", lenient, !lenient)
+		}
+	}
+}
+
+func TestTargetStatements(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:  "Snat, snatpool and virtual",
+			input: "when HTTP_REQUEST {\n snat automap\n virtual /Common/vs_internal\n log local0. \"from [virtual name]\"\n}",
+		},
+		{
+			name:  "Snatpool member and use forms",
+			input: "when LB_SELECTED {\n snatpool my_snat_pool member 10.0.0.5 80\n use pool web_pool\n}",
+		},
+		{
+			name:  "Snat to an address and virtual in a variable",
+			input: "when CLIENT_ACCEPTED {\n set target vs_internal\n snat 10.1.1.1\n virtual $target\n}",
+		},
+		{
+			name:          "Virtual and pool in the same block",
+			input:         "when HTTP_REQUEST {\n pool web_pool\n use virtual vs_internal\n}",
+			expectedCodes: []diagnostic.Code{diagnostic.NodePoolConflict},
+		},
+		{
+			name:          "Snat and snatpool in the same block",
+			input:         "when HTTP_REQUEST {\n snat automap\n snatpool my_snat_pool\n}",
+			expectedCodes: []diagnostic.Code{diagnostic.NodePoolConflict},
+		},
+		{
+			name:          "Invalid snat address and use target",
+			input:         "when HTTP_REQUEST {\n snat automatic\n use server web\n}",
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand, diagnostic.InvalidCommand},
+		},
+		{
+			name:          "Snatpool without a name",
+			input:         "when HTTP_REQUEST {\n snatpool\n}",
+			expectedCodes: []diagnostic.Code{diagnostic.InvalidCommand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			p.ParseProgram()
+
+			assertDiagnosticCodes(t, p, tt.expectedCodes)
+		})
+	}
+}
+
+func TestNodeStatement(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedAddress  string
+		expectedPort     string
+		expectedMessages []string
+	}{
+		{name: "Address and port", input: "node 10.0.0.1 8080", expectedAddress: "10.0.0.1", expectedPort: "8080"},
+		{name: "Port after a colon", input: "node 10.0.0.1:8080", expectedAddress: "10.0.0.1", expectedPort: "8080"},
+		{name: "IPv6 address", input: "node 2001:db8::1 443", expectedAddress: "2001:db8::1", expectedPort: "443"},
+		{name: "Route domain", input: "node 10.0.0.1%2", expectedAddress: "10.0.0.1%2"},
+		{name: "Bracketed IPv6 address and port", input: "node [fe80::1]:8443", expectedAddress: "fe80::1", expectedPort: "8443"},
+		{name: "IPv6 loopback", input: "node ::1 80", expectedAddress: "::1", expectedPort: "80"},
+		{name: "Substituted address", input: "node [LB::server addr] 80", expectedAddress: "[[LB::server addr]]", expectedPort: "80"},
+		{
+			name:             "Port out of range",
+			input:            "node 10.0.0.1 70000",
+			expectedAddress:  "10.0.0.1",
+			expectedPort:     "70000",
+			expectedMessages: []string{"invalid node port '70000', expected a number from 1 to 65535"},
+		},
+		{
+			name:             "Port zero after a colon",
+			input:            "node 10.0.0.1:0",
+			expectedAddress:  "10.0.0.1",
+			expectedPort:     "0",
+			expectedMessages: []string{"invalid node port '0'"},
+		},
+		{
+			name:             "Malformed address",
+			input:            "node 10.0.0.300 80",
+			expectedAddress:  "10.0.0.300",
+			expectedPort:     "80",
+			expectedMessages: []string{"invalid node address '10.0.0.300'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New("when HTTP_REQUEST {\n " + tt.input + "\n}")
+			p := New(l)
+			program := p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || !strings.HasPrefix(diagnostics[i].Message, message) || diagnostics[i].Line != 2 {
+					t.Errorf("diagnostics[%d] expected %s %q on line 2, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+
+			var node *ast.NodeStatement
+			ast.Inspect(program, func(n ast.Node) bool {
+				if ns, ok := n.(*ast.NodeStatement); ok {
+					node = ns
+				}
+				return true
+			})
+			if node == nil {
+				t.Fatalf("no node statement in %s", program.String())
+			}
+			if node.IPAddress != tt.expectedAddress || node.Port != tt.expectedPort {
+				t.Errorf("node wrong. expected address=%q port=%q, got address=%q port=%q", tt.expectedAddress, tt.expectedPort, node.IPAddress, node.Port)
+			}
+		})
+	}
+}
+
+func TestCidrLiterals(t *testing.T) {
+	tests := []struct {
+		network          string
+		expectedMessages []string
+	}{
+		{network: "10.0.0.0/8"},
+		{network: "10.0.0.0/255.255.0.0"},
+		{network: "2001:db8::/32"},
+		{network: "0.0.0.0/0"},
+		{network: "10.0.0.0/33", expectedMessages: []string{"invalid prefix length /33 for 10.0.0.0, expected 0 to 32"}},
+		{network: "2001:db8::/129", expectedMessages: []string{"invalid prefix length /129 for 2001:db8::, expected 0 to 128"}},
+		{network: "10.0.0.0/255.0.255.0", expectedMessages: []string{"invalid netmask 255.0.255.0 for 10.0.0.0, its ones aren't contiguous"}},
+		{network: "2001:db8::/255.255.0.0", expectedMessages: []string{"invalid netmask 255.255.0.0 for 2001:db8::, expected an IPv4 netmask"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			input := "when CLIENT_ACCEPTED {\n if { [IP::addr [IP::client_addr] equals " + tt.network + "] } { drop }\n}"
+			l := lexer.New(input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || diagnostics[i].Message != message {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+
+			var cidr *ast.CidrLiteral
+			ast.Inspect(program, func(node ast.Node) bool {
+				if c, ok := node.(*ast.CidrLiteral); ok {
+					cidr = c
+				}
+				return true
+			})
+			if cidr == nil || cidr.String() != tt.network {
+				t.Errorf("expected the network %s, got %v", tt.network, cidr)
+			}
+		})
+	}
+}
+
+func TestIpAddrCommand(t *testing.T) {
+	tests := []struct {
+		command          string
+		expectedMessages []string
+	}{
+		{command: "IP::addr [IP::client_addr] equals 10.1.0.0/16"},
+		{command: `IP::addr "[IP::client_addr] mask 255.255.0.0" equals 10.1.0.0`},
+		{command: `IP::addr $client equals "10.0.0.0/8"`},
+		{command: "IP::addr [IP::client_addr] mask 255.255.255.0"},
+		{command: "IP::addr [IP::client_addr]/24 equals 10.1.1.0"},
+		{command: "IP::addr $client/24 equals 10.1.1.0"},
+		{command: "IP::addr $client/130 equals 10.1.1.0", expectedMessages: []string{"invalid prefix length /130 for $client, expected 0 to 128"}},
+		{command: "IP::addr parse -ipv4 -swap $bytes 4"},
+		{command: "IP::addr [IP::client_addr] eq 10.1.0.0", expectedMessages: []string{"invalid operator 'eq' for IP::addr, expected equals or mask"}},
+		{command: "IP::addr [IP::client_addr] equals 10.1.0.300", expectedMessages: []string{"invalid address '10.1.0.300' for IP::addr"}},
+		{command: `IP::addr $client equals "10.0.0.0/40"`, expectedMessages: []string{"invalid prefix length /40 for 10.0.0.0, expected 0 to 32"}},
+		{command: `IP::addr "[IP::client_addr] mask 255.0.255.0" equals 10.1.0.0`, expectedMessages: []string{"invalid netmask 255.0.255.0 for IP::addr, its ones aren't contiguous"}},
+		{command: "IP::addr [IP::client_addr] mask 16", expectedMessages: []string{"invalid netmask '16' for IP::addr, expected one such as 255.255.0.0"}},
+		{command: "IP::addr parse -ipv5 $bytes", expectedMessages: []string{"invalid option '-ipv5' for IP::addr parse, expected one of -ipv4, -ipv6, -swap"}},
+		{command: "IP::addr parse -ipv4", expectedMessages: []string{"wrong # args: IP::addr parse expects the bytes of an address and an optional offset, got 0 argument(s)"}},
+		{command: "IP::addr [IP::client_addr]", expectedMessages: []string{`wrong # args: should be "IP::addr addr1 equals addr2" or "IP::addr addr mask mask", got 1 argument(s)`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			input := "when CLIENT_ACCEPTED {\n set client [IP::client_addr]\n set bytes [TCP::payload 4]\n if { [" + tt.command + "] } { drop }\n}"
+			l := lexer.New(input)
+			p := New(l)
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != len(tt.expectedMessages) {
+				t.Fatalf("Expected %d diagnostics, got %d: %v", len(tt.expectedMessages), len(diagnostics), diagnostics)
+			}
+			for i, message := range tt.expectedMessages {
+				if diagnostics[i].Code != diagnostic.InvalidCommand || diagnostics[i].Message != message {
+					t.Errorf("diagnostics[%d] expected %s %q, got %v", i, diagnostic.InvalidCommand, message, diagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+// an analyzer reporting the same findings for every rule
+type fixedAnalyzer struct {
+	name     string
+	findings []diagnostic.Diagnostic
+}
+
+func (a fixedAnalyzer) Name() string                               { return a.name }
+func (a fixedAnalyzer) Check(*ast.Program) []diagnostic.Diagnostic { return a.findings }
+
+func TestAnalyzers(t *testing.T) {
+	RegisterAnalyzer(fixedAnalyzer{name: "first", findings: []diagnostic.Diagnostic{{Message: "replaced", Line: 1}}})
+	RegisterAnalyzer(fixedAnalyzer{name: "second", findings: []diagnostic.Diagnostic{{Code: diagnostic.PutsInEvent, Message: "kept", Line: 2}}})
+	RegisterAnalyzer(fixedAnalyzer{name: "first", findings: []diagnostic.Diagnostic{{Message: "custom", Line: 1}}})
+	defer UnregisterAnalyzer("first")
+	defer UnregisterAnalyzer("second")
+
+	if names := len(Analyzers()); names != 2 {
+		t.Fatalf("Expected 2 analyzers, got %d", names)
+	}
+
+	p := New(lexer.New("when HTTP_REQUEST {\n pool web_pool\n}"))
+	p.ParseProgram()
+	expected := []diagnostic.Diagnostic{
+		{Code: diagnostic.CustomCheck, Message: "first: custom", Line: 1},
+		{Code: diagnostic.PutsInEvent, Message: "kept", Line: 2},
+	}
+	if diagnostics := p.Diagnostics(); !reflect.DeepEqual(diagnostics, expected) {
+		t.Errorf("expected %v, got %v", expected, diagnostics)
+	}
+}
+
+func TestLintOptions(t *testing.T) {
+	input := `when HTTP_REQUEST {
+  ACME::tag request [HTTP::uri]
+  HTTP::header insert "X-Acme Trace" [ACME::trace]
+  puts "request"
+}`
+	tests := []struct {
+		name          string
+		opts          config.Options
+		expectedCodes []diagnostic.Code
+	}{
+		{
+			name:          "Defaults",
+			expectedCodes: []diagnostic.Code{diagnostic.UnknownNamespace, diagnostic.InvalidCommand, diagnostic.UnknownNamespace, diagnostic.PutsInEvent},
+		},
+		{
+			name: "Custom commands, headers and disabled checks",
+			opts: config.Options{
+				CustomCommands: []string{"ACME::tag", "ACME::trace"},
+				CustomHeaders:  []string{"x-acme trace"},
+				DisabledChecks: []string{string(diagnostic.PutsInEvent)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.PutsSeverity = "warning"
+			result := ValidateWithOptions(input, tt.opts)
+			codes := []diagnostic.Code{}
+			for _, d := range result.Diagnostics {
+				codes = append(codes, d.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.expectedCodes) && (len(codes) > 0 || len(tt.expectedCodes) > 0) {
+				t.Errorf("expected %v, got %v", tt.expectedCodes, result.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestFormatRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "Indentation and closing braces",
+			input:    "when HTTP_REQUEST {\n\tif { $a } {\n  pool a\n      }   else {\npool b }\n}",
+			expected: "when HTTP_REQUEST {\n    if { $a } {\n        pool a\n    } else {\n        pool b }\n}\n",
+		},
+		{
+			name:     "Blank lines and trailing spaces",
+			input:    "\n\nwhen HTTP_REQUEST {   \n  pool a\n\n\n\n  drop\n}\n\n",
+			expected: "when HTTP_REQUEST {\n    pool a\n\n    drop\n}\n",
+		},
+		{
+			name:     "Command substitutions",
+			input:    "when HTTP_REQUEST {\n  set host [ string tolower  [ HTTP::header   value \"X  Host\" ] ]\n}",
+			expected: "when HTTP_REQUEST {\n    set host [string tolower [HTTP::header value \"X  Host\"]]\n}\n",
+		},
+		{
+			name:     "Patterns aren't respaced",
+			input:    "when HTTP_REQUEST {\n  if { [regexp {[ a-z]+} [HTTP::uri]] } { drop }\n}",
+			expected: "when HTTP_REQUEST {\n    if { [regexp {[ a-z]+} [HTTP::uri]] } { drop }\n}\n",
+		},
+		{
+			name:     "Multi-line values are kept",
+			input:    "when HTTP_REQUEST {\n  set msg \"one\n  two\"\n  HTTP::respond 200 content {\n<p>\n  hi\n</p>\n}\n}",
+			expected: "when HTTP_REQUEST {\n    set msg \"one\n  two\"\n    HTTP::respond 200 content {\n<p>\n  hi\n</p>\n}\n}\n",
+		},
+		{
+			name:     "Comments and continued lines",
+			input:    "when HTTP_REQUEST {\n# route {api}\n  log local0. \\\n\"done\"\n}",
+			expected: "when HTTP_REQUEST {\n    # route {api}\n    log local0. \\\n        \"done\"\n}\n",
+		},
+		{
+			name:  "Unclosed brace",
+			input: "when HTTP_REQUEST {\n  pool a\n",
+			err:   "unbalanced braces: 1 { left open",
+		},
+		{
+			name:  "Extra closing brace",
+			input: "when HTTP_REQUEST {\n  pool a\n}\n}",
+			err:   "line 4: unbalanced braces: } without {",
+		},
+		{
+			name:  "Unterminated string",
+			input: "when HTTP_REQUEST {\n  log local0. \"open\n}",
+			err:   "unterminated quoted string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted, err := FormatRule(tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if formatted != tt.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tt.expected, formatted)
+			}
+			if again, _ := FormatRule(formatted); again != formatted {
+				t.Errorf("formatting isn't stable, got:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestFormatRuleWithStyle(t *testing.T) {
+	input := "when HTTP_REQUEST {\n  if { $a } {\n    switch [HTTP::uri] {\n      \"/a\" {\n        pool a\n      }\n      default { pool b }\n    }\n  } else {\n    drop\n  }\n}\n"
+
+	tests := []struct {
+		name     string
+		input    string
+		style    config.Style
+		expected string
+	}{
+		{
+			name:     "Indent width",
+			input:    input,
+			style:    config.Style{Indent: 2},
+			expected: input,
+		},
+		{
+			name:     "Braces on the next line",
+			input:    input,
+			style:    config.Style{Braces: "next-line"},
+			expected: "when HTTP_REQUEST \\\n{\n    if { $a } \\\n    {\n        switch [HTTP::uri] {\n            \"/a\" {\n                pool a\n            }\n            default { pool b }\n        }\n    } else \\\n    {\n        drop\n    }\n}\n",
+		},
+		{
+			name:     "Braces on the next line without a continuation",
+			input:    "when HTTP_REQUEST\n{\n  pool a\n}\n",
+			style:    config.Style{Indent: 2, Braces: "next-line"},
+			expected: "when HTTP_REQUEST \\\n{\n  pool a\n}\n",
+		},
+		{
+			name:     "Braces on the same line",
+			input:    "when HTTP_REQUEST \\\n{\n  if { $a }\n  {\n    pool a\n  } else \\\n      {\n    drop\n  }\n}\n",
+			style:    config.Style{Indent: 2, Braces: "same-line"},
+			expected: "when HTTP_REQUEST {\n  if { $a } {\n    pool a\n  } else {\n    drop\n  }\n}\n",
+		},
+		{
+			name:     "Multi-line condition kept",
+			input:    "when HTTP_REQUEST {\n  if {\n    $a } {\n    pool a\n  }\n}\n",
+			style:    config.Style{Indent: 2, Braces: "next-line"},
+			expected: "when HTTP_REQUEST \\\n{\n  if {\n    $a } {\n    pool a\n  }\n}\n",
+		},
+		{
+			name:     "Cases aligned with their switch",
+			input:    input,
+			style:    config.Style{Indent: 2, SwitchCases: "aligned"},
+			expected: "when HTTP_REQUEST {\n  if { $a } {\n    switch [HTTP::uri] {\n    \"/a\" {\n      pool a\n    }\n    default { pool b }\n    }\n  } else {\n    drop\n  }\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted, err := FormatRuleWithStyle(tt.input, tt.style)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if formatted != tt.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tt.expected, formatted)
+			}
+			if again, _ := FormatRuleWithStyle(formatted, tt.style); again != formatted {
+				t.Errorf("formatting isn't stable, got:\n%s", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// the request values an LTM policy condition can match, by the command
+// reading them
+var policyOperands = map[string]string{
+	"HTTP::host": "http-host host",
+	"HTTP::uri":  "http-uri",
+	"HTTP::path": "http-uri path",
+}
+
+// the policy match types of the comparison operators
+var policyOperators = map[string]string{
+	"equals":      "equals",
+	"eq":          "equals",
+	"==":          "equals",
+	"starts_with": "starts-with",
+	"ends_with":   "ends-with",
+	"contains":    "contains",
+}
+
+// a rule of an LTM policy: a condition, empty for the default rule, and the
+// action taken when it matches
+type policyRule struct {
+	condition string
+	action    string
+}
+
+func (r policyRule) String() string {
+	if r.condition == "" {
+		return "default → " + r.action
+	}
+	return r.condition + " → " + r.action
+}
+
+// reports rules that only pick a pool, redirect or reject HTTP requests by
+// their host or path, which a first-match LTM policy does without an iRule.
+// the check is advisory and only runs with --suggest-policies
+func (p *Parser) checkPolicyCandidate(program *ast.Program) {
+	if !p.opts.SuggestPolicies || len(p.procs) > 0 || len(program.Statements) != 1 {
+		return
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return
+	}
+	when, ok := stmt.Expression.(*ast.WhenExpression)
+	if !ok || when.Event.String() != "HTTP_REQUEST" || when.Block == nil || len(when.Block.Statements) != 1 {
+		return
+	}
+
+	var rules []policyRule
+	switch s := when.Block.Statements[0].(type) {
+	case *ast.IfStatement:
+		rules, ok = ifPolicyRules(s)
+	case *ast.SwitchStatement:
+		rules, ok = switchPolicyRules(s)
+	default:
+		ok = false
+	}
+	if !ok {
+		return
+	}
+
+	described := make([]string, len(rules))
+	for i, rule := range rules {
+		described[i] = rule.String()
+	}
+	p.reportSeverity(diagnostic.Info, diagnostic.PolicyCandidate, "rule could be an LTM policy with %d rules: %s", []any{len(rules), strings.Join(described, "; "), when.Token}...)
+}
+
+// maps an if/elseif/else chain to policy rules
+func ifPolicyRules(stmt *ast.IfStatement) ([]policyRule, bool) {
+	rules := []policyRule{}
+	for stmt != nil {
+		condition, ok := policyCondition(stmt.Condition)
+		if !ok {
+			return nil, false
+		}
+		action, ok := policyAction(stmt.Consequence)
+		if !ok {
+			return nil, false
+		}
+		rules = append(rules, policyRule{condition: condition, action: action})
+
+		if stmt.Alternative == nil {
+			return rules, true
+		}
+		// elseif is an if statement wrapped in the alternative
+		if len(stmt.Alternative.Statements) == 1 {
+			if next, ok := stmt.Alternative.Statements[0].(*ast.IfStatement); ok {
+				stmt = next
+				continue
+			}
+		}
+		action, ok = policyAction(stmt.Alternative)
+		if !ok {
+			return nil, false
+		}
+		return append(rules, policyRule{action: action}), true
+	}
+	return rules, true
+}
+
+// maps a switch on a request value to policy rules. glob patterns are only
+// accepted when a policy match type expresses them
+func switchPolicyRules(stmt *ast.SwitchStatement) ([]policyRule, bool) {
+	if stmt.IsRegex {
+		return nil, false
+	}
+	operand, ok := policyOperand(stmt.Value)
+	if !ok {
+		return nil, false
+	}
+
+	rules := []policyRule{}
+	for i, c := range stmt.Cases {
+		pattern, ok := literalWord(c.Value)
+		if !ok {
+			return nil, false
+		}
+		operator, value := "equals", pattern
+		if stmt.IsGlob {
+			if operator, value, ok = globMatchType(pattern); !ok {
+				return nil, false
+			}
+		}
+		// a case falling through takes the action of the body it runs
+		action, ok := policyAction(stmt.Body(i))
+		if !ok {
+			return nil, false
+		}
+		rules = append(rules, policyRule{condition: operand + " " + operator + " " + value, action: action})
+	}
+	if stmt.Default != nil {
+		action, ok := policyAction(stmt.Default.Consequence)
+		if !ok {
+			return nil, false
+		}
+		rules = append(rules, policyRule{action: action})
+	}
+	return rules, len(rules) > 0
+}
+
+// converts a glob pattern with at most a leading and a trailing * into a
+// policy match type and value
+func globMatchType(pattern string) (string, string, bool) {
+	prefix, suffix := strings.HasPrefix(pattern, "*"), strings.HasSuffix(pattern, "*")
+	value := strings.TrimSuffix(strings.TrimPrefix(pattern, "*"), "*")
+	if value == "" || strings.ContainsAny(value, `*?[]\`) {
+		return "", "", false
+	}
+	switch {
+	case prefix && suffix:
+		return "contains", value, true
+	case prefix:
+		return "ends-with", value, true
+	case suffix:
+		return "starts-with", value, true
+	}
+	return "equals", value, true
+}
+
+// converts a comparison of a request value with a literal into a policy
+// condition
+func policyCondition(condition ast.Expression) (string, bool) {
+	infix, ok := condition.(*ast.InfixExpression)
+	if !ok {
+		return "", false
+	}
+	operator, ok := policyOperators[infix.Operator]
+	if !ok {
+		return "", false
+	}
+	operand, ok := policyOperand(infix.Left)
+	if !ok {
+		return "", false
+	}
+	value, ok := policyLiteral(infix.Right)
+	if !ok {
+		return "", false
+	}
+	return operand + " " + operator + " " + value, true
+}
+
+// returns the policy operand of [HTTP::host], [HTTP::uri] or [HTTP::path],
+// optionally lowercased with string tolower as policy matches ignore case
+func policyOperand(expr ast.Expression) (string, bool) {
+	for {
+		switch e := expr.(type) {
+		case *ast.ArrayLiteral:
+			if len(e.Elements) != 1 {
+				return "", false
+			}
+			expr = e.Elements[0]
+		case *ast.StringOperation:
+			if e.Operation != "tolower" || len(e.Arguments) != 1 {
+				return "", false
+			}
+			expr = e.Arguments[0]
+		case *ast.HttpExpression:
+			if e.Command == nil || e.Method != nil || e.Argument != nil {
+				return "", false
+			}
+			operand, ok := policyOperands[e.Command.Value]
+			return operand, ok
+		default:
+			return "", false
+		}
+	}
+}
+
+// returns a literal without variables or commands substituted into it
+func policyLiteral(expr ast.Expression) (string, bool) {
+	value, ok := literalWord(expr)
+	if !ok || value == "" || strings.ContainsAny(value, "$[") {
+		return "", false
+	}
+	return value, true
+}
+
+// converts the body of a branch into a policy action: pool <name>,
+// HTTP::redirect <location> or reject
+func policyAction(block *ast.BlockStatement) (string, bool) {
+	if block == nil || len(block.Statements) != 1 {
+		return "", false
+	}
+	stmt, ok := block.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return "", false
+	}
+
+	switch e := stmt.Expression.(type) {
+	case *ast.CallExpression:
+		fn, ok := e.Function.(*ast.Identifier)
+		if !ok || fn.Value != "pool" || len(e.Arguments) != 1 {
+			return "", false
+		}
+		name, ok := policyLiteral(e.Arguments[0])
+		if !ok {
+			return "", false
+		}
+		return "forward to pool " + name, true
+	case *ast.HttpExpression:
+		if e.Command == nil || e.Command.Value != "HTTP::redirect" || e.Method != nil {
+			return "", false
+		}
+		location, ok := policyLiteral(e.Argument)
+		if !ok {
+			return "", false
+		}
+		return "redirect to " + location, true
+	case *ast.Identifier:
+		if e.Value == "reject" {
+			return "reset traffic", true
+		}
+	}
+	return "", false
+}
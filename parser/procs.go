@@ -0,0 +1,280 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// matches proc_name, rule::proc_name and /Partition/rule::proc_name
+var callTargetRegex = regexp.MustCompile(`^(?:((?:/[\w.-]+){2,}|[\w.-]+)::)?([a-zA-Z_]\w*)$`)
+
+// ProcCall is a call to a proc. Rule is empty for procs defined in the same
+// rule, otherwise it holds the rule name exactly as written in the call
+type ProcCall struct {
+	Rule string
+	Proc string
+	Args int
+	Line int
+}
+
+// ProcSignature is the number of arguments a proc accepts. parameters with a
+// default are optional and a trailing args parameter takes any number of
+// them, leaving MaxArgs at -1
+type ProcSignature struct {
+	MinArgs int
+	MaxArgs int
+}
+
+func (s ProcSignature) String() string {
+	return CommandSpec{MinArgs: s.MinArgs, MaxArgs: s.MaxArgs}.describeArgs()
+}
+
+// accepts reports whether a call with this many arguments matches
+func (s ProcSignature) accepts(args int) bool {
+	return args >= s.MinArgs && (s.MaxArgs < 0 || args <= s.MaxArgs)
+}
+
+// Library maps the name of a rule, with or without its partition, to the
+// procs it defines, so calls into shared library rules can be resolved
+type Library map[string]map[string]ProcSignature
+
+// Add registers the procs of a parsed rule file. the procs of every ltm rule
+// of a configuration are registered under the name of their rule, the procs
+// of any other file under name
+func (lib Library) Add(name string, p *Parser) {
+	for rule, procs := range p.ruleProcs {
+		lib[rule] = procs
+	}
+	if len(p.ruleProcs) == 0 {
+		lib[name] = p.procs
+	}
+}
+
+func (pc ProcCall) String() string {
+	if pc.Rule == "" {
+		return pc.Proc
+	}
+	return pc.Rule + "::" + pc.Proc
+}
+
+// returns the names of the procs defined in the parsed rule
+func (p *Parser) Procs() []string {
+	procs := []string{}
+	for name := range p.procs {
+		procs = append(procs, name)
+	}
+	return procs
+}
+
+// returns every call made to a proc in another rule
+func (p *Parser) ProcCalls() []ProcCall {
+	calls := []ProcCall{}
+	for _, call := range p.procCalls {
+		if call.Rule != "" {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (p *Parser) parseProcStatement() ast.Statement {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseProcStatement Start - Current token: %s\n", p.curToken.Literal)
+	}
+	stmt := &ast.ProcStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		p.reportError("parseProcStatement: expected proc name, got %v", p.curToken.Literal)
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseProcStatement: expected parameter list, got %v", p.curToken.Literal)
+		return nil
+	}
+
+	// parameters are either a name or a {name default} pair
+	signature := ProcSignature{}
+	for !p.peekTokenIs(token.RBRACE) && !p.peekTokenIs(token.EOF) {
+		p.nextToken()
+		if p.curTokenIs(token.LBRACE) {
+			p.nextToken()
+			stmt.Parameters = append(stmt.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+				p.nextToken()
+			}
+			signature.MaxArgs++
+			continue
+		}
+		stmt.Parameters = append(stmt.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		signature.MaxArgs++
+		signature.MinArgs = signature.MaxArgs
+	}
+	if n := len(stmt.Parameters); n > 0 && stmt.Parameters[n-1].Value == "args" {
+		signature.MaxArgs = -1
+		signature.MinArgs = min(signature.MinArgs, n-1)
+	}
+	p.nextToken() // closing brace of the parameter list
+
+	for _, param := range stmt.Parameters {
+		p.declaredVariables[param.Value] = true
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseProcStatement: expected proc body, got %v", p.curToken.Literal)
+		return nil
+	}
+	outerVariables := p.currentVariables
+	p.currentVariables = newProcVariables(stmt.Name.Value, p.currentRule, stmt.Parameters)
+	p.variables = append(p.variables, p.currentVariables)
+	stmt.Body = p.parseRoutineBody()
+	p.currentVariables = outerVariables
+	p.procs[stmt.Name.Value] = signature
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseProcStatement End - Proc: %s, Parameters: %d\n", stmt.Name.Value, len(stmt.Parameters))
+	}
+	return stmt
+}
+
+// parses call [[/Partition/]rule::]proc_name ?arg ...?
+func (p *Parser) parseCallCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseCallCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	cmd := &ast.CommandInvocation{Token: p.curToken, Command: p.curToken.Literal}
+
+	if p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "call expects a proc name")
+		return cmd
+	}
+	p.nextToken()
+
+	call := -1
+	if p.curTokenIs(token.IDENT) && !strings.HasPrefix(p.curToken.Literal, "$") {
+		target := p.curToken.Literal
+		cmd.Arguments = append(cmd.Arguments, &ast.Identifier{Token: p.curToken, Value: target})
+
+		match := callTargetRegex.FindStringSubmatch(target)
+		if match == nil {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid call target '%s', expected proc, rule::proc or /Partition/rule::proc", []any{target, p.curToken}...)
+		} else {
+			call = len(p.procCalls)
+			p.procCalls = append(p.procCalls, ProcCall{Rule: match[1], Proc: match[2], Line: p.curToken.Line})
+		}
+	} else if target := p.parseCommandArgument(); target != nil {
+		// the target is computed at runtime and can't be resolved
+		cmd.Arguments = append(cmd.Arguments, target)
+	}
+
+	args := p.parseCommandArguments()
+	cmd.Arguments = append(cmd.Arguments, args...)
+	if call >= 0 {
+		p.procCalls[call].Args = len(args)
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseCallCommand End - Arguments: %d\n", len(cmd.Arguments))
+	}
+	return cmd
+}
+
+func shiftProcCalls(calls []ProcCall, offset int) []ProcCall {
+	shifted := []ProcCall{}
+	for _, call := range calls {
+		call.Line += offset
+		shifted = append(shifted, call)
+	}
+	return shifted
+}
+
+func (p *Parser) resolveLocalProcCalls() {
+	for _, call := range p.procCalls {
+		if call.Rule != "" {
+			continue
+		}
+		signature, ok := p.procs[call.Proc]
+		if !ok {
+			p.reportDiagnostic(diagnostic.UndefinedProc, "call to undefined proc '%s'", []any{call.Proc, call.Line}...)
+			continue
+		}
+		if !signature.accepts(call.Args) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: proc %s expects %s, got %d", []any{call.Proc, signature, call.Args, call.Line}...)
+		}
+	}
+}
+
+// ResolveProcCalls checks calls into other rules against the procs those rules
+// define. library maps a rule name, with or without its partition, to its procs
+func ResolveProcCalls(calls []ProcCall, library map[string][]string) []diagnostic.Diagnostic {
+	lib := Library{}
+	for rule, procs := range library {
+		lib[rule] = map[string]ProcSignature{}
+		for _, proc := range procs {
+			lib[rule][proc] = ProcSignature{MinArgs: 0, MaxArgs: -1}
+		}
+	}
+	return ResolveLibraryCalls(calls, lib)
+}
+
+// ResolveLibraryCalls checks calls into other rules against the procs of the
+// library, and the number of arguments each call passes
+func ResolveLibraryCalls(calls []ProcCall, library Library) []diagnostic.Diagnostic {
+	diagnostics := []diagnostic.Diagnostic{}
+
+	for _, call := range calls {
+		procs, found := lookupRule(call.Rule, library)
+		if !found {
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{
+				Code:    diagnostic.UndefinedProc,
+				Message: fmt.Sprintf("call to %s: rule '%s' not found", call, call.Rule),
+				Line:    call.Line,
+			})
+			continue
+		}
+
+		signature, defined := procs[call.Proc]
+		switch {
+		case !defined:
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{
+				Code:    diagnostic.UndefinedProc,
+				Message: fmt.Sprintf("call to %s: rule '%s' has no proc '%s'", call, call.Rule, call.Proc),
+				Line:    call.Line,
+			})
+		case !signature.accepts(call.Args):
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{
+				Code:    diagnostic.InvalidCommand,
+				Message: fmt.Sprintf("wrong # args: proc %s expects %s, got %d", call, signature, call.Args),
+				Line:    call.Line,
+			})
+		}
+	}
+
+	return diagnostics
+}
+
+// a rule referenced without a partition matches the rule of that name in any
+// partition
+func lookupRule(rule string, library Library) (map[string]ProcSignature, bool) {
+	if procs, ok := library[rule]; ok {
+		return procs, true
+	}
+	for name, procs := range library {
+		if !strings.HasPrefix(rule, "/") || !strings.HasPrefix(name, "/") {
+			if ruleBaseName(name) == ruleBaseName(rule) {
+				return procs, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func ruleBaseName(rule string) string {
+	return rule[strings.LastIndex(rule, "/")+1:]
+}
@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// parses regsub ?switches? exp string subSpec varName. the variable is set
+// to the string with the substitutions made
+func (p *Parser) parseRegsubCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegsubCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	expr := &ast.RegsubExpression{Token: p.curToken}
+
+	var args []ast.Expression
+	expr.Flags, args = p.parseRegexpSwitches(expr.Token, validRegsubFlags)
+	if len(args) != 4 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"regsub ?switches? exp string subSpec varName\"", []any{expr.Token}...)
+		return nil
+	}
+	expr.Pattern, expr.InputString, expr.Replacement = args[0], args[1], args[2]
+	expr.ResultVar = p.regexpVariable(args[3])
+	p.checkRegexpPattern(expr.Token, expr.Pattern)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegsubCommand End - Flags: %v, ResultVar: %v\n", expr.Flags, expr.ResultVar)
+	}
+	return expr
+}
+
+// parses regexp ?switches? exp string ?matchVar? ?subMatchVar ...?. the match
+// variables are set to the match and to what each capture group matched
+func (p *Parser) parseRegexpCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegexpCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	expr := &ast.RegexpExpression{Token: p.curToken}
+
+	var args []ast.Expression
+	expr.Flags, args = p.parseRegexpSwitches(expr.Token, validRegexpFlags)
+	if len(args) < 2 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"regexp ?switches? exp string ?matchVar? ?subMatchVar ...?\"", []any{expr.Token}...)
+		return nil
+	}
+	expr.Pattern, expr.InputString = args[0], args[1]
+	for _, arg := range args[2:] {
+		if v := p.regexpVariable(arg); v != nil {
+			expr.MatchVars = append(expr.MatchVars, v)
+		}
+	}
+
+	if containsString(expr.Flags, "-inline") && len(expr.MatchVars) > 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "regexp match variables not allowed when using -inline", []any{expr.Token}...)
+	}
+	compiled := p.checkRegexpPattern(expr.Token, expr.Pattern)
+	if compiled != nil && !containsString(expr.Flags, "-about") && len(expr.MatchVars) > compiled.NumSubexp()+1 {
+		p.reportWarning(diagnostic.InvalidCommand, "regexp sets %d match variables but the pattern has only %d capture group(s)", []any{len(expr.MatchVars), compiled.NumSubexp(), expr.Token}...)
+	}
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseRegexpCommand End - Flags: %v, MatchVars: %v\n", expr.Flags, expr.MatchVars)
+	}
+	return expr
+}
+
+// parses the words of a regexp or regsub command and splits off its leading
+// switches, checking them against the valid ones. -- ends the switches so a
+// pattern may start with -
+func (p *Parser) parseRegexpSwitches(command token.Token, valid map[string]bool) ([]string, []ast.Expression) {
+	args := p.parseRegexpWords()
+
+	flags := []string{}
+	for len(args) > 0 {
+		flag, ok := literalWord(args[0])
+		if !ok || !strings.HasPrefix(flag, "-") {
+			break
+		}
+		args = args[1:]
+		if flag == "--" {
+			break
+		}
+
+		takesValue, known := valid[flag]
+		if !known {
+			switches := []string{}
+			for name := range valid {
+				switches = append(switches, name)
+			}
+			sort.Strings(switches)
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid switch '%s' for %s, expected one of %s", []any{flag, command.Literal, strings.Join(switches, ", "), command}...)
+			continue
+		}
+		flags = append(flags, flag)
+		if takesValue && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	return flags, args
+}
+
+// parses the words up to the end of the command. a bare word the lexer
+// splits, such as /path/+ or ->, is joined back into one
+func (p *Parser) parseRegexpWords() []ast.Expression {
+	args := []ast.Expression{}
+	for !p.peekIsCommandEnd() && !p.peekTokenIs(token.RBRACKET) && !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case token.LBRACKET, token.LBRACE, token.STRING, token.REGEX:
+			arg := p.parseCommandArgument()
+			if arg == nil {
+				return args
+			}
+			args = append(args, arg)
+			continue
+		}
+
+		word := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		for p.peekIsAdjacent() && !p.peekTokenIs(token.LBRACKET) && !p.peekTokenIs(token.RBRACKET) &&
+			!p.peekTokenIs(token.RBRACE) && !p.peekIsCommandEnd() {
+			p.nextToken()
+			word.Value += p.curToken.Literal
+		}
+		args = append(args, word)
+	}
+	return args
+}
+
+// returns the variable a regexp or regsub argument names, declaring it
+func (p *Parser) regexpVariable(arg ast.Expression) *ast.Identifier {
+	name, ok := literalWord(arg)
+	if !ok || strings.HasPrefix(name, "-") && name != "->" {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "expected a variable name, got '%s'", []any{arg.String(), tokenOf(arg)}...)
+		return nil
+	}
+	ident := &ast.Identifier{Token: tokenOf(arg), Value: name}
+	p.declareSetVariable(name)
+	return ident
+}
+
+// returns the token an argument starts with
+func tokenOf(arg ast.Expression) token.Token {
+	switch arg := arg.(type) {
+	case *ast.Identifier:
+		return arg.Token
+	case *ast.StringLiteral:
+		return arg.Token
+	case *ast.NumberLiteral:
+		return arg.Token
+	case *ast.ArrayLiteral:
+		return arg.Token
+	}
+	return token.Token{Literal: arg.TokenLiteral()}
+}
+
+// tcl's word boundary escapes and their go equivalents
+var tclRegexpEscapes = strings.NewReplacer(`\m`, `\b`, `\M`, `\b`, `\y`, `\b`, `\Y`, `\B`, `\Z`, `\z`)
+
+// compiles a literal pattern to catch bad syntax such as unbalanced
+// parentheses. tcl's regular expressions support more than go's, such as
+// back references and lookahead, so only syntax errors both share are
+// reported. returns the compiled pattern, nil when it isn't known
+func (p *Parser) checkRegexpPattern(command token.Token, arg ast.Expression) *regexp.Regexp {
+	pattern, ok := regexpPatternText(arg)
+	if !ok {
+		return nil
+	}
+
+	compiled, err := regexp.Compile(tclRegexpEscapes.Replace(pattern))
+	var syntaxErr *syntax.Error
+	if errors.As(err, &syntaxErr) {
+		switch syntaxErr.Code {
+		case syntax.ErrMissingParen, syntax.ErrUnexpectedParen, syntax.ErrMissingBracket,
+			syntax.ErrMissingRepeatArgument, syntax.ErrInvalidRepeatOp, syntax.ErrInvalidRepeatSize,
+			syntax.ErrInvalidCharRange, syntax.ErrTrailingBackslash:
+			p.reportDiagnostic(diagnostic.InvalidPattern, "couldn't compile regular expression pattern '%s' of %s: %s", []any{pattern, command.Literal, syntaxErr.Code.String(), command}...)
+		}
+		return nil
+	}
+	return compiled
+}
+
+// returns the text of a pattern known before runtime. a quoted pattern is
+// substituted first, so "\d" reaches the regexp engine as d
+func regexpPatternText(arg ast.Expression) (string, bool) {
+	switch arg := arg.(type) {
+	case *ast.RegexPattern:
+		return arg.Value, true
+	case *ast.StringLiteral:
+		if arg.Token.Type != token.STRING {
+			return arg.Value, true
+		}
+		if strings.ContainsAny(arg.Value, "$[") {
+			return "", false
+		}
+		return tclUnescape(arg.Value), true
+	case *ast.Identifier:
+		if strings.ContainsAny(arg.Value, "$[") {
+			return "", false
+		}
+		return tclUnescape(arg.Value), true
+	}
+	return "", false
+}
+
+// applies tcl's backslash substitution to a quoted or bare word
+func tclUnescape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
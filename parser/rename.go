@@ -0,0 +1,317 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elkrammer/irule-validator/lexer"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// a variable name a rename accepts, optionally in the static namespace
+var renameNameRegex = regexp.MustCompile(`^(?:static::)?[A-Za-z_]\w*$`)
+
+// the words of a command that name a variable, by command. i is the position
+// of the word among the arguments, starting at 1, and args holds them all
+var variableArguments = map[string]func(i int, args []string) bool{
+	"set":     func(i int, args []string) bool { return i == 1 },
+	"incr":    func(i int, args []string) bool { return i == 1 },
+	"append":  func(i int, args []string) bool { return i == 1 },
+	"lappend": func(i int, args []string) bool { return i == 1 },
+	"unset":   func(i int, args []string) bool { return !strings.HasPrefix(args[i-1], "-") },
+	"foreach": func(i int, args []string) bool { return i%2 == 1 && i < len(args) },
+	"lassign": func(i int, args []string) bool { return i >= 2 },
+	"catch":   func(i int, args []string) bool { return i >= 2 },
+	"info":    func(i int, args []string) bool { return i == 2 && args[0] == "exists" },
+	"array":   func(i int, args []string) bool { return i == 2 },
+	"scan":    func(i int, args []string) bool { return positional(i, args) >= 3 },
+	"regexp":  func(i int, args []string) bool { return positional(i, args) >= 3 },
+	"regsub":  func(i int, args []string) bool { return positional(i, args) == 4 },
+}
+
+// returns the position of an argument among those that follow the leading
+// options, starting at 1, or 0 for an option
+func positional(i int, args []string) int {
+	first := 0
+	for first < len(args) && strings.HasPrefix(args[first], "-") {
+		first++
+		if args[first-1] == "--" {
+			break
+		}
+	}
+	return i - first
+}
+
+// Rename is a single occurrence of a renamed variable
+type Rename struct {
+	Line   int
+	Column int // 1-based, counted in characters
+}
+
+// RenameVariable renames the variable old to new wherever the rule sets or
+// reads it: $old, ${old} and $old(key) references, references inside quoted
+// strings, and the words naming it in commands such as set, foreach or
+// regsub. comments, braced literals and other words spelled like the variable
+// are left alone, while braced bodies and conditions are renamed. it returns
+// the renamed rule and the renamed occurrences
+func RenameVariable(input, old, new string) (string, []Rename, error) {
+	for _, name := range []string{old, new} {
+		if !renameNameRegex.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	if old == new {
+		return input, nil, nil
+	}
+	if len(variableOccurrences(input, new)) > 0 {
+		return "", nil, fmt.Errorf("variable %s is already used", new)
+	}
+
+	occurrences := variableOccurrences(input, old)
+	sort.Slice(occurrences, func(i, j int) bool {
+		if occurrences[i].Line != occurrences[j].Line {
+			return occurrences[i].Line < occurrences[j].Line
+		}
+		return occurrences[i].Column < occurrences[j].Column
+	})
+
+	// replace from the end so the columns of earlier occurrences stay valid
+	lines := strings.SplitAfter(input, "\n")
+	for i := len(occurrences) - 1; i >= 0; i-- {
+		o := occurrences[i]
+		line := lines[o.Line-1]
+		offset := byteOffset(line, o.Column)
+		lines[o.Line-1] = line[:offset] + new + line[offset+len(old):]
+	}
+	return strings.Join(lines, ""), occurrences, nil
+}
+
+// the braced words of a command tcl evaluates as a script or an expression,
+// by command. i is the position of the word among the arguments, starting at
+// 1, and args holds them all. the other braced words are literals
+var evaluatedArguments = map[string]func(i int, args []string) bool{
+	"when":    func(i int, args []string) bool { return true },
+	"if":      func(i int, args []string) bool { return true }, // conditions and bodies, elseif and else included
+	"while":   func(i int, args []string) bool { return true },
+	"for":     func(i int, args []string) bool { return true },
+	"expr":    func(i int, args []string) bool { return true },
+	"eval":    func(i int, args []string) bool { return true },
+	"uplevel": func(i int, args []string) bool { return true },
+	"after":   func(i int, args []string) bool { return true },
+	"ltm":     func(i int, args []string) bool { return true },
+	"switch":  func(i int, args []string) bool { return true }, // the patterns are words of its body
+	"catch":   func(i int, args []string) bool { return i == 1 },
+	"foreach": func(i int, args []string) bool { return i == len(args) },
+	"proc":    func(i int, args []string) bool { return i == 3 },
+}
+
+// a command being read, with the words seen so far
+type renameCommand struct {
+	words      []string
+	candidates []renameCandidate
+	braced     []renameBraced
+}
+
+// a bare word spelled like the variable, renamed if its command takes a
+// variable name at its position
+type renameCandidate struct {
+	word int
+	at   Rename
+}
+
+// the occurrences inside a braced word, renamed if its command evaluates the
+// word
+type renameBraced struct {
+	word  int
+	found []Rename
+}
+
+// the commands inside an open bracket or brace, or the rule itself
+type renameFrame struct {
+	cmd        renameCommand
+	found      []Rename // the occurrences of the commands finished so far
+	brace      bool
+	word       int  // the word of the enclosing command the frame opened in
+	switchBody bool // a switch body, whose words are patterns and bodies
+}
+
+// keeps the occurrences of the command read so far that name the variable
+// and starts the next one
+func (f *renameFrame) finish() {
+	cmd := f.cmd
+	f.cmd = renameCommand{}
+	if len(cmd.words) == 0 {
+		return
+	}
+	if isVariable, ok := variableArguments[cmd.words[0]]; ok {
+		for _, c := range cmd.candidates {
+			if isVariable(c.word, cmd.words[1:]) {
+				f.found = append(f.found, c.at)
+			}
+		}
+	}
+	for _, b := range cmd.braced {
+		if f.evaluates(cmd.words, b.word) {
+			f.found = append(f.found, b.found...)
+		}
+	}
+}
+
+// reports whether the braced word at position i of a command is evaluated.
+// the bodies of a switch follow their patterns, and a braced word starting a
+// command is a block
+func (f *renameFrame) evaluates(words []string, i int) bool {
+	if f.switchBody {
+		return i%2 == 1
+	}
+	if i == 0 {
+		return true
+	}
+	isEvaluated, ok := evaluatedArguments[words[0]]
+	return ok && isEvaluated(i, words[1:])
+}
+
+// returns the position of the first character of every occurrence of name.
+// occurrences in braced words are only returned when the word is evaluated,
+// as the body of if or the condition of while are, and not in a literal
+// such as the message of log local0. {$name}
+func variableOccurrences(input, name string) []Rename {
+	lines := strings.SplitAfter(input, "\n")
+
+	stack := []*renameFrame{{}}
+	// closes the innermost frame, handing its occurrences to the command it
+	// opened in
+	pop := func() {
+		frame := stack[len(stack)-1]
+		frame.finish()
+		stack = stack[:len(stack)-1]
+		parent := stack[len(stack)-1]
+		if frame.brace {
+			parent.cmd.braced = append(parent.cmd.braced, renameBraced{word: frame.word, found: frame.found})
+		} else {
+			parent.found = append(parent.found, frame.found...)
+		}
+	}
+
+	l := lexer.New(input)
+	prev := token.Token{Type: token.SEMICOLON}
+	for tok := l.NextToken(); tok.Type != token.EOF; prev, tok = tok, l.NextToken() {
+		frame := stack[len(stack)-1]
+		if tok.LineStart || prev.Type == token.SEMICOLON {
+			frame.finish()
+		}
+		cmd := &frame.cmd
+
+		newWord := len(cmd.words) == 0 || startsWord(lines, tok)
+		switch {
+		case tok.Type == token.SEMICOLON:
+			continue
+		case tok.Type == token.RBRACKET || tok.Type == token.RBRACE:
+			if len(stack) > 1 {
+				pop()
+			}
+			continue
+		}
+
+		if newWord {
+			cmd.words = append(cmd.words, tok.Literal)
+		} else if n := len(cmd.words); n > 0 {
+			cmd.words[n-1] += tok.Literal
+		}
+
+		switch {
+		case tok.Type == token.LBRACKET || tok.Type == token.LBRACE:
+			// ${name} lexes as $, {, name and }
+			if tok.Type == token.LBRACE && prev.Literal == "$" && !newWord {
+				next := l.NextToken()
+				if next.Literal == name {
+					frame.found = append(frame.found, Rename{Line: next.Line, Column: next.Column})
+				}
+				for next.Type != token.RBRACE && next.Type != token.EOF {
+					next = l.NextToken()
+				}
+				continue
+			}
+			stack = append(stack, &renameFrame{
+				brace:      tok.Type == token.LBRACE,
+				word:       len(cmd.words) - 1,
+				switchBody: tok.Type == token.LBRACE && cmd.words[0] == "switch",
+			})
+		case tok.Type == token.STRING:
+			frame.found = append(frame.found, stringOccurrences(lines, tok, name)...)
+		case tok.Literal == "$"+name:
+			frame.found = append(frame.found, Rename{Line: tok.Line, Column: tok.Column + 1})
+		case tok.Literal == name && newWord && len(cmd.words) > 1:
+			cmd.candidates = append(cmd.candidates, renameCandidate{word: len(cmd.words) - 1, at: Rename{Line: tok.Line, Column: tok.Column}})
+		}
+	}
+	// braces left open are taken as evaluated
+	for len(stack) > 1 {
+		stack[len(stack)-1].brace = false
+		pop()
+	}
+	stack[0].finish()
+	return stack[0].found
+}
+
+// reports whether whitespace separates a token from the one before it
+func startsWord(lines []string, tok token.Token) bool {
+	if tok.Line < 1 || tok.Line > len(lines) || tok.Column <= 1 {
+		return true
+	}
+	line := lines[tok.Line-1]
+	before, _ := utf8.DecodeLastRuneInString(line[:byteOffset(line, tok.Column)])
+	return unicode.IsSpace(before)
+}
+
+// finds $name and ${name} in a quoted string, skipping escaped dollar signs
+func stringOccurrences(lines []string, tok token.Token, name string) []Rename {
+	if tok.Line < 1 || tok.Line > len(lines) {
+		return nil
+	}
+	// the literal of a string starts after its opening quote. strings
+	// spanning lines aren't renamed
+	line := lines[tok.Line-1]
+	start := byteOffset(line, tok.Column+1)
+	if !strings.HasPrefix(line[start:], tok.Literal) {
+		return nil
+	}
+
+	occurrences := []Rename{}
+	text := tok.Literal
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\':
+			i++
+		case text[i] != '$':
+		case strings.HasPrefix(text[i+1:], "{"+name+"}"):
+			occurrences = append(occurrences, Rename{Line: tok.Line, Column: tok.Column + 1 + utf8.RuneCountInString(text[:i+2])})
+		case strings.HasPrefix(text[i+1:], name) && !continuesName(text[i+1+len(name):]):
+			occurrences = append(occurrences, Rename{Line: tok.Line, Column: tok.Column + 1 + utf8.RuneCountInString(text[:i+1])})
+		}
+	}
+	return occurrences
+}
+
+// reports whether the text after a variable name still belongs to the name
+func continuesName(rest string) bool {
+	if strings.HasPrefix(rest, "::") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// converts a 1-based character column into a byte offset of line
+func byteOffset(line string, column int) int {
+	offset := 0
+	for i := 1; i < column && offset < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	return offset
+}
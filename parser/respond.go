@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// the characters a header name may consist of, an http token
+var headerTokenRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// the HTTP::respond -version values
+var respondVersions = []string{"1.0", "1.1", "auto"}
+
+// statuses that send the client elsewhere and need a Location header
+var redirectStatuses = map[int]bool{301: true, 302: true, 303: true, 307: true, 308: true}
+
+// parses HTTP::respond status ?-version version? ?content value? ?noserver?
+// ?-reset? ?name value ...?. the options and header pairs may come in any
+// order after the status
+func (p *Parser) parseHttpRespondCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseHttpRespondCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	expr := &ast.HttpRespondExpression{Token: p.curToken}
+
+	args := p.parseCommandArguments()
+	if len(args) == 0 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"HTTP::respond status ?content value? ?noserver? ?name value ...?\"", []any{expr.Token}...)
+		return expr
+	}
+	expr.Status = args[0]
+
+	for i := 1; i < len(args); i++ {
+		word, literal := literalWord(args[i])
+		switch {
+		case literal && (word == "content" || word == "-version"):
+			if i+1 == len(args) {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::respond %s expects a value", []any{word, expr.Token}...)
+				continue
+			}
+			i++
+			if word == "-version" {
+				expr.Version = args[i]
+				if version, ok := literalWord(args[i]); ok && !containsString(respondVersions, version) {
+					p.reportDiagnostic(diagnostic.InvalidCommand, "invalid HTTP::respond -version '%s', expected one of %s", []any{version, strings.Join(respondVersions, ", "), expr.Token}...)
+				}
+				continue
+			}
+			if expr.Content != nil {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::respond is given content twice", []any{expr.Token}...)
+			}
+			expr.Content = args[i]
+		case literal && (word == "noserver" || word == "-reset"):
+			expr.Flags = append(expr.Flags, word)
+		case i+1 == len(args):
+			if !literal {
+				word = args[i].String()
+			}
+			p.reportDiagnostic(diagnostic.InvalidCommand, "HTTP::respond header %s has no value", []any{word, expr.Token}...)
+		default:
+			if literal && !headerTokenRegex.MatchString(word) && !p.isCustomHeader(word) {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "invalid header name '%s' for HTTP::respond", []any{word, expr.Token}...)
+			}
+			expr.Headers = append(expr.Headers, ast.HttpHeaderField{Name: args[i], Value: args[i+1]})
+			i++
+		}
+	}
+	p.checkRespondStatus(expr)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseHttpRespondCommand End - %s\n", expr.String())
+	}
+	return expr
+}
+
+// reports a literal status that isn't an http status code, and a redirect
+// without a Location header
+func (p *Parser) checkRespondStatus(expr *ast.HttpRespondExpression) {
+	word, ok := literalWord(expr.Status)
+	if !ok {
+		return
+	}
+	status, err := strconv.Atoi(word)
+	if err != nil || status < 100 || status > 599 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid HTTP::respond status '%s', expected a code from 100 to 599", []any{word, expr.Token}...)
+		return
+	}
+	if !redirectStatuses[status] {
+		return
+	}
+	for _, header := range expr.Headers {
+		name, ok := literalWord(header.Name)
+		if !ok || strings.EqualFold(name, "Location") {
+			return
+		}
+	}
+	p.reportWarning(diagnostic.InvalidCommand, "HTTP::respond %d redirects but sets no Location header", []any{status, expr.Token}...)
+}
+
+// parses HTTP::redirect url
+func (p *Parser) parseHttpRedirectCommand() ast.Expression {
+	expr := &ast.HttpExpression{Token: p.curToken, Command: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+
+	args := p.parseCommandArguments()
+	if len(args) != 1 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: HTTP::redirect expects 1 argument(s), got %d", []any{len(args), expr.Token}...)
+	}
+	if len(args) > 0 {
+		expr.Argument = args[0]
+	}
+	return expr
+}
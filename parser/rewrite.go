@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// commands returning the request URI, or a part of it, as the client sent it,
+// that is already URI encoded
+var encodedRequestCommands = []string{"HTTP::uri", "HTTP::path", "HTTP::query"}
+
+// checks the value HTTP::uri or HTTP::path rewrites the request with. a value
+// known before runtime must begin with /, and a string map must not replace a
+// leading / with something else
+func (p *Parser) checkURIRewrite(expr *ast.HttpExpression) {
+	command := expr.Command.Value
+
+	if value, ok := literalWord(expr.Argument); ok {
+		if !strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "$") && !strings.HasPrefix(value, "[") {
+			p.reportWarning(diagnostic.URIRewrite, "%s rewrite to '%s' doesn't begin with /", []any{command, value, expr.Token}...)
+		}
+		return
+	}
+
+	mapping, ok := stringMapOf(expr.Argument)
+	if !ok {
+		return
+	}
+	replaced := []string{}
+	for key, value := range mapping.Pairs {
+		from, ok := literalWord(key)
+		if !ok {
+			continue
+		}
+		// empty map values parse as nil
+		to := ""
+		if value != nil {
+			if to, ok = literalWord(value); !ok {
+				continue
+			}
+		}
+		// an empty replacement keeps the slash following the replaced text
+		dropsSlash := strings.HasPrefix(from, "/") && to != "" && !strings.HasPrefix(to, "/")
+		if dropsSlash || (to == "" && from != "/" && strings.HasSuffix(from, "/")) {
+			replaced = append(replaced, from)
+		}
+	}
+	sort.Strings(replaced)
+	for _, from := range replaced {
+		p.reportWarning(diagnostic.URIRewrite, "%s rewrite with string map replaces '%s' so the new value can lose its leading /", []any{command, from, expr.Token}...)
+	}
+}
+
+// returns the pairs of a [string map {...} ...] argument
+func stringMapOf(arg ast.Expression) (*ast.MapLiteral, bool) {
+	array, ok := arg.(*ast.ArrayLiteral)
+	if !ok || len(array.Elements) != 1 {
+		return nil, false
+	}
+	op, ok := array.Elements[0].(*ast.StringOperation)
+	if !ok || op.Operation != "map" {
+		return nil, false
+	}
+	for _, arg := range op.Arguments {
+		if mapping, ok := arg.(*ast.MapLiteral); ok {
+			return mapping, true
+		}
+	}
+	return nil, false
+}
+
+// URI::encode <string>. encoding the request URI or a value already encoded
+// encodes its % signs a second time
+func checkURIEncode(p *Parser, cmd *ast.CommandInvocation) {
+	array, ok := cmd.Arguments[0].(*ast.ArrayLiteral)
+	if !ok || len(array.Elements) != 1 {
+		return
+	}
+	switch inner := array.Elements[0].(type) {
+	case *ast.HttpExpression:
+		if inner.Command != nil && inner.Argument == nil && containsString(encodedRequestCommands, inner.Command.Value) {
+			p.reportWarning(diagnostic.URIRewrite, "URI::encode of [%s] encodes the already encoded request again, use URI::decode first", []any{inner.Command.Value, cmd.Token}...)
+		}
+	case *ast.CommandInvocation:
+		if inner.Command == "URI::encode" {
+			p.reportWarning(diagnostic.URIRewrite, "URI::encode of [URI::encode ...] encodes the value twice", []any{cmd.Token}...)
+		}
+	}
+}
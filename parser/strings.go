@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// the arguments a string subcommand accepts, not counting its options
+type stringSignature struct {
+	min, max     int
+	options      map[string]bool // flag -> whether it takes a value
+	optionsAfter int             // operands before the options, e.g. the class of string is
+	usage        string
+}
+
+// returns the names of the string subcommands, sorted
+func stringSubcommands() []string {
+	names := make([]string, 0, len(stringSignatures))
+	for name := range stringSignatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checks the arguments of a string subcommand against its signature,
+// reporting them the way tcl does. returns the operands without the options,
+// and whether they matched
+func (p *Parser) checkStringArguments(op *ast.StringOperation, signature stringSignature) ([]ast.Expression, bool) {
+	operands := append([]ast.Expression{}, op.Arguments[:min(signature.optionsAfter, len(op.Arguments))]...)
+	rest := op.Arguments[len(operands):]
+	for len(signature.options) > 0 && len(rest) > 0 {
+		flag, ok := rest[0].(*ast.Identifier)
+		if !ok || !strings.HasPrefix(flag.Value, "-") {
+			break
+		}
+		takesValue, known := signature.options[flag.Value]
+		if !known {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for string %s, expected one of %s", []any{flag.Value, op.Operation, strings.Join(sortedKeys(signature.options), ", "), op.Token}...)
+			return nil, false
+		}
+		rest = rest[1:]
+		if takesValue {
+			if len(rest) == 0 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"%s\"", []any{signature.usage, op.Token}...)
+				return nil, false
+			}
+			rest = rest[1:]
+		}
+	}
+	operands = append(operands, rest...)
+
+	if len(operands) < signature.min || len(operands) > signature.max {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: should be \"%s\"", []any{signature.usage, op.Token}...)
+		return operands, false
+	}
+	return operands, true
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,282 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the options of switch and whether each takes a value
+var switchOptions = map[string]bool{
+	"-exact":    false,
+	"-glob":     false,
+	"-regex":    false,
+	"-regexp":   false,
+	"-nocase":   false,
+	"-matchvar": true,
+	"-indexvar": true,
+	"--":        false,
+}
+
+// parses the options of a switch up to its value. -- ends the options so a
+// value starting with - isn't taken for one
+func (p *Parser) parseSwitchOptions(stmt *ast.SwitchStatement) {
+	modes := []string{}
+	variables := []string{}
+
+options:
+	for p.curTokenIs(token.MINUS) && p.peekTokenIs(token.IDENT) && p.peekIsAdjacent() {
+		p.nextToken()
+		option := "-" + p.curToken.Literal
+		p.nextToken() // move past the option
+
+		takesValue, ok := switchOptions[option]
+		if !ok {
+			valid := []string{}
+			for name := range switchOptions {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for switch, expected one of %s", []any{option, strings.Join(valid, ", "), stmt.Token}...)
+			continue
+		}
+		stmt.Options = append(stmt.Options, option)
+
+		switch option {
+		case "--":
+			break options
+		case "-exact":
+			modes = append(modes, option)
+		case "-glob":
+			stmt.IsGlob = true
+			modes = append(modes, option)
+		case "-regex", "-regexp":
+			stmt.IsRegex = true
+			modes = append(modes, option)
+		case "-nocase":
+			stmt.NoCase = true
+		}
+
+		if takesValue {
+			if !p.curTokenIs(token.IDENT) || p.curToken.LineStart {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "switch %s expects a variable name", []any{option, stmt.Token}...)
+				continue
+			}
+			p.declareSetVariable(p.curToken.Literal)
+			variables = append(variables, option)
+			p.nextToken() // move past the variable name
+		}
+	}
+
+	if len(modes) > 1 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "switch accepts only one of -exact, -glob, -regexp, got %s", []any{strings.Join(modes, " "), stmt.Token}...)
+	}
+	if !stmt.IsRegex {
+		for _, option := range variables {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "switch %s requires -regexp", []any{option, stmt.Token}...)
+		}
+	}
+}
+
+// parses a pattern of a switch and its body. a body of - falls through to the
+// body of the next pattern, so "a" - "b" { ... } runs the same body for both
+func (p *Parser) parseCaseStatement() *ast.CaseStatement {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: Start parseCaseStatement at line %d\n", p.currentLine)
+	}
+
+	caseStmt := &ast.CaseStatement{Token: p.curToken, Line: p.curToken.Line}
+
+	caseStmt.Value = p.parseCasePattern()
+	if caseStmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.MINUS) && !p.peekToken.LineStart {
+		p.nextToken() // move to the '-' body
+		caseStmt.FallThrough = true
+		return caseStmt
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		p.reportError("parseCaseStatement: Expected '{' or '-' after case pattern")
+		return nil
+	}
+	caseStmt.Consequence = p.parseCaseBody()
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: End parseCaseStatement, created case with pattern '%v' at line %d\n", caseStmt.Value, caseStmt.Line)
+	}
+	return caseStmt
+}
+
+// parses a quoted or braced pattern or a bare word such as /api* or 80
+func (p *Parser) parseCasePattern() ast.Expression {
+	if p.curTokenIs(token.STRING) {
+		p.isParsingCasePattern = true
+		defer func() { p.isParsingCasePattern = false }()
+
+		pattern := p.parseExpression(LOWEST)
+		if _, ok := pattern.(*ast.StringLiteral); !ok {
+			p.reportError("parseCaseStatement: Expected string literal for case pattern, got %T", pattern)
+			return nil
+		}
+		return pattern
+	}
+
+	if p.curTokenIs(token.LBRACE) {
+		return p.parseBracedWord()
+	}
+
+	if p.curTokenIs(token.MINUS) {
+		p.reportError("parseCaseStatement: Invalid case pattern starting with token: %s", p.curToken.Literal)
+		return nil
+	}
+
+	// the lexer splits a bare word on characters such as / and *
+	pattern := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	for p.peekIsAdjacent() && !p.peekTokenIs(token.LBRACE) && !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		pattern.Value += p.curToken.Literal
+	}
+	return pattern
+}
+
+// skips the rest of a malformed case up to the next pattern boundary: a
+// pattern starting a line or the brace closing the switch. the current token
+// is left on the last token skipped
+func (p *Parser) skipToNextCase() {
+	depth := 0
+	for {
+		switch {
+		case p.curTokenIs(token.LBRACE):
+			depth++
+		case p.curTokenIs(token.RBRACE):
+			depth--
+		}
+		if p.peekTokenIs(token.EOF) {
+			return
+		}
+		if depth <= 0 && (p.peekToken.LineStart || p.peekTokenIs(token.RBRACE)) {
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// what the commands a switch commonly tests return
+var (
+	addressCommands = []string{"IP::client_addr", "IP::server_addr", "IP::remote_addr", "IP::local_addr"}
+	hostCommands    = []string{"HTTP::host"}
+	pathCommands    = []string{"HTTP::uri", "HTTP::path"}
+)
+
+// an IPv4 address or network with glob characters, such as 10.0.* or 192.168.1.[0-9]
+var addressPatternRegex = regexp.MustCompile(`^[0-9*?\[\]-]+(\.[0-9*?\[\]-]+){1,3}(/[0-9]+)?$`)
+
+// a host name such as www.example.com or *.example.com
+var hostPatternRegex = regexp.MustCompile(`^[*?]?[A-Za-z0-9*?-]*(\.[A-Za-z0-9*?-]+)*\.[A-Za-z][A-Za-z*?]+$`)
+
+// warns about -glob patterns that can't match what the switch value returns,
+// such as paths in a switch on [IP::client_addr]: a sign of patterns copied
+// from a switch on another value
+func (p *Parser) checkSwitchValueType(stmt *ast.SwitchStatement) {
+	if !stmt.IsGlob {
+		return
+	}
+	command := switchValueCommand(stmt.Value)
+
+	var kind string
+	var mismatched func(pattern string) string
+	switch {
+	case containsString(addressCommands, command):
+		kind = "an address"
+		mismatched = func(pattern string) string {
+			switch {
+			case isPathPattern(pattern):
+				return "a path"
+			case hostPatternRegex.MatchString(pattern):
+				return "a host name"
+			}
+			return ""
+		}
+	case containsString(hostCommands, command):
+		kind = "a host name"
+		mismatched = func(pattern string) string {
+			if isPathPattern(pattern) {
+				return "a path"
+			}
+			return ""
+		}
+	case containsString(pathCommands, command):
+		kind = "a path"
+		mismatched = func(pattern string) string {
+			if addressPatternRegex.MatchString(pattern) && strings.ContainsAny(pattern, "0123456789") {
+				return "an address"
+			}
+			return ""
+		}
+	default:
+		return
+	}
+
+	for _, caseStmt := range stmt.Cases {
+		for _, pattern := range casePatterns(caseStmt.Value) {
+			if looksLike := mismatched(pattern.Value); looksLike != "" {
+				p.reportWarning(diagnostic.InvalidPattern, "switch -glob pattern '%s' looks like %s, but %s returns %s", []any{pattern.Value, looksLike, command, kind, pattern.Token}...)
+			}
+		}
+	}
+}
+
+// returns the command whose result a switch tests, such as HTTP::uri in
+// [string tolower [HTTP::uri]]
+func switchValueCommand(value ast.Expression) string {
+	command := ""
+	ast.Inspect(value, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IpExpression:
+			command = n.String()
+		case *ast.Identifier:
+			if strings.Contains(n.Value, "::") && !strings.HasPrefix(n.Value, "$") {
+				command = n.Value
+			}
+		}
+		return command == ""
+	})
+	return command
+}
+
+// a path pattern starts with /, possibly after leading wildcards
+func isPathPattern(pattern string) bool {
+	return strings.HasPrefix(strings.TrimLeft(pattern, "*?"), "/")
+}
+
+// returns the literal patterns of a case, all of them for a MultiPattern
+func casePatterns(value ast.Expression) []*ast.StringLiteral {
+	switch v := value.(type) {
+	case *ast.StringLiteral:
+		return []*ast.StringLiteral{v}
+	case *ast.GlobPattern:
+		return []*ast.StringLiteral{{Token: v.Token, Value: v.Value}}
+	case *ast.MultiPattern:
+		patterns := []*ast.StringLiteral{}
+		for _, pattern := range v.Patterns {
+			patterns = append(patterns, casePatterns(pattern)...)
+		}
+		return patterns
+	}
+	return nil
+}
+
+// reports whether the peek token follows the current token without space
+func (p *Parser) peekIsAdjacent() bool {
+	return p.peekToken.Line == p.curToken.Line && !p.peekTokenIs(token.EOF) &&
+		p.peekToken.Column == p.curToken.Column+utf8.RuneCountInString(p.curToken.Literal)
+}
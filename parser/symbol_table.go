@@ -1,10 +1,31 @@
 package parser
 
+import "github.com/elkrammer/irule-validator/diagnostic"
+
 type SymbolType int
 
 const (
 	NODE SymbolType = iota
 	POOL
+	LOOP    // marks the body of a loop or switch case, which break and continue are valid in
+	ROUTINE // marks the body of an event or proc, which break and continue can't leave
+	VIRTUAL
+	SNAT
+	SNATPOOL
+)
+
+// the commands declaring each symbol and the symbols they can't share a block
+// with: a connection goes to a single node, pool or virtual server and is
+// translated by a single snat or snatpool
+var (
+	symbolCommands  = map[SymbolType]string{NODE: "node", POOL: "pool", VIRTUAL: "virtual", SNAT: "snat", SNATPOOL: "snatpool"}
+	symbolConflicts = map[SymbolType][]SymbolType{
+		NODE:     {POOL, VIRTUAL},
+		POOL:     {NODE, VIRTUAL},
+		VIRTUAL:  {NODE, POOL},
+		SNAT:     {SNATPOOL},
+		SNATPOOL: {SNAT},
+	}
 )
 
 type SymbolTable struct {
@@ -31,16 +52,33 @@ func (st *SymbolTable) ExitScope() {
 	}
 }
 
+// marks the current scope as the body of a loop, event or proc
+func (st *SymbolTable) Mark(symType SymbolType) {
+	st.scopes[len(st.scopes)-1][symType] = SymbolInfo{declared: true}
+}
+
+// reports whether the current scope is inside a loop of the event or proc
+// being parsed
+func (st *SymbolTable) InLoop() bool {
+	for i := len(st.scopes) - 1; i >= 0; i-- {
+		if st.scopes[i][LOOP].declared {
+			return true
+		}
+		if st.scopes[i][ROUTINE].declared {
+			return false
+		}
+	}
+	return false
+}
+
 func (st *SymbolTable) Declare(p *Parser, symType SymbolType) {
 	currentScope := st.scopes[len(st.scopes)-1]
 
-	if symType == NODE && currentScope[POOL].declared {
-		p.reportError("Invalid combination: 'node' and 'pool' in the same block.")
-		return
-	}
-	if symType == POOL && currentScope[NODE].declared {
-		p.reportError("Invalid combination: 'pool' and 'node' in the same block.")
-		return
+	for _, other := range symbolConflicts[symType] {
+		if currentScope[other].declared {
+			p.reportDiagnostic(diagnostic.NodePoolConflict, "Invalid combination: '%s' and '%s' in the same block.", symbolCommands[symType], symbolCommands[other])
+			return
+		}
 	}
 
 	currentScope[symType] = SymbolInfo{declared: true}
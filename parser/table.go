@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// a table subcommand: the options it takes besides -subtable, its argument
+// bounds and the argument from which on the arguments are a timeout and a
+// lifetime
+type tableSubcommand struct {
+	options  []string
+	minArgs  int
+	maxArgs  int
+	timeouts int // -1 when the subcommand takes no timeout
+}
+
+var tableSubcommands = map[string]tableSubcommand{
+	"set":      {options: []string{"-notouch", "-mustexist", "-excl", "-georedundancy"}, minArgs: 2, maxArgs: 4, timeouts: 2},
+	"add":      {options: []string{"-notouch", "-georedundancy"}, minArgs: 2, maxArgs: 4, timeouts: 2},
+	"replace":  {options: []string{"-notouch", "-georedundancy"}, minArgs: 2, maxArgs: 4, timeouts: 2},
+	"lookup":   {options: []string{"-notouch", "-georedundancy"}, minArgs: 1, maxArgs: 1, timeouts: -1},
+	"incr":     {options: []string{"-notouch", "-mustexist", "-georedundancy"}, minArgs: 1, maxArgs: 2, timeouts: -1},
+	"append":   {options: []string{"-notouch", "-mustexist", "-georedundancy"}, minArgs: 2, maxArgs: 2, timeouts: -1},
+	"delete":   {options: []string{"-all", "-georedundancy"}, minArgs: 1, maxArgs: 1, timeouts: -1},
+	"timeout":  {options: []string{"-remaining", "-georedundancy"}, minArgs: 1, maxArgs: 2, timeouts: 1},
+	"lifetime": {options: []string{"-remaining", "-georedundancy"}, minArgs: 1, maxArgs: 2, timeouts: 1},
+	"keys":     {options: []string{"-count", "-notouch"}, minArgs: 0, maxArgs: 0, timeouts: -1},
+}
+
+// parses table <subcommand> ?-subtable <name>? ?options? <arguments>
+func (p *Parser) parseTableCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseTableCommand Start - Current token: %s\n", p.curToken.Literal)
+	}
+	cmd := &ast.TableCommand{Token: p.curToken}
+
+	if p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "table expects a subcommand", []any{cmd.Token}...)
+		return cmd
+	}
+	p.nextToken()
+	cmd.Subcommand = p.curToken.Literal
+	if p.curTokenIs(token.LBRACKET) || strings.HasPrefix(cmd.Subcommand, "$") {
+		// the subcommand is computed at runtime and can't be checked
+		if sub := p.parseCommandArgument(); sub != nil {
+			cmd.Subcommand = sub.String()
+		}
+		cmd.Arguments = p.parseCommandArguments()
+		return cmd
+	}
+
+	args := p.parseCommandArguments()
+	for len(args) > 0 {
+		option, ok := literalWord(args[0])
+		if !ok || !strings.HasPrefix(option, "-") {
+			break
+		}
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		if option == "-subtable" {
+			if len(args) == 0 {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "table %s -subtable expects a name", []any{cmd.Subcommand, cmd.Token}...)
+				break
+			}
+			cmd.Subtable, args = args[0], args[1:]
+			continue
+		}
+		cmd.Options = append(cmd.Options, &ast.Identifier{Token: cmd.Token, Value: option})
+	}
+	cmd.Arguments = args
+
+	p.validateTableCommand(cmd)
+
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseTableCommand End - Subcommand: %s, Arguments: %d\n", cmd.Subcommand, len(cmd.Arguments))
+	}
+	return cmd
+}
+
+func (p *Parser) validateTableCommand(cmd *ast.TableCommand) {
+	subcommand, ok := tableSubcommands[cmd.Subcommand]
+	if !ok {
+		valid := []string{}
+		for name := range tableSubcommands {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid subcommand '%s' for table, expected one of %s", []any{cmd.Subcommand, strings.Join(valid, ", "), cmd.Token}...)
+		return
+	}
+
+	all := false
+	for _, opt := range cmd.Options {
+		option := opt.(*ast.Identifier).Value
+		if !containsString(subcommand.options, option) {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid option '%s' for table %s, expected -subtable or one of %s", []any{option, cmd.Subcommand, strings.Join(subcommand.options, ", "), cmd.Token}...)
+		}
+		all = all || option == "-all"
+	}
+
+	if cmd.Subcommand == "keys" && cmd.Subtable == nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "table keys expects -subtable", []any{cmd.Token}...)
+	}
+
+	spec := CommandSpec{MinArgs: subcommand.minArgs, MaxArgs: subcommand.maxArgs}
+	if all {
+		// delete -all takes no key
+		spec.MinArgs, spec.MaxArgs = 0, 0
+	}
+	argCount := len(cmd.Arguments)
+	if argCount < spec.MinArgs || argCount > spec.MaxArgs {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: table %s expects %s, got %d", []any{cmd.Subcommand, spec.describeArgs(), argCount, cmd.Token}...)
+		return
+	}
+
+	for i, arg := range cmd.Arguments {
+		word, ok := literalWord(arg)
+		if !ok {
+			continue
+		}
+		switch {
+		case subcommand.timeouts >= 0 && i >= subcommand.timeouts:
+			if _, err := strconv.Atoi(word); err != nil && word != "indef" {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "table %s expects a number of seconds or indef for argument %d, got '%s'", []any{cmd.Subcommand, i + 1, word, cmd.Token}...)
+			}
+		case cmd.Subcommand == "incr" && i == 1:
+			if _, err := strconv.Atoi(word); err != nil {
+				p.reportDiagnostic(diagnostic.InvalidCommand, "table incr expects a number for argument %d, got '%s'", []any{i + 1, word, cmd.Token}...)
+			}
+		}
+	}
+}
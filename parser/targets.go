@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// the name of a configuration object, optionally with its partition and
+// folder as in /Common/app/my_pool
+var objectNameRegex = regexp.MustCompile(`^/?([A-Za-z0-9_.-]+/)*[A-Za-z0-9_.-]+$`)
+
+// the route domain an address may end with, as in 10.0.0.1%2
+var routeDomainRegex = regexp.MustCompile(`%[0-9]+$`)
+
+// the commands use selects a target with, as in use pool my_pool
+var useTargets = []string{"node", "pool", "snat", "snatpool", "virtual"}
+
+// parses snat automap|none|address ?port?
+func (p *Parser) parseSnatStatement() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseSnatStatement Start - Current token: %s\n", p.curToken.Literal)
+	}
+	p.symbolTable.Declare(p, SNAT)
+	stmt := p.newTargetStatement()
+
+	args := p.parseCommandArguments()
+	stmt.Arguments = args
+	if len(args) == 0 || len(args) > 2 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: snat expects automap, none or an address and port, got %d argument(s)", []any{len(args), stmt.Token}...)
+		return stmt
+	}
+
+	switch arg := args[0].(type) {
+	case *ast.IpAddressLiteral:
+	case *ast.Identifier:
+		if arg.Value != "automap" && arg.Value != "none" && !strings.HasPrefix(arg.Value, "$") && net.ParseIP(arg.Value) == nil {
+			p.reportDiagnostic(diagnostic.InvalidCommand, "invalid snat address '%s', expected automap, none or an IP address", []any{arg.Value, stmt.Token}...)
+		}
+	}
+	return stmt
+}
+
+// parses snatpool name ?member address ?port??
+func (p *Parser) parseSnatpoolStatement() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseSnatpoolStatement Start - Current token: %s\n", p.curToken.Literal)
+	}
+	p.symbolTable.Declare(p, SNATPOOL)
+	stmt := p.newTargetStatement()
+
+	name := p.parseObjectName(stmt.Token)
+	if name == nil {
+		return stmt
+	}
+	stmt.Arguments = append([]ast.Expression{name}, p.parseCommandArguments()...)
+	members := stmt.Arguments[1:]
+	if len(members) > 0 && (!isWord(members[0], "member") || len(members) > 3 || len(members) == 1) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "snatpool expects a name optionally followed by member address ?port?", []any{stmt.Token}...)
+	}
+	return stmt
+}
+
+// parses virtual ?name?. without a name, or given the word name, it returns
+// the name of the current virtual server instead of sending the connection to
+// another one
+func (p *Parser) parseVirtualStatement() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseVirtualStatement Start - Current token: %s\n", p.curToken.Literal)
+	}
+	stmt := p.newTargetStatement()
+	if p.peekIsWordEnd() {
+		return stmt
+	}
+
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "name" {
+		p.nextToken()
+		stmt.Arguments = []ast.Expression{&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		return stmt
+	}
+
+	p.symbolTable.Declare(p, VIRTUAL)
+	name := p.parseObjectName(stmt.Token)
+	if name == nil {
+		return stmt
+	}
+	stmt.Arguments = append([]ast.Expression{name}, p.parseCommandArguments()...)
+	if len(stmt.Arguments) > 1 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: virtual expects at most 1 argument(s), got %d", []any{len(stmt.Arguments), stmt.Token}...)
+	}
+	return stmt
+}
+
+// parses use node|pool|snat|snatpool|virtual ..., the spelling of the target
+// commands before BIG-IP 9
+func (p *Parser) parseUseStatement() ast.Expression {
+	use := p.curToken
+	if !p.peekTokenIs(token.IDENT) || !containsString(useTargets, p.peekToken.Literal) {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid use target '%s', expected one of %s", []any{p.peekToken.Literal, strings.Join(useTargets, ", "), use}...)
+		return &ast.CallExpression{Token: use, Function: &ast.Identifier{Token: use, Value: use.Literal}, Arguments: p.parseCommandArguments()}
+	}
+
+	p.nextToken()
+	switch p.curToken.Literal {
+	case "node":
+		return p.parseNodeStatement()
+	case "pool":
+		return p.parsePoolStatement()
+	case "snat":
+		return p.parseSnatStatement()
+	case "snatpool":
+		return p.parseSnatpoolStatement()
+	default:
+		return p.parseVirtualStatement()
+	}
+}
+
+func (p *Parser) newTargetStatement() *ast.CallExpression {
+	return &ast.CallExpression{Token: p.curToken, Function: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+}
+
+// parses the name of the object a command targets. a name spelled out in the
+// rule has to be a valid object name, one in a variable or a command
+// substitution is only known at runtime
+func (p *Parser) parseObjectName(command token.Token) ast.Expression {
+	if p.peekIsWordEnd() {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "wrong # args: %s expects a name", []any{command.Literal, command}...)
+		return nil
+	}
+
+	p.nextToken()
+	if p.isSubstitutedWord() {
+		return p.parseCommandArgument()
+	}
+
+	start := p.curToken
+	name := p.readAdjacentWord()
+	if !objectNameRegex.MatchString(name) {
+		p.reportDiagnostic(diagnostic.InvalidIdentifier, "invalid %s name '%s'", []any{command.Literal, name, start}...)
+	}
+	return &ast.Identifier{Token: start, Value: name}
+}
+
+// reports whether no further word follows in the current command
+func (p *Parser) peekIsWordEnd() bool {
+	return p.peekIsCommandEnd() || p.peekTokenIs(token.RBRACKET) || p.peekTokenIs(token.RBRACE)
+}
+
+// reports whether the current word is quoted or substituted, so its value is
+// only known at runtime
+func (p *Parser) isSubstitutedWord() bool {
+	return p.curTokenIs(token.STRING) || p.curTokenIs(token.LBRACKET) || strings.HasPrefix(p.curToken.Literal, "$")
+}
+
+// reads a bare word the lexer splits into several tokens, such as the
+// partition path /Common/my_pool or the address 2001:db8::1
+func (p *Parser) readAdjacentWord() string {
+	word := p.curToken.Literal
+	for p.peekIsAdjacent() && !p.peekIsWordEnd() {
+		p.nextToken()
+		word += p.curToken.Literal
+	}
+	return word
+}
+
+// splits an IPv4 address from the port following it after a colon, and a
+// bracketed IPv6 address as in [fe80::1]:443 from its port. other IPv6
+// addresses are returned whole
+func splitNodeAddress(word string) (string, string) {
+	if strings.HasPrefix(word, "[") {
+		address, port, _ := strings.Cut(word[1:], "]")
+		return address, strings.TrimPrefix(port, ":")
+	}
+	if strings.Count(word, ":") == 1 {
+		address, port, _ := strings.Cut(word, ":")
+		return address, port
+	}
+	return word, ""
+}
+
+// reports an address that is neither an IP address nor the full path of a
+// node, as in /Common/web1
+func (p *Parser) checkNodeAddress(address string, tok token.Token) {
+	if strings.HasPrefix(address, "/") && objectNameRegex.MatchString(address) {
+		return
+	}
+	if net.ParseIP(routeDomainRegex.ReplaceAllString(address, "")) == nil {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid node address '%s', expected an IPv4 or IPv6 address or a /partition/name", []any{address, tok}...)
+	}
+}
+
+func (p *Parser) checkNodePort(port string, tok token.Token) {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		p.reportDiagnostic(diagnostic.InvalidCommand, "invalid node port '%s', expected a number from 1 to 65535", []any{port, tok}...)
+	}
+}
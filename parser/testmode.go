@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/token"
+)
+
+// parses assert <condition> ?message?, a pseudo-command that only exists in
+// test fixtures
+func (p *Parser) parseAssertCommand() ast.Expression {
+	if p.opts.DebugMode {
+		fmt.Printf("DEBUG: parseAssertCommand Start - Line: %d\n", p.curToken.Line)
+	}
+
+	cmd := &ast.CommandInvocation{Token: p.curToken, Command: p.curToken.Literal}
+	cmd.Arguments = p.parseCommandArguments()
+
+	if !p.opts.TestMode {
+		p.reportDiagnostic(diagnostic.TestOnlyCommand, "%s is only available with --test-mode", []any{cmd.Command, cmd.Token}...)
+		return cmd
+	}
+	p.validateCommand(cmd, CommandSpec{Name: "assert", MinArgs: 1, MaxArgs: 2})
+
+	return cmd
+}
+
+// checks that every '# expect: <command>' comment names a command the rule
+// actually runs, e.g. '# expect: pool api_pool' needs a 'pool api_pool'
+func (p *Parser) checkExpectations() {
+	for _, expectation := range p.l.Expectations() {
+		if !p.containsCommand(expectation.Words) {
+			p.reportDiagnostic(diagnostic.UnmetExpectation, "expected command '%s' not found in the rule", []any{strings.Join(expectation.Words, " "), expectation.Line}...)
+		}
+	}
+}
+
+func (p *Parser) containsCommand(words []string) bool {
+	for i := range p.seenTokens {
+		if !p.startsCommand(i) || i+len(words) > len(p.seenTokens) {
+			continue
+		}
+
+		matched := true
+		for j, word := range words {
+			if p.seenTokens[i+j].Literal != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether the i-th seen token is the first word of a command
+func (p *Parser) startsCommand(i int) bool {
+	if i == 0 || p.seenTokens[i].LineStart {
+		return true
+	}
+	switch p.seenTokens[i-1].Type {
+	case token.LBRACE, token.LBRACKET, token.SEMICOLON:
+		return true
+	}
+	return false
+}
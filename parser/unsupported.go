@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/diagnostic"
+)
+
+// where the gaps in the validator's coverage of iRules are tracked
+const unsupportedURL = "https://github.com/elkrammer/irule-validator/issues"
+
+// iRules commands of known namespaces the validator has no spec for yet
+var unsupportedCommands = []string{
+	"HTTP::disable", "HTTP::enable", "HTTP::fallback", "HTTP::has_responded",
+	"HTTP::hsts", "HTTP::is_redirect", "HTTP::request", "HTTP::request_num",
+	"HTTP::retry",
+	"IP::hops", "IP::idle_timeout", "IP::protocol", "IP::stats", "IP::tos",
+	"IP::ttl", "IP::version",
+	"X509::cert_fields", "X509::not_valid_after", "X509::not_valid_before",
+	"X509::serial_number", "X509::signature_algorithm", "X509::subject_public_key",
+	"X509::subject_public_key_RSA_bits", "X509::subject_public_key_type",
+	"X509::verify_cert_error_string", "X509::whole",
+}
+
+// iRules namespaces the validator has no commands for yet
+var unsupportedNamespaces = []string{
+	"ACCESS", "ACL", "ADAPT", "ASM", "AUTH", "AVR", "BOTDEFENSE", "CACHE",
+	"CLASSIFY", "COMPRESS", "DIAMETER", "FIX", "FLOW", "FTP", "GTP", "HTML",
+	"ICAP", "ILX", "IPFIX", "ISESSION", "LINE", "LSN", "NTLM", "ONECONNECT",
+	"PCP", "PEM", "PROFILE", "PSC", "RADIUS", "REST", "RTSP", "SCTP", "SDP",
+	"SIP", "SMTPS", "SOCKS", "STATS", "TAP", "UDP", "WAM", "WEBSSO", "XML",
+}
+
+// reports whether a word is an iRules command the validator knows of but
+// doesn't check yet
+func isUnsupportedCommand(word string) bool {
+	if containsString(unsupportedCommands, word) {
+		return true
+	}
+	namespace, _, found := strings.Cut(word, "::")
+	return found && containsString(unsupportedNamespaces, namespace)
+}
+
+// reports a command the validator doesn't support yet and skips its
+// arguments, so the rest of the rule is still checked. --lenient makes it a
+// warning
+func (p *Parser) parseUnsupportedCommand() ast.Expression {
+	cmd := &ast.CommandInvocation{Token: p.curToken, Command: p.curToken.Literal}
+	severity := diagnostic.Error
+	if p.opts.Lenient {
+		severity = diagnostic.Warning
+	}
+	p.reportSeverity(severity, diagnostic.UnsupportedConstruct, "%s is not supported by the validator yet, see %s", []any{cmd.Command, unsupportedURL, cmd.Token}...)
+
+	if isCommandStart(p.prevToken, p.curToken) {
+		cmd.Arguments = p.parseCommandArguments()
+	}
+	return cmd
+}
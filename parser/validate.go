@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/ast"
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/lexer"
+)
+
+// Stats summarizes the parse tree of an iRule
+type Stats struct {
+	Statements int            // statements at any depth, including those in event and proc bodies
+	Events     int            // event handlers
+	Procs      int            // proc definitions
+	Commands   map[string]int // namespaced commands keyed by namespace, e.g. HTTP
+	Nodes      map[string]int // nodes of the parse tree keyed by type, e.g. SwitchStatement
+	MaxDepth   int            // deepest nesting of blocks, 1 for the body of an event
+	Expensive  []EventCost    // expensive constructs of every event handler
+}
+
+// Result is the outcome of validating an iRule
+type Result struct {
+	Program     *ast.Program
+	Diagnostics []diagnostic.Diagnostic // sorted by line, without duplicates
+	Stats       Stats
+}
+
+// Validate parses an iRule and returns its diagnostics along with statistics
+// about the parse tree
+func Validate(input string) Result {
+	return ValidateWithOptions(input, config.CurrentOptions())
+}
+
+// ValidateWithOptions validates an iRule with options of its own instead of
+// those set with the command line flags. it is safe to call from several
+// goroutines at once
+func ValidateWithOptions(input string, opts config.Options) Result {
+	l := lexer.NewWithOptions(input, opts)
+	p := New(l)
+	program := p.ParseProgram()
+
+	stats := CollectStats(program)
+	stats.Events = len(p.EventHandlers())
+	stats.Procs = len(p.Procs())
+	stats.Expensive = p.EventCosts()
+
+	return Result{Program: program, Diagnostics: diagnostic.Normalize(p.Diagnostics()), Stats: stats}
+}
+
+// CollectStats counts the statements, nodes and namespaced commands of a
+// parse tree and measures its nesting. events, procs and expensive constructs
+// are known to the parser and are left empty
+func CollectStats(program *ast.Program) Stats {
+	stats := Stats{Commands: map[string]int{}, Nodes: map[string]int{}}
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		if _, ok := node.(ast.Statement); ok {
+			stats.Statements++
+		}
+		if namespace, ok := commandNamespace(node); ok {
+			stats.Commands[namespace]++
+		}
+		stats.Nodes[strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")]++
+		return true
+	})
+	stats.MaxDepth = blockDepth(program)
+
+	return stats
+}
+
+// Merge adds the statistics of another rule, such as the next ltm rule of a
+// bigip.conf
+func (s *Stats) Merge(other Stats) {
+	s.Statements += other.Statements
+	s.Events += other.Events
+	s.Procs += other.Procs
+	s.Expensive = append(s.Expensive, other.Expensive...)
+	if s.Commands == nil {
+		s.Commands = map[string]int{}
+	}
+	for namespace, count := range other.Commands {
+		s.Commands[namespace] += count
+	}
+	if s.Nodes == nil {
+		s.Nodes = map[string]int{}
+	}
+	for kind, count := range other.Nodes {
+		s.Nodes[kind] += count
+	}
+	s.MaxDepth = max(s.MaxDepth, other.MaxDepth)
+}
+
+// returns how deep blocks nest beneath node
+func blockDepth(node ast.Node) int {
+	depth := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == node {
+			return true
+		}
+		if block, ok := n.(*ast.BlockStatement); ok {
+			depth = max(depth, 1+blockDepth(block))
+			return false
+		}
+		return true
+	})
+	return depth
+}
+
+// returns the namespace of a namespaced command, e.g. HTTP for HTTP::uri
+func commandNamespace(node ast.Node) (string, bool) {
+	var name string
+	switch n := node.(type) {
+	case *ast.CommandInvocation:
+		name = n.Command
+	case *ast.HttpExpression:
+		name = n.Token.Literal
+	case *ast.HttpRespondExpression:
+		name = n.Token.Literal
+	case *ast.HttpUriExpression:
+		name = "HTTP::uri"
+	case *ast.LoadBalancerExpression:
+		name = n.Token.Literal
+	case *ast.SSLExpression:
+		name = n.Token.Literal
+	case *ast.IpExpression:
+		name = n.Token.Literal
+	default:
+		return "", false
+	}
+
+	namespace, _, found := strings.Cut(name, "::")
+	return namespace, found && namespace != ""
+}
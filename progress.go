@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// a single line of --progress json output
+type progressEvent struct {
+	Event       string     `json:"event"`
+	File        string     `json:"file"`
+	Index       int        `json:"index"`
+	Total       int        `json:"total"`
+	Status      fileStatus `json:"status,omitempty"`
+	Diagnostics *int       `json:"diagnostics,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"`
+}
+
+// streams per-file start/finish events so wrappers can display progress
+// while thousands of rules are validated. files validated concurrently finish
+// in any order
+type progressReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	total   int
+	started map[int]time.Time
+}
+
+func newProgressReporter(out io.Writer, format string, total int) *progressReporter {
+	reporter := &progressReporter{total: total, started: make(map[int]time.Time)}
+	if format == "json" {
+		reporter.encoder = json.NewEncoder(out)
+	}
+	return reporter
+}
+
+func (r *progressReporter) start(index int, filename string) {
+	if r.encoder == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[index] = time.Now()
+	r.encoder.Encode(progressEvent{Event: "start", File: filename, Index: index + 1, Total: r.total})
+}
+
+func (r *progressReporter) finish(index int, filename string, result fileResult) {
+	if r.encoder == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	duration := time.Since(r.started[index]).Milliseconds()
+	delete(r.started, index)
+	r.encoder.Encode(progressEvent{
+		Event:       "finish",
+		File:        filename,
+		Index:       index + 1,
+		Total:       r.total,
+		Status:      result.status,
+		Diagnostics: &result.diagnostics,
+		DurationMs:  &duration,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// renames the variable named with --var in a rule and prints the changed
+// lines as a diff. with --dry-run the rule is left untouched. returns the exit
+// code
+func runRename(out io.Writer, args []string) int {
+	if config.RenameVar == "" || len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s rename --var <old_name> [--dry-run] <new_name> <file>\n", os.Args[0])
+		return 2
+	}
+	name, filename := args[0], args[1]
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file :%v\n", err)
+		return 1
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file :%v\n", err)
+		return 1
+	}
+
+	renamed, renames, err := parser.RenameVariable(string(content), config.RenameVar, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot rename %s in %s: %v\n", config.RenameVar, filename, err)
+		return 1
+	}
+	if len(renames) == 0 {
+		fmt.Fprintf(os.Stderr, "No variable named %s in %s\n", config.RenameVar, filename)
+		return 1
+	}
+
+	writeLineDiff(out, filename, string(content), renamed)
+	if config.DryRun {
+		fmt.Fprintf(out, "Would rename %d occurrences of %s to %s\n", len(renames), config.RenameVar, name)
+		return 0
+	}
+
+	if err := os.WriteFile(filename, []byte(renamed), info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing file :%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Renamed %d occurrences of %s to %s\n", len(renames), config.RenameVar, name)
+	return 0
+}
+
+// prints the lines that differ between two versions of a file that have the
+// same number of lines, which holds for renames
+func writeLineDiff(out io.Writer, filename, before, after string) {
+	old, new := strings.Split(before, "\n"), strings.Split(after, "\n")
+
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", filename, filename)
+	for i := range old {
+		if old[i] == new[i] {
+			continue
+		}
+		fmt.Fprintf(out, "@@ -%d +%d @@\n-%s\n+%s\n", i+1, i+1, old[i], new[i])
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
 	"github.com/elkrammer/irule-validator/lexer"
 	"github.com/elkrammer/irule-validator/parser"
 )
@@ -22,12 +25,19 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			runCommand(out, line)
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		errors, warnings := splitDiagnostics(diagnostic.Normalize(p.Diagnostics()))
+		printDiagnostics(out, "❌ Errors:", errors)
+		printDiagnostics(out, "⚠️ Warnings:", warnings)
+		if len(errors) != 0 {
 			continue
 		}
 		io.WriteString(out, program.String())
@@ -35,10 +45,42 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "Woops! We ran into some funky business here!\n")
-	io.WriteString(out, "Parser Errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "  "+msg+"\n")
+// runs a meta-command such as :debug on
+func runCommand(out io.Writer, line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":debug":
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			io.WriteString(out, "usage: :debug on|off\n")
+			return
+		}
+		config.SetDebug(fields[1] == "on")
+		fmt.Fprintf(out, "debug output %s\n", fields[1])
+	default:
+		fmt.Fprintf(out, "unknown command %s, expected :debug on|off\n", fields[0])
+	}
+}
+
+// splits findings into the errors and the warnings and notes
+func splitDiagnostics(diagnostics []diagnostic.Diagnostic) ([]diagnostic.Diagnostic, []diagnostic.Diagnostic) {
+	var errors, warnings []diagnostic.Diagnostic
+	for _, d := range diagnostics {
+		if d.IsError() {
+			errors = append(errors, d)
+		} else {
+			warnings = append(warnings, d)
+		}
+	}
+	return errors, warnings
+}
+
+// prints findings under a heading, one per line as the command line does
+func printDiagnostics(out io.Writer, heading string, diagnostics []diagnostic.Diagnostic) {
+	if len(diagnostics) == 0 {
+		return
+	}
+	io.WriteString(out, heading+"\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, "   "+d.String()+"\n")
 	}
 }
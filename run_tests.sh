@@ -9,8 +9,10 @@ run_and_check() {
 
 go build -buildvcs=true
 run_and_check go test ./ast
+run_and_check go test ./diagnostic
 run_and_check go test ./lexer
 run_and_check go test ./parser
+run_and_check ./irule-validator selftest
 
 # Get a list of all files in the test-data directory
 test_files=(test-data/*)
@@ -18,7 +20,6 @@ exclude_files=(
   "active_members.irule"
   "class_match.irule"
   "complex3.irule"
-  "cookie.irule"
   "routes01.irule"
   "bad-rule.irule"
   "bad-rule2.irule"
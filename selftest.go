@@ -0,0 +1,93 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/elkrammer/irule-validator/config"
+	"github.com/elkrammer/irule-validator/diagnostic"
+	"github.com/elkrammer/irule-validator/parser"
+)
+
+// rules under selftest/good have to pass validation and rules under
+// selftest/bad have to fail it with the error listed in selftestFailures
+//
+//go:embed selftest
+var selftestCorpus embed.FS
+
+// the error every rule under selftest/bad has to be reported with, so a bad
+// rule failing for another reason doesn't go unnoticed
+var selftestFailures = map[string]diagnostic.Code{
+	"invalid-http-command.irule": diagnostic.InvalidCommand,
+	"unbalanced-braces.irule":    diagnostic.UnbalancedBraces,
+	"undefined-proc.irule":       diagnostic.UndefinedProc,
+	"unknown-event.irule":        diagnostic.SyntaxError,
+	"wrong-arg-count.irule":      diagnostic.InvalidCommand,
+}
+
+// runs the embedded corpus through the validator with the current flags and
+// reports every rule whose result differs from what its directory expects.
+// returns the number of unexpected results
+func runSelftest(out io.Writer) int {
+	total, unexpected := 0, 0
+
+	err := fs.WalkDir(selftestCorpus, "selftest", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := selftestCorpus.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		total++
+		expectFailure := path.Base(path.Dir(name)) == "bad"
+		result := parser.Validate(string(content))
+		failed := diagnostic.HasErrors(result.Diagnostics)
+
+		code, known := selftestFailures[path.Base(name)]
+
+		switch {
+		case expectFailure && !known:
+			unexpected++
+			fmt.Fprintf(out, "❌ %s has no expected error in selftestFailures\n", name)
+		case expectFailure && failed && !hasCode(result.Diagnostics, code):
+			unexpected++
+			fmt.Fprintf(out, "❌ %s was expected to fail with %s\n", name, code)
+			printParserErrors(out, result.Diagnostics)
+		case failed == expectFailure:
+			if config.PrintErrors || config.DebugMode {
+				fmt.Fprintf(out, "✅ %s\n", name)
+			}
+		case failed:
+			unexpected++
+			fmt.Fprintf(out, "❌ %s was expected to pass\n", name)
+			printParserErrors(out, result.Diagnostics)
+		default:
+			unexpected++
+			fmt.Fprintf(out, "❌ %s was expected to fail with %s\n", name, code)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Error reading self-test corpus: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "Self-test results: %d/%d as expected\n", total-unexpected, total)
+	return unexpected
+}
+
+// reports whether one of the errors of a rule has the given code
+func hasCode(diagnostics []diagnostic.Diagnostic, code diagnostic.Code) bool {
+	for _, d := range diagnostics {
+		if d.Code == code && d.IsError() {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,11 @@ type Token struct {
 	Type    TokenType
 	Literal string
 	Line    int
+	Column  int // 1-based, counted in characters
+	Offset  int // byte offset of the token in the input
+	// LineStart is set when an unescaped newline separates this token from the
+	// previous one. TCL treats such a newline as a command terminator.
+	LineStart bool
 }
 
 // predefined token types
@@ -18,11 +23,12 @@ const (
 	SKIP_TO_NEXT_CASE = "SKIP_TO_NEXT_CASE"
 
 	// types
-	BLOCK   = "BLOCK"
-	IDENT   = "IDENT"
-	ILLEGAL = "ILLEGAL"
-	NUMBER  = "NUMBER"
-	STRING  = "STRING"
+	BLOCK        = "BLOCK"
+	IDENT        = "IDENT"
+	ILLEGAL      = "ILLEGAL"
+	NUMBER       = "NUMBER"
+	STRING       = "STRING"
+	RESOLVER_REF = "RESOLVER_REF" // @<ip> or @<virtual server path>
 
 	//operators
 	ASSIGN       = "="
@@ -40,6 +46,14 @@ const (
 	COLON        = ":"
 	DOUBLE_COLON = "::"
 	CARET        = "^"
+	POWER        = "**"
+	SHIFT_LEFT   = "<<"
+	SHIFT_RIGHT  = ">>"
+	LT_EQ        = "<="
+	GT_EQ        = ">="
+	PIPE         = "|"
+	TILDE        = "~"
+	QUESTION     = "?"
 
 	// delimiters
 	COMMA    = ","
@@ -57,12 +71,13 @@ const (
 	RETURN  = "RETURN"
 	TRUE    = "TRUE"
 	FALSE   = "FALSE"
-	ARRAY   = "ARRAY"
 	SET     = "SET"
 	FOREACH = "FOREACH"
 	IN      = "IN"
 	REGEX   = "REGEX"
 	REGSUB  = "REGSUB"
+	PROC    = "PROC"
+	CALL    = "CALL"
 
 	// HTTP TOKENS
 	HTTP_REQUEST  = "HTTP_REQUEST"
@@ -99,6 +114,40 @@ const (
 	SSL_SESSIONVALID   = "SSL::sessionvalid"
 	SSL_SESSIONUPDATES = "SSL::sessionupdates"
 
+	// WEBSOCKET TOKENS
+	WS_CLIENT_DATA       = "WS_CLIENT_DATA"
+	WS_CLIENT_FRAME      = "WS_CLIENT_FRAME"
+	WS_CLIENT_FRAME_DONE = "WS_CLIENT_FRAME_DONE"
+	WS_REQUEST           = "WS_REQUEST"
+	WS_RESPONSE          = "WS_RESPONSE"
+	WS_SERVER_DATA       = "WS_SERVER_DATA"
+	WS_SERVER_FRAME      = "WS_SERVER_FRAME"
+	WS_SERVER_FRAME_DONE = "WS_SERVER_FRAME_DONE"
+
+	// NAME RESOLUTION TOKENS
+	NAME_RESOLVED = "NAME_RESOLVED"
+
+	// URL CATEGORIZATION TOKENS
+	CATEGORY_MATCHED = "CATEGORY_MATCHED"
+
+	// STREAM TOKENS
+	STREAM_MATCHED = "STREAM_MATCHED"
+
+	// MQTT TOKENS
+	MQTT_CLIENT_DATA     = "MQTT_CLIENT_DATA"
+	MQTT_SERVER_DATA     = "MQTT_SERVER_DATA"
+	MQTT_CLIENT_INGRESS  = "MQTT_CLIENT_INGRESS"
+	MQTT_SERVER_INGRESS  = "MQTT_SERVER_INGRESS"
+	MQTT_CLIENT_EGRESS   = "MQTT_CLIENT_EGRESS"
+	MQTT_SERVER_EGRESS   = "MQTT_SERVER_EGRESS"
+	MQTT_CLIENT_SHUTDOWN = "MQTT_CLIENT_SHUTDOWN"
+	MQTT_SERVER_SHUTDOWN = "MQTT_SERVER_SHUTDOWN"
+
+	// MESSAGE ROUTING TOKENS
+	MR_INGRESS = "MR_INGRESS"
+	MR_EGRESS  = "MR_EGRESS"
+	MR_FAILED  = "MR_FAILED"
+
 	IP_ADDRESS     = "IP_ADDRESS"
 	IP_CLIENT_ADDR = "IP::client_addr"
 	IP_SERVER_ADDR = "IP::server_addr"
@@ -208,7 +257,6 @@ var keywords = map[string]TokenType{
 	"switch":      SWITCH,
 	"case":        CASE,
 	"default":     DEFAULT,
-	"array":       ARRAY,
 	"match":       MATCH,
 	"matches":     MATCHES,
 	"ends_with":   ENDS_WITH,
@@ -220,6 +268,7 @@ var keywords = map[string]TokenType{
 	"ltm":         LTM,
 	"rule":        RULE,
 	"regsub":      REGSUB,
+	"proc":        PROC,
 
 	// F5 Event Contexts
 	"HTTP_REQUEST":        HTTP_REQUEST,
@@ -232,6 +281,17 @@ var keywords = map[string]TokenType{
 	"IP_CLIENT_ADDR":      IP_CLIENT_ADDR,
 }
 
+// returns the keywords that are looked up as the given token type
+func KeywordsFor(t TokenType) []string {
+	literals := []string{}
+	for literal, tokenType := range keywords {
+		if tokenType == t {
+			literals = append(literals, literal)
+		}
+	}
+	return literals
+}
+
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok